@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// --- Profiles and Cross-Account Access ---
+
+// roleSessionName identifies this tool's sessions in the target account's
+// CloudTrail.
+const roleSessionName = "auto-route53"
+
+// accountClients are the clients for one profile and role combination.
+type accountClients struct {
+	cfg     aws.Config
+	route53 *route53.Client
+	acm     *acm.Client
+	// cloudfront is the client for records with a cloudfront_distribution_id.
+	cloudfront *cloudfront.Client
+	// acmByRegion holds the ACM clients for records with a cert_region,
+	// guarded by accountsMu.
+	acmByRegion map[string]*acm.Client
+	// elbv2ByRegion holds the ELBv2 clients for records with listener_arns,
+	// by the region of the listener, guarded by accountsMu.
+	elbv2ByRegion map[string]*elbv2.Client
+	// apiGatewayByRegion holds the API Gateway clients for records with an
+	// api_gateway_domain, by the region of the certificate, guarded by
+	// accountsMu.
+	apiGatewayByRegion map[string]*apigatewayv2.Client
+}
+
+var (
+	// baseAWSConfig is the default configuration, which roles are assumed
+	// from unless the record names a profile. It is set once at startup.
+	baseAWSConfig aws.Config
+
+	accountsMu     sync.Mutex
+	profileConfigs = map[string]aws.Config{}
+	accountsByKey  = map[string]*accountClients{}
+)
+
+// loadAWSConfig loads the AWS configuration for a shared-config profile, or
+// the default configuration if profile is empty, with the retry, timeout,
+// endpoint and TLS settings applied.
+func loadAWSConfig(ctx context.Context, appConfig *AppConfig, profile string) (aws.Config, error) {
+	options := []func(*config.LoadOptions) error{
+		config.WithRetryer(newRetryer(appConfig)),
+		config.WithHTTPClient(newAPIHTTPClient(appConfig)),
+	}
+	if profile != "" {
+		options = append(options, config.WithSharedConfigProfile(profile))
+	}
+	if appConfig.AWSEndpointURL != "" {
+		options = append(options, config.WithBaseEndpoint(appConfig.AWSEndpointURL))
+	}
+	if appConfig.UseFIPSEndpoint {
+		options = append(options, config.WithUseFIPSEndpoint(aws.FIPSEndpointStateEnabled))
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, options...)
+	if err != nil {
+		return aws.Config{}, err
+	}
+	cfg.APIOptions = append(cfg.APIOptions, countAPIErrors, traceAPICalls)
+	if len(appConfig.AWSServiceEndpoints) > 0 {
+		cfg.ConfigSources = append(cfg.ConfigSources, appConfig.AWSServiceEndpoints)
+	}
+	return cfg, nil
+}
+
+// loadAccountProfiles loads the shared-config profiles named by records that
+// have not been loaded yet, so that a missing or broken profile is reported
+// when the configuration is loaded rather than on first use. appConfig
+// supplies the retry, timeout and endpoint settings in effect since startup.
+func loadAccountProfiles(ctx context.Context, appConfig *AppConfig, records []RecordConfig) error {
+	for _, record := range records {
+		if record.Profile == "" {
+			continue
+		}
+		accountsMu.Lock()
+		_, loaded := profileConfigs[record.Profile]
+		accountsMu.Unlock()
+		if loaded {
+			continue
+		}
+		cfg, err := loadAWSConfig(ctx, appConfig, record.Profile)
+		if err != nil {
+			return fmt.Errorf("failed to load AWS profile %q for record %s: %w", record.Profile, record.RecordName, err)
+		}
+		accountsMu.Lock()
+		profileConfigs[record.Profile] = cfg
+		accountsMu.Unlock()
+	}
+	return nil
+}
+
+// normalizeRole validates the record's profile, role and cert_region
+// settings.
+func normalizeRole(record *RecordConfig) error {
+	record.CertRegion = strings.ToLower(strings.TrimSpace(record.CertRegion))
+	if record.CertRegion != "" {
+		if err := checkACMRegion(record.CertRegion); err != nil {
+			return fmt.Errorf("record %s has an invalid cert_region: %w", record.RecordName, err)
+		}
+	}
+	record.Profile = strings.TrimSpace(record.Profile)
+	record.RoleARN = strings.TrimSpace(record.RoleARN)
+	record.ExternalID = strings.TrimSpace(record.ExternalID)
+	if record.RoleARN == "" {
+		if record.ExternalID != "" {
+			return fmt.Errorf("record %s has an external_id but no role_arn", record.RecordName)
+		}
+		return nil
+	}
+	if parsed, err := arn.Parse(record.RoleARN); err != nil || parsed.Service != "iam" || !strings.HasPrefix(parsed.Resource, "role/") {
+		return fmt.Errorf("record %s has an invalid role_arn %q", record.RecordName, record.RoleARN)
+	}
+	return nil
+}
+
+// clientsFor returns the clients for the record's profile and role,
+// creating them on first use. Assumed-role credentials are fetched lazily
+// and refreshed before they expire.
+func clientsFor(record RecordConfig) *accountClients {
+	key := record.Profile + "|" + record.RoleARN + "|" + record.ExternalID
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	if clients, ok := accountsByKey[key]; ok {
+		return clients
+	}
+	cfg := baseAWSConfig
+	if record.Profile != "" {
+		cfg = profileConfigs[record.Profile]
+	}
+	if record.RoleARN != "" {
+		source := cfg
+		cfg = source.Copy()
+		provider := stscreds.NewAssumeRoleProvider(sts.NewFromConfig(source), record.RoleARN, func(o *stscreds.AssumeRoleOptions) {
+			o.RoleSessionName = roleSessionName
+			if record.ExternalID != "" {
+				o.ExternalID = aws.String(record.ExternalID)
+			}
+		})
+		cfg.Credentials = aws.NewCredentialsCache(provider)
+	}
+	clients := &accountClients{cfg: cfg, route53: route53.NewFromConfig(cfg), acm: acm.NewFromConfig(cfg), cloudfront: cloudfront.NewFromConfig(cfg), acmByRegion: map[string]*acm.Client{}, elbv2ByRegion: map[string]*elbv2.Client{}, apiGatewayByRegion: map[string]*apigatewayv2.Client{}}
+	accountsByKey[key] = clients
+	return clients
+}
+
+// route53For returns the Route53 client to use for a record: client itself,
+// unless the record names a profile or a role to assume.
+func route53For(client *route53.Client, record RecordConfig) *route53.Client {
+	if record.Profile == "" && record.RoleARN == "" {
+		return client
+	}
+	return clientsFor(record).route53
+}
+
+// acmFor is route53For for ACM, also taking the record's cert_region into
+// account.
+func acmFor(client *acm.Client, record RecordConfig) *acm.Client {
+	if record.Profile == "" && record.RoleARN == "" && record.CertRegion == "" {
+		return client
+	}
+	clients := clientsFor(record)
+	if record.CertRegion == "" || record.CertRegion == clients.cfg.Region {
+		return clients.acm
+	}
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	regional, ok := clients.acmByRegion[record.CertRegion]
+	if !ok {
+		regional = acm.NewFromConfig(clients.cfg, func(o *acm.Options) {
+			o.Region = record.CertRegion
+		})
+		clients.acmByRegion[record.CertRegion] = regional
+	}
+	return regional
+}
+
+// elbv2For returns the ELBv2 client for the record's account in region,
+// creating it on first use.
+func elbv2For(record RecordConfig, region string) *elbv2.Client {
+	clients := clientsFor(record)
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	client, ok := clients.elbv2ByRegion[region]
+	if !ok {
+		client = elbv2.NewFromConfig(clients.cfg, func(o *elbv2.Options) {
+			o.Region = region
+		})
+		clients.elbv2ByRegion[region] = client
+	}
+	return client
+}
+
+// apiGatewayFor is elbv2For for API Gateway.
+func apiGatewayFor(record RecordConfig, region string) *apigatewayv2.Client {
+	clients := clientsFor(record)
+	accountsMu.Lock()
+	defer accountsMu.Unlock()
+	client, ok := clients.apiGatewayByRegion[region]
+	if !ok {
+		client = apigatewayv2.NewFromConfig(clients.cfg, func(o *apigatewayv2.Options) {
+			o.Region = region
+		})
+		clients.apiGatewayByRegion[region] = client
+	}
+	return client
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	ststypes "github.com/aws/aws-sdk-go-v2/service/sts/types"
+)
+
+// useAccounts makes fake answer the clients created for profiles and roles,
+// starting with none cached.
+func useAccounts(t *testing.T, fake *fakeAWS) {
+	t.Helper()
+	savedConfig, savedAccounts, savedProfiles := baseAWSConfig, accountsByKey, profileConfigs
+	baseAWSConfig = fake.config()
+	accountsByKey = map[string]*accountClients{}
+	profileConfigs = map[string]aws.Config{}
+	t.Cleanup(func() {
+		baseAWSConfig, accountsByKey, profileConfigs = savedConfig, savedAccounts, savedProfiles
+	})
+}
+
+func TestRoleClients(t *testing.T) {
+	fake := newFakeAWS(t)
+	useAccounts(t, fake)
+	base := fake.route53()
+	plain := RecordConfig{RecordName: "home.example.com"}
+	if route53For(base, plain) != base {
+		t.Error("a record without a profile or role should use the default client")
+	}
+
+	dns := RecordConfig{RecordName: "a.example.com", RoleARN: "arn:aws:iam::222222222222:role/dns", ExternalID: "secret"}
+	other := RecordConfig{RecordName: "b.example.com", RoleARN: "arn:aws:iam::333333333333:role/dns"}
+	client := route53For(base, dns)
+	if client == base {
+		t.Fatal("a record with a role should not use the default client")
+	}
+	if route53For(base, RecordConfig{RecordName: "c.example.com", RoleARN: dns.RoleARN, ExternalID: dns.ExternalID}) != client {
+		t.Error("records with the same role should share a client")
+	}
+	if route53For(base, other) == client {
+		t.Error("records with different roles should not share a client")
+	}
+
+	var assumed []*sts.AssumeRoleInput
+	fake.on("AssumeRole", func(input any) (any, error) {
+		assumed = append(assumed, input.(*sts.AssumeRoleInput))
+		return &sts.AssumeRoleOutput{Credentials: &ststypes.Credentials{
+			AccessKeyId:     aws.String("AKIDEXAMPLE"),
+			SecretAccessKey: aws.String("secret"),
+			SessionToken:    aws.String("token"),
+			Expiration:      aws.Time(time.Now().Add(time.Hour)),
+		}}, nil
+	})
+	for range 2 {
+		creds, err := clientsFor(dns).cfg.Credentials.Retrieve(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if creds.AccessKeyID != "AKIDEXAMPLE" {
+			t.Errorf("role credentials = %+v, want the assumed role's", creds)
+		}
+	}
+	if len(assumed) != 1 {
+		t.Fatalf("assumed the role %d times, want once while its credentials are cached", len(assumed))
+	}
+	input := assumed[0]
+	if aws.ToString(input.RoleArn) != dns.RoleARN || aws.ToString(input.ExternalId) != "secret" || aws.ToString(input.RoleSessionName) != roleSessionName {
+		t.Errorf("AssumeRole(%s, external ID %q, session %q), want %s with the record's external ID and session %s",
+			aws.ToString(input.RoleArn), aws.ToString(input.ExternalId), aws.ToString(input.RoleSessionName), dns.RoleARN, roleSessionName)
+	}
+
+	// The role's clients are answered like the default ones.
+	fake.on("ListHostedZonesByName", hostedZones(map[string]string{"example.com.": "Z1"}))
+	if _, err := client.ListHostedZonesByName(context.Background(), &route53.ListHostedZonesByNameInput{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegionalACMClients(t *testing.T) {
+	fake := newFakeAWS(t)
+	useAccounts(t, fake)
+	base := fake.acm()
+	if acmFor(base, RecordConfig{RecordName: "home.example.com"}) != base {
+		t.Error("a record without a profile, role or cert_region should use the default client")
+	}
+
+	record := RecordConfig{RecordName: "home.example.com", CertRegion: "eu-west-1"}
+	regional := acmFor(base, record)
+	if regional == base {
+		t.Fatal("a record with a cert_region should not use the default client")
+	}
+	if got := regional.Options().Region; got != "eu-west-1" {
+		t.Errorf("cert_region client is in %s, want eu-west-1", got)
+	}
+	if acmFor(base, record) != regional {
+		t.Error("the cert_region client should be reused")
+	}
+	if got := acmFor(base, RecordConfig{RecordName: "home.example.com", CertRegion: "us-east-1"}).Options().Region; got != "us-east-1" {
+		t.Errorf("a cert_region of the default region uses a client in %s", got)
+	}
+
+	role := RecordConfig{RecordName: "home.example.com", RoleARN: "arn:aws:iam::222222222222:role/dns", CertRegion: "eu-west-1"}
+	if client := acmFor(base, role); client == regional || client.Options().Region != "eu-west-1" {
+		t.Error("a role's cert_region client should be its own, in the cert_region")
+	}
+}
+
+func TestLoadCertArnChecksAccount(t *testing.T) {
+	const (
+		ours   = "arn:aws:acm:us-east-1:111111111111:certificate/ours"
+		theirs = "arn:aws:acm:us-east-1:222222222222:certificate/theirs"
+	)
+	tests := []struct {
+		name      string
+		stored    string
+		accountID string
+		dryRun    bool
+		want      string
+		wantKept  string
+	}{
+		{name: "same account", stored: ours, accountID: "111111111111", want: ours, wantKept: ours},
+		{name: "unknown account", stored: theirs, want: theirs, wantKept: theirs},
+		{name: "other account", stored: theirs, accountID: "111111111111", want: "", wantKept: ""},
+		{name: "other account in dry run", stored: theirs, accountID: "111111111111", dryRun: true, want: "", wantKept: theirs},
+		{name: "nothing stored", accountID: "111111111111"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			key := certStateFile("home.example.com")
+			if tt.stored != "" {
+				if err := storeString(key, tt.stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+			appConfig := &AppConfig{AWSAccountID: tt.accountID, DryRun: tt.dryRun}
+			if got := loadCertArn(appConfig, key, "home.example.com"); got != tt.want {
+				t.Errorf("loadCertArn() = %q, want %q", got, tt.want)
+			}
+			kept, err := getStoredString(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if kept != tt.wantKept {
+				t.Errorf("stored ARN is %q afterwards, want %q", kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,422 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"golang.org/x/crypto/acme"
+)
+
+// --- ACME Certificates ---
+
+const (
+	certBackendACM  = "acm"
+	certBackendACME = "acme"
+
+	defaultACMEDirectoryURL = "https://acme-v02.api.letsencrypt.org/directory"
+	defaultACMECertDir      = "data/certs"
+
+	// acmeRenewBefore is how long before expiry an ACME certificate is
+	// renewed, a third of the 90-day lifetime of Let's Encrypt certificates.
+	acmeRenewBefore  = 30 * 24 * time.Hour
+	acmeChallengeTTL = 60
+
+	acmeAccountKeyFile = "account.key"
+	acmePrivateKeyFile = "privkey.pem"
+	acmeFullChainFile  = "fullchain.pem"
+
+	// acmeCertificateIssued is the status shown for a stored certificate,
+	// named like the ACM one.
+	acmeCertificateIssued = "ISSUED"
+)
+
+// acmeCertDir is the directory the record's key and certificate chain are
+// written to, under ACME_CERT_DIR.
+func acmeCertDir(appConfig *AppConfig, record RecordConfig) string {
+	return filepath.Join(appConfig.ACMECertDir, strings.ReplaceAll(certStateKey(record), "*", "wildcard"))
+}
+
+// loadPEMCertificate parses the first certificate of a PEM file, returning
+// nil if the file does not exist.
+func loadPEMCertificate(path string) (*x509.Certificate, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("%s does not contain a PEM certificate", path)
+	}
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// writeFileAtomic replaces path with data, so that a server reading the
+// file never sees it half written.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := stageFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	return os.Rename(tmp, path)
+}
+
+// stageFile writes data to a temporary file next to path, ready to be
+// renamed over it, and returns the temporary file's name.
+func stageFile(path string, data []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		return "", err
+	}
+	if _, err := tmp.Write(data); err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// acmeAccountKey loads the ACME account key from ACME_CERT_DIR, creating it
+// on first use.
+func acmeAccountKey(appConfig *AppConfig) (crypto.Signer, error) {
+	path := filepath.Join(appConfig.ACMECertDir, acmeAccountKeyFile)
+	data, err := os.ReadFile(path)
+	if err == nil {
+		block, _ := pem.Decode(data)
+		if block == nil {
+			return nil, fmt.Errorf("%s does not contain a PEM key", path)
+		}
+		return x509.ParseECPrivateKey(block.Bytes)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(appConfig.ACMECertDir, 0700); err != nil {
+		return nil, err
+	}
+	if err := writeFileAtomic(path, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		return nil, fmt.Errorf("failed to store ACME account key: %w", err)
+	}
+	slog.Info("Created ACME account key", "component", "acme", "path", path)
+	return key, nil
+}
+
+// newACMEClient returns a client for ACME_DIRECTORY_URL, registering the
+// account if the server does not know it yet.
+func newACMEClient(ctx context.Context, appConfig *AppConfig) (*acme.Client, error) {
+	key, err := acmeAccountKey(appConfig)
+	if err != nil {
+		return nil, err
+	}
+	client := &acme.Client{
+		Key:          key,
+		DirectoryURL: appConfig.ACMEDirectoryURL,
+		UserAgent:    "auto-route53",
+		HTTPClient: &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: clientTLSConfig(appConfig.RootCAs),
+		}},
+	}
+	// Looking the account up first spares the server a registration on
+	// every pass; only a key it does not know is registered.
+	if existing, err := client.GetReg(ctx, ""); err == nil {
+		client.KID = acme.KeyID(existing.URI)
+		return client, nil
+	} else if !errors.Is(err, acme.ErrNoAccount) {
+		return nil, fmt.Errorf("failed to look up ACME account: %w", err)
+	}
+	account := &acme.Account{}
+	if appConfig.ACMEEmail != "" {
+		account.Contact = []string{"mailto:" + appConfig.ACMEEmail}
+	}
+	if _, err := client.Register(ctx, account, acme.AcceptTOS); err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, fmt.Errorf("failed to register ACME account: %w", err)
+	}
+	return client, nil
+}
+
+// certificateKey generates a private key of the record's key algorithm.
+func certificateKey(record RecordConfig) (crypto.Signer, error) {
+	switch certKeyAlgorithm(record) {
+	case acmtypes.KeyAlgorithmEcPrime256v1:
+		return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	case acmtypes.KeyAlgorithmEcSecp384r1:
+		return ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	default:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	}
+}
+
+// changeChallengeRecord creates or deletes the TXT record set of a DNS-01
+// challenge. A created record is waited for until it reaches every Route53
+// name server, whatever WAIT_FOR_INSYNC says, since the ACME server looks it
+// up straight away.
+func changeChallengeRecord(ctx context.Context, appConfig *AppConfig, client *route53.Client, zoneID, name string, values []string, action r53types.ChangeAction) error {
+	records := make([]r53types.ResourceRecord, 0, len(values))
+	for _, value := range values {
+		records = append(records, r53types.ResourceRecord{Value: aws.String(quoteTXT(value))})
+	}
+	challengeConfig := *appConfig
+	challengeConfig.WaitForInsync = action != r53types.ChangeActionDelete
+	err := applyChangeBatch(ctx, &challengeConfig, client, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &r53types.ChangeBatch{
+			Comment: aws.String("ACME DNS-01 challenge"),
+			Changes: []r53types.Change{{
+				Action: action,
+				ResourceRecordSet: &r53types.ResourceRecordSet{
+					Name:            aws.String(name),
+					Type:            r53types.RRTypeTxt,
+					TTL:             aws.Int64(acmeChallengeTTL),
+					ResourceRecords: records,
+				},
+			}},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to %s challenge record %s: %w", strings.ToLower(string(action)), name, err)
+	}
+	return nil
+}
+
+// manageACMECertificate obtains a certificate for the record's names from
+// the ACME server using DNS-01 challenges in Route53, and writes the private
+// key and certificate chain to the record's directory under ACME_CERT_DIR.
+// An existing certificate is kept until it is within acmeRenewBefore of
+// expiring or no longer covers the record's names.
+func manageACMECertificate(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, record RecordConfig) error {
+	names := certificateNames(record)
+	dir := acmeCertDir(appConfig, record)
+	logger := slog.With("component", "acme", "domain", record.RecordName)
+
+	cert, err := loadPEMCertificate(filepath.Join(dir, acmeFullChainFile))
+	if err != nil {
+		logger.Warn("Could not read the stored certificate, requesting a new one", "error", err)
+	}
+	if cert != nil {
+		certExpiry.WithLabelValues(record.RecordName).Set(float64(cert.NotAfter.Unix()))
+		putCertificateExpiryMetric(appConfig, record.RecordName, cert.NotAfter)
+		status.recordCertificate(record.RecordName, "", acmeCertificateIssued, cert.NotAfter)
+		if coversNames(cert.DNSNames, names) && time.Until(cert.NotAfter) > acmeRenewBefore {
+			logger.Info("Certificate is valid, skipping", "expires", cert.NotAfter.Format(time.RFC3339))
+			return nil
+		}
+		logger.Info("Certificate is due for renewal or does not cover every name", "expires", cert.NotAfter.Format(time.RFC3339))
+	}
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would request a certificate from the ACME server with DNS-01 validation", "names", names, "directory", appConfig.ACMEDirectoryURL, "dir", dir)
+		return nil
+	}
+
+	client, err := newACMEClient(ctx, appConfig)
+	if err != nil {
+		return err
+	}
+	order, err := client.AuthorizeOrder(ctx, acme.DomainIDs(names...))
+	if err != nil {
+		return fmt.Errorf("failed to create ACME order: %w", err)
+	}
+
+	// A name and its wildcard share one challenge record name, so the
+	// values are collected per record name.
+	challengeValues := map[string][]string{}
+	var challenges []*acme.Challenge
+	var authzURLs []string
+	for _, authzURL := range order.AuthzURLs {
+		authz, err := client.GetAuthorization(ctx, authzURL)
+		if err != nil {
+			return fmt.Errorf("failed to get ACME authorization: %w", err)
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+		var challenge *acme.Challenge
+		for _, c := range authz.Challenges {
+			if c.Type == "dns-01" {
+				challenge = c
+				break
+			}
+		}
+		if challenge == nil {
+			return fmt.Errorf("the ACME server offers no dns-01 challenge for %s", authz.Identifier.Value)
+		}
+		value, err := client.DNS01ChallengeRecord(challenge.Token)
+		if err != nil {
+			return err
+		}
+		name := "_acme-challenge." + strings.TrimPrefix(authz.Identifier.Value, "*.")
+		challengeValues[name] = append(challengeValues[name], value)
+		challenges = append(challenges, challenge)
+		authzURLs = append(authzURLs, authzURL)
+	}
+
+	challengeZones := map[string]string{}
+	defer func() {
+		// Clean up even after a shutdown request, with a context of its own.
+		cleanupCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+		defer cancel()
+		for name, zoneID := range challengeZones {
+			if err := changeChallengeRecord(cleanupCtx, appConfig, r53Client, zoneID, name, challengeValues[name], r53types.ChangeActionDelete); err != nil {
+				logger.Warn("Failed to remove challenge record", "record", name, "zone_id", zoneID, "error", err)
+			}
+		}
+	}()
+	for _, name := range slices.Sorted(maps.Keys(challengeValues)) {
+		zoneID := resolveValidationZone(ctx, r53Client, record, name)
+		logger.Info("Creating challenge record", "record", name, "zone_id", zoneID)
+		if err := changeChallengeRecord(ctx, appConfig, r53Client, zoneID, name, challengeValues[name], r53types.ChangeActionUpsert); err != nil {
+			return err
+		}
+		challengeZones[name] = zoneID
+	}
+
+	validationCtx, cancel := context.WithTimeout(ctx, appConfig.CertValidationWait)
+	defer cancel()
+	for i, challenge := range challenges {
+		if _, err := client.Accept(validationCtx, challenge); err != nil {
+			return fmt.Errorf("failed to accept ACME challenge: %w", err)
+		}
+		if _, err := client.WaitAuthorization(validationCtx, authzURLs[i]); err != nil {
+			if ctx.Err() == nil && validationCtx.Err() != nil {
+				notify(ctx, appConfig, notifyEvent{
+					Type:    eventCertTimeout,
+					Record:  record.RecordName,
+					Message: fmt.Sprintf("Certificate for %s was not validated within %s", record.RecordName, appConfig.CertValidationWait),
+				})
+			}
+			return fmt.Errorf("ACME authorization failed: %w", err)
+		}
+	}
+	order, err = client.WaitOrder(validationCtx, order.URI)
+	if err != nil {
+		return fmt.Errorf("ACME order did not become ready: %w", err)
+	}
+
+	key, err := certificateKey(record)
+	if err != nil {
+		return err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: names[0]},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create certificate request: %w", err)
+	}
+	chain, _, err := client.CreateOrderCert(validationCtx, order.FinalizeURL, csr, true)
+	if err != nil {
+		return fmt.Errorf("failed to finalize ACME order: %w", err)
+	}
+	if err := writeACMECertificate(dir, key, chain); err != nil {
+		return err
+	}
+	details := map[string]string{"dir": dir}
+	if leaf, err := x509.ParseCertificate(chain[0]); err == nil {
+		certExpiry.WithLabelValues(record.RecordName).Set(float64(leaf.NotAfter.Unix()))
+		putCertificateExpiryMetric(appConfig, record.RecordName, leaf.NotAfter)
+		status.recordCertificate(record.RecordName, "", acmeCertificateIssued, leaf.NotAfter)
+		logger.Info("Certificate issued", "dir", dir, "expires", leaf.NotAfter.Format(time.RFC3339))
+		details["expires"] = leaf.NotAfter.Format(time.RFC3339)
+	}
+	certRequests.WithLabelValues(record.RecordName).Inc()
+	notify(ctx, appConfig, notifyEvent{
+		Type:    eventCertIssued,
+		Record:  record.RecordName,
+		Message: "Certificate issued for " + record.RecordName,
+		Details: details,
+	})
+	return nil
+}
+
+// writeACMECertificate writes the private key and the certificate chain,
+// leaf first, as privkey.pem and fullchain.pem. Both are written to
+// temporary files first and then renamed over the live ones, the chain
+// last, so a server reloading meanwhile always finds a certificate and a
+// failed write leaves the old pair in place.
+func writeACMECertificate(dir string, key crypto.Signer, chain [][]byte) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	var fullChain []byte
+	for _, cert := range chain {
+		fullChain = append(fullChain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert})...)
+	}
+	keyPath, chainPath := filepath.Join(dir, acmePrivateKeyFile), filepath.Join(dir, acmeFullChainFile)
+	keyTmp, err := stageFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	defer os.Remove(keyTmp)
+	chainTmp, err := stageFile(chainPath, fullChain, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write certificate chain: %w", err)
+	}
+	defer os.Remove(chainTmp)
+	if err := os.Rename(keyTmp, keyPath); err != nil {
+		return fmt.Errorf("failed to write private key: %w", err)
+	}
+	if err := os.Rename(chainTmp, chainPath); err != nil {
+		return fmt.Errorf("failed to write certificate chain: %w", err)
+	}
+	return nil
+}
+
+// reportACMECertificate logs whether the record has a certificate on disk
+// and when it expires, without contacting the ACME server.
+func reportACMECertificate(appConfig *AppConfig, record RecordConfig) error {
+	path := filepath.Join(acmeCertDir(appConfig, record), acmeFullChainFile)
+	cert, err := loadPEMCertificate(path)
+	if err != nil {
+		return err
+	}
+	if cert == nil {
+		slog.Info("REPORT: No certificate found", "component", "acme", "domain", record.RecordName, "path", path)
+		return nil
+	}
+	certExpiry.WithLabelValues(record.RecordName).Set(float64(cert.NotAfter.Unix()))
+	status.recordCertificate(record.RecordName, "", acmeCertificateIssued, cert.NotAfter)
+	slog.Info("REPORT: Certificate", "component", "acme", "domain", record.RecordName, "path", path, "expires", cert.NotAfter.Format(time.RFC3339), "covers_names", coversNames(cert.DNSNames, certificateNames(record)))
+	return nil
+}
@@ -0,0 +1,468 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"golang.org/x/crypto/acme"
+)
+
+// acmeServer is a stub ACME directory for one account. It checks no
+// signatures and validates every accepted challenge at once, as valid or,
+// with failAuthorizations set, as invalid.
+type acmeServer struct {
+	*httptest.Server
+	failAuthorizations bool
+
+	caKey *ecdsa.PrivateKey
+	ca    *x509.Certificate
+
+	nonce atomic.Int64
+
+	mu       sync.Mutex
+	accounts int
+	orders   int
+	authzs   []*stubAuthz
+	issued   []byte
+}
+
+type stubAuthz struct {
+	value    string
+	wildcard bool
+	token    string
+	accepted bool
+}
+
+func newACMEServer(t *testing.T) *acmeServer {
+	t.Helper()
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Stub ACME CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &acmeServer{caKey: caKey, ca: ca}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /directory", s.directory)
+	mux.HandleFunc("/nonce", func(w http.ResponseWriter, r *http.Request) { s.setNonce(w) })
+	mux.HandleFunc("POST /account", s.account)
+	mux.HandleFunc("POST /order", s.newOrder)
+	mux.HandleFunc("POST /order/1", s.order)
+	mux.HandleFunc("POST /authz/{i}", s.authorization)
+	mux.HandleFunc("POST /challenge/{i}", s.challenge)
+	mux.HandleFunc("POST /finalize", s.finalize)
+	mux.HandleFunc("POST /cert", s.certificate)
+	s.Server = httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *acmeServer) setNonce(w http.ResponseWriter) {
+	w.Header().Set("Replay-Nonce", strconv.FormatInt(s.nonce.Add(1), 10))
+	w.Header().Set("Cache-Control", "no-store")
+}
+
+func (s *acmeServer) reply(w http.ResponseWriter, code int, location string, body any) {
+	s.setNonce(w)
+	if location != "" {
+		w.Header().Set("Location", s.URL+location)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+// payload decodes the payload of the JWS in the request body into v.
+func payload(r *http.Request, v any) error {
+	var jws struct{ Payload string }
+	if err := json.NewDecoder(r.Body).Decode(&jws); err != nil {
+		return err
+	}
+	data, err := base64.RawURLEncoding.DecodeString(jws.Payload)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func (s *acmeServer) directory(w http.ResponseWriter, r *http.Request) {
+	s.reply(w, http.StatusOK, "", map[string]string{
+		"newNonce":   s.URL + "/nonce",
+		"newAccount": s.URL + "/account",
+		"newOrder":   s.URL + "/order",
+	})
+}
+
+func (s *acmeServer) account(w http.ResponseWriter, r *http.Request) {
+	var req struct{ OnlyReturnExisting bool }
+	if err := payload(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case req.OnlyReturnExisting && s.accounts == 0:
+		s.reply(w, http.StatusBadRequest, "", map[string]string{"type": "urn:ietf:params:acme:error:accountDoesNotExist", "detail": "no such account"})
+	case req.OnlyReturnExisting:
+		s.reply(w, http.StatusOK, "/account/1", map[string]string{"status": "valid"})
+	default:
+		s.accounts++
+		s.reply(w, http.StatusCreated, "/account/1", map[string]string{"status": "valid"})
+	}
+}
+
+func (s *acmeServer) newOrder(w http.ResponseWriter, r *http.Request) {
+	var req struct{ Identifiers []struct{ Value string } }
+	if err := payload(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.orders++
+	s.authzs, s.issued = nil, nil
+	for i, id := range req.Identifiers {
+		value, wildcard := id.Value, false
+		if len(value) > 2 && value[:2] == "*." {
+			value, wildcard = value[2:], true
+		}
+		s.authzs = append(s.authzs, &stubAuthz{value: value, wildcard: wildcard, token: fmt.Sprintf("token-%d-%d", s.orders, i)})
+	}
+	s.reply(w, http.StatusCreated, "/order/1", s.orderBody())
+}
+
+// orderBody describes the current order; s.mu must be held.
+func (s *acmeServer) orderBody() map[string]any {
+	order := map[string]any{"status": "pending", "finalize": s.URL + "/finalize"}
+	var urls []string
+	ready := true
+	for i, authz := range s.authzs {
+		urls = append(urls, fmt.Sprintf("%s/authz/%d", s.URL, i))
+		ready = ready && authz.accepted && !s.failAuthorizations
+	}
+	order["authorizations"] = urls
+	switch {
+	case s.issued != nil:
+		order["status"], order["certificate"] = "valid", s.URL+"/cert"
+	case ready:
+		order["status"] = "ready"
+	}
+	return order
+}
+
+func (s *acmeServer) order(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reply(w, http.StatusOK, "/order/1", s.orderBody())
+}
+
+// stubAuthzFor returns the authorization the {i} of the request path names;
+// s.mu must be held.
+func (s *acmeServer) stubAuthzFor(w http.ResponseWriter, r *http.Request) (int, *stubAuthz) {
+	i, err := strconv.Atoi(r.PathValue("i"))
+	if err != nil || i < 0 || i >= len(s.authzs) {
+		http.NotFound(w, r)
+		return 0, nil
+	}
+	return i, s.authzs[i]
+}
+
+func (s *acmeServer) challengeBody(i int, authz *stubAuthz) map[string]string {
+	return map[string]string{"type": "dns-01", "url": fmt.Sprintf("%s/challenge/%d", s.URL, i), "token": authz.token, "status": "pending"}
+}
+
+func (s *acmeServer) authorization(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, authz := s.stubAuthzFor(w, r)
+	if authz == nil {
+		return
+	}
+	status := "pending"
+	if authz.accepted && s.failAuthorizations {
+		status = "invalid"
+	} else if authz.accepted {
+		status = "valid"
+	}
+	s.reply(w, http.StatusOK, "", map[string]any{
+		"status":     status,
+		"identifier": map[string]string{"type": "dns", "value": authz.value},
+		"wildcard":   authz.wildcard,
+		"challenges": []map[string]string{
+			{"type": "http-01", "url": s.URL + "/unused", "token": "unused", "status": "pending"},
+			s.challengeBody(i, authz),
+		},
+	})
+}
+
+func (s *acmeServer) challenge(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, authz := s.stubAuthzFor(w, r)
+	if authz == nil {
+		return
+	}
+	authz.accepted = true
+	s.reply(w, http.StatusOK, "", s.challengeBody(i, authz))
+}
+
+func (s *acmeServer) finalize(w http.ResponseWriter, r *http.Request) {
+	var req struct{ CSR string }
+	if err := payload(r, &req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	der, err := base64.RawURLEncoding.DecodeString(req.CSR)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	leaf, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(int64(s.orders + 1)),
+		Subject:      csr.Subject,
+		DNSNames:     csr.DNSNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(90 * 24 * time.Hour),
+	}, s.ca, csr.PublicKey, s.caKey)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	s.issued = leaf
+	s.reply(w, http.StatusOK, "/order/1", s.orderBody())
+}
+
+func (s *acmeServer) certificate(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setNonce(w)
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.issued})
+	pem.Encode(w, &pem.Block{Type: "CERTIFICATE", Bytes: s.ca.Raw})
+}
+
+// onChallengeRecords answers the Route53 calls of DNS-01 challenges in
+// example.com and returns the change batches sent.
+func onChallengeRecords(fake *fakeAWS) func() []*route53.ChangeResourceRecordSetsInput {
+	fake.on("ListHostedZonesByName", hostedZones(map[string]string{"example.com.": "Z1"}))
+	var mu sync.Mutex
+	var sent []*route53.ChangeResourceRecordSetsInput
+	fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, input.(*route53.ChangeResourceRecordSetsInput))
+		return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+	})
+	fake.on("GetChange", func(any) (any, error) {
+		return &route53.GetChangeOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1"), Status: r53types.ChangeStatusInsync}}, nil
+	})
+	return func() []*route53.ChangeResourceRecordSetsInput {
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(sent)
+	}
+}
+
+func acmeTestConfig(t *testing.T, server *acmeServer) *AppConfig {
+	t.Helper()
+	resetHostedZoneCache(t)
+	return &AppConfig{
+		ACMEDirectoryURL:   server.URL + "/directory",
+		ACMECertDir:        t.TempDir(),
+		InsyncTimeout:      time.Minute,
+		CertValidationWait: time.Minute,
+	}
+}
+
+// challengeChange returns the only change of a batch, which must be for the
+// challenge record of example.com.
+func challengeChange(t *testing.T, batch *route53.ChangeResourceRecordSetsInput) r53types.Change {
+	t.Helper()
+	if len(batch.ChangeBatch.Changes) != 1 {
+		t.Fatalf("challenge batch has %d changes, want 1", len(batch.ChangeBatch.Changes))
+	}
+	change := batch.ChangeBatch.Changes[0]
+	if name := aws.ToString(change.ResourceRecordSet.Name); name != "_acme-challenge.example.com" || change.ResourceRecordSet.Type != r53types.RRTypeTxt {
+		t.Errorf("challenge record is %s %s, want TXT _acme-challenge.example.com", change.ResourceRecordSet.Type, name)
+	}
+	if zone := aws.ToString(batch.HostedZoneId); zone != "Z1" {
+		t.Errorf("challenge record changed in zone %s, want Z1", zone)
+	}
+	return change
+}
+
+func TestACMECertificateIssued(t *testing.T) {
+	server := newACMEServer(t)
+	fake := newFakeAWS(t)
+	sent := onChallengeRecords(fake)
+	appConfig := acmeTestConfig(t, server)
+	// The apex and its wildcard share one challenge record.
+	record := RecordConfig{RecordName: "example.com", SubjectAlternativeNames: []string{"*.example.com"}, ZoneID: "Z1"}
+
+	if err := manageACMECertificate(context.Background(), appConfig, fake.route53(), record); err != nil {
+		t.Fatal(err)
+	}
+	batches := sent()
+	if len(batches) != 2 {
+		t.Fatalf("sent %d challenge changes, want an UPSERT and a DELETE", len(batches))
+	}
+	upsert, cleanup := challengeChange(t, batches[0]), challengeChange(t, batches[1])
+	if upsert.Action != r53types.ChangeActionUpsert || cleanup.Action != r53types.ChangeActionDelete {
+		t.Fatalf("challenge changes are %s then %s, want UPSERT then DELETE", upsert.Action, cleanup.Action)
+	}
+	key, err := acmeAccountKey(appConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &acme.Client{Key: key}
+	var want []string
+	server.mu.Lock()
+	authzs := server.authzs
+	server.mu.Unlock()
+	for _, authz := range authzs {
+		value, err := client.DNS01ChallengeRecord(authz.token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, quoteTXT(value))
+	}
+	if got := recordSetValues(upsert.ResourceRecordSet); !slices.Equal(got, want) {
+		t.Errorf("challenge record holds %q, want the values of both authorizations %q", got, want)
+	}
+	if got := recordSetValues(cleanup.ResourceRecordSet); !slices.Equal(got, want) {
+		t.Errorf("the DELETE names %q, not the values created %q, so Route53 would reject it", got, want)
+	}
+
+	dir := acmeCertDir(appConfig, record)
+	cert, err := loadPEMCertificate(filepath.Join(dir, acmeFullChainFile))
+	if err != nil || cert == nil {
+		t.Fatalf("no certificate written: %v", err)
+	}
+	if !coversNames(cert.DNSNames, certificateNames(record)) {
+		t.Errorf("certificate covers %q, want %q", cert.DNSNames, certificateNames(record))
+	}
+	if _, err := tls.LoadX509KeyPair(filepath.Join(dir, acmeFullChainFile), filepath.Join(dir, acmePrivateKeyFile)); err != nil {
+		t.Errorf("privkey.pem does not go with fullchain.pem: %v", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var files []string
+	for _, entry := range entries {
+		files = append(files, entry.Name())
+	}
+	if !slices.Equal(files, []string{acmeFullChainFile, acmePrivateKeyFile}) {
+		t.Errorf("certificate directory holds %q, want only the key and chain", files)
+	}
+
+	// The certificate is still valid, so the next pass orders nothing, and
+	// a new client reuses the registered account.
+	if err := manageACMECertificate(context.Background(), appConfig, fake.route53(), record); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newACMEClient(context.Background(), appConfig); err != nil {
+		t.Fatal(err)
+	}
+	server.mu.Lock()
+	defer server.mu.Unlock()
+	if server.orders != 1 || server.accounts != 1 {
+		t.Errorf("the server saw %d orders and %d registrations, want one of each", server.orders, server.accounts)
+	}
+}
+
+func TestACMECertificateCleanupOnFailure(t *testing.T) {
+	server := newACMEServer(t)
+	server.failAuthorizations = true
+	fake := newFakeAWS(t)
+	sent := onChallengeRecords(fake)
+	appConfig := acmeTestConfig(t, server)
+	record := RecordConfig{RecordName: "example.com", ZoneID: "Z1"}
+
+	if err := manageACMECertificate(context.Background(), appConfig, fake.route53(), record); err == nil {
+		t.Fatal("manageACMECertificate() succeeded with an invalid authorization")
+	}
+	batches := sent()
+	if len(batches) != 2 {
+		t.Fatalf("sent %d challenge changes, want an UPSERT and a DELETE", len(batches))
+	}
+	if action := challengeChange(t, batches[1]).Action; action != r53types.ChangeActionDelete {
+		t.Errorf("last challenge change is %s, want the record deleted", action)
+	}
+	if _, err := os.Stat(filepath.Join(acmeCertDir(appConfig, record), acmeFullChainFile)); !os.IsNotExist(err) {
+		t.Errorf("a certificate was written for a failed order: %v", err)
+	}
+}
+
+func TestACMEChallengeIsAudited(t *testing.T) {
+	server := newACMEServer(t)
+	fake := newFakeAWS(t)
+	onChallengeRecords(fake)
+	appConfig := acmeTestConfig(t, server)
+	appConfig.AuditLog = filepath.Join(t.TempDir(), "audit.jsonl")
+	record := RecordConfig{RecordName: "example.com", ZoneID: "Z1"}
+
+	if err := manageACMECertificate(context.Background(), appConfig, fake.route53(), record); err != nil {
+		t.Fatal(err)
+	}
+	entries := readAuditLog(t, appConfig.AuditLog)
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want the challenge record's creation and deletion", len(entries))
+	}
+	created, deleted := entries[0], entries[1]
+	for _, entry := range entries {
+		if entry.Action != auditRoute53Change || entry.Record != "_acme-challenge.example.com" || entry.ZoneID != "Z1" || entry.Outcome != "success" {
+			t.Errorf("audit entry = %+v, want a successful change of _acme-challenge.example.com in Z1", entry)
+		}
+	}
+	if len(created.New) != 1 || len(created.Old) != 0 || !slices.Equal(deleted.Old, created.New) || len(deleted.New) != 0 {
+		t.Errorf("audit entries have new %q then old %q, want the challenge value created and then deleted", created.New, deleted.Old)
+	}
+}
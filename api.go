@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+)
+
+// --- REST API ---
+
+// The reasons a requested action is refused.
+var (
+	errStandby        = errors.New("this instance is a standby, send the request to the leader")
+	errNotConfigured  = errors.New("not configured")
+	errSyncRunning    = errors.New("a sync of the record is already running")
+	errInvalidRequest = errors.New("invalid request")
+)
+
+// controller carries out the actions requested through the API.
+type controller struct {
+	ctx       context.Context
+	r53Client *route53.Client
+	tasks     *recordTasks
+	// wakeDDNS starts the next DDNS cycle now, as req asks.
+	wakeDDNS func(req ddnsRequest)
+	// syncing holds the records a manual sync is running for.
+	syncing sync.Map
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(body)
+}
+
+func writeAPIError(w http.ResponseWriter, code int, message string) {
+	writeJSON(w, code, map[string]string{"error": message})
+}
+
+// authorized checks the bearer token of a request that triggers an action.
+// Actions are refused while API_TOKEN is unset.
+func authorized(w http.ResponseWriter, r *http.Request) bool {
+	token := currentConfig.Load().APIToken
+	if token == "" {
+		writeAPIError(w, http.StatusForbidden, "set API_TOKEN to enable the API actions")
+		return false
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="auto-route53"`)
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid API token")
+		return false
+	}
+	return true
+}
+
+// registerAPI adds the API endpoints to mux. Actions run in the background:
+// the response only says they started.
+func (c *controller) registerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		snap := status.snapshot()
+		snap.Config = effectiveConfig(currentConfig.Load())
+		writeJSON(w, http.StatusOK, snap)
+	})
+	mux.HandleFunc("POST /check", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) && c.respond(w, c.check(), "") {
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "check started"})
+		}
+	})
+	mux.HandleFunc("POST /records/{name}/sync", func(w http.ResponseWriter, r *http.Request) {
+		name := canonicalName(r.PathValue("name"))
+		if authorized(w, r) && c.respond(w, c.syncRecord(name), "no record named "+name+" is configured") {
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "sync started", "record": name})
+		}
+	})
+	mux.HandleFunc("POST /certs/{domain}/reconcile", func(w http.ResponseWriter, r *http.Request) {
+		domain := canonicalName(r.PathValue("domain"))
+		if authorized(w, r) && c.respond(w, c.reconcileCertificate(domain), "no certificate task for "+domain+" is running") {
+			writeJSON(w, http.StatusAccepted, map[string]string{"status": "reconcile started", "domain": domain})
+		}
+	})
+	mux.HandleFunc("GET /trigger", c.serveTrigger)
+	mux.HandleFunc("POST /trigger", c.serveTrigger)
+	mux.HandleFunc("GET /nic/update", c.serveDynDNS)
+}
+
+// respond writes the error response for a refused action, with notFound as
+// the message for errNotConfigured. It reports whether err is nil.
+func (c *controller) respond(w http.ResponseWriter, err error, notFound string) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, errNotConfigured):
+		writeAPIError(w, http.StatusNotFound, notFound)
+	case errors.Is(err, errInvalidRequest):
+		writeAPIError(w, http.StatusBadRequest, err.Error())
+	case errors.Is(err, errSyncRunning):
+		writeAPIError(w, http.StatusConflict, err.Error())
+	case errors.Is(err, errStandby):
+		writeAPIError(w, http.StatusServiceUnavailable, err.Error())
+	default:
+		writeAPIError(w, http.StatusInternalServerError, err.Error())
+	}
+	return false
+}
+
+// The actions below are shared by the REST and gRPC APIs. They are only
+// carried out by the leader.
+
+// check starts a DDNS cycle now.
+func (c *controller) check() error {
+	if status.snapshot().Standby {
+		return errStandby
+	}
+	c.wakeDDNS(ddnsRequest{})
+	return nil
+}
+
+// syncRecord starts writing the current address to the records named name.
+func (c *controller) syncRecord(name string) error {
+	if status.snapshot().Standby {
+		return errStandby
+	}
+	appConfig := currentConfig.Load()
+	var records []RecordConfig
+	for _, record := range appConfig.RecordsToUpdate {
+		if canonicalName(record.RecordName) == name {
+			records = append(records, record)
+		}
+	}
+	if len(records) == 0 {
+		return errNotConfigured
+	}
+	if _, running := c.syncing.LoadOrStore(name, true); running {
+		return errSyncRunning
+	}
+	go func() {
+		defer c.syncing.Delete(name)
+		c.syncRecords(appConfig, records)
+	}()
+	return nil
+}
+
+// reconcileCertificate starts the certificate check of domain.
+func (c *controller) reconcileCertificate(domain string) error {
+	if status.snapshot().Standby {
+		return errStandby
+	}
+	if !c.tasks.reconcile(domain) {
+		return errNotConfigured
+	}
+	return nil
+}
+
+// syncRecords writes the current address to the given records, whether or
+// not it changed. It compares against the live records rather than the
+// stored address, and does not store it, so that the records the sync leaves
+// out are still updated by the next cycle if the address changed. Unlike a
+// cycle, it neither pings the heartbeat nor flushes CloudWatch metrics.
+func (c *controller) syncRecords(appConfig *AppConfig, records []RecordConfig) {
+	syncConfig := *appConfig
+	syncConfig.RecordsToUpdate = records
+	syncConfig.Stateless = true
+	ctx := withCycle(context.WithoutCancel(c.ctx), newCycleID())
+	loggerFrom(ctx).Info("Manual sync requested", "record", records[0].RecordName)
+	if err := syncAllRecords(ctx, &syncConfig, c.r53Client, ddnsRequest{force: true}); err != nil {
+		slog.Error("Manual sync failed", "component", "api", "record", records[0].RecordName, "error", err)
+	}
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+// useConfig makes appConfig the configuration in effect for the test.
+func useConfig(t *testing.T, appConfig *AppConfig) {
+	t.Helper()
+	old := currentConfig.Load()
+	currentConfig.Store(appConfig)
+	t.Cleanup(func() { currentConfig.Store(old) })
+}
+
+func TestStatusRedactsConfig(t *testing.T) {
+	const (
+		apiToken      = "api-token-1234"
+		npmIdentity   = "admin@example.com"
+		npmSecret     = "npm-secret-5678"
+		webhookToken  = "webhook-token-9012"
+		heartbeatUUID = "d1f1a9b6-heartbeat"
+		dyndnsSecret  = "router-password"
+		externalID    = "external-id-3456"
+		ipCheckToken  = "ipcheck-token-1111"
+		execSecret    = "exec-secret-2222"
+		endpointKey   = "endpoint-key-3333"
+	)
+	useConfig(t, &AppConfig{
+		SleepTime:       5 * time.Minute,
+		RecordsToUpdate: []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com", RoleARN: "arn:aws:iam::222222222222:role/dns", ExternalID: externalID}},
+		NPMBaseURL:      "http://npm:81",
+		NPMIdentity:     npmIdentity,
+		NPMSecret:       npmSecret,
+		APIToken:        apiToken,
+		HeartbeatURL:    "https://hc-ping.com/" + heartbeatUUID,
+		Notifiers:       []notifyTarget{{notifier: &webhookNotifier{url: "https://hooks.example.com/notify?token=" + webhookToken}}},
+		DynDNSUsers:     []dyndnsUser{{Username: "router", Password: dyndnsSecret, Hostnames: []string{"home.example.com"}}},
+		RFC2136Keys:     []tsigKey{{Name: "dhcp.", Algorithm: "hmac-sha256.", Secret: []byte("tsig-secret-7890")}},
+		CertMode:        certModeManage,
+		StateBackend:    stateBackendFile,
+		LeaderElection:  "off",
+		IPv4Sources: []ipSource{
+			&httpIPSource{url: "https://ip.example.com/v1/" + ipCheckToken + "?key=" + ipCheckToken},
+			&execIPSource{command: []string{"/scripts/ip.sh", "--key", execSecret}},
+		},
+		AWSEndpointURL:      "http://localstack:4566/?key=" + endpointKey,
+		AWSServiceEndpoints: endpointOverrides{"Route 53": "https://route53.example.com/" + endpointKey},
+	})
+	mux := http.NewServeMux()
+	(&controller{}).registerAPI(mux)
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/status", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET /status = %d, want 200", rec.Code)
+	}
+	body := rec.Body.String()
+
+	// "dGNpZy1zZWNyZXQtNzg5MA==" is the TSIG secret as base64, as it
+	// would be marshalled.
+	for _, secret := range []string{apiToken, npmIdentity, npmSecret, webhookToken, heartbeatUUID, dyndnsSecret, externalID, ipCheckToken, execSecret, endpointKey, "tsig-secret-7890", "dGNpZy1zZWNyZXQtNzg5MA=="} {
+		if strings.Contains(body, secret) {
+			t.Errorf("GET /status exposes %q", secret)
+		}
+	}
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"config block", `"config":{`},
+		{"plain setting", `"NPM_URL":"http://npm:81"`},
+		{"api token", `"API_TOKEN":"(redacted)"`},
+		{"npm secret", `"NPM_SECRET":"(redacted)"`},
+		{"unset secret", `"HEARTBEAT_FAIL_URL":""`},
+		{"heartbeat host", `"HEARTBEAT_URL":"https://hc-ping.com"`},
+		{"webhook host", `"name":"webhook https://hooks.example.com"`},
+		{"tsig key name", `"hmac-sha256:dhcp.:(redacted)"`},
+		{"dyndns password", `"password":"(redacted)"`},
+		{"record", `"record_name":"home.example.com"`},
+		{"ip check host", `"IP_CHECK_URLS":["https://ip.example.com","exec:/scripts/ip.sh"]`},
+		{"endpoint host", `"AWS_ENDPOINT_URL":"http://localstack:4566"`},
+		{"service endpoint host", `"AWS_ENDPOINT_URL_\u003cSERVICE\u003e":{"Route 53":"https://route53.example.com"}`},
+		{"role arn shown by default", `"role_arn":"arn:aws:iam::222222222222:role/dns"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !strings.Contains(body, tt.want) {
+				t.Errorf("GET /status does not contain %s:\n%s", tt.want, body)
+			}
+		})
+	}
+}
+
+func TestManualSyncComparesLiveRecord(t *testing.T) {
+	const publicIP = "198.41.0.4"
+	tests := []struct {
+		name        string
+		live        string
+		wantChanged bool
+	}{
+		{name: "record already current", live: publicIP},
+		{name: "record out of date", live: "199.9.14.201", wantChanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			var pings atomic.Int32
+			heartbeat := httptest.NewServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) { pings.Add(1) }))
+			defer heartbeat.Close()
+
+			appConfig, target := useNotifier(t)
+			appConfig.IPv4Sources = []ipSource{staticIPSource{publicIP}}
+			appConfig.HeartbeatURL = heartbeat.URL
+			appConfig.RecordsToUpdate = []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com", TTL: 300}}
+
+			fake := newFakeAWS(t)
+			fake.on("ListResourceRecordSets", func(any) (any, error) {
+				return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []r53types.ResourceRecordSet{{
+					Name:            aws.String("home.example.com."),
+					Type:            r53types.RRTypeA,
+					TTL:             aws.Int64(300),
+					ResourceRecords: []r53types.ResourceRecord{{Value: aws.String(tt.live)}},
+				}}}, nil
+			})
+			fake.on("ChangeResourceRecordSets", func(any) (any, error) {
+				return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+			})
+
+			c := &controller{ctx: context.Background(), r53Client: fake.route53()}
+			c.syncRecords(appConfig, appConfig.RecordsToUpdate)
+			if n := fake.count("ChangeResourceRecordSets"); n != 1 {
+				t.Errorf("ChangeResourceRecordSets was called %d times, want the record written once", n)
+			}
+			if n := pings.Load(); n != 0 {
+				t.Errorf("the heartbeat was pinged %d times by a manual sync", n)
+			}
+			var changes []notifyEvent
+			for _, event := range target.sent() {
+				if event.Type == eventIPChanged {
+					changes = append(changes, event)
+				}
+			}
+			if !tt.wantChanged {
+				if len(changes) != 0 {
+					t.Errorf("sent %v, want no ip_changed for a record that already held the address", changes)
+				}
+				return
+			}
+			if len(changes) != 1 || changes[0].Details["previous_ip"] != tt.live {
+				t.Errorf("sent %v, want one ip_changed with the live address as previous_ip", changes)
+			}
+		})
+	}
+}
+
+func TestActionsRequireAPIToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		token    string
+		header   string
+		wantCode int
+		wantGRPC codes.Code
+	}{
+		{name: "API_TOKEN unset", wantCode: http.StatusForbidden, wantGRPC: codes.PermissionDenied},
+		{name: "API_TOKEN unset with a token given", header: "Bearer guess", wantCode: http.StatusForbidden, wantGRPC: codes.PermissionDenied},
+		{name: "no token given", token: "api-token", wantCode: http.StatusUnauthorized, wantGRPC: codes.Unauthenticated},
+		{name: "wrong token", token: "api-token", header: "Bearer guess", wantCode: http.StatusUnauthorized, wantGRPC: codes.Unauthenticated},
+		{name: "right token", token: "api-token", header: "Bearer api-token", wantCode: http.StatusAccepted, wantGRPC: codes.OK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, &AppConfig{APIToken: tt.token, RecordsToUpdate: []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com"}}})
+			var wakes atomic.Int32
+			c := &controller{ctx: context.Background(), wakeDDNS: func(ddnsRequest) { wakes.Add(1) }}
+			mux := http.NewServeMux()
+			c.registerAPI(mux)
+
+			for _, path := range []string{"/check", "/records/home.example.com/sync", "/certs/home.example.com/reconcile"} {
+				if tt.wantCode == http.StatusAccepted && path != "/check" {
+					continue
+				}
+				req := httptest.NewRequest(http.MethodPost, path, nil)
+				if tt.header != "" {
+					req.Header.Set("Authorization", tt.header)
+				}
+				rec := httptest.NewRecorder()
+				mux.ServeHTTP(rec, req)
+				if rec.Code != tt.wantCode {
+					t.Errorf("POST %s = %d, want %d", path, rec.Code, tt.wantCode)
+				}
+			}
+			wantWakes := int32(0)
+			if tt.wantCode == http.StatusAccepted {
+				wantWakes = 1
+			}
+			if got := wakes.Load(); got != wantWakes {
+				t.Errorf("started %d checks, want %d", got, wantWakes)
+			}
+
+			ctx := context.Background()
+			if tt.header != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.header))
+			}
+			if got := grpcstatus.Code(requireToken(ctx)); got != tt.wantGRPC {
+				t.Errorf("requireToken() = %s, want %s", got, tt.wantGRPC)
+			}
+		})
+	}
+}
+
+func TestStatusRedactsARNs(t *testing.T) {
+	const roleARN = "arn:aws:iam::222222222222:role/dns"
+	const listenerARN = "arn:aws:elasticloadbalancing:us-east-1:222222222222:listener/app/web/1/2"
+	config := effectiveConfig(&AppConfig{
+		StatusRedactARNs: true,
+		RecordsToUpdate:  []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com", RoleARN: roleARN, ListenerArns: []string{listenerARN}}},
+	})
+	record := config["RECORDS_TO_UPDATE"].([]RecordConfig)[0]
+	if record.RoleARN != redacted || len(record.ListenerArns) != 1 || record.ListenerArns[0] != redacted {
+		t.Errorf("with STATUS_REDACT_ARNS, the record shows role_arn %q and listener_arns %q", record.RoleARN, record.ListenerArns)
+	}
+}
+
+func TestTriggerWithIP(t *testing.T) {
+	tests := []struct {
+		name        string
+		ip          string
+		trust       bool
+		wantCode    int
+		wantTrusted map[string]string
+	}{
+		{name: "trusted address", ip: "198.41.0.4", trust: true, wantCode: http.StatusAccepted, wantTrusted: map[string]string{"IPv4": "198.41.0.4"}},
+		{name: "IPv4-mapped address", ip: "::ffff:198.41.0.4", trust: true, wantCode: http.StatusAccepted, wantTrusted: map[string]string{"IPv4": "198.41.0.4"}},
+		{name: "address ignored without TRIGGER_TRUST_IP", ip: "198.41.0.4", wantCode: http.StatusAccepted},
+		{name: "not an address", ip: "router", trust: true, wantCode: http.StatusBadRequest},
+		{name: "reserved address", ip: "192.0.2.1", trust: true, wantCode: http.StatusBadRequest},
+		{name: "family without records", ip: "2606:4700:4700::1111", trust: true, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, &AppConfig{APIToken: "api-token", TriggerTrustIP: tt.trust, RecordsToUpdate: []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com"}}})
+			waker := newDDNSWaker()
+			c := &controller{ctx: context.Background(), wakeDDNS: waker.request}
+			mux := http.NewServeMux()
+			c.registerAPI(mux)
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/trigger?token=api-token&ip="+tt.ip, nil))
+			if rec.Code != tt.wantCode {
+				t.Fatalf("GET /trigger?ip=%s = %d, want %d", tt.ip, rec.Code, tt.wantCode)
+			}
+			select {
+			case <-waker.wake:
+			default:
+				if tt.wantCode == http.StatusAccepted {
+					t.Fatal("the trigger did not wake the DDNS loop")
+				}
+				return
+			}
+			if tt.wantCode != http.StatusAccepted {
+				t.Fatal("a refused trigger woke the DDNS loop")
+			}
+			req := waker.take()
+			if !req.force || !reflect.DeepEqual(req.trusted, tt.wantTrusted) {
+				t.Errorf("the trigger requested force %t with addresses %v, want force with %v", req.force, req.trusted, tt.wantTrusted)
+			}
+		})
+	}
+}
+
+func TestTriggeredAddressIsKeptFromManualSync(t *testing.T) {
+	const triggered, detected = "198.41.0.4", "199.9.14.201"
+	useStateDir(t)
+	appConfig := &AppConfig{
+		APIToken:        "api-token",
+		TriggerTrustIP:  true,
+		IPv4Sources:     []ipSource{staticIPSource{detected}},
+		RecordsToUpdate: []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com", TTL: 300}},
+	}
+	useConfig(t, appConfig)
+	fake := newFakeAWS(t)
+	fake.on("ListResourceRecordSets", func(any) (any, error) { return &route53.ListResourceRecordSetsOutput{}, nil })
+	var written []string
+	fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+		for _, change := range input.(*route53.ChangeResourceRecordSetsInput).ChangeBatch.Changes {
+			written = append(written, recordSetValues(change.ResourceRecordSet)...)
+		}
+		return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+	})
+	waker := newDDNSWaker()
+	c := &controller{ctx: context.Background(), r53Client: fake.route53(), wakeDDNS: waker.request}
+	if err := c.trigger(triggered); err != nil {
+		t.Fatal(err)
+	}
+
+	// A manual sync that runs before the triggered cycle detects the address
+	// and leaves the triggered one to the cycle.
+	c.syncRecords(appConfig, appConfig.RecordsToUpdate)
+	if err := runDDNSCycle(context.Background(), appConfig, fake.route53(), waker.take()); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{detected, triggered}; !slices.Equal(written, want) {
+		t.Errorf("wrote %q, want the manual sync's detected address and then the triggered one: %q", written, want)
+	}
+}
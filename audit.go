@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// --- Audit Log ---
+
+// The actions recorded in the audit log.
+const (
+	auditRoute53Change = "route53_change"
+	auditACMRequest    = "acm_request"
+	auditACMDelete     = "acm_delete"
+
+	auditHealthCheckCreate = "health_check_create"
+	auditHealthCheckUpdate = "health_check_update"
+)
+
+// recentAuditSize is how many entries are kept in memory for the dashboard.
+const recentAuditSize = 50
+
+var (
+	// auditMu serializes writes, so that entries from concurrent tasks are
+	// never interleaved.
+	auditMu sync.Mutex
+	// recentAudit holds the latest entries, oldest first, with or without
+	// AUDIT_LOG.
+	recentAudit []auditEntry
+)
+
+// auditEntry is one line of AUDIT_LOG.
+type auditEntry struct {
+	Time          time.Time `json:"time"`
+	CycleID       string    `json:"cycle_id,omitempty"`
+	Action        string    `json:"action"`
+	ZoneID        string    `json:"zone_id,omitempty"`
+	Record        string    `json:"record,omitempty"`
+	Type          string    `json:"type,omitempty"`
+	SetIdentifier string    `json:"set_identifier,omitempty"`
+	Old           []string  `json:"old,omitempty"`
+	New           []string  `json:"new,omitempty"`
+	ChangeID      string    `json:"change_id,omitempty"`
+	HealthCheckID string    `json:"health_check_id,omitempty"`
+	ARN           string    `json:"arn,omitempty"`
+	Outcome       string    `json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+}
+
+// writeAudit appends entries to AUDIT_LOG, one JSON object per line, with
+// the outcome taken from err, and keeps the latest for the dashboard. The
+// file is opened for every write, so that it can be rotated by moving it
+// away. A failed write is logged, but does not fail the change it records,
+// which has already been made.
+func writeAudit(ctx context.Context, appConfig *AppConfig, err error, entries ...auditEntry) {
+	if len(entries) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	var lines []byte
+	for i := range entries {
+		entry := &entries[i]
+		entry.Time, entry.CycleID, entry.Outcome = now, cycleIDFrom(ctx), "success"
+		if err != nil {
+			entry.Outcome, entry.Error = "failed", err.Error()
+		}
+		line, marshalErr := json.Marshal(entry)
+		if marshalErr != nil {
+			continue
+		}
+		lines = append(append(lines, line...), '\n')
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	recentAudit = append(recentAudit, entries...)
+	if excess := len(recentAudit) - recentAuditSize; excess > 0 {
+		recentAudit = slices.Delete(recentAudit, 0, excess)
+	}
+	if appConfig.AuditLog == "" {
+		return
+	}
+	file, openErr := os.OpenFile(appConfig.AuditLog, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if openErr == nil {
+		_, openErr = file.Write(lines)
+		if closeErr := file.Close(); openErr == nil {
+			openErr = closeErr
+		}
+	}
+	if openErr != nil {
+		loggerFrom(ctx).Error("Failed to write audit log", "path", appConfig.AuditLog, "error", openErr)
+	}
+}
+
+// recentAuditEntries returns the latest audit entries, newest first.
+func recentAuditEntries() []auditEntry {
+	auditMu.Lock()
+	defer auditMu.Unlock()
+	entries := slices.Clone(recentAudit)
+	slices.Reverse(entries)
+	return entries
+}
+
+// auditChanges describes a change batch for the audit log. A DELETE followed
+// by a CREATE of the same record set, which is how a live set is replaced,
+// becomes one entry with the old and the new values.
+func auditChanges(zoneID, changeID string, changes []r53types.Change) []auditEntry {
+	var entries []auditEntry
+	for i := 0; i < len(changes); i++ {
+		set := changes[i].ResourceRecordSet
+		entry := auditEntry{
+			Action:        auditRoute53Change,
+			ZoneID:        zoneID,
+			Record:        aws.ToString(set.Name),
+			Type:          string(set.Type),
+			SetIdentifier: aws.ToString(set.SetIdentifier),
+			ChangeID:      changeID,
+		}
+		if changes[i].Action == r53types.ChangeActionDelete {
+			entry.Old = recordSetValues(set)
+			if i+1 < len(changes) && changes[i+1].Action == r53types.ChangeActionCreate && sameRecordSet(set, changes[i+1].ResourceRecordSet) {
+				i++
+				entry.New = recordSetValues(changes[i].ResourceRecordSet)
+			}
+		} else {
+			entry.New = recordSetValues(set)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func sameRecordSet(a, b *r53types.ResourceRecordSet) bool {
+	return canonicalName(aws.ToString(a.Name)) == canonicalName(aws.ToString(b.Name)) && a.Type == b.Type && aws.ToString(a.SetIdentifier) == aws.ToString(b.SetIdentifier)
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/mail"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sesv2"
+	sestypes "github.com/aws/aws-sdk-go-v2/service/sesv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// --- AWS Notifiers ---
+
+// snsMaxSubject is the longest subject SNS accepts for email subscribers.
+const snsMaxSubject = 100
+
+// defaultSESSubject is the subject template for SES email.
+const defaultSESSubject = "[auto-route53] {{.Message}}"
+
+// defaultEventBridgeSource is the source of the events put on EventBridge.
+const defaultEventBridgeSource = "auto-route53"
+
+// snsNotifier publishes events to an SNS topic. Subscribers other than email
+// receive the event as JSON, email the chat message, and the event type is
+// sent as the "event" message attribute for subscription filter policies.
+type snsNotifier struct {
+	topicArn string
+	region   string
+
+	once   sync.Once
+	client *sns.Client
+}
+
+func (n *snsNotifier) Name() string { return "sns " + n.topicArn }
+
+func (n *snsNotifier) Send(ctx context.Context, event notifyEvent) error {
+	// The AWS configuration is loaded after the notifiers are built.
+	n.once.Do(func() {
+		n.client = sns.NewFromConfig(baseAWSConfig, func(o *sns.Options) {
+			o.Region = n.region
+		})
+	})
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	text := formatNotification(event)
+	message, err := json.Marshal(map[string]string{"default": string(payload), "email": text, "email-json": string(payload)})
+	if err != nil {
+		return err
+	}
+	subject := strings.SplitN(text, "\n", 2)[0]
+	if len(subject) > snsMaxSubject {
+		subject = subject[:snsMaxSubject-3] + "..."
+	}
+	_, err = n.client.Publish(ctx, &sns.PublishInput{
+		TopicArn:         aws.String(n.topicArn),
+		Message:          aws.String(string(message)),
+		MessageStructure: aws.String("json"),
+		Subject:          aws.String(subject),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"event": {DataType: aws.String("String"), StringValue: aws.String(event.Type)},
+		},
+	})
+	return err
+}
+
+// sesNotifier emails events with SES. The subject is rendered from a
+// text/template of the event, the body is the chat message.
+type sesNotifier struct {
+	from    string
+	to      []string
+	region  string
+	subject *template.Template
+
+	once   sync.Once
+	client *sesv2.Client
+}
+
+func (n *sesNotifier) Name() string { return "ses" }
+
+func (n *sesNotifier) Send(ctx context.Context, event notifyEvent) error {
+	n.once.Do(func() {
+		n.client = sesv2.NewFromConfig(baseAWSConfig, func(o *sesv2.Options) {
+			if n.region != "" {
+				o.Region = n.region
+			}
+		})
+	})
+	var subject strings.Builder
+	if err := n.subject.Execute(&subject, event); err != nil {
+		return fmt.Errorf("failed to render SES_SUBJECT: %w", err)
+	}
+	body := formatNotification(event) + "\n\ntime: " + event.Time.Format(time.RFC3339)
+	_, err := n.client.SendEmail(ctx, &sesv2.SendEmailInput{
+		FromEmailAddress: aws.String(n.from),
+		Destination:      &sestypes.Destination{ToAddresses: n.to},
+		Content: &sestypes.EmailContent{
+			Simple: &sestypes.Message{
+				// Header lines cannot hold line breaks.
+				Subject: &sestypes.Content{Data: aws.String(strings.Join(strings.Fields(subject.String()), " "))},
+				Body:    &sestypes.Body{Text: &sestypes.Content{Data: aws.String(body)}},
+			},
+		},
+	})
+	return err
+}
+
+// eventBridgeNotifier puts events on an EventBridge bus, with the event type
+// as the detail type and the event as the detail, so that rules can match
+// e.g. {"source": ["auto-route53"], "detail-type": ["records_updated"]}.
+type eventBridgeNotifier struct {
+	bus    string
+	source string
+	region string
+
+	once   sync.Once
+	client *eventbridge.Client
+}
+
+func (n *eventBridgeNotifier) Name() string { return "eventbridge " + n.bus }
+
+func (n *eventBridgeNotifier) Send(ctx context.Context, event notifyEvent) error {
+	n.once.Do(func() {
+		n.client = eventbridge.NewFromConfig(baseAWSConfig, func(o *eventbridge.Options) {
+			if n.region != "" {
+				o.Region = n.region
+			}
+		})
+	})
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	entry := ebtypes.PutEventsRequestEntry{
+		EventBusName: aws.String(n.bus),
+		Source:       aws.String(n.source),
+		DetailType:   aws.String(event.Type),
+		Detail:       aws.String(string(detail)),
+		Time:         aws.Time(event.Time),
+	}
+	if certArn := event.Details["arn"]; certArn != "" {
+		entry.Resources = []string{certArn}
+	}
+	output, err := n.client.PutEvents(ctx, &eventbridge.PutEventsInput{Entries: []ebtypes.PutEventsRequestEntry{entry}})
+	if err != nil {
+		return err
+	}
+	// PutEvents reports rejected entries in the output, not as an error.
+	if output.FailedEntryCount > 0 && len(output.Entries) > 0 {
+		return fmt.Errorf("event was rejected: %s: %s", aws.ToString(output.Entries[0].ErrorCode), aws.ToString(output.Entries[0].ErrorMessage))
+	}
+	return nil
+}
+
+// loadSESNotifier builds the SES target, if SES_FROM is set.
+func loadSESNotifier(settings *configSource) (*notifyTarget, error) {
+	from := settings.Get("SES_FROM")
+	to := settings.GetList("SES_TO", nil)
+	if from == "" && len(to) == 0 {
+		return nil, nil
+	}
+	if from == "" || len(to) == 0 {
+		return nil, fmt.Errorf("SES_FROM and SES_TO must be set together")
+	}
+	for _, address := range append([]string{from}, to...) {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return nil, fmt.Errorf("invalid SES email address %q: %w", address, err)
+		}
+	}
+	region := settings.Get("SES_REGION")
+	if region != "" && !awsRegionPattern.MatchString(region) {
+		return nil, fmt.Errorf("invalid SES_REGION %q", region)
+	}
+	subject, err := template.New("SES_SUBJECT").Parse(settings.GetDefault("SES_SUBJECT", defaultSESSubject))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SES_SUBJECT: %w", err)
+	}
+	events, err := parseNotifyEventsDefault(settings, "SES_EVENTS", criticalNotifyEvents)
+	if err != nil {
+		return nil, err
+	}
+	return &notifyTarget{&sesNotifier{from: from, to: to, region: region, subject: subject}, events}, nil
+}
+
+// loadAWSNotifiers builds the SNS, EventBridge and SES targets that are
+// configured.
+func loadAWSNotifiers(settings *configSource) ([]notifyTarget, error) {
+	var targets []notifyTarget
+	if topicArn := settings.Get("SNS_TOPIC_ARN"); topicArn != "" {
+		parsed, err := arn.Parse(topicArn)
+		if err != nil || parsed.Service != "sns" {
+			return nil, fmt.Errorf("invalid SNS_TOPIC_ARN %q", topicArn)
+		}
+		events, err := parseNotifyEvents(settings, "SNS_EVENTS")
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, notifyTarget{&snsNotifier{topicArn: topicArn, region: parsed.Region}, events})
+	}
+	if bus := settings.Get("EVENTBRIDGE_BUS"); bus != "" {
+		// The bus is a name in the default region, or an ARN.
+		region := ""
+		if arn.IsARN(bus) {
+			parsed, err := arn.Parse(bus)
+			if err != nil || parsed.Service != "events" {
+				return nil, fmt.Errorf("invalid EVENTBRIDGE_BUS %q", bus)
+			}
+			region = parsed.Region
+		}
+		events, err := parseNotifyEvents(settings, "EVENTBRIDGE_EVENTS")
+		if err != nil {
+			return nil, err
+		}
+		source := settings.GetDefault("EVENTBRIDGE_SOURCE", defaultEventBridgeSource)
+		targets = append(targets, notifyTarget{&eventBridgeNotifier{bus: bus, source: source, region: region}, events})
+	}
+	ses, err := loadSESNotifier(settings)
+	if err != nil {
+		return nil, err
+	}
+	if ses != nil {
+		targets = append(targets, *ses)
+	}
+	return targets, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+)
+
+const (
+	caaCheckOff       = "off"
+	caaCheckWarn      = "warn"
+	caaCheckBlock     = "block"
+	caaCheckProvision = "provision"
+
+	defaultDNSServer = "8.8.8.8:53"
+)
+
+// amazonCAADomains are the issuer domains that allow ACM to issue certificates.
+var amazonCAADomains = []string{"amazon.com", "amazontrust.com", "awstrust.com", "amazonaws.com"}
+
+// --- CAA Functions ---
+
+func resolverAddress() string {
+	conf, err := dns.ClientConfigFromFile("/etc/resolv.conf")
+	if err != nil || len(conf.Servers) == 0 {
+		return defaultDNSServer
+	}
+	return conf.Servers[0] + ":" + conf.Port
+}
+
+// lookupCAA returns the relevant CAA record set for domainName and the name
+// it was found at. Following RFC 8659, it climbs towards the root and returns
+// the first non-empty set.
+func lookupCAA(domainName string) ([]*dns.CAA, string, error) {
+	client := new(dns.Client)
+	server := resolverAddress()
+	labels := dns.SplitDomainName(strings.TrimPrefix(domainName, "*."))
+	for i := range labels {
+		candidate := dns.Fqdn(strings.Join(labels[i:], "."))
+		msg := new(dns.Msg)
+		msg.SetQuestion(candidate, dns.TypeCAA)
+		resp, _, err := client.Exchange(msg, server)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to query CAA records for %s: %w", candidate, err)
+		}
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError {
+			return nil, "", fmt.Errorf("CAA query for %s failed: %s", candidate, dns.RcodeToString[resp.Rcode])
+		}
+		var records []*dns.CAA
+		for _, rr := range resp.Answer {
+			if caa, ok := rr.(*dns.CAA); ok {
+				records = append(records, caa)
+			}
+		}
+		if len(records) > 0 {
+			return records, canonicalName(candidate), nil
+		}
+	}
+	return nil, "", nil
+}
+
+// amazonMayIssue reports whether the CAA record set permits Amazon to issue a
+// certificate for domainName. An empty set, or one without issue tags, places
+// no restriction.
+func amazonMayIssue(domainName string, records []*dns.CAA) bool {
+	tag := "issue"
+	if strings.HasPrefix(domainName, "*.") {
+		for _, record := range records {
+			if strings.EqualFold(record.Tag, "issuewild") {
+				tag = "issuewild"
+				break
+			}
+		}
+	}
+
+	restricted := false
+	for _, record := range records {
+		if !strings.EqualFold(record.Tag, tag) {
+			continue
+		}
+		restricted = true
+		issuer := strings.ToLower(strings.TrimSpace(strings.SplitN(record.Value, ";", 2)[0]))
+		for _, amazon := range amazonCAADomains {
+			if issuer == amazon {
+				return true
+			}
+		}
+	}
+	return !restricted
+}
+
+// preflightCAANames runs preflightCAA for each name on a certificate.
+func preflightCAANames(ctx context.Context, appConfig *AppConfig, client *route53.Client, names []string) bool {
+	for _, name := range names {
+		if !preflightCAA(ctx, appConfig, client, name) {
+			return false
+		}
+	}
+	return true
+}
+
+// preflightCAA checks the domain's CAA records according to mode and reports
+// whether the certificate request should go ahead. A failed lookup is only
+// logged, since it says nothing about whether Amazon may issue. In provision
+// mode, a CAA record set in one of our hosted zones that shuts Amazon out is
+// extended to allow it.
+func preflightCAA(ctx context.Context, appConfig *AppConfig, client *route53.Client, domainName string) bool {
+	mode := appConfig.CAACheck
+	if mode == caaCheckOff {
+		return true
+	}
+	records, setName, err := lookupCAA(domainName)
+	if err != nil {
+		slog.Warn("Could not check CAA records", "component", "acm", "domain", domainName, "error", err)
+		return true
+	}
+	if amazonMayIssue(domainName, records) {
+		return true
+	}
+
+	if mode == caaCheckProvision {
+		err := provisionAmazonCAA(ctx, appConfig, client, domainName, setName)
+		if err == nil {
+			return true
+		}
+		slog.Error("Failed to add Amazon to the CAA records", "component", "acm", "domain", domainName, "caa_name", setName, "error", err)
+	}
+
+	problem := "CAA records do not permit Amazon to issue certificates; add a record such as 0 issue \"amazon.com\""
+	if mode == caaCheckBlock {
+		slog.Error(problem+". Not requesting a certificate", "component", "acm", "domain", domainName)
+		return false
+	}
+	slog.Warn(problem+". Validation is likely to fail", "component", "acm", "domain", domainName)
+	return true
+}
+
+// provisionAmazonCAA adds issue records for every Amazon issuer domain to the
+// CAA record set at setName, and issuewild records too if the set restricts
+// wildcards. The other issuers in the set are kept. The set is replaced in a
+// single batch, which fails rather than overwriting a concurrent change.
+func provisionAmazonCAA(ctx context.Context, appConfig *AppConfig, client *route53.Client, domainName, setName string) error {
+	zoneID, err := findHostedZoneID(ctx, client, setName)
+	if err != nil {
+		return err
+	}
+	if zoneID == "" {
+		return fmt.Errorf("%s is not in a hosted zone of this account", setName)
+	}
+	live, err := liveRecordSet(ctx, client, zoneID, setName, r53types.RRTypeCaa, "")
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		// The CAA records resolved above live outside Route53, or have
+		// changed since; either way there is nothing here to extend.
+		return fmt.Errorf("no CAA record set for %s in hosted zone %s", setName, zoneID)
+	}
+
+	values := recordSetValues(live)
+	tags := []string{"issue"}
+	for _, value := range values {
+		if fields := strings.Fields(value); len(fields) >= 2 && strings.EqualFold(fields[1], "issuewild") {
+			tags = append(tags, "issuewild")
+			break
+		}
+	}
+	for _, tag := range tags {
+		for _, amazon := range amazonCAADomains {
+			value := fmt.Sprintf("0 %s %q", tag, amazon)
+			if !containsFold(values, value) {
+				values = append(values, value)
+			}
+		}
+	}
+
+	slog.Info("Adding Amazon to CAA records", "component", "acm", "domain", domainName, "caa_name", setName, "zone_id", zoneID)
+	update := recordUpdate{
+		Record:   RecordConfig{ZoneID: zoneID, RecordName: setName, TTL: aws.ToInt64(live.TTL)},
+		Type:     r53types.RRTypeCaa,
+		Values:   values,
+		Replaces: live,
+	}
+	return updateRoute53Records(ctx, appConfig, client, []recordUpdate{update})[0]
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+)
+
+// parseCAA parses CAA records in zone file form, e.g. `0 issue "amazon.com"`.
+func parseCAA(t *testing.T, values ...string) []*dns.CAA {
+	t.Helper()
+	var records []*dns.CAA
+	for _, value := range values {
+		rr, err := dns.NewRR("example.com. 300 IN CAA " + value)
+		if err != nil {
+			t.Fatalf("bad CAA record %q: %v", value, err)
+		}
+		records = append(records, rr.(*dns.CAA))
+	}
+	return records
+}
+
+func TestAmazonMayIssue(t *testing.T) {
+	tests := []struct {
+		name    string
+		domain  string
+		records []string
+		want    bool
+	}{
+		{name: "no records", domain: "www.example.com", want: true},
+		{name: "only iodef", domain: "www.example.com", records: []string{`0 iodef "mailto:security@example.com"`}, want: true},
+		{name: "amazon allowed", domain: "www.example.com", records: []string{`0 issue "amazon.com"`}, want: true},
+		{name: "amazon among others", domain: "www.example.com", records: []string{`0 issue "letsencrypt.org"`, `0 issue "amazontrust.com"`}, want: true},
+		{name: "amazon with parameters", domain: "www.example.com", records: []string{`0 issue "Amazon.com; accounturi=x"`}, want: true},
+		{name: "another ca only", domain: "www.example.com", records: []string{`0 issue "anotherca.com"`}},
+		{name: "nobody may issue", domain: "www.example.com", records: []string{`0 issue ";"`}},
+		{name: "wildcard follows issue", domain: "*.example.com", records: []string{`0 issue "amazon.com"`}, want: true},
+		{name: "issuewild overrides issue", domain: "*.example.com", records: []string{`0 issue "amazon.com"`, `0 issuewild "anotherca.com"`}},
+		{name: "issuewild allows amazon", domain: "*.example.com", records: []string{`0 issue "anotherca.com"`, `0 issuewild "amazon.com"`}, want: true},
+		{name: "issuewild ignored for plain names", domain: "www.example.com", records: []string{`0 issue "amazon.com"`, `0 issuewild ";"`}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := amazonMayIssue(tt.domain, parseCAA(t, tt.records...)); got != tt.want {
+				t.Errorf("amazonMayIssue(%q, %q) = %t, want %t", tt.domain, tt.records, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProvisionAmazonCAA(t *testing.T) {
+	tests := []struct {
+		name string
+		live []string
+		want []string
+	}{
+		{
+			name: "issue only",
+			live: []string{`0 issue "anotherca.com"`},
+			want: []string{`0 issue "anotherca.com"`, `0 issue "amazon.com"`, `0 issue "amazontrust.com"`, `0 issue "awstrust.com"`, `0 issue "amazonaws.com"`},
+		},
+		{
+			name: "wildcards restricted too",
+			live: []string{`0 issue "anotherca.com"`, `0 issuewild ";"`},
+			want: []string{
+				`0 issue "anotherca.com"`, `0 issuewild ";"`,
+				`0 issue "amazon.com"`, `0 issue "amazontrust.com"`, `0 issue "awstrust.com"`, `0 issue "amazonaws.com"`,
+				`0 issuewild "amazon.com"`, `0 issuewild "amazontrust.com"`, `0 issuewild "awstrust.com"`, `0 issuewild "amazonaws.com"`,
+			},
+		},
+		{
+			name: "amazon already partly listed",
+			live: []string{`0 issue "anotherca.com"`, `0 issue "amazon.com"`},
+			want: []string{`0 issue "anotherca.com"`, `0 issue "amazon.com"`, `0 issue "amazontrust.com"`, `0 issue "awstrust.com"`, `0 issue "amazonaws.com"`},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetHostedZoneCache(t)
+			fake := newFakeAWS(t)
+			fake.on("ListHostedZonesByName", func(any) (any, error) {
+				return &route53.ListHostedZonesByNameOutput{HostedZones: []r53types.HostedZone{{Id: aws.String("/hostedzone/Z1"), Name: aws.String("example.com.")}}}, nil
+			})
+			fake.on("ListResourceRecordSets", func(any) (any, error) {
+				var records []r53types.ResourceRecord
+				for _, value := range tt.live {
+					records = append(records, r53types.ResourceRecord{Value: aws.String(value)})
+				}
+				return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []r53types.ResourceRecordSet{{
+					Name: aws.String("example.com."), Type: r53types.RRTypeCaa, TTL: aws.Int64(3600), ResourceRecords: records,
+				}}}, nil
+			})
+			var changes []r53types.Change
+			fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+				changes = input.(*route53.ChangeResourceRecordSetsInput).ChangeBatch.Changes
+				return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+			})
+
+			if err := provisionAmazonCAA(context.Background(), &AppConfig{}, fake.route53(), "www.example.com", "example.com"); err != nil {
+				t.Fatal(err)
+			}
+			// The live set is replaced, so a concurrent change fails the batch.
+			if len(changes) != 2 || changes[0].Action != r53types.ChangeActionDelete || changes[1].Action != r53types.ChangeActionCreate {
+				t.Fatalf("changes = %+v, want a DELETE of the live set and a CREATE", changes)
+			}
+			created := changes[1].ResourceRecordSet
+			if aws.ToInt64(created.TTL) != 3600 {
+				t.Errorf("TTL = %d, want the live TTL", aws.ToInt64(created.TTL))
+			}
+			if got := recordSetValues(created); !slices.Equal(got, tt.want) {
+				t.Errorf("CAA values = %q, want %q", got, tt.want)
+			}
+			if !amazonMayIssue("*.example.com", parseCAA(t, recordSetValues(created)...)) {
+				t.Errorf("Amazon still may not issue wildcards under %q", recordSetValues(created))
+			}
+		})
+	}
+}
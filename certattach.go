@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/apigatewayv2"
+	apigwtypes "github.com/aws/aws-sdk-go-v2/service/apigatewayv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/cloudfront"
+	cftypes "github.com/aws/aws-sdk-go-v2/service/cloudfront/types"
+	elbv2 "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2"
+	elbv2types "github.com/aws/aws-sdk-go-v2/service/elasticloadbalancingv2/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// --- Certificate Attachment ---
+
+// cloudfrontCertRegion is the only region CloudFront takes ACM certificates
+// from.
+const cloudfrontCertRegion = "us-east-1"
+
+// cloudfrontDistributionIDPattern matches CloudFront distribution IDs.
+var cloudfrontDistributionIDPattern = regexp.MustCompile(`^[A-Z0-9]{8,20}$`)
+
+// normalizeListenerArns validates the record's listener_arns.
+func normalizeListenerArns(record *RecordConfig) error {
+	for i, listenerArn := range record.ListenerArns {
+		listenerArn = strings.TrimSpace(listenerArn)
+		parsed, err := arn.Parse(listenerArn)
+		if err != nil || parsed.Service != "elasticloadbalancing" || !strings.HasPrefix(parsed.Resource, "listener/") {
+			return fmt.Errorf("record %s has an invalid listener ARN %q", record.RecordName, listenerArn)
+		}
+		if !record.TLS {
+			return fmt.Errorf("record %s has listener_arns but tls is not enabled", record.RecordName)
+		}
+		record.ListenerArns[i] = listenerArn
+	}
+	if record.ListenerDefault && len(record.ListenerArns) == 0 {
+		return fmt.Errorf("record %s has listener_default but no listener_arns", record.RecordName)
+	}
+	return nil
+}
+
+// attachCertificateToListeners adds the record's stored certificate to each
+// of its HTTPS or TLS listeners, as an additional certificate served by SNI,
+// or as the default certificate with listener_default. Listeners that
+// already have it are left alone.
+func attachCertificateToListeners(ctx context.Context, appConfig *AppConfig, record RecordConfig) error {
+	certArn, _ := getStoredString(certStateFile(certStateKey(record)))
+	if len(record.ListenerArns) == 0 || certArn == "" {
+		return nil
+	}
+	parsedCert, err := arn.Parse(certArn)
+	if err != nil {
+		return fmt.Errorf("invalid stored certificate ARN %q: %w", certArn, err)
+	}
+	logger := slog.With("component", "acm", "domain", record.RecordName)
+
+	for _, listenerArn := range record.ListenerArns {
+		// listener_arns were validated when the configuration was loaded.
+		parsedListener, _ := arn.Parse(listenerArn)
+		region := parsedListener.Region
+		if region != parsedCert.Region {
+			return fmt.Errorf("listener %s is in %s but the certificate is in %s; set cert_region to %s", listenerArn, region, parsedCert.Region, region)
+		}
+		client := elbv2For(record, region)
+
+		attached, isDefault := false, false
+		paginator := elbv2.NewDescribeListenerCertificatesPaginator(client, &elbv2.DescribeListenerCertificatesInput{ListenerArn: aws.String(listenerArn)})
+		for paginator.HasMorePages() && !attached {
+			page, err := paginator.NextPage(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to list the certificates of listener %s: %w", listenerArn, err)
+			}
+			for _, cert := range page.Certificates {
+				if aws.ToString(cert.CertificateArn) == certArn {
+					attached, isDefault = true, aws.ToBool(cert.IsDefault)
+					break
+				}
+			}
+		}
+		if attached && (isDefault || !record.ListenerDefault) {
+			continue
+		}
+
+		if appConfig.DryRun {
+			logger.Info("DRY RUN: Would attach certificate to listener", "listener", listenerArn, "default", record.ListenerDefault, "arn", certArn)
+			continue
+		}
+		certificates := []elbv2types.Certificate{{CertificateArn: aws.String(certArn)}}
+		if record.ListenerDefault {
+			_, err = client.ModifyListener(ctx, &elbv2.ModifyListenerInput{ListenerArn: aws.String(listenerArn), Certificates: certificates})
+		} else {
+			_, err = client.AddListenerCertificates(ctx, &elbv2.AddListenerCertificatesInput{ListenerArn: aws.String(listenerArn), Certificates: certificates})
+		}
+		if err != nil {
+			return fmt.Errorf("failed to attach certificate to listener %s: %w", listenerArn, err)
+		}
+		logger.Info("Attached certificate to listener", "listener", listenerArn, "default", record.ListenerDefault, "arn", certArn)
+	}
+	return nil
+}
+
+// normalizeCloudFrontDistribution validates the record's
+// cloudfront_distribution_id.
+func normalizeCloudFrontDistribution(record *RecordConfig) error {
+	record.CloudFrontDistID = strings.ToUpper(strings.TrimSpace(record.CloudFrontDistID))
+	if record.CloudFrontDistID == "" {
+		return nil
+	}
+	if !cloudfrontDistributionIDPattern.MatchString(record.CloudFrontDistID) {
+		return fmt.Errorf("record %s has an invalid cloudfront_distribution_id %q", record.RecordName, record.CloudFrontDistID)
+	}
+	if !record.TLS {
+		return fmt.Errorf("record %s has a cloudfront_distribution_id but tls is not enabled", record.RecordName)
+	}
+	if record.CertRegion != "" && record.CertRegion != cloudfrontCertRegion {
+		return fmt.Errorf("record %s has a cloudfront_distribution_id, so its cert_region must be %s", record.RecordName, cloudfrontCertRegion)
+	}
+	return nil
+}
+
+// attachCertificateToDistribution makes the record's stored certificate the
+// viewer certificate of its CloudFront distribution, keeping the
+// distribution's other TLS settings. The distribution's aliases must already
+// include the certificate's names.
+func attachCertificateToDistribution(ctx context.Context, appConfig *AppConfig, record RecordConfig) error {
+	certArn, _ := getStoredString(certStateFile(certStateKey(record)))
+	if record.CloudFrontDistID == "" || certArn == "" {
+		return nil
+	}
+	if parsed, err := arn.Parse(certArn); err != nil || parsed.Region != cloudfrontCertRegion {
+		return fmt.Errorf("certificate %s cannot be used by CloudFront, which needs certificates in %s; set cert_region to %s", certArn, cloudfrontCertRegion, cloudfrontCertRegion)
+	}
+	logger := slog.With("component", "acm", "domain", record.RecordName, "distribution", record.CloudFrontDistID)
+	client := clientsFor(record).cloudfront
+
+	output, err := client.GetDistributionConfig(ctx, &cloudfront.GetDistributionConfigInput{Id: aws.String(record.CloudFrontDistID)})
+	if err != nil {
+		return fmt.Errorf("failed to get the configuration of distribution %s: %w", record.CloudFrontDistID, err)
+	}
+	distribution := output.DistributionConfig
+	current := distribution.ViewerCertificate
+	if current != nil && aws.ToString(current.ACMCertificateArn) == certArn {
+		return nil
+	}
+
+	viewer := &cftypes.ViewerCertificate{
+		ACMCertificateArn:            aws.String(certArn),
+		CloudFrontDefaultCertificate: aws.Bool(false),
+		SSLSupportMethod:             cftypes.SSLSupportMethodSniOnly,
+		MinimumProtocolVersion:       cftypes.MinimumProtocolVersionTLSv122021,
+	}
+	// The default certificate only allows TLSv1 and has no support method;
+	// for a distribution that already had its own certificate, keep both.
+	if current != nil && !aws.ToBool(current.CloudFrontDefaultCertificate) {
+		if current.SSLSupportMethod != "" {
+			viewer.SSLSupportMethod = current.SSLSupportMethod
+		}
+		if current.MinimumProtocolVersion != "" {
+			viewer.MinimumProtocolVersion = current.MinimumProtocolVersion
+		}
+	}
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would set the distribution's viewer certificate", "arn", certArn)
+		return nil
+	}
+	distribution.ViewerCertificate = viewer
+	_, err = client.UpdateDistribution(ctx, &cloudfront.UpdateDistributionInput{
+		Id:                 aws.String(record.CloudFrontDistID),
+		IfMatch:            output.ETag,
+		DistributionConfig: distribution,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update distribution %s: %w", record.CloudFrontDistID, err)
+	}
+	logger.Info("Set the distribution's viewer certificate", "arn", certArn)
+	return nil
+}
+
+// normalizeAPIGatewayDomain validates the record's api_gateway_domain and
+// api_gateway_alias.
+func normalizeAPIGatewayDomain(record *RecordConfig) error {
+	record.APIGatewayDomain = canonicalName(record.APIGatewayDomain)
+	if record.APIGatewayDomain == "" {
+		if record.APIGatewayAlias {
+			return fmt.Errorf("record %s has api_gateway_alias but no api_gateway_domain", record.RecordName)
+		}
+		return nil
+	}
+	if !record.TLS {
+		return fmt.Errorf("record %s has an api_gateway_domain but tls is not enabled", record.RecordName)
+	}
+	if !coversNames(certificateNames(*record), []string{record.APIGatewayDomain}) {
+		return fmt.Errorf("record %s has api_gateway_domain %s, which its certificate does not cover; add it to subject_alternative_names", record.RecordName, record.APIGatewayDomain)
+	}
+	// The record itself follows the public IP, which the alias would undo.
+	if record.APIGatewayAlias && record.APIGatewayDomain == canonicalName(record.RecordName) {
+		return fmt.Errorf("record %s cannot have an api_gateway_alias for itself; use one of its subject_alternative_names as api_gateway_domain", record.RecordName)
+	}
+	return nil
+}
+
+// attachCertificateToAPIGateway creates the record's API Gateway custom
+// domain name with its stored certificate, or moves an existing one to the
+// certificate, and with api_gateway_alias points an alias record for the
+// domain at it.
+func attachCertificateToAPIGateway(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, record RecordConfig) error {
+	certArn, _ := getStoredString(certStateFile(certStateKey(record)))
+	if record.APIGatewayDomain == "" || certArn == "" {
+		return nil
+	}
+	parsedCert, err := arn.Parse(certArn)
+	if err != nil {
+		return fmt.Errorf("invalid stored certificate ARN %q: %w", certArn, err)
+	}
+	logger := slog.With("component", "acm", "domain", record.RecordName, "api_gateway_domain", record.APIGatewayDomain)
+	client := apiGatewayFor(record, parsedCert.Region)
+
+	var configuration apigwtypes.DomainNameConfiguration
+	output, err := client.GetDomainName(ctx, &apigatewayv2.GetDomainNameInput{DomainName: aws.String(record.APIGatewayDomain)})
+	var notFound *apigwtypes.NotFoundException
+	switch {
+	case errors.As(err, &notFound):
+		if appConfig.DryRun {
+			logger.Info("DRY RUN: Would create API Gateway custom domain", "arn", certArn)
+			return nil
+		}
+		created, err := client.CreateDomainName(ctx, &apigatewayv2.CreateDomainNameInput{
+			DomainName: aws.String(record.APIGatewayDomain),
+			DomainNameConfigurations: []apigwtypes.DomainNameConfiguration{{
+				CertificateArn: aws.String(certArn),
+				EndpointType:   apigwtypes.EndpointTypeRegional,
+				SecurityPolicy: apigwtypes.SecurityPolicyTls12,
+			}},
+			Tags: map[string]string{certManagedByTagKey: certManagedByTagValue},
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create API Gateway custom domain %s: %w", record.APIGatewayDomain, err)
+		}
+		logger.Info("Created API Gateway custom domain", "arn", certArn)
+		configuration = created.DomainNameConfigurations[0]
+	case err != nil:
+		return fmt.Errorf("failed to get API Gateway custom domain %s: %w", record.APIGatewayDomain, err)
+	default:
+		configuration = output.DomainNameConfigurations[0]
+		if aws.ToString(configuration.CertificateArn) != certArn {
+			if appConfig.DryRun {
+				logger.Info("DRY RUN: Would move API Gateway custom domain to certificate", "arn", certArn)
+				return nil
+			}
+			// Only the settings can be sent back, not the fields API Gateway
+			// fills in.
+			updated, err := client.UpdateDomainName(ctx, &apigatewayv2.UpdateDomainNameInput{
+				DomainName: aws.String(record.APIGatewayDomain),
+				DomainNameConfigurations: []apigwtypes.DomainNameConfiguration{{
+					CertificateArn:                      aws.String(certArn),
+					EndpointType:                        configuration.EndpointType,
+					SecurityPolicy:                      configuration.SecurityPolicy,
+					IpAddressType:                       configuration.IpAddressType,
+					OwnershipVerificationCertificateArn: configuration.OwnershipVerificationCertificateArn,
+				}},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update API Gateway custom domain %s: %w", record.APIGatewayDomain, err)
+			}
+			logger.Info("Moved API Gateway custom domain to certificate", "arn", certArn)
+			configuration = updated.DomainNameConfigurations[0]
+		}
+	}
+
+	if !record.APIGatewayAlias {
+		return nil
+	}
+	return upsertAPIGatewayAlias(ctx, appConfig, r53Client, record, configuration)
+}
+
+// upsertAPIGatewayAlias points A, and for a dual-stack domain AAAA, alias
+// records for the record's api_gateway_domain at the domain's API Gateway
+// endpoint, in the hosted zone found for the name. Records that already do
+// are left alone.
+func upsertAPIGatewayAlias(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, record RecordConfig, configuration apigwtypes.DomainNameConfiguration) error {
+	target := &AliasConfig{
+		DNSName:      canonicalName(aws.ToString(configuration.ApiGatewayDomainName)),
+		HostedZoneID: aws.ToString(configuration.HostedZoneId),
+	}
+	zoneID, err := findHostedZoneID(ctx, r53Client, record.APIGatewayDomain)
+	if err != nil {
+		return err
+	}
+	if zoneID == "" {
+		zoneID = record.ZoneID
+	}
+
+	aliasRecord := RecordConfig{
+		RecordName: record.APIGatewayDomain,
+		ZoneID:     zoneID,
+		Profile:    record.Profile,
+		RoleARN:    record.RoleARN,
+		ExternalID: record.ExternalID,
+		Alias:      target,
+	}
+	recordTypes := []r53types.RRType{r53types.RRTypeA}
+	if configuration.IpAddressType == apigwtypes.IpAddressTypeDualstack {
+		recordTypes = append(recordTypes, r53types.RRTypeAaaa)
+	}
+	want := describeAlias(target.DNSName, target.HostedZoneID, false)
+	var updates []recordUpdate
+	for _, recordType := range recordTypes {
+		live, err := liveRecordValues(ctx, r53Client, zoneID, record.APIGatewayDomain, recordType, "")
+		if err != nil {
+			return err
+		}
+		if len(live) == 1 && live[0] == want {
+			continue
+		}
+		updates = append(updates, recordUpdate{Record: aliasRecord, Type: recordType})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return errors.Join(updateRoute53Records(ctx, appConfig, r53Client, updates)...)
+}
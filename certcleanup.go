@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+)
+
+// --- Duplicate Certificate Cleanup ---
+
+const (
+	certCleanupOff    = "off"
+	certCleanupReport = "report"
+	certCleanupDelete = "delete"
+)
+
+// certCleanupStatuses are the statuses a duplicate can be in. A certificate
+// pending validation is left alone, in case another instance is validating
+// it.
+var certCleanupStatuses = []acmtypes.CertificateStatus{
+	acmtypes.CertificateStatusIssued,
+	acmtypes.CertificateStatusValidationTimedOut,
+	acmtypes.CertificateStatusFailed,
+	acmtypes.CertificateStatusExpired,
+	acmtypes.CertificateStatusInactive,
+	acmtypes.CertificateStatusRevoked,
+}
+
+// cleanupDuplicateCertificates finds the certificates ACM holds for exactly
+// the record's names, keeps the newest issued one and the one in the state
+// file, and lists (CERT_CLEANUP=report) or deletes (CERT_CLEANUP=delete) the
+// others, such as those left by a crash loop before idempotency tokens were
+// used. Imported certificates and any that are attached to a resource are
+// never touched. Nothing is deleted while the record has no issued
+// certificate.
+func cleanupDuplicateCertificates(ctx context.Context, appConfig *AppConfig, client *acm.Client, record RecordConfig) error {
+	matches, err := listRecordCertificates(ctx, client, record, certCleanupStatuses)
+	if err != nil {
+		return err
+	}
+	names := certificateNames(record)
+	var keep string
+	var keepIssued time.Time
+	var duplicates []acmtypes.CertificateSummary
+	for _, match := range matches {
+		cert := match.Summary
+		if !sameNames(match.Names, names) || cert.Type == acmtypes.CertificateTypeImported {
+			continue
+		}
+		duplicates = append(duplicates, cert)
+		if cert.Status == acmtypes.CertificateStatusIssued && cert.IssuedAt != nil && (keep == "" || cert.IssuedAt.After(keepIssued)) {
+			keep, keepIssued = aws.ToString(cert.CertificateArn), *cert.IssuedAt
+		}
+	}
+	if keep == "" {
+		return nil
+	}
+	storedArn, _ := getStoredString(certStateFile(certStateKey(record)))
+
+	logger := slog.With("component", "acm", "domain", record.RecordName, "kept_arn", keep)
+	for _, cert := range duplicates {
+		certArn := aws.ToString(cert.CertificateArn)
+		if certArn == keep || certArn == storedArn {
+			continue
+		}
+		if aws.ToBool(cert.InUse) {
+			logger.Info("Duplicate certificate is in use, keeping it", "arn", certArn)
+			continue
+		}
+		if appConfig.CertCleanup == certCleanupReport || appConfig.DryRun {
+			prefix := "REPORT"
+			if appConfig.DryRun {
+				prefix = "DRY RUN"
+			}
+			logger.Info(prefix+": Would delete duplicate certificate", "arn", certArn, "cert_status", cert.Status, "created", aws.ToTime(cert.CreatedAt).Format(time.RFC3339))
+			continue
+		}
+		logger.Info("Deleting duplicate certificate", "arn", certArn, "cert_status", cert.Status)
+		_, err := client.DeleteCertificate(ctx, &acm.DeleteCertificateInput{CertificateArn: cert.CertificateArn})
+		writeAudit(ctx, appConfig, err, auditEntry{Action: auditACMDelete, Record: record.RecordName, ARN: certArn})
+		if err != nil {
+			return fmt.Errorf("failed to delete duplicate certificate %s: %w", certArn, err)
+		}
+	}
+	return nil
+}
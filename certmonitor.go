@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// --- Certificate Monitoring ---
+
+// defaultCertExpiryWarning is how long before expiry a certificate is
+// reported. ACM starts managed renewal 60 days before, so a certificate this
+// close to expiry has not been renewed.
+const defaultCertExpiryWarning = 30 * 24 * time.Hour
+
+// describeStoredCertificate looks up a certificate the state file says was
+// issued and reports any problems with it, rather than trusting the stored
+// ARN forever.
+func describeStoredCertificate(ctx context.Context, appConfig *AppConfig, client *acm.Client, domainName, certArn string) (*acmtypes.CertificateDetail, error) {
+	output, err := client.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(certArn)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to describe certificate %s: %w", certArn, err)
+	}
+	checkCertificateHealth(ctx, appConfig, domainName, output.Certificate)
+	return output.Certificate, nil
+}
+
+// checkCertificateHealth exports the certificate's expiry and warns when it
+// is within CERT_EXPIRY_WARNING of expiring or its managed renewal is stuck.
+func checkCertificateHealth(ctx context.Context, appConfig *AppConfig, domainName string, cert *acmtypes.CertificateDetail) {
+	logger := slog.With("component", "acm", "domain", domainName, "arn", aws.ToString(cert.CertificateArn))
+	status.recordCertificate(domainName, aws.ToString(cert.CertificateArn), string(cert.Status), aws.ToTime(cert.NotAfter))
+	if cert.Status != acmtypes.CertificateStatusIssued {
+		certExpiry.DeleteLabelValues(domainName)
+		logger.Warn("Stored certificate is no longer issued", "cert_status", cert.Status)
+		return
+	}
+	if cert.NotAfter != nil {
+		certExpiry.WithLabelValues(domainName).Set(float64(cert.NotAfter.Unix()))
+		putCertificateExpiryMetric(appConfig, domainName, *cert.NotAfter)
+		if remaining := time.Until(*cert.NotAfter); remaining < appConfig.CertExpiryWarning {
+			logger.Warn("Certificate expires soon", "expires", cert.NotAfter.Format(time.RFC3339), "remaining", remaining.Round(time.Hour), "renewal_eligibility", cert.RenewalEligibility)
+			notifyCertificateExpiring(ctx, appConfig, domainName, *cert.NotAfter, map[string]string{
+				"arn":                 aws.ToString(cert.CertificateArn),
+				"renewal_eligibility": string(cert.RenewalEligibility),
+			})
+		}
+	}
+	if renewal := cert.RenewalSummary; renewal != nil {
+		switch renewal.RenewalStatus {
+		case acmtypes.RenewalStatusPendingValidation:
+			logger.Warn("Certificate renewal is waiting for DNS validation; check that the validation records still exist", "renewal_status", renewal.RenewalStatus)
+		case acmtypes.RenewalStatusFailed:
+			logger.Warn("Certificate renewal failed", "renewal_status", renewal.RenewalStatus, "reason", renewal.RenewalStatusReason)
+		}
+	}
+}
+
+// ensureValidationRecords recreates any DNS validation record of an issued
+// certificate that has been removed or changed. ACM needs the records again
+// for managed renewal, which otherwise fails without warning months later.
+func ensureValidationRecords(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, record RecordConfig, cert *acmtypes.CertificateDetail) error {
+	seen := map[string]bool{}
+	for _, option := range cert.DomainValidationOptions {
+		validationRecord := option.ResourceRecord
+		if validationRecord == nil {
+			continue
+		}
+		name := canonicalName(aws.ToString(validationRecord.Name))
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		zoneID := resolveValidationZone(ctx, r53Client, record, name)
+		values, err := liveRecordValues(ctx, r53Client, zoneID, name, r53types.RRType(validationRecord.Type), "")
+		if err != nil {
+			slog.Warn("Could not check validation record", "component", "acm", "domain", record.RecordName, "validation_record", name, "zone_id", zoneID, "error", err)
+			continue
+		}
+		if len(values) == 1 && canonicalName(values[0]) == canonicalName(aws.ToString(validationRecord.Value)) {
+			continue
+		}
+		slog.Warn("Validation record is missing or changed, recreating it", "component", "acm", "domain", record.RecordName, "validation_record", name, "zone_id", zoneID, "live_values", values)
+		if err := upsertValidationRecord(ctx, appConfig, r53Client, zoneID, validationRecord); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeValidationRecords deletes the DNS validation records of a newly
+// issued certificate, for CERT_REMOVE_VALIDATION_RECORDS. Only records that
+// still hold the value ACM asked for are deleted.
+func removeValidationRecords(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, record RecordConfig, validationRecords []*acmtypes.ResourceRecord) error {
+	var updates []recordUpdate
+	for _, validationRecord := range validationRecords {
+		name := canonicalName(aws.ToString(validationRecord.Name))
+		recordType := r53types.RRType(validationRecord.Type)
+		zoneID := resolveValidationZone(ctx, r53Client, record, name)
+		live, err := liveRecordSet(ctx, r53Client, zoneID, name, recordType, "")
+		if err != nil {
+			return err
+		}
+		if live == nil || !sameValues(recordSetValues(live), []string{aws.ToString(validationRecord.Value)}) {
+			continue
+		}
+		target := RecordConfig{ZoneID: zoneID, RecordName: name, RoleARN: record.RoleARN, ExternalID: record.ExternalID, Profile: record.Profile}
+		updates = append(updates, recordUpdate{Record: target, Type: recordType, Replaces: live, Delete: true})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	var errs []error
+	for _, err := range updateRoute53Records(ctx, appConfig, r53Client, updates) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("failed to remove validation records: %w", err)
+	}
+	return nil
+}
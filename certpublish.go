@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// --- Certificate ARN Publishing ---
+
+// certSSMParameter returns the SSM parameter the record's certificate ARN is
+// published to: its ssm_parameter, else CERT_SSM_PREFIX followed by the
+// record name, else "" for none.
+func certSSMParameter(appConfig *AppConfig, record RecordConfig) string {
+	if record.SSMParameter != "" {
+		return record.SSMParameter
+	}
+	if appConfig.CertSSMPrefix == "" {
+		return ""
+	}
+	return appConfig.CertSSMPrefix + ssmParameterUnsafe.ReplaceAllString(record.RecordName, "_")
+}
+
+// validateSSMParameterName checks a parameter name or prefix given in the
+// configuration.
+func validateSSMParameterName(setting, name string) error {
+	if !strings.HasPrefix(name, "/") || ssmParameterUnsafe.MatchString(name) {
+		return fmt.Errorf("invalid %s %q: expected a path such as /certs/example.com", setting, name)
+	}
+	return nil
+}
+
+// publishCertificateArn writes the record's stored certificate ARN to its
+// SSM parameter, so that Terraform, CDK and other services can look it up.
+// The parameter is only written when its value differs.
+func publishCertificateArn(ctx context.Context, appConfig *AppConfig, client *ssm.Client, record RecordConfig) error {
+	name := certSSMParameter(appConfig, record)
+	certArn, _ := getStoredString(certStateFile(certStateKey(record)))
+	if name == "" || certArn == "" {
+		return nil
+	}
+	logger := slog.With("component", "acm", "domain", record.RecordName, "parameter", name)
+
+	output, err := client.GetParameter(ctx, &ssm.GetParameterInput{Name: aws.String(name)})
+	var notFound *ssmtypes.ParameterNotFound
+	switch {
+	case err == nil && aws.ToString(output.Parameter.Value) == certArn:
+		return nil
+	case err != nil && !errors.As(err, &notFound):
+		return fmt.Errorf("failed to read SSM parameter %s: %w", name, err)
+	}
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would publish certificate ARN to SSM", "arn", certArn)
+		return nil
+	}
+	_, err = client.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:        aws.String(name),
+		Value:       aws.String(certArn),
+		Type:        ssmtypes.ParameterTypeString,
+		Overwrite:   aws.Bool(true),
+		Description: aws.String("ACM certificate ARN for " + record.RecordName + ", managed by auto-route53"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write SSM parameter %s: %w", name, err)
+	}
+	logger.Info("Published certificate ARN to SSM", "arn", certArn)
+	return nil
+}
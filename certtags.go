@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+)
+
+// --- Certificate Tags ---
+
+const (
+	certManagedByTagKey   = "managed-by"
+	certManagedByTagValue = "auto-route53"
+
+	// maxCertTags is the most tags ACM allows on one certificate.
+	maxCertTags = 50
+)
+
+// parseTags parses the CERT_TAGS setting, given either as comma separated
+// key=value pairs or, from the config file, as a table.
+func parseTags(value string) (map[string]string, error) {
+	tags := map[string]string{}
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return tags, nil
+	}
+	if strings.HasPrefix(value, "{") {
+		if err := json.Unmarshal([]byte(value), &tags); err != nil {
+			return nil, fmt.Errorf("invalid CERT_TAGS: %w", err)
+		}
+	} else {
+		for _, pair := range strings.Split(value, ",") {
+			if pair = strings.TrimSpace(pair); pair == "" {
+				continue
+			}
+			key, tagValue, ok := strings.Cut(pair, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid CERT_TAGS entry %q: expected key=value", pair)
+			}
+			tags[strings.TrimSpace(key)] = strings.TrimSpace(tagValue)
+		}
+	}
+	if err := validateTags(tags); err != nil {
+		return nil, fmt.Errorf("invalid CERT_TAGS: %w", err)
+	}
+	return tags, nil
+}
+
+// validateTags checks tags against ACM's limits.
+func validateTags(tags map[string]string) error {
+	for key, value := range tags {
+		if key == "" || len(key) > 128 {
+			return fmt.Errorf("tag key %q must be 1 to 128 characters", key)
+		}
+		if strings.HasPrefix(strings.ToLower(key), "aws:") {
+			return fmt.Errorf("tag key %q uses the reserved aws: prefix", key)
+		}
+		if len(value) > 256 {
+			return fmt.Errorf("value of tag %q is longer than 256 characters", key)
+		}
+	}
+	return nil
+}
+
+// normalizeCertTags validates the record's cert_tags.
+func normalizeCertTags(record *RecordConfig) error {
+	if len(record.CertTags) == 0 {
+		return nil
+	}
+	if !record.TLS {
+		return fmt.Errorf("record %s has cert_tags but tls is not enabled", record.RecordName)
+	}
+	if err := validateTags(record.CertTags); err != nil {
+		return fmt.Errorf("record %s has invalid cert_tags: %w", record.RecordName, err)
+	}
+	return nil
+}
+
+// certificateTags returns the tags to request the record's certificate
+// with: managed-by=auto-route53, then CERT_TAGS, then the record's own
+// cert_tags, later ones taking precedence.
+func certificateTags(appConfig *AppConfig, record RecordConfig) ([]acmtypes.Tag, error) {
+	merged := map[string]string{certManagedByTagKey: certManagedByTagValue}
+	maps.Copy(merged, appConfig.CertTags)
+	maps.Copy(merged, record.CertTags)
+	if len(merged) > maxCertTags {
+		return nil, fmt.Errorf("certificate for %s would have %d tags, ACM allows at most %d", record.RecordName, len(merged), maxCertTags)
+	}
+	tags := make([]acmtypes.Tag, 0, len(merged))
+	for _, key := range slices.Sorted(maps.Keys(merged)) {
+		tags = append(tags, acmtypes.Tag{Key: aws.String(key), Value: aws.String(merged[key])})
+	}
+	return tags, nil
+}
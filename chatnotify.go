@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// --- Chat Notifiers ---
+
+// telegramAPIURL is the Bot API endpoint, followed by the bot token.
+const telegramAPIURL = "https://api.telegram.org/bot"
+
+// discordMaxContent is the longest message Discord accepts, in characters.
+const discordMaxContent = 2000
+
+// formatNotification renders an event as a short chat message: the message
+// itself, then one line per detail.
+func formatNotification(event notifyEvent) string {
+	var text strings.Builder
+	fmt.Fprintf(&text, "auto-route53: %s", event.Message)
+	keys := make([]string, 0, len(event.Details))
+	for key := range event.Details {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		if value := event.Details[key]; value != "" {
+			fmt.Fprintf(&text, "\n%s: %s", key, value)
+		}
+	}
+	return text.String()
+}
+
+// slackNotifier posts to a Slack incoming webhook.
+type slackNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *slackNotifier) Name() string { return "slack" }
+
+func (n *slackNotifier) Send(ctx context.Context, event notifyEvent) error {
+	body, err := json.Marshal(map[string]string{"text": formatNotification(event)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.client, n.url, body, nil)
+}
+
+// discordNotifier posts to a Discord channel webhook.
+type discordNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (n *discordNotifier) Name() string { return "discord" }
+
+func (n *discordNotifier) Send(ctx context.Context, event notifyEvent) error {
+	content := []rune(formatNotification(event))
+	if len(content) > discordMaxContent {
+		content = append(content[:discordMaxContent-3], []rune("...")...)
+	}
+	body, err := json.Marshal(map[string]string{"content": string(content)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.client, n.url, body, nil)
+}
+
+// telegramNotifier sends messages through a Telegram bot to one chat.
+type telegramNotifier struct {
+	token  string
+	chatID string
+	client *http.Client
+}
+
+func (n *telegramNotifier) Name() string { return "telegram" }
+
+func (n *telegramNotifier) Send(ctx context.Context, event notifyEvent) error {
+	body, err := json.Marshal(map[string]string{"chat_id": n.chatID, "text": formatNotification(event)})
+	if err != nil {
+		return err
+	}
+	return postJSON(ctx, n.client, telegramAPIURL+n.token+"/sendMessage", body, nil)
+}
+
+// loadChatNotifiers builds the Slack, Discord and Telegram targets that are
+// configured.
+func loadChatNotifiers(settings *configSource, client *http.Client) ([]notifyTarget, error) {
+	var targets []notifyTarget
+	if slackURL := settings.Get("SLACK_WEBHOOK_URL"); slackURL != "" {
+		if err := validateEndpointURL("SLACK_WEBHOOK_URL", slackURL); err != nil {
+			return nil, err
+		}
+		events, err := parseNotifyEvents(settings, "SLACK_EVENTS")
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, notifyTarget{&slackNotifier{url: slackURL, client: client}, events})
+	}
+	if discordURL := settings.Get("DISCORD_WEBHOOK_URL"); discordURL != "" {
+		if err := validateEndpointURL("DISCORD_WEBHOOK_URL", discordURL); err != nil {
+			return nil, err
+		}
+		events, err := parseNotifyEvents(settings, "DISCORD_EVENTS")
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, notifyTarget{&discordNotifier{url: discordURL, client: client}, events})
+	}
+	token, chatID := settings.Get("TELEGRAM_BOT_TOKEN"), settings.Get("TELEGRAM_CHAT_ID")
+	if token != "" || chatID != "" {
+		if token == "" || chatID == "" {
+			return nil, fmt.Errorf("TELEGRAM_BOT_TOKEN and TELEGRAM_CHAT_ID must be set together")
+		}
+		events, err := parseNotifyEvents(settings, "TELEGRAM_EVENTS")
+		if err != nil {
+			return nil, err
+		}
+		targets = append(targets, notifyTarget{&telegramNotifier{token: token, chatID: chatID, client: client}, events})
+	}
+	return targets, nil
+}
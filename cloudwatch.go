@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatch"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
+)
+
+// --- CloudWatch Metrics ---
+
+// cloudWatchMaxDatums is the most metric data PutMetricData takes at once.
+const cloudWatchMaxDatums = 1000
+
+var (
+	cloudWatchMu sync.Mutex
+	// cloudWatchData holds the data recorded since the last flush.
+	cloudWatchData   []cwtypes.MetricDatum
+	cloudWatchOnce   sync.Once
+	cloudWatchClient *cloudwatch.Client
+)
+
+// validateCloudWatchNamespace checks CLOUDWATCH_NAMESPACE against the rules
+// of PutMetricData.
+func validateCloudWatchNamespace(namespace string) error {
+	if len(namespace) > 255 || strings.HasPrefix(namespace, "AWS/") {
+		return fmt.Errorf("invalid CLOUDWATCH_NAMESPACE %q: must be at most 255 characters and not start with AWS/", namespace)
+	}
+	return nil
+}
+
+// putCloudWatchMetric records a datum to publish at the end of the cycle, if
+// CLOUDWATCH_NAMESPACE is set. dimensions are name and value pairs.
+func putCloudWatchMetric(appConfig *AppConfig, name string, unit cwtypes.StandardUnit, value float64, dimensions ...string) {
+	if appConfig.CloudWatchNamespace == "" {
+		return
+	}
+	datum := cwtypes.MetricDatum{
+		MetricName: aws.String(name),
+		Unit:       unit,
+		Value:      aws.Float64(value),
+		Timestamp:  aws.Time(time.Now()),
+	}
+	for i := 0; i+1 < len(dimensions); i += 2 {
+		datum.Dimensions = append(datum.Dimensions, cwtypes.Dimension{Name: aws.String(dimensions[i]), Value: aws.String(dimensions[i+1])})
+	}
+	cloudWatchMu.Lock()
+	defer cloudWatchMu.Unlock()
+	cloudWatchData = append(cloudWatchData, datum)
+}
+
+// flushCloudWatchMetrics publishes the data recorded since the last flush.
+// Data that fails to publish is dropped, so that an unreachable CloudWatch
+// cannot grow the buffer without bound.
+func flushCloudWatchMetrics(ctx context.Context, appConfig *AppConfig) {
+	if appConfig.CloudWatchNamespace == "" {
+		return
+	}
+	cloudWatchMu.Lock()
+	data := cloudWatchData
+	cloudWatchData = nil
+	cloudWatchMu.Unlock()
+	if len(data) == 0 {
+		return
+	}
+	logger := loggerFrom(ctx)
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would publish CloudWatch metrics", "namespace", appConfig.CloudWatchNamespace, "count", len(data))
+		return
+	}
+	cloudWatchOnce.Do(func() {
+		cloudWatchClient = cloudwatch.NewFromConfig(baseAWSConfig, func(o *cloudwatch.Options) {
+			if appConfig.CloudWatchRegion != "" {
+				o.Region = appConfig.CloudWatchRegion
+			}
+		})
+	})
+	putCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	for start := 0; start < len(data); start += cloudWatchMaxDatums {
+		chunk := data[start:min(start+cloudWatchMaxDatums, len(data))]
+		_, err := cloudWatchClient.PutMetricData(putCtx, &cloudwatch.PutMetricDataInput{
+			Namespace:  aws.String(appConfig.CloudWatchNamespace),
+			MetricData: chunk,
+		})
+		if err != nil {
+			logger.Warn("Failed to publish CloudWatch metrics", "namespace", appConfig.CloudWatchNamespace, "count", len(chunk), "error", err)
+			continue
+		}
+		logger.Debug("Published CloudWatch metrics", "namespace", appConfig.CloudWatchNamespace, "count", len(chunk))
+	}
+}
+
+// putCertificateExpiryMetric records the days left before a certificate
+// expires.
+func putCertificateExpiryMetric(appConfig *AppConfig, domainName string, notAfter time.Time) {
+	putCloudWatchMetric(appConfig, "CertificateDaysToExpiry", cwtypes.StandardUnitNone, time.Until(notAfter).Hours()/24, "Domain", domainName)
+}
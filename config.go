@@ -0,0 +1,1033 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// --- Configuration Loading ---
+
+// configSource resolves settings by name. Environment variables always win;
+// otherwise the value comes from CONFIG_FILE, where settings use the
+// lowercase form of the variable name (e.g. sleep_time, records_to_update).
+// It remembers where each setting it returned came from.
+type configSource struct {
+	path    string
+	file    map[string]interface{}
+	origins map[string]string
+}
+
+// The origins of a setting.
+const (
+	originEnv  = "environment"
+	originFile = "config file"
+)
+
+// newConfigSource loads the optional config file. The format is chosen from
+// the file extension: .yaml/.yml, .toml or .json.
+func newConfigSource(path string) (*configSource, error) {
+	source := &configSource{path: path, file: map[string]interface{}{}, origins: map[string]string{}}
+	if path == "" {
+		return source, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CONFIG_FILE %s: %w", path, err)
+	}
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported CONFIG_FILE format %q: use .yaml, .yml, .toml or .json", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse CONFIG_FILE %s: %w", path, err)
+	}
+	for key, value := range raw {
+		source.file[strings.ToLower(key)] = value
+	}
+	return source, nil
+}
+
+// Get returns the setting as a string. Lists and tables from the file, such
+// as records_to_update, are returned as JSON so they go through the same
+// parsing as their environment variable form.
+func (c *configSource) Get(key string) string {
+	if value := os.Getenv(key); value != "" {
+		c.origins[key] = originEnv
+		return value
+	}
+	value := c.fileValue(key)
+	if value != "" {
+		c.origins[key] = originFile
+	}
+	return value
+}
+
+// fileValue returns the setting as CONFIG_FILE gives it, ignoring the
+// environment.
+func (c *configSource) fileValue(key string) string {
+	value, ok := c.file[strings.ToLower(key)]
+	if !ok || value == nil {
+		return ""
+	}
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case int, int64, bool:
+		return fmt.Sprint(v)
+	default:
+		encoded, err := json.Marshal(v)
+		if err != nil {
+			return fmt.Sprint(v)
+		}
+		return string(encoded)
+	}
+}
+
+// GetBool parses a boolean setting, returning fallback when it is unset.
+func (c *configSource) GetBool(key string, fallback bool) (bool, error) {
+	value := c.Get(key)
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("invalid %s %q: must be true or false", key, value)
+	}
+	return parsed, nil
+}
+
+// GetDuration parses a duration setting given either as a Go duration
+// string such as "30s" or as a plain number of seconds.
+func (c *configSource) GetDuration(key string, fallback time.Duration) (time.Duration, error) {
+	value := strings.TrimSpace(c.Get(key))
+	if value == "" {
+		return fallback, nil
+	}
+	duration, err := parseSleepTime(value, "")
+	if err != nil || duration <= 0 {
+		return 0, fmt.Errorf("invalid %s %q: must be a positive duration such as 10s", key, value)
+	}
+	return duration, nil
+}
+
+// GetList returns a list setting. The environment form is comma separated;
+// the file may use either a native list or the same comma separated string.
+func (c *configSource) GetList(key string, fallback []string) []string {
+	value := c.Get(key)
+	var items []string
+	if strings.HasPrefix(value, "[") && json.Unmarshal([]byte(value), &items) == nil {
+		value = strings.Join(items, ",")
+	}
+	items = nil
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	if len(items) == 0 {
+		return fallback
+	}
+	return items
+}
+
+func (c *configSource) GetDefault(key, fallback string) string {
+	if value := c.Get(key); value != "" {
+		return value
+	}
+	return fallback
+}
+
+var sleepTimeUnits = map[string]time.Duration{
+	"seconds": time.Second,
+	"minutes": time.Minute,
+	"hours":   time.Hour,
+}
+
+// parseSleepTime interprets SLEEP_TIME either as a plain number in the given
+// unit (seconds by default) or as a Go duration string such as "5m". A unit
+// is rejected when combined with a duration string, since it is unclear
+// which one the user meant.
+func parseSleepTime(value, unit string) (time.Duration, error) {
+	value = strings.TrimSpace(value)
+	unit = strings.ToLower(strings.TrimSpace(unit))
+	if value == "" {
+		return 300 * time.Second, nil
+	}
+
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		if unit == "" {
+			unit = "seconds"
+		}
+		multiplier, ok := sleepTimeUnits[unit]
+		if !ok {
+			return 0, fmt.Errorf("invalid SLEEP_TIME_UNIT %q: must be seconds, minutes or hours", unit)
+		}
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid SLEEP_TIME %q: must be greater than zero", value)
+		}
+		return time.Duration(n) * multiplier, nil
+	}
+
+	if unit != "" {
+		return 0, fmt.Errorf("SLEEP_TIME_UNIT cannot be combined with the duration string SLEEP_TIME=%q; use either a plain number with a unit or a duration string", value)
+	}
+	sleepTime, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid SLEEP_TIME format: %w", err)
+	}
+	if sleepTime <= 0 {
+		return 0, fmt.Errorf("invalid SLEEP_TIME %q: must be greater than zero", value)
+	}
+	return sleepTime, nil
+}
+
+// normalizeRecords trims stray whitespace from the string fields of each
+// record (a common copy-paste mistake), canonicalizes the record names, and
+// validates the cleaned values. An empty zone_id is left for
+// resolveRecordZones to discover.
+func normalizeRecords(records []RecordConfig) error {
+	for i := range records {
+		record := &records[i]
+		record.ZoneID = strings.TrimSpace(record.ZoneID)
+		record.ValidationZoneID = strings.TrimSpace(record.ValidationZoneID)
+		record.RecordName = canonicalName(record.RecordName)
+
+		if record.RecordName == "" {
+			return fmt.Errorf("record %d in RECORDS_TO_UPDATE has an empty record_name", i)
+		}
+		if strings.ContainsAny(record.ZoneID, " \t\r\n") {
+			return fmt.Errorf("record %s has an invalid zone_id %q", record.RecordName, record.ZoneID)
+		}
+		if strings.ContainsAny(record.RecordName, " \t\r\n") {
+			return fmt.Errorf("record %q has whitespace inside record_name", record.RecordName)
+		}
+		if record.Port < 0 || record.Port > 65535 {
+			return fmt.Errorf("record %s has an invalid port %d", record.RecordName, record.Port)
+		}
+		if record.TTL == 0 {
+			record.TTL = defaultRecordTTL
+		}
+		if record.TTL < 0 || record.TTL > 2147483647 {
+			return fmt.Errorf("record %s has an invalid ttl %d", record.RecordName, record.TTL)
+		}
+		if err := normalizeRecordType(record); err != nil {
+			return err
+		}
+		if err := normalizeRoutingPolicy(record); err != nil {
+			return err
+		}
+		if err := normalizeHealthCheck(record); err != nil {
+			return err
+		}
+		if err := normalizeRecordMode(record); err != nil {
+			return err
+		}
+		if err := normalizeReverseZones(record); err != nil {
+			return err
+		}
+		if err := normalizeRole(record); err != nil {
+			return err
+		}
+		if err := normalizeCertificateNames(record); err != nil {
+			return err
+		}
+		if err := normalizeKeyAlgorithm(record); err != nil {
+			return err
+		}
+		if err := normalizeCertTags(record); err != nil {
+			return err
+		}
+		if record.SSMParameter = strings.TrimSpace(record.SSMParameter); record.SSMParameter != "" {
+			if err := validateSSMParameterName("ssm_parameter of record "+record.RecordName, record.SSMParameter); err != nil {
+				return err
+			}
+		}
+		if err := normalizeKubernetesTargets(record); err != nil {
+			return err
+		}
+		if err := normalizeListenerArns(record); err != nil {
+			return err
+		}
+		if err := normalizeCloudFrontDistribution(record); err != nil {
+			return err
+		}
+		if err := normalizeAPIGatewayDomain(record); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func loadConfig() (*AppConfig, error) {
+	return readConfig(true)
+}
+
+// readConfig loads the configuration, which need not list any records
+// unless requireRecords is set. The history command reads only the state
+// backend, so it runs without RECORDS_TO_UPDATE.
+func readConfig(requireRecords bool) (*AppConfig, error) {
+	settings, err := newConfigSource(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		return nil, err
+	}
+
+	sleepTime, err := parseSleepTime(settings.Get("SLEEP_TIME"), settings.Get("SLEEP_TIME_UNIT"))
+	if err != nil {
+		return nil, err
+	}
+
+	records, recordOrigins, configMerge, err := loadRecords(settings, requireRecords)
+	if err != nil {
+		return nil, err
+	}
+
+	certMode := strings.ToLower(settings.Get("CERT_MODE"))
+	if certMode == "" {
+		certMode = certModeManage
+	}
+	if certMode != certModeManage && certMode != certModeReport {
+		return nil, fmt.Errorf("invalid CERT_MODE %q: must be %q or %q", certMode, certModeManage, certModeReport)
+	}
+
+	certTags, err := parseTags(settings.Get("CERT_TAGS"))
+	if err != nil {
+		return nil, err
+	}
+	certReconcilePeriod, err := settings.GetDuration("CERT_RECONCILE_INTERVAL", defaultCertReconcilePeriod)
+	if err != nil {
+		return nil, err
+	}
+	certExpiryWarning, err := settings.GetDuration("CERT_EXPIRY_WARNING", defaultCertExpiryWarning)
+	if err != nil {
+		return nil, err
+	}
+	certBackend := strings.ToLower(settings.GetDefault("CERT_BACKEND", certBackendACM))
+	if certBackend != certBackendACM && certBackend != certBackendACME {
+		return nil, fmt.Errorf("invalid CERT_BACKEND %q: must be %q or %q", certBackend, certBackendACM, certBackendACME)
+	}
+	acmeDirectoryURL := settings.GetDefault("ACME_DIRECTORY_URL", defaultACMEDirectoryURL)
+	if err := validateEndpointURL("ACME_DIRECTORY_URL", acmeDirectoryURL); err != nil {
+		return nil, err
+	}
+	certSSMPrefix := settings.Get("CERT_SSM_PREFIX")
+	if certSSMPrefix != "" {
+		if err := validateSSMParameterName("CERT_SSM_PREFIX", certSSMPrefix); err != nil {
+			return nil, err
+		}
+	}
+	kubernetesNamespace := settings.Get("KUBERNETES_NAMESPACE")
+	if kubernetesNamespace != "" && !kubeNamePattern.MatchString(kubernetesNamespace) {
+		return nil, fmt.Errorf("invalid KUBERNETES_NAMESPACE %q", kubernetesNamespace)
+	}
+	certDropValidation, err := settings.GetBool("CERT_REMOVE_VALIDATION_RECORDS", false)
+	if err != nil {
+		return nil, err
+	}
+	certCleanup := strings.ToLower(settings.GetDefault("CERT_CLEANUP", certCleanupOff))
+	if certCleanup != certCleanupOff && certCleanup != certCleanupReport && certCleanup != certCleanupDelete {
+		return nil, fmt.Errorf("invalid CERT_CLEANUP %q: must be %q, %q or %q", certCleanup, certCleanupOff, certCleanupReport, certCleanupDelete)
+	}
+
+	regionCheck := strings.ToLower(settings.Get("ACM_REGION_CHECK"))
+	if regionCheck == "" {
+		regionCheck = regionCheckWarn
+	}
+	if regionCheck != regionCheckWarn && regionCheck != regionCheckAbort && regionCheck != regionCheckOff {
+		return nil, fmt.Errorf("invalid ACM_REGION_CHECK %q: must be %q, %q or %q", regionCheck, regionCheckWarn, regionCheckAbort, regionCheckOff)
+	}
+
+	caaCheck := strings.ToLower(settings.Get("CAA_CHECK"))
+	if caaCheck == "" {
+		caaCheck = caaCheckWarn
+	}
+	if caaCheck != caaCheckOff && caaCheck != caaCheckWarn && caaCheck != caaCheckBlock && caaCheck != caaCheckProvision {
+		return nil, fmt.Errorf("invalid CAA_CHECK %q: must be %q, %q, %q or %q", caaCheck, caaCheckOff, caaCheckWarn, caaCheckBlock, caaCheckProvision)
+	}
+
+	ipResponseMaxBytes := int64(defaultIPResponseMaxBytes)
+	if value := settings.Get("IP_RESPONSE_MAX_BYTES"); value != "" {
+		ipResponseMaxBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || ipResponseMaxBytes <= 0 {
+			return nil, fmt.Errorf("invalid IP_RESPONSE_MAX_BYTES %q: must be a positive number of bytes", value)
+		}
+	}
+
+	ipCheckMode := strings.ToLower(settings.GetDefault("IP_CHECK_MODE", ipCheckFallback))
+	if ipCheckMode != ipCheckFallback && ipCheckMode != ipCheckConsensus {
+		return nil, fmt.Errorf("invalid IP_CHECK_MODE %q: must be %q or %q", ipCheckMode, ipCheckFallback, ipCheckConsensus)
+	}
+
+	ipCheckTimeout, err := settings.GetDuration("IP_CHECK_TIMEOUT", defaultIPCheckTimeout)
+	if err != nil {
+		return nil, err
+	}
+	caBundle := settings.Get("CA_BUNDLE")
+	var rootCAs *x509.CertPool
+	if caBundle != "" {
+		rootCAs, err = loadCABundle(caBundle)
+		if err != nil {
+			return nil, err
+		}
+	}
+	sourceOptions := ipSourceOptions{
+		MaxBytes:  ipResponseMaxBytes,
+		UserAgent: settings.GetDefault("IP_CHECK_USER_AGENT", defaultIPCheckUserAgent),
+		RootCAs:   rootCAs,
+	}
+	ipv4Sources, err := newIPSources(settings.GetList("IP_CHECK_URLS", defaultIPCheckURLs), sourceOptions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP_CHECK_URLS: %w", err)
+	}
+	// IPV6_CHECK_URL predates the list form and is still honoured.
+	ipv6URLs := settings.GetList("IPV6_CHECK_URLS", settings.GetList("IPV6_CHECK_URL", defaultIPv6CheckURLs))
+	ipv6Sources, err := newIPSources(ipv6URLs, sourceOptions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IPV6_CHECK_URLS: %w", err)
+	}
+	notifiers, err := loadNotifiers(settings, rootCAs)
+	if err != nil {
+		return nil, err
+	}
+	heartbeatURL, heartbeatFailURL := settings.Get("HEARTBEAT_URL"), settings.Get("HEARTBEAT_FAIL_URL")
+	for name, value := range map[string]string{"HEARTBEAT_URL": heartbeatURL, "HEARTBEAT_FAIL_URL": heartbeatFailURL} {
+		if value != "" {
+			if err := validateEndpointURL(name, value); err != nil {
+				return nil, err
+			}
+		}
+	}
+	cloudWatchNamespace := settings.Get("CLOUDWATCH_NAMESPACE")
+	if err := validateCloudWatchNamespace(cloudWatchNamespace); err != nil {
+		return nil, err
+	}
+	// As in the OpenTelemetry SDKs, the base endpoint gets the traces path
+	// appended and the traces endpoint is used as it is.
+	otlpTracesEndpoint := settings.Get("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if base := settings.Get("OTEL_EXPORTER_OTLP_ENDPOINT"); otlpTracesEndpoint == "" && base != "" {
+		otlpTracesEndpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+	}
+	if otlpTracesEndpoint != "" {
+		if err := validateEndpointURL("OTLP traces endpoint", otlpTracesEndpoint); err != nil {
+			return nil, err
+		}
+	}
+	ipHistorySize := defaultIPHistorySize
+	if value := settings.Get("IP_HISTORY_SIZE"); value != "" {
+		ipHistorySize, err = strconv.Atoi(value)
+		if err != nil || ipHistorySize < 0 {
+			return nil, fmt.Errorf("invalid IP_HISTORY_SIZE %q: must be a number of entries, or 0", value)
+		}
+	}
+	dashboard, err := settings.GetBool("DASHBOARD", true)
+	if err != nil {
+		return nil, err
+	}
+	statusRedactARNs, err := settings.GetBool("STATUS_REDACT_ARNS", false)
+	if err != nil {
+		return nil, err
+	}
+	triggerTrustIP, err := settings.GetBool("TRIGGER_TRUST_IP", false)
+	if err != nil {
+		return nil, err
+	}
+	dyndnsUsers, err := parseDynDNSUsers(settings)
+	if err != nil {
+		return nil, err
+	}
+	rfc2136Addr := settings.Get("RFC2136_ADDR")
+	rfc2136Keys, err := parseTSIGKeys(settings)
+	if err != nil {
+		return nil, err
+	}
+	rfc2136Zones, err := parseRFC2136Zones(settings)
+	if err != nil {
+		return nil, err
+	}
+	if rfc2136Addr != "" && (len(rfc2136Keys) == 0 || len(rfc2136Zones) == 0) {
+		return nil, fmt.Errorf("RFC2136_ADDR needs RFC2136_TSIG_KEYS and RFC2136_ZONES")
+	}
+	notifyFailureLimit := defaultNotifyFailureThreshold
+	if value := settings.Get("NOTIFY_FAILURE_THRESHOLD"); value != "" {
+		notifyFailureLimit, err = strconv.Atoi(value)
+		if err != nil || notifyFailureLimit < 1 {
+			return nil, fmt.Errorf("invalid NOTIFY_FAILURE_THRESHOLD %q: must be a positive number", value)
+		}
+	}
+	notifyCoalesceWindow, err := settings.GetDuration("NOTIFY_COALESCE_WINDOW", 0)
+	if err != nil {
+		return nil, err
+	}
+	var notifyRateLimit int
+	if value := settings.Get("NOTIFY_RATE_LIMIT"); value != "" {
+		notifyRateLimit, err = strconv.Atoi(value)
+		if err != nil || notifyRateLimit < 1 {
+			return nil, fmt.Errorf("invalid NOTIFY_RATE_LIMIT %q: must be a positive number of notifications per hour", value)
+		}
+	}
+
+	dryRun, err := settings.GetBool("DRY_RUN", false)
+	if err != nil {
+		return nil, err
+	}
+
+	stateless, err := settings.GetBool("STATELESS", false)
+	if err != nil {
+		return nil, err
+	}
+
+	stateBackend := strings.ToLower(settings.GetDefault("STATE_BACKEND", stateBackendFile))
+	stateS3Bucket := settings.Get("STATE_S3_BUCKET")
+	stateDynamoDBTable := settings.Get("STATE_DYNAMODB_TABLE")
+	switch stateBackend {
+	case stateBackendFile, stateBackendSSM:
+	case stateBackendS3:
+		if stateS3Bucket == "" {
+			return nil, fmt.Errorf("STATE_BACKEND=s3 requires STATE_S3_BUCKET")
+		}
+	case stateBackendDynamoDB:
+		if stateDynamoDBTable == "" {
+			return nil, fmt.Errorf("STATE_BACKEND=dynamodb requires STATE_DYNAMODB_TABLE")
+		}
+	default:
+		return nil, fmt.Errorf("invalid STATE_BACKEND %q: must be %q, %q, %q or %q", stateBackend, stateBackendFile, stateBackendS3, stateBackendDynamoDB, stateBackendSSM)
+	}
+
+	leaderElection := strings.ToLower(settings.GetDefault("LEADER_ELECTION", leaderElectionOff))
+	leaderTable := settings.GetDefault("LEADER_ELECTION_TABLE", stateDynamoDBTable)
+	leaderBucket := settings.GetDefault("LEADER_ELECTION_BUCKET", stateS3Bucket)
+	switch leaderElection {
+	case leaderElectionOff:
+	case leaderElectionDynamoDB:
+		if leaderTable == "" {
+			return nil, fmt.Errorf("LEADER_ELECTION=dynamodb requires LEADER_ELECTION_TABLE or STATE_DYNAMODB_TABLE")
+		}
+	case leaderElectionS3:
+		if leaderBucket == "" {
+			return nil, fmt.Errorf("LEADER_ELECTION=s3 requires LEADER_ELECTION_BUCKET or STATE_S3_BUCKET")
+		}
+	default:
+		return nil, fmt.Errorf("invalid LEADER_ELECTION %q: must be %q, %q or %q", leaderElection, leaderElectionOff, leaderElectionDynamoDB, leaderElectionS3)
+	}
+	leaderLease, err := settings.GetDuration("LEADER_LEASE_DURATION", defaultLeaderLeaseDuration)
+	if err != nil {
+		return nil, err
+	}
+	leaderKey := settings.GetDefault("LEADER_ELECTION_KEY", "leader")
+	if leaderElection == leaderElectionS3 {
+		leaderKey = settings.GetDefault("LEADER_ELECTION_KEY", settings.GetDefault("STATE_S3_PREFIX", "auto-route53/")+"leader.json")
+	}
+
+	ownershipForce, err := settings.GetBool("OWNERSHIP_FORCE", false)
+	if err != nil {
+		return nil, err
+	}
+	ownerID := settings.Get("OWNER_ID")
+	if strings.ContainsAny(ownerID, ",\" \t") {
+		return nil, fmt.Errorf("invalid OWNER_ID %q: must not contain commas, quotes or whitespace", ownerID)
+	}
+
+	waitForInsync, err := settings.GetBool("WAIT_FOR_INSYNC", false)
+	if err != nil {
+		return nil, err
+	}
+	insyncTimeout, err := settings.GetDuration("INSYNC_TIMEOUT", defaultInsyncTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	verifyPropagation, err := settings.GetBool("VERIFY_PROPAGATION", false)
+	if err != nil {
+		return nil, err
+	}
+	propagationTimeout, err := settings.GetDuration("PROPAGATION_TIMEOUT", defaultPropagationTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	certValidationWait, err := settings.GetDuration("CERT_VALIDATION_TIMEOUT", defaultCertValidationWait)
+	if err != nil {
+		return nil, err
+	}
+	certPollInterval, err := settings.GetDuration("CERT_POLL_INTERVAL", defaultCertPollInterval)
+	if err != nil {
+		return nil, err
+	}
+	certConcurrency := defaultCertConcurrency
+	if value := settings.Get("CERT_CONCURRENCY"); value != "" {
+		certConcurrency, err = strconv.Atoi(value)
+		if err != nil || certConcurrency < 1 {
+			return nil, fmt.Errorf("invalid CERT_CONCURRENCY %q: must be a positive number", value)
+		}
+	}
+
+	retryMaxAttempts := defaultRetryMaxAttempts
+	if value := settings.Get("RETRY_MAX_ATTEMPTS"); value != "" {
+		retryMaxAttempts, err = strconv.Atoi(value)
+		if err != nil || retryMaxAttempts < 1 {
+			return nil, fmt.Errorf("invalid RETRY_MAX_ATTEMPTS %q: must be a positive number", value)
+		}
+	}
+	retryMaxBackoff, err := settings.GetDuration("RETRY_MAX_BACKOFF", defaultRetryMaxBackoff)
+	if err != nil {
+		return nil, err
+	}
+	retryMode := strings.ToLower(settings.GetDefault("RETRY_MODE", retryModeStandard))
+	if retryMode != retryModeStandard && retryMode != retryModeAdaptive {
+		return nil, fmt.Errorf("invalid RETRY_MODE %q: must be %q or %q", retryMode, retryModeStandard, retryModeAdaptive)
+	}
+	apiTimeout, err := settings.GetDuration("API_TIMEOUT", defaultAPITimeout)
+	if err != nil {
+		return nil, err
+	}
+	apiConnectTimeout, err := settings.GetDuration("API_CONNECT_TIMEOUT", defaultAPIConnectTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	useFIPSEndpoint, err := settings.GetBool("USE_FIPS_ENDPOINT", false)
+	if err != nil {
+		return nil, err
+	}
+
+	awsEndpointURL := settings.Get("AWS_ENDPOINT_URL")
+	if awsEndpointURL != "" {
+		if err := validateEndpointURL("AWS_ENDPOINT_URL", awsEndpointURL); err != nil {
+			return nil, err
+		}
+	}
+	awsServiceEndpoints, err := loadEndpointOverrides(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	runOnce, err := settings.GetBool("RUN_ONCE", false)
+	if err != nil {
+		return nil, err
+	}
+	if len(os.Args) > 1 && os.Args[1] == "once" {
+		runOnce = true
+	}
+
+	return &AppConfig{
+		SleepTime:            sleepTime,
+		RecordsToUpdate:      records,
+		NPMBaseURL:           settings.Get("NPM_URL"),
+		NPMIdentity:          settings.Get("NPM_IDENTITY"),
+		NPMSecret:            settings.Get("NPM_SECRET"),
+		ForwardHost:          settings.Get("FORWARD_HOST_IP"),
+		CertMode:             certMode,
+		CertTags:             certTags,
+		CertReconcilePeriod:  certReconcilePeriod,
+		CertConcurrency:      certConcurrency,
+		CertValidationWait:   certValidationWait,
+		CertPollInterval:     certPollInterval,
+		CertExpiryWarning:    certExpiryWarning,
+		CertCleanup:          certCleanup,
+		CertDropValidation:   certDropValidation,
+		CertBackend:          certBackend,
+		ACMEDirectoryURL:     acmeDirectoryURL,
+		ACMEEmail:            settings.Get("ACME_EMAIL"),
+		ACMECertDir:          settings.GetDefault("ACME_CERT_DIR", defaultACMECertDir),
+		CertSSMPrefix:        certSSMPrefix,
+		KubernetesNamespace:  kubernetesNamespace,
+		ACMRegionCheck:       regionCheck,
+		CAACheck:             caaCheck,
+		IPResponseMaxBytes:   ipResponseMaxBytes,
+		IPCheckMode:          ipCheckMode,
+		IPCheckTimeout:       ipCheckTimeout,
+		IPv4Sources:          ipv4Sources,
+		IPv6Sources:          ipv6Sources,
+		Notifiers:            notifiers,
+		NotifyFailureLimit:   notifyFailureLimit,
+		NotifyCoalesceWindow: notifyCoalesceWindow,
+		NotifyRateLimit:      notifyRateLimit,
+		HeartbeatURL:         heartbeatURL,
+		HeartbeatFailURL:     heartbeatFailURL,
+		CloudWatchNamespace:  cloudWatchNamespace,
+		CloudWatchRegion:     settings.Get("CLOUDWATCH_REGION"),
+		OTLPTracesEndpoint:   otlpTracesEndpoint,
+		AuditLog:             settings.Get("AUDIT_LOG"),
+		IPHistorySize:        ipHistorySize,
+		Dashboard:            dashboard,
+		APIToken:             settings.Get("API_TOKEN"),
+		StatusRedactARNs:     statusRedactARNs,
+		TriggerTrustIP:       triggerTrustIP,
+		DynDNSUsers:          dyndnsUsers,
+		RFC2136Addr:          rfc2136Addr,
+		RFC2136Keys:          rfc2136Keys,
+		RFC2136Zones:         rfc2136Zones,
+		DryRun:               dryRun,
+		Stateless:            stateless,
+		StateBackend:         stateBackend,
+		StateS3Bucket:        stateS3Bucket,
+		StateS3Prefix:        settings.GetDefault("STATE_S3_PREFIX", "auto-route53/"),
+		StateDynamoDBTable:   stateDynamoDBTable,
+		StateSSMPrefix:       settings.GetDefault("STATE_SSM_PREFIX", "/auto-route53/"),
+		LeaderElection:       leaderElection,
+		LeaderElectionTable:  leaderTable,
+		LeaderElectionBucket: leaderBucket,
+		LeaderElectionKey:    leaderKey,
+		LeaderLeaseDuration:  leaderLease,
+		OwnerID:              ownerID,
+		OwnershipTXTPrefix:   settings.GetDefault("OWNERSHIP_TXT_PREFIX", defaultOwnershipTXTPrefix),
+		OwnershipForce:       ownershipForce,
+		WaitForInsync:        waitForInsync,
+		InsyncTimeout:        insyncTimeout,
+		VerifyPropagation:    verifyPropagation,
+		PropagationTimeout:   propagationTimeout,
+		RetryMaxAttempts:     retryMaxAttempts,
+		RetryMaxBackoff:      retryMaxBackoff,
+		RetryMode:            retryMode,
+		APITimeout:           apiTimeout,
+		APIConnectTimeout:    apiConnectTimeout,
+		AWSEndpointURL:       awsEndpointURL,
+		AWSServiceEndpoints:  awsServiceEndpoints,
+		UseFIPSEndpoint:      useFIPSEndpoint,
+		CABundle:             caBundle,
+		RootCAs:              rootCAs,
+		RunOnce:              runOnce,
+		HTTPAddr:             settings.Get("HTTP_ADDR"),
+		GRPCAddr:             settings.Get("GRPC_ADDR"),
+		LogFormat:            settings.GetDefault("LOG_FORMAT", "text"),
+		LogLevel:             settings.GetDefault("LOG_LEVEL", "info"),
+		ConfigMerge:          configMerge,
+		Origins:              settings.report(recordOrigins),
+	}, nil
+}
+
+// --- Configuration Sources ---
+
+const (
+	configMergeReplace = "replace"
+	configMergeAppend  = "append"
+)
+
+// loadRecords reads RECORDS_TO_UPDATE. If both the environment and
+// CONFIG_FILE set it, CONFIG_MERGE decides: replace, the default, uses only
+// the environment's records, and append adds them to the file's, refusing a
+// record that is in both. It also returns where each record came from and
+// the merge policy.
+func loadRecords(settings *configSource, required bool) ([]RecordConfig, []string, string, error) {
+	configMerge := strings.ToLower(settings.GetDefault("CONFIG_MERGE", configMergeReplace))
+	if configMerge != configMergeReplace && configMerge != configMergeAppend {
+		return nil, nil, "", fmt.Errorf("invalid CONFIG_MERGE %q: must be %q or %q", configMerge, configMergeReplace, configMergeAppend)
+	}
+
+	// Replace single quotes with double quotes if needed
+	envRecords, err := parseRecords(strings.ReplaceAll(os.Getenv("RECORDS_TO_UPDATE"), "'", "\""), originEnv)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	fileRecords, err := parseRecords(settings.fileValue("RECORDS_TO_UPDATE"), originFile)
+	if err != nil {
+		return nil, nil, "", err
+	}
+	if envRecords == nil && fileRecords == nil {
+		if !required {
+			return nil, nil, configMerge, nil
+		}
+		return nil, nil, "", fmt.Errorf("RECORDS_TO_UPDATE not set in the environment or CONFIG_FILE")
+	}
+
+	var records []RecordConfig
+	var origins []string
+	add := func(from []RecordConfig, origin string) {
+		for _, record := range from {
+			records = append(records, record)
+			origins = append(origins, origin)
+		}
+	}
+	if envRecords == nil || configMerge == configMergeAppend {
+		add(fileRecords, originFile)
+	}
+	add(envRecords, originEnv)
+	// The records are normalized together so that errors name their index
+	// in the merged list.
+	if err := normalizeRecords(records); err != nil {
+		return nil, nil, "", err
+	}
+	if configMerge == configMergeAppend {
+		if err := checkMergedRecords(records, origins); err != nil {
+			return nil, nil, "", err
+		}
+	}
+	if envRecords != nil {
+		settings.origins["RECORDS_TO_UPDATE"] = originEnv
+	} else {
+		settings.origins["RECORDS_TO_UPDATE"] = originFile
+	}
+	return records, origins, configMerge, nil
+}
+
+// parseRecords parses the JSON form of RECORDS_TO_UPDATE from origin. It
+// returns nil if value is empty.
+func parseRecords(value, origin string) ([]RecordConfig, error) {
+	if value == "" {
+		return nil, nil
+	}
+	records := []RecordConfig{}
+	if err := json.Unmarshal([]byte(value), &records); err != nil {
+		return nil, fmt.Errorf("failed to parse RECORDS_TO_UPDATE JSON from the %s: %w", origin, err)
+	}
+	return records, nil
+}
+
+// checkMergedRecords refuses a record set that the environment and the
+// config file both define, which CONFIG_MERGE=append would otherwise update
+// twice with different settings.
+func checkMergedRecords(records []RecordConfig, origins []string) error {
+	fromFile := map[string]bool{}
+	for i, record := range records {
+		key := record.RecordName + "|" + record.Type + "|" + record.SetIdentifier
+		if origins[i] == originFile {
+			fromFile[key] = true
+		} else if fromFile[key] {
+			return fmt.Errorf("record %s is in RECORDS_TO_UPDATE in both the environment and CONFIG_FILE; with CONFIG_MERGE=append, define it in one of them", record.RecordName)
+		}
+	}
+	return nil
+}
+
+// configOrigins says where the settings in effect came from.
+type configOrigins struct {
+	// File is the CONFIG_FILE path, if any.
+	File string
+	// FromEnv and FromFile are the settings taken from each source.
+	FromEnv, FromFile []string
+	// Overridden are the settings of the file that the environment
+	// overrides.
+	Overridden []string
+	// Records gives the origin of each of RecordsToUpdate.
+	Records []string
+}
+
+// report returns where the settings looked up so far came from.
+func (c *configSource) report(recordOrigins []string) configOrigins {
+	origins := configOrigins{File: c.path, Records: recordOrigins}
+	for key, origin := range c.origins {
+		switch origin {
+		case originEnv:
+			origins.FromEnv = append(origins.FromEnv, key)
+			// Records the file adds to, under CONFIG_MERGE=append, are
+			// not overridden.
+			appended := key == "RECORDS_TO_UPDATE" && slices.Contains(recordOrigins, originFile)
+			if !appended && c.fileValue(key) != "" {
+				origins.Overridden = append(origins.Overridden, key)
+			}
+		case originFile:
+			origins.FromFile = append(origins.FromFile, key)
+		}
+	}
+	slices.Sort(origins.FromEnv)
+	slices.Sort(origins.FromFile)
+	slices.Sort(origins.Overridden)
+	return origins
+}
+
+// logConfigOrigins logs which settings and records came from the
+// environment and which from CONFIG_FILE.
+func logConfigOrigins(appConfig *AppConfig) {
+	origins := appConfig.Origins
+	if origins.File == "" {
+		slog.Info("Configuration loaded from the environment", "settings", origins.FromEnv)
+		return
+	}
+	slog.Info("Configuration loaded", "config_file", origins.File, "from_environment", origins.FromEnv, "from_file", origins.FromFile)
+	for _, key := range origins.Overridden {
+		slog.Warn("Setting in CONFIG_FILE is overridden by the environment", "setting", key, "config_file", origins.File)
+	}
+	var fromEnv, fromFile []string
+	for i, record := range appConfig.RecordsToUpdate {
+		if origins.Records[i] == originEnv {
+			fromEnv = append(fromEnv, record.RecordName)
+		} else {
+			fromFile = append(fromFile, record.RecordName)
+		}
+	}
+	slog.Info("Records loaded", "config_merge", appConfig.ConfigMerge, "from_environment", fromEnv, "from_file", fromFile)
+}
+
+// --- Effective Configuration ---
+
+// redacted replaces a secret in the effective configuration.
+const redacted = "(redacted)"
+
+// redactSecret hides value if it is set, so that the configuration still
+// shows whether a secret is configured.
+func redactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redacted
+}
+
+// redactURLSetting is redactURL for a setting that may be empty.
+func redactURLSetting(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactURL(value)
+}
+
+// redactSourceName describes an IP source without what may be secret: the
+// path and query of an HTTP source, which often carry an API key, and the
+// arguments of a command.
+func redactSourceName(source ipSource) string {
+	switch source := source.(type) {
+	case *httpIPSource:
+		return redactURL(source.url)
+	case *execIPSource:
+		return "exec:" + source.command[0]
+	}
+	return source.Name()
+}
+
+// effectiveConfig returns the settings in effect, keyed by their environment
+// variable, for the status response. Tokens, passwords, TSIG secrets and
+// credentials are replaced by redacted, every URL is cut down to its scheme
+// and host, and with STATUS_REDACT_ARNS so are the records' ARNs.
+func effectiveConfig(appConfig *AppConfig) map[string]any {
+	records := make([]RecordConfig, len(appConfig.RecordsToUpdate))
+	for i, record := range appConfig.RecordsToUpdate {
+		record.ExternalID = redactSecret(record.ExternalID)
+		if appConfig.StatusRedactARNs {
+			record.RoleARN = redactSecret(record.RoleARN)
+			record.ListenerArns = slices.Repeat([]string{redacted}, len(record.ListenerArns))
+		}
+		records[i] = record
+	}
+	sourceNames := func(sources []ipSource) []string {
+		names := make([]string, 0, len(sources))
+		for _, source := range sources {
+			names = append(names, redactSourceName(source))
+		}
+		return names
+	}
+	serviceEndpoints := make(map[string]string, len(appConfig.AWSServiceEndpoints))
+	for sdkID, endpoint := range appConfig.AWSServiceEndpoints {
+		serviceEndpoints[sdkID] = redactURL(endpoint)
+	}
+	notifiers := make([]map[string]any, 0, len(appConfig.Notifiers))
+	for _, target := range appConfig.Notifiers {
+		notifiers = append(notifiers, map[string]any{"name": target.Name(), "events": target.events})
+	}
+	dyndnsUsers := make([]dyndnsUser, len(appConfig.DynDNSUsers))
+	for i, user := range appConfig.DynDNSUsers {
+		user.Password = redactSecret(user.Password)
+		dyndnsUsers[i] = user
+	}
+	tsigKeys := make([]string, 0, len(appConfig.RFC2136Keys))
+	for _, key := range appConfig.RFC2136Keys {
+		tsigKeys = append(tsigKeys, strings.TrimSuffix(key.Algorithm, ".")+":"+key.Name+":"+redacted)
+	}
+	rfc2136Zones := make([]string, 0, len(appConfig.RFC2136Zones))
+	for _, zone := range appConfig.RFC2136Zones {
+		rfc2136Zones = append(rfc2136Zones, zone.Name+"="+zone.ZoneID)
+	}
+
+	return map[string]any{
+		"SLEEP_TIME":                     appConfig.SleepTime.String(),
+		"RECORDS_TO_UPDATE":              records,
+		"CONFIG_MERGE":                   appConfig.ConfigMerge,
+		"NPM_URL":                        redactURLSetting(appConfig.NPMBaseURL),
+		"NPM_IDENTITY":                   redactSecret(appConfig.NPMIdentity),
+		"NPM_SECRET":                     redactSecret(appConfig.NPMSecret),
+		"FORWARD_HOST_IP":                appConfig.ForwardHost,
+		"CERT_MODE":                      appConfig.CertMode,
+		"CERT_TAGS":                      appConfig.CertTags,
+		"CERT_RECONCILE_INTERVAL":        appConfig.CertReconcilePeriod.String(),
+		"CERT_CONCURRENCY":               appConfig.CertConcurrency,
+		"CERT_VALIDATION_TIMEOUT":        appConfig.CertValidationWait.String(),
+		"CERT_POLL_INTERVAL":             appConfig.CertPollInterval.String(),
+		"CERT_EXPIRY_WARNING":            appConfig.CertExpiryWarning.String(),
+		"CERT_CLEANUP":                   appConfig.CertCleanup,
+		"CERT_REMOVE_VALIDATION_RECORDS": appConfig.CertDropValidation,
+		"CERT_BACKEND":                   appConfig.CertBackend,
+		"ACME_DIRECTORY_URL":             redactURLSetting(appConfig.ACMEDirectoryURL),
+		"ACME_EMAIL":                     appConfig.ACMEEmail,
+		"ACME_CERT_DIR":                  appConfig.ACMECertDir,
+		"CERT_SSM_PREFIX":                appConfig.CertSSMPrefix,
+		"KUBERNETES_NAMESPACE":           appConfig.KubernetesNamespace,
+		"ACM_REGION_CHECK":               appConfig.ACMRegionCheck,
+		"CAA_CHECK":                      appConfig.CAACheck,
+		"IP_RESPONSE_MAX_BYTES":          appConfig.IPResponseMaxBytes,
+		"IP_CHECK_MODE":                  appConfig.IPCheckMode,
+		"IP_CHECK_TIMEOUT":               appConfig.IPCheckTimeout.String(),
+		"IP_CHECK_URLS":                  sourceNames(appConfig.IPv4Sources),
+		"IPV6_CHECK_URLS":                sourceNames(appConfig.IPv6Sources),
+		"notifiers":                      notifiers,
+		"NOTIFY_FAILURE_THRESHOLD":       appConfig.NotifyFailureLimit,
+		"NOTIFY_COALESCE_WINDOW":         appConfig.NotifyCoalesceWindow.String(),
+		"NOTIFY_RATE_LIMIT":              appConfig.NotifyRateLimit,
+		"HEARTBEAT_URL":                  redactURLSetting(appConfig.HeartbeatURL),
+		"HEARTBEAT_FAIL_URL":             redactURLSetting(appConfig.HeartbeatFailURL),
+		"CLOUDWATCH_NAMESPACE":           appConfig.CloudWatchNamespace,
+		"CLOUDWATCH_REGION":              appConfig.CloudWatchRegion,
+		"OTEL_EXPORTER_OTLP_ENDPOINT":    redactURLSetting(appConfig.OTLPTracesEndpoint),
+		"AUDIT_LOG":                      appConfig.AuditLog,
+		"IP_HISTORY_SIZE":                appConfig.IPHistorySize,
+		"DASHBOARD":                      appConfig.Dashboard,
+		"API_TOKEN":                      redactSecret(appConfig.APIToken),
+		"STATUS_REDACT_ARNS":             appConfig.StatusRedactARNs,
+		"TRIGGER_TRUST_IP":               appConfig.TriggerTrustIP,
+		"DYNDNS_USERS":                   dyndnsUsers,
+		"RFC2136_ADDR":                   appConfig.RFC2136Addr,
+		"RFC2136_TSIG_KEYS":              tsigKeys,
+		"RFC2136_ZONES":                  rfc2136Zones,
+		"DRY_RUN":                        appConfig.DryRun,
+		"STATELESS":                      appConfig.Stateless,
+		"STATE_BACKEND":                  appConfig.StateBackend,
+		"STATE_S3_BUCKET":                appConfig.StateS3Bucket,
+		"STATE_S3_PREFIX":                appConfig.StateS3Prefix,
+		"STATE_DYNAMODB_TABLE":           appConfig.StateDynamoDBTable,
+		"STATE_SSM_PREFIX":               appConfig.StateSSMPrefix,
+		"LEADER_ELECTION":                appConfig.LeaderElection,
+		"LEADER_ELECTION_TABLE":          appConfig.LeaderElectionTable,
+		"LEADER_ELECTION_BUCKET":         appConfig.LeaderElectionBucket,
+		"LEADER_ELECTION_KEY":            appConfig.LeaderElectionKey,
+		"LEADER_LEASE_DURATION":          appConfig.LeaderLeaseDuration.String(),
+		"OWNER_ID":                       appConfig.OwnerID,
+		"OWNERSHIP_TXT_PREFIX":           appConfig.OwnershipTXTPrefix,
+		"OWNERSHIP_FORCE":                appConfig.OwnershipForce,
+		"WAIT_FOR_INSYNC":                appConfig.WaitForInsync,
+		"INSYNC_TIMEOUT":                 appConfig.InsyncTimeout.String(),
+		"VERIFY_PROPAGATION":             appConfig.VerifyPropagation,
+		"PROPAGATION_TIMEOUT":            appConfig.PropagationTimeout.String(),
+		"RETRY_MAX_ATTEMPTS":             appConfig.RetryMaxAttempts,
+		"RETRY_MAX_BACKOFF":              appConfig.RetryMaxBackoff.String(),
+		"RETRY_MODE":                     appConfig.RetryMode,
+		"API_TIMEOUT":                    appConfig.APITimeout.String(),
+		"API_CONNECT_TIMEOUT":            appConfig.APIConnectTimeout.String(),
+		"AWS_ENDPOINT_URL":               redactURLSetting(appConfig.AWSEndpointURL),
+		"AWS_ENDPOINT_URL_<SERVICE>":     serviceEndpoints,
+		"USE_FIPS_ENDPOINT":              appConfig.UseFIPSEndpoint,
+		"CA_BUNDLE":                      appConfig.CABundle,
+		"RUN_ONCE":                       appConfig.RunOnce,
+		"HTTP_ADDR":                      appConfig.HTTPAddr,
+		"GRPC_ADDR":                      appConfig.GRPCAddr,
+		"LOG_FORMAT":                     appConfig.LogFormat,
+		"LOG_LEVEL":                      appConfig.LogLevel,
+		"aws_account_id":                 appConfig.AWSAccountID,
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func TestParseSleepTime(t *testing.T) {
+	tests := []struct {
+		value   string
+		unit    string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "", want: 300 * time.Second},
+		{value: "90", want: 90 * time.Second},
+		{value: "90", unit: "seconds", want: 90 * time.Second},
+		{value: "5", unit: "minutes", want: 5 * time.Minute},
+		{value: "2", unit: "Hours", want: 2 * time.Hour},
+		{value: " 30 ", unit: " minutes ", want: 30 * time.Minute},
+		{value: "5m", want: 5 * time.Minute},
+		{value: "1h30m", want: 90 * time.Minute},
+		{value: "5", unit: "days", wantErr: true},
+		{value: "0", wantErr: true},
+		{value: "-5", unit: "minutes", wantErr: true},
+		{value: "-1m", wantErr: true},
+		{value: "soon", wantErr: true},
+		// A unit with a duration string is ambiguous.
+		{value: "5m", unit: "minutes", wantErr: true},
+		{value: "10s", unit: "seconds", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value+"/"+tt.unit, func(t *testing.T) {
+			got, err := parseSleepTime(tt.value, tt.unit)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseSleepTime(%q, %q) = %v, want an error", tt.value, tt.unit, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseSleepTime(%q, %q) failed: %v", tt.value, tt.unit, err)
+			}
+			if got != tt.want {
+				t.Errorf("parseSleepTime(%q, %q) = %v, want %v", tt.value, tt.unit, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeRecordsTrimsWhitespace(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  RecordConfig
+		want    RecordConfig
+		wantErr bool
+	}{
+		{
+			name:   "padded fields",
+			record: RecordConfig{ZoneID: " Z123 ", RecordName: "\thome.example.com \n", ValidationZoneID: " Z456"},
+			want:   RecordConfig{ZoneID: "Z123", RecordName: "home.example.com", ValidationZoneID: "Z456", TTL: defaultRecordTTL, Mode: recordModeReplace},
+		},
+		{
+			name:   "padded role and profile",
+			record: RecordConfig{ZoneID: "Z123", RecordName: "home.example.com", Profile: " prod ", RoleARN: " arn:aws:iam::222222222222:role/dns "},
+			want:   RecordConfig{ZoneID: "Z123", RecordName: "home.example.com", Profile: "prod", RoleARN: "arn:aws:iam::222222222222:role/dns", TTL: defaultRecordTTL, Mode: recordModeReplace},
+		},
+		{
+			name:    "blank record name",
+			record:  RecordConfig{ZoneID: "Z123", RecordName: "   "},
+			wantErr: true,
+		},
+		{
+			name:    "whitespace inside the zone id",
+			record:  RecordConfig{ZoneID: "Z1 23", RecordName: "home.example.com"},
+			wantErr: true,
+		},
+		{
+			name:    "whitespace inside the record name",
+			record:  RecordConfig{ZoneID: "Z123", RecordName: "home .example.com"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := []RecordConfig{tt.record}
+			err := normalizeRecords(records)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("normalizeRecords() accepted %+v", records[0])
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(records[0], tt.want) {
+				t.Errorf("normalizeRecords() = %+v, want %+v", records[0], tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigTrimsRecordsToUpdate(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("RECORDS_TO_UPDATE", `[{"zone_id": " Z123 ", "record_name": " Home.Example.com. "}]`)
+	appConfig, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := appConfig.RecordsToUpdate[0]
+	if record.ZoneID != "Z123" || record.RecordName != "home.example.com" {
+		t.Fatalf("loaded record %+v, want zone Z123 and name home.example.com", record)
+	}
+
+	fake := newFakeAWS(t)
+	var zoneID, name string
+	fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+		change := input.(*route53.ChangeResourceRecordSetsInput)
+		zoneID, name = aws.ToString(change.HostedZoneId), aws.ToString(change.ChangeBatch.Changes[0].ResourceRecordSet.Name)
+		return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+	})
+	updates := []recordUpdate{{Record: record, Type: r53types.RRTypeA, Values: []string{"198.41.0.4"}}}
+	if err := updateRoute53Records(context.Background(), appConfig, fake.route53(), updates)[0]; err != nil {
+		t.Fatal(err)
+	}
+	if zoneID != "Z123" || name != "home.example.com" {
+		t.Errorf("change sent to zone %q for %q, want Z123 and home.example.com", zoneID, name)
+	}
+}
+
+func TestConfigMerge(t *testing.T) {
+	const fileRecords = `
+sleep_time: 10m
+cert_mode: report
+records_to_update:
+  - zone_id: Z1
+    record_name: file.example.com
+  - zone_id: Z1
+    record_name: shared.example.com
+`
+	tests := []struct {
+		name        string
+		file        string
+		env         map[string]string
+		wantRecords []string
+		wantOrigins []string
+		wantErr     string
+	}{
+		{
+			name:        "file only",
+			file:        fileRecords,
+			wantRecords: []string{"file.example.com", "shared.example.com"},
+			wantOrigins: []string{originFile, originFile},
+		},
+		{
+			name:        "environment only",
+			env:         map[string]string{"RECORDS_TO_UPDATE": `[{'zone_id': 'Z2', 'record_name': 'env.example.com'}]`},
+			wantRecords: []string{"env.example.com"},
+			wantOrigins: []string{originEnv},
+		},
+		{
+			name:        "replace by default",
+			file:        fileRecords,
+			env:         map[string]string{"RECORDS_TO_UPDATE": `[{"zone_id": "Z2", "record_name": "env.example.com"}]`},
+			wantRecords: []string{"env.example.com"},
+			wantOrigins: []string{originEnv},
+		},
+		{
+			name:        "append",
+			file:        fileRecords,
+			env:         map[string]string{"CONFIG_MERGE": "append", "RECORDS_TO_UPDATE": `[{"zone_id": "Z2", "record_name": "env.example.com"}]`},
+			wantRecords: []string{"file.example.com", "shared.example.com", "env.example.com"},
+			wantOrigins: []string{originFile, originFile, originEnv},
+		},
+		{
+			name:        "append set in the file",
+			file:        "config_merge: Append\n" + fileRecords,
+			env:         map[string]string{"RECORDS_TO_UPDATE": `[{"zone_id": "Z2", "record_name": "env.example.com"}]`},
+			wantRecords: []string{"file.example.com", "shared.example.com", "env.example.com"},
+			wantOrigins: []string{originFile, originFile, originEnv},
+		},
+		{
+			name:    "append of a record in both",
+			file:    fileRecords,
+			env:     map[string]string{"CONFIG_MERGE": "append", "RECORDS_TO_UPDATE": `[{"zone_id": "Z2", "record_name": "Shared.Example.com."}]`},
+			wantErr: "record shared.example.com is in RECORDS_TO_UPDATE in both",
+		},
+		{
+			name:        "same name with another type",
+			file:        fileRecords,
+			env:         map[string]string{"CONFIG_MERGE": "append", "RECORDS_TO_UPDATE": `[{"zone_id": "Z1", "record_name": "shared.example.com", "type": "TXT", "value": "\"hello\""}]`},
+			wantRecords: []string{"file.example.com", "shared.example.com", "shared.example.com"},
+			wantOrigins: []string{originFile, originFile, originEnv},
+		},
+		{
+			name:    "invalid policy",
+			file:    fileRecords,
+			env:     map[string]string{"CONFIG_MERGE": "merge"},
+			wantErr: `invalid CONFIG_MERGE "merge"`,
+		},
+		{
+			name:    "no records anywhere",
+			file:    "sleep_time: 10m\n",
+			wantErr: "RECORDS_TO_UPDATE not set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("CONFIG_FILE", "")
+			t.Setenv("CONFIG_MERGE", "")
+			t.Setenv("RECORDS_TO_UPDATE", "")
+			if tt.file != "" {
+				path := filepath.Join(t.TempDir(), "config.yaml")
+				if err := os.WriteFile(path, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+				t.Setenv("CONFIG_FILE", path)
+			}
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			appConfig, err := loadConfig()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("loadConfig() error = %v, want one containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, record := range appConfig.RecordsToUpdate {
+				names = append(names, record.RecordName)
+			}
+			if !slices.Equal(names, tt.wantRecords) {
+				t.Errorf("records = %q, want %q", names, tt.wantRecords)
+			}
+			if !slices.Equal(appConfig.Origins.Records, tt.wantOrigins) {
+				t.Errorf("record origins = %q, want %q", appConfig.Origins.Records, tt.wantOrigins)
+			}
+		})
+	}
+}
+
+func TestReadConfigWithoutRecords(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("RECORDS_TO_UPDATE", "")
+	if _, err := loadConfig(); err == nil {
+		t.Error("loadConfig() accepted a configuration without records")
+	}
+	appConfig, err := readConfig(false)
+	if err != nil {
+		t.Fatalf("readConfig(false) = %v, want the history command to run without records", err)
+	}
+	if len(appConfig.RecordsToUpdate) != 0 || appConfig.StateBackend != stateBackendFile {
+		t.Errorf("readConfig(false) loaded %d records and state backend %q, want none and the file backend", len(appConfig.RecordsToUpdate), appConfig.StateBackend)
+	}
+}
+
+func TestConfigOrigins(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "sleep_time: 10m\ncert_mode: report\nrecords_to_update:\n  - zone_id: Z1\n    record_name: file.example.com\n"
+	if err := os.WriteFile(path, []byte(file), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("CONFIG_MERGE", "")
+	t.Setenv("SLEEP_TIME", "30s")
+	t.Setenv("RECORDS_TO_UPDATE", `[{"zone_id": "Z2", "record_name": "env.example.com"}]`)
+	appConfig, err := loadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if appConfig.SleepTime != 30*time.Second || appConfig.CertMode != certModeReport {
+		t.Fatalf("SleepTime = %v and CertMode = %q, want the environment's 30s and the file's report", appConfig.SleepTime, appConfig.CertMode)
+	}
+	origins := appConfig.Origins
+	for _, key := range []string{"SLEEP_TIME", "RECORDS_TO_UPDATE"} {
+		if !slices.Contains(origins.FromEnv, key) || !slices.Contains(origins.Overridden, key) {
+			t.Errorf("%s should come from the environment, overriding the file: %+v", key, origins)
+		}
+	}
+	if !slices.Contains(origins.FromFile, "CERT_MODE") || slices.Contains(origins.FromEnv, "CERT_MODE") {
+		t.Errorf("CERT_MODE should come from the file: %+v", origins)
+	}
+
+	logs := captureLogs(t)
+	logConfigOrigins(appConfig)
+	for _, want := range []string{
+		`"msg":"Setting in CONFIG_FILE is overridden by the environment","setting":"RECORDS_TO_UPDATE"`,
+		`"msg":"Setting in CONFIG_FILE is overridden by the environment","setting":"SLEEP_TIME"`,
+		`"msg":"Records loaded","config_merge":"replace","from_environment":["env.example.com"],"from_file":null`,
+	} {
+		if !strings.Contains(logs.String(), want) {
+			t.Errorf("log does not contain %s:\n%s", want, logs)
+		}
+	}
+}
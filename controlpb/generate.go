@@ -0,0 +1,6 @@
+// Package controlpb holds the protobuf definitions of the auto-route53
+// control API and the Go code generated from them, for clients of the gRPC
+// server.
+package controlpb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative control.proto
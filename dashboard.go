@@ -0,0 +1,137 @@
+package main
+
+import (
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// --- Web Dashboard ---
+
+// dashboardHistorySize is how many past addresses the dashboard lists.
+const dashboardHistorySize = 10
+
+// dashboardData is what the dashboard template renders.
+type dashboardData struct {
+	Now       time.Time
+	Status    statusSnapshot
+	History   []ipHistoryEntry
+	Changes   []auditEntry
+	DryRun    bool
+	Readiness string
+}
+
+var dashboardFuncs = template.FuncMap{
+	"ago": func(now, t time.Time) string {
+		if t.IsZero() {
+			return "never"
+		}
+		return now.Sub(t).Round(time.Second).String() + " ago"
+	},
+	"until": func(now, t time.Time) string {
+		if t.IsZero() {
+			return "unknown"
+		}
+		return t.Sub(now).Round(time.Hour).String()
+	},
+	"date": func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.UTC().Format(time.RFC3339)
+	},
+	"join": func(values []string) string { return strings.Join(values, ", ") },
+}
+
+// dashboardTemplate is kept in the source, since the image is built from the
+// Go files alone.
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(dashboardFuncs).Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<meta http-equiv="refresh" content="30">
+<title>auto-route53</title>
+<style>
+body { font-family: system-ui, sans-serif; margin: 2rem auto; max-width: 72rem; padding: 0 1rem; color: #222; }
+h1 { font-size: 1.5rem; }
+h2 { font-size: 1.1rem; margin-top: 2rem; }
+table { border-collapse: collapse; width: 100%; font-size: 0.9rem; }
+th, td { text-align: left; padding: 0.35rem 0.6rem; border-bottom: 1px solid #ddd; vertical-align: top; }
+th { background: #f4f4f4; }
+.ok { color: #11772d; }
+.bad { color: #b3261e; }
+.note { padding: 0.5rem 0.8rem; background: #fff4ce; border-radius: 4px; }
+.muted { color: #777; }
+</style>
+</head>
+<body>
+<h1>auto-route53</h1>
+{{if .Status.Standby}}<p class="note">This instance is a standby and does not update records.</p>{{end}}
+{{if .DryRun}}<p class="note">DRY RUN: no changes are made.</p>{{end}}
+<p>{{if .Readiness}}<span class="bad">Not ready: {{.Readiness}}</span>{{else}}<span class="ok">All records in sync.</span>{{end}}
+<span class="muted">Last successful update: {{ago .Now .Status.LastSuccessfulUpdate}}.</span></p>
+
+<h2>Public addresses</h2>
+<table>
+<tr><th>Family</th><th>Address</th><th>Checked</th><th>Error</th></tr>
+{{range $family, $check := .Status.IPChecks}}<tr><td>{{$family}}</td><td>{{$check.IP}}</td><td>{{ago $.Now $check.Time}}</td><td class="bad">{{$check.Error}}</td></tr>
+{{else}}<tr><td colspan="4" class="muted">No IP check has completed yet.</td></tr>
+{{end}}</table>
+
+<h2>Records</h2>
+<table>
+<tr><th>Name</th><th>Type</th><th>Value</th><th>Last in sync</th><th>Last changed</th><th>Error</th></tr>
+{{range .Status.Records}}<tr><td>{{.Name}}</td><td>{{.Type}}</td><td>{{.Value}}</td><td>{{ago $.Now .LastSynced}}</td><td>{{ago $.Now .LastChanged}}</td><td class="bad">{{.LastError}}</td></tr>
+{{else}}<tr><td colspan="6" class="muted">No records checked yet.</td></tr>
+{{end}}</table>
+
+{{if .Status.Certificates}}<h2>Certificates</h2>
+<table>
+<tr><th>Domain</th><th>Status</th><th>Expires</th><th>Expires in</th><th>Last checked</th><th>Error</th></tr>
+{{range .Status.Certificates}}<tr><td>{{.Domain}}{{if .ARN}}<br><span class="muted">{{.ARN}}</span>{{end}}</td><td>{{.Status}}</td><td>{{date .Expires}}</td><td>{{until $.Now .Expires}}</td><td>{{ago $.Now .LastChecked}}</td><td class="bad">{{.LastError}}</td></tr>
+{{end}}</table>
+{{end}}
+
+<h2>Recent changes</h2>
+<table>
+<tr><th>Time</th><th>Action</th><th>Record</th><th>Old</th><th>New</th><th>Outcome</th></tr>
+{{range .Changes}}<tr><td>{{date .Time}}</td><td>{{.Action}}</td><td>{{.Record}} {{.Type}}{{if .ARN}}<br><span class="muted">{{.ARN}}</span>{{end}}</td><td>{{join .Old}}</td><td>{{join .New}}</td><td>{{if .Error}}<span class="bad" title="{{.Error}}">{{.Outcome}}</span>{{else}}{{.Outcome}}{{end}}</td></tr>
+{{else}}<tr><td colspan="6" class="muted">No changes since the updater started.</td></tr>
+{{end}}</table>
+
+{{if .History}}<h2>Address history</h2>
+<table>
+<tr><th>First seen</th><th>Family</th><th>Address</th></tr>
+{{range .History}}<tr><td>{{date .Time}}</td><td>{{.Family}}</td><td>{{.IP}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`))
+
+// serveDashboard renders the status page at /, unless DASHBOARD is off.
+func serveDashboard(w http.ResponseWriter, r *http.Request) {
+	appConfig := currentConfig.Load()
+	if r.URL.Path != "/" || !appConfig.Dashboard {
+		http.NotFound(w, r)
+		return
+	}
+	snap := status.snapshot()
+	data := dashboardData{
+		Now:       time.Now(),
+		Status:    snap,
+		Changes:   recentAuditEntries(),
+		DryRun:    appConfig.DryRun,
+		Readiness: readinessProblem(snap),
+	}
+	if history, err := ipHistorySnapshot(); err == nil {
+		data.History = history[:min(len(history), dashboardHistorySize)]
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplate.Execute(w, data); err != nil {
+		slog.Warn("Failed to render the dashboard", "component", "http", "error", err)
+	}
+}
@@ -0,0 +1,250 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+)
+
+// --- DynDNS2 Server ---
+
+// dyndnsMaxHostnames is how many hostnames one update may name, as in the
+// DynDNS2 protocol, which answers "numhost" beyond that.
+const dyndnsMaxHostnames = 20
+
+// dyndnsUser is an account of the DynDNS2 endpoint and the hostnames it may
+// update. Without a zone_id, the zone of each hostname is looked up like
+// that of a record.
+type dyndnsUser struct {
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+	Hostnames []string `json:"hostnames"`
+	ZoneID    string   `json:"zone_id,omitempty"`
+	TTL       int64    `json:"ttl,omitempty"`
+}
+
+// parseDynDNSUsers reads DYNDNS_USERS, a JSON array of accounts.
+func parseDynDNSUsers(settings *configSource) ([]dyndnsUser, error) {
+	value := settings.Get("DYNDNS_USERS")
+	if value == "" {
+		return nil, nil
+	}
+	var users []dyndnsUser
+	if err := json.Unmarshal([]byte(value), &users); err != nil {
+		return nil, fmt.Errorf("failed to parse DYNDNS_USERS JSON: %w", err)
+	}
+	seen := map[string]bool{}
+	for i := range users {
+		user := &users[i]
+		if user.Username == "" || user.Password == "" {
+			return nil, fmt.Errorf("DYNDNS_USERS entry %d needs a username and a password", i)
+		}
+		if strings.Contains(user.Username, ":") {
+			return nil, fmt.Errorf("DYNDNS_USERS username %q must not contain a colon", user.Username)
+		}
+		if seen[user.Username] {
+			return nil, fmt.Errorf("DYNDNS_USERS username %q is listed twice", user.Username)
+		}
+		seen[user.Username] = true
+		if len(user.Hostnames) == 0 {
+			return nil, fmt.Errorf("DYNDNS_USERS user %s has no hostnames", user.Username)
+		}
+		for j, hostname := range user.Hostnames {
+			user.Hostnames[j] = canonicalName(hostname)
+			if user.Hostnames[j] == "" || strings.ContainsAny(user.Hostnames[j], " \t\r\n") {
+				return nil, fmt.Errorf("DYNDNS_USERS user %s has an invalid hostname %q", user.Username, hostname)
+			}
+		}
+		user.ZoneID = strings.TrimSpace(user.ZoneID)
+		if user.TTL == 0 {
+			user.TTL = defaultRecordTTL
+		}
+		if user.TTL < 0 || user.TTL > 2147483647 {
+			return nil, fmt.Errorf("DYNDNS_USERS user %s has an invalid ttl %d", user.Username, user.TTL)
+		}
+	}
+	return users, nil
+}
+
+// dyndnsAuthenticate returns the user whose credentials the request carries.
+func dyndnsAuthenticate(users []dyndnsUser, r *http.Request) (dyndnsUser, bool) {
+	username, password, ok := r.BasicAuth()
+	if !ok {
+		return dyndnsUser{}, false
+	}
+	for _, user := range users {
+		if user.Username == username && subtle.ConstantTimeCompare([]byte(password), []byte(user.Password)) == 1 {
+			return user, true
+		}
+	}
+	return dyndnsUser{}, false
+}
+
+// dyndnsAddresses returns the addresses an update asks for: those in myip
+// and myipv6, or else the address the request came from.
+func dyndnsAddresses(r *http.Request) ([]netip.Addr, error) {
+	query := r.URL.Query()
+	var values []string
+	for _, key := range []string{"myip", "myipv6"} {
+		for _, value := range strings.Split(query.Get(key), ",") {
+			if value = strings.TrimSpace(value); value != "" {
+				values = append(values, value)
+			}
+		}
+	}
+	if len(values) == 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			return nil, err
+		}
+		values = []string{host}
+	}
+	var addrs []netip.Addr
+	seen := map[string]bool{}
+	for _, value := range values {
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not an IP address", value)
+		}
+		addr = addr.Unmap()
+		family := addrFamily(addr)
+		if err := checkPublishableIP(addr.String(), family); err != nil {
+			return nil, err
+		}
+		if seen[family.Name] {
+			return nil, fmt.Errorf("more than one %s address given", family.Name)
+		}
+		seen[family.Name] = true
+		addrs = append(addrs, addr)
+	}
+	return addrs, nil
+}
+
+// addrFamily returns the family of addr.
+func addrFamily(addr netip.Addr) addressFamily {
+	if addr.Is4() {
+		return ipv4Family
+	}
+	return ipv6Family
+}
+
+// serveDynDNS handles /nic/update in the DynDNS2 protocol, so that the DDNS
+// clients built into routers, NAS devices and cameras can point their own
+// records at their address. The answer is one line per hostname.
+func (c *controller) serveDynDNS(w http.ResponseWriter, r *http.Request) {
+	appConfig := currentConfig.Load()
+	if len(appConfig.DynDNSUsers) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	user, ok := dyndnsAuthenticate(appConfig.DynDNSUsers, r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auto-route53"`)
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprintln(w, "badauth")
+		return
+	}
+	if status.snapshot().Standby {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprintln(w, "911")
+		return
+	}
+	var hostnames []string
+	for _, hostname := range strings.Split(r.URL.Query().Get("hostname"), ",") {
+		if hostname = canonicalName(hostname); hostname != "" {
+			hostnames = append(hostnames, hostname)
+		}
+	}
+	if len(hostnames) == 0 {
+		fmt.Fprintln(w, "notfqdn")
+		return
+	}
+	if len(hostnames) > dyndnsMaxHostnames {
+		fmt.Fprintln(w, "numhost")
+		return
+	}
+	ctx := withCycle(context.WithoutCancel(r.Context()), newCycleID())
+	logger := loggerFrom(ctx).With("user", user.Username)
+	addrs, err := dyndnsAddresses(r)
+	if err != nil {
+		logger.Warn("Refusing DynDNS update", "error", err)
+		for range hostnames {
+			fmt.Fprintln(w, "dnserr")
+		}
+		return
+	}
+	for _, hostname := range hostnames {
+		fmt.Fprintln(w, c.dyndnsUpdate(ctx, appConfig, user, hostname, addrs))
+	}
+}
+
+// dyndnsUpdate points hostname at addrs and returns the DynDNS2 answer for
+// it.
+func (c *controller) dyndnsUpdate(ctx context.Context, appConfig *AppConfig, user dyndnsUser, hostname string, addrs []netip.Addr) string {
+	logger := loggerFrom(ctx).With("user", user.Username, "record", hostname)
+	if !slices.Contains(user.Hostnames, hostname) {
+		logger.Warn("DynDNS update for a hostname the user may not update")
+		return "nohost"
+	}
+	zoneID := user.ZoneID
+	if zoneID == "" {
+		var err error
+		zoneID, err = findHostedZoneID(ctx, c.r53Client, hostname)
+		if err != nil {
+			logger.Error("DynDNS update failed", "error", err)
+			return "dnserr"
+		}
+		if zoneID == "" {
+			logger.Warn("No public hosted zone matches the DynDNS hostname")
+			return "nohost"
+		}
+	}
+
+	record := RecordConfig{ZoneID: zoneID, RecordName: hostname, TTL: user.TTL}
+	values := make([]string, 0, len(addrs))
+	var updates []recordUpdate
+	for _, addr := range addrs {
+		family := addrFamily(addr)
+		values = append(values, addr.String())
+		live, err := liveRecordValues(ctx, c.r53Client, zoneID, hostname, family.RecordType, "")
+		if err != nil {
+			// Writing the record anyway is safe, since updates are UPSERTs.
+			logger.Warn("Could not read the live record, updating it anyway", "type", family.RecordType, "error", err)
+		} else if len(live) == 1 && live[0] == addr.String() {
+			continue
+		}
+		updates = append(updates, recordUpdate{Record: record, Type: family.RecordType, Values: []string{addr.String()}})
+	}
+	answer := strings.Join(values, ",")
+	if len(updates) == 0 {
+		logger.Info("DynDNS record already holds the address", "ip", answer)
+		return "nochg " + answer
+	}
+	for _, err := range updateRoute53Records(ctx, appConfig, c.r53Client, updates) {
+		if err != nil {
+			logger.Error("DynDNS update failed", "error", err)
+			notify(ctx, appConfig, notifyEvent{
+				Type:    eventUpdateFail,
+				Record:  hostname,
+				Message: fmt.Sprintf("DynDNS update of %s to %s failed", hostname, answer),
+				Details: map[string]string{"source": "dyndns", "user": user.Username, "ip": answer, "error": err.Error()},
+			})
+			return "dnserr"
+		}
+	}
+	logger.Info("DynDNS record updated", "ip", answer)
+	notify(ctx, appConfig, notifyEvent{
+		Type:    eventUpdated,
+		Record:  hostname,
+		Message: fmt.Sprintf("Updated %s to %s for DynDNS user %s", hostname, answer, user.Username),
+		Details: map[string]string{"source": "dyndns", "user": user.Username, "ip": answer, "records": hostname},
+	})
+	return "good " + answer
+}
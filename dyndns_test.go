@@ -0,0 +1,185 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+var testDynDNSUsers = []dyndnsUser{
+	{Username: "nas", Password: "nas-secret", Hostnames: []string{"nas.example.com", "cam.example.com"}, ZoneID: "Z1", TTL: 300},
+	{Username: "office", Password: "office-secret", Hostnames: []string{"office.example.com"}, ZoneID: "Z1", TTL: 300},
+}
+
+func TestServeDynDNS(t *testing.T) {
+	tooMany := make([]string, dyndnsMaxHostnames+1)
+	for i := range tooMany {
+		tooMany[i] = "nas.example.com"
+	}
+	tests := []struct {
+		name        string
+		username    string
+		password    string
+		query       string
+		remoteAddr  string
+		wantCode    int
+		wantBody    string
+		wantWritten []string
+	}{
+		{name: "no credentials", query: "hostname=nas.example.com&myip=199.9.14.201", wantCode: http.StatusUnauthorized, wantBody: "badauth\n"},
+		{name: "wrong password", username: "nas", password: "office-secret", query: "hostname=nas.example.com&myip=199.9.14.201", wantCode: http.StatusUnauthorized, wantBody: "badauth\n"},
+		{name: "hostname of another user", username: "nas", password: "nas-secret", query: "hostname=office.example.com&myip=199.9.14.201", wantBody: "nohost\n"},
+		{name: "no hostname", username: "nas", password: "nas-secret", query: "myip=199.9.14.201", wantBody: "notfqdn\n"},
+		{name: "too many hostnames", username: "nas", password: "nas-secret", query: "hostname=" + strings.Join(tooMany, ",") + "&myip=199.9.14.201", wantBody: "numhost\n"},
+		{name: "address unchanged", username: "nas", password: "nas-secret", query: "hostname=nas.example.com&myip=198.41.0.4", wantBody: "nochg 198.41.0.4\n"},
+		{name: "address changed", username: "nas", password: "nas-secret", query: "hostname=Nas.Example.com.&myip=199.9.14.201", wantBody: "good 199.9.14.201\n", wantWritten: []string{"nas.example.com A 199.9.14.201"}},
+		{
+			name: "both families", username: "nas", password: "nas-secret", query: "hostname=cam.example.com&myip=199.9.14.201&myipv6=2606:4700:4700::1111",
+			wantBody:    "good 199.9.14.201,2606:4700:4700::1111\n",
+			wantWritten: []string{"cam.example.com A 199.9.14.201", "cam.example.com AAAA 2606:4700:4700::1111"},
+		},
+		{
+			name: "one answer per hostname", username: "nas", password: "nas-secret", query: "hostname=nas.example.com,office.example.com&myip=199.9.14.201",
+			wantBody:    "good 199.9.14.201\nnohost\n",
+			wantWritten: []string{"nas.example.com A 199.9.14.201"},
+		},
+		{name: "reserved address", username: "nas", password: "nas-secret", query: "hostname=nas.example.com,cam.example.com&myip=192.0.2.1", wantBody: "dnserr\ndnserr\n"},
+		{name: "two addresses of a family", username: "nas", password: "nas-secret", query: "hostname=nas.example.com&myip=198.41.0.4,199.9.14.201", wantBody: "dnserr\n"},
+		{name: "address the request came from", username: "nas", password: "nas-secret", query: "hostname=nas.example.com", remoteAddr: "199.9.14.201:41234", wantBody: "good 199.9.14.201\n", wantWritten: []string{"nas.example.com A 199.9.14.201"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useConfig(t, &AppConfig{DynDNSUsers: testDynDNSUsers})
+			fake := newFakeAWS(t)
+			fake.on("ListResourceRecordSets", liveSets(map[string]r53types.ResourceRecordSet{
+				"nas.example.com A": {Name: aws.String("nas.example.com."), Type: r53types.RRTypeA, TTL: aws.Int64(300), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("198.41.0.4")}}},
+			}))
+			var written []string
+			fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+				for _, change := range input.(*route53.ChangeResourceRecordSetsInput).ChangeBatch.Changes {
+					set := change.ResourceRecordSet
+					for _, value := range recordSetValues(set) {
+						written = append(written, canonicalName(aws.ToString(set.Name))+" "+string(set.Type)+" "+value)
+					}
+				}
+				return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+			})
+			c := &controller{ctx: context.Background(), r53Client: fake.route53()}
+
+			req := httptest.NewRequest(http.MethodGet, "/nic/update?"+tt.query, nil)
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			rec := httptest.NewRecorder()
+			c.serveDynDNS(rec, req)
+			wantCode := tt.wantCode
+			if wantCode == 0 {
+				wantCode = http.StatusOK
+			}
+			if rec.Code != wantCode || rec.Body.String() != tt.wantBody {
+				t.Errorf("GET /nic/update?%s = %d %q, want %d %q", tt.query, rec.Code, rec.Body.String(), wantCode, tt.wantBody)
+			}
+			slices.Sort(written)
+			if !slices.Equal(written, tt.wantWritten) {
+				t.Errorf("wrote %q, want %q", written, tt.wantWritten)
+			}
+		})
+	}
+}
+
+func TestServeDynDNSWithoutUsers(t *testing.T) {
+	useConfig(t, &AppConfig{})
+	c := &controller{ctx: context.Background()}
+	rec := httptest.NewRecorder()
+	c.serveDynDNS(rec, httptest.NewRequest(http.MethodGet, "/nic/update?hostname=nas.example.com", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("GET /nic/update without DYNDNS_USERS = %d, want 404", rec.Code)
+	}
+}
+
+func TestDynDNSAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		username string
+		password string
+		noAuth   bool
+		want     string
+	}{
+		{name: "first user", username: "nas", password: "nas-secret", want: "nas"},
+		{name: "second user", username: "office", password: "office-secret", want: "office"},
+		{name: "another user's password", username: "nas", password: "office-secret"},
+		{name: "password prefix", username: "nas", password: "nas-secre"},
+		{name: "unknown user", username: "guest", password: "nas-secret"},
+		{name: "no basic auth", noAuth: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/nic/update", nil)
+			if !tt.noAuth {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			user, ok := dyndnsAuthenticate(testDynDNSUsers, req)
+			if ok != (tt.want != "") || user.Username != tt.want {
+				t.Errorf("dyndnsAuthenticate() = %q, %t; want %q", user.Username, ok, tt.want)
+			}
+		})
+	}
+}
+
+func TestDynDNSAddresses(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		remoteAddr string
+		want       []string
+		wantErr    bool
+	}{
+		{name: "myip", query: "myip=199.9.14.201", want: []string{"199.9.14.201"}},
+		{name: "myip and myipv6", query: "myip=199.9.14.201&myipv6=2606:4700:4700::1111", want: []string{"199.9.14.201", "2606:4700:4700::1111"}},
+		{name: "both families in myip", query: "myip=2606:4700:4700::1111,%20199.9.14.201", want: []string{"2606:4700:4700::1111", "199.9.14.201"}},
+		{name: "IPv4-mapped address", query: "myip=::ffff:199.9.14.201", want: []string{"199.9.14.201"}},
+		{name: "remote address", remoteAddr: "[2606:4700:4700::1111]:41234", want: []string{"2606:4700:4700::1111"}},
+		{name: "myip takes precedence over the remote address", query: "myip=199.9.14.201", remoteAddr: "198.41.0.4:41234", want: []string{"199.9.14.201"}},
+		{name: "remote address without a port", remoteAddr: "199.9.14.201", wantErr: true},
+		{name: "not an address", query: "myip=router", wantErr: true},
+		{name: "documentation address", query: "myip=192.0.2.1", wantErr: true},
+		{name: "unique local address", query: "myipv6=fd00::1", wantErr: true},
+		{name: "two IPv4 addresses", query: "myip=198.41.0.4,199.9.14.201", wantErr: true},
+		{name: "two IPv6 addresses", query: "myip=2606:4700:4700::1111&myipv6=2606:4700:4700::1001", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/nic/update?"+tt.query, nil)
+			if tt.remoteAddr != "" {
+				req.RemoteAddr = tt.remoteAddr
+			}
+			addrs, err := dyndnsAddresses(req)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("dyndnsAddresses() = %v, want an error", addrs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, addr := range addrs {
+				got = append(got, addr.String())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("dyndnsAddresses() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// --- Custom AWS Endpoints ---
+
+// endpointServices are the SDK IDs of the AWS services the application
+// calls, whose endpoints can be overridden with AWS_ENDPOINT_URL_<SERVICE>,
+// where <SERVICE> is the ID in upper case with spaces replaced by
+// underscores (e.g. AWS_ENDPOINT_URL_ROUTE_53).
+var endpointServices = []string{"Route 53", "ACM", "STS", "S3", "DynamoDB", "SSM", "SNS", "SESv2", "Elastic Load Balancing v2", "CloudFront", "ApiGatewayV2", "CloudWatch", "EventBridge"}
+
+func endpointSettingName(sdkID string) string {
+	return "AWS_ENDPOINT_URL_" + strings.ToUpper(strings.ReplaceAll(sdkID, " ", "_"))
+}
+
+// endpointOverrides is a config source that supplies per-service endpoints
+// to the AWS clients. The SDK reads the same settings from the environment
+// itself; this source adds the ones set in CONFIG_FILE.
+type endpointOverrides map[string]string
+
+func (e endpointOverrides) GetServiceBaseEndpoint(_ context.Context, sdkID string) (string, bool, error) {
+	endpoint, ok := e[sdkID]
+	return endpoint, ok, nil
+}
+
+func validateEndpointURL(name, endpoint string) error {
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("invalid %s %q: expected an http or https URL", name, endpoint)
+	}
+	return nil
+}
+
+// loadEndpointOverrides reads AWS_ENDPOINT_URL_<SERVICE> for every service
+// the application uses.
+func loadEndpointOverrides(settings *configSource) (endpointOverrides, error) {
+	overrides := endpointOverrides{}
+	for _, sdkID := range endpointServices {
+		name := endpointSettingName(sdkID)
+		if endpoint := settings.Get(name); endpoint != "" {
+			if err := validateEndpointURL(name, endpoint); err != nil {
+				return nil, err
+			}
+			overrides[sdkID] = endpoint
+		}
+	}
+	return overrides, nil
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// execIPSource runs a user-supplied command and uses its standard output as
+// the address. Configured as exec:command [args...]. The command is run
+// directly, not through a shell, with IP_FAMILY set to IPv4 or IPv6 so that
+// one script can serve both families.
+type execIPSource struct {
+	command  []string
+	maxBytes int64
+}
+
+func (s *execIPSource) Name() string { return "exec:" + strings.Join(s.command, " ") }
+
+func (s *execIPSource) GetIP(ctx context.Context, family addressFamily) (string, error) {
+	cmd := exec.CommandContext(ctx, s.command[0], s.command[1:]...)
+	cmd.Env = append(os.Environ(), "IP_FAMILY="+family.Name)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedWriter{buf: &stdout, remaining: s.maxBytes + 1}
+	cmd.Stderr = &limitedWriter{buf: &stderr, remaining: 1024}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return "", fmt.Errorf("command timed out: %w", ctx.Err())
+		}
+		if detail := strings.TrimSpace(stderr.String()); detail != "" {
+			return "", fmt.Errorf("command failed: %w: %s", err, detail)
+		}
+		return "", fmt.Errorf("command failed: %w", err)
+	}
+	if int64(stdout.Len()) > s.maxBytes {
+		return "", fmt.Errorf("command output exceeds %d bytes", s.maxBytes)
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// limitedWriter keeps at most remaining bytes and silently drops the rest,
+// so a runaway command cannot exhaust memory.
+type limitedWriter struct {
+	buf       *bytes.Buffer
+	remaining int64
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if w.remaining > 0 {
+		n := int64(len(p))
+		if n > w.remaining {
+			n = w.remaining
+		}
+		w.buf.Write(p[:n])
+		w.remaining -= n
+	}
+	return len(p), nil
+}
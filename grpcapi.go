@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log/slog"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/moootid/awsroute53updater-go/controlpb"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	grpcstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// --- gRPC Control API ---
+
+// eventStreamBuffer is how many events a WatchEvents stream may fall behind
+// before events are dropped for it.
+const eventStreamBuffer = 64
+
+var (
+	eventSubscribersMu sync.Mutex
+	// eventSubscribers are the channels of the open WatchEvents streams.
+	eventSubscribers = map[chan notifyEvent]struct{}{}
+)
+
+// subscribeEvents returns a channel that receives every event from now on,
+// and the function that closes it.
+func subscribeEvents() (<-chan notifyEvent, func()) {
+	events := make(chan notifyEvent, eventStreamBuffer)
+	eventSubscribersMu.Lock()
+	eventSubscribers[events] = struct{}{}
+	eventSubscribersMu.Unlock()
+	return events, func() {
+		eventSubscribersMu.Lock()
+		delete(eventSubscribers, events)
+		eventSubscribersMu.Unlock()
+	}
+}
+
+// publishEvent hands event to every subscriber. A subscriber that is not
+// keeping up misses it rather than holding up the work that sent it.
+func publishEvent(event notifyEvent) {
+	eventSubscribersMu.Lock()
+	defer eventSubscribersMu.Unlock()
+	for events := range eventSubscribers {
+		select {
+		case events <- event:
+		default:
+			slog.Warn("Event stream is not keeping up, dropping event", "component", "grpc", "event", event.Type)
+		}
+	}
+}
+
+// grpcControl serves the Control service with the same actions as the REST
+// API.
+type grpcControl struct {
+	controlpb.UnimplementedControlServer
+	controller *controller
+}
+
+// requireToken checks the bearer token in the "authorization" metadata of a
+// call that triggers an action. Actions are refused while API_TOKEN is
+// unset.
+func requireToken(ctx context.Context) error {
+	token := currentConfig.Load().APIToken
+	if token == "" {
+		return grpcstatus.Error(codes.PermissionDenied, "set API_TOKEN to enable the API actions")
+	}
+	md, _ := metadata.FromIncomingContext(ctx)
+	for _, value := range md.Get("authorization") {
+		if given, ok := strings.CutPrefix(value, "Bearer "); ok && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+			return nil
+		}
+	}
+	return grpcstatus.Error(codes.Unauthenticated, "missing or invalid API token")
+}
+
+// grpcError converts the error of a refused action to a gRPC status.
+func grpcError(err error, notFound string) error {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, errNotConfigured):
+		return grpcstatus.Error(codes.NotFound, notFound)
+	case errors.Is(err, errInvalidRequest):
+		return grpcstatus.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, errSyncRunning):
+		return grpcstatus.Error(codes.AlreadyExists, err.Error())
+	case errors.Is(err, errStandby):
+		return grpcstatus.Error(codes.Unavailable, err.Error())
+	default:
+		return grpcstatus.Error(codes.Internal, err.Error())
+	}
+}
+
+// timestamp converts t, leaving it unset if t is zero.
+func timestamp(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func (s *grpcControl) GetStatus(ctx context.Context, _ *controlpb.GetStatusRequest) (*controlpb.Status, error) {
+	snap := status.snapshot()
+	reply := &controlpb.Status{
+		IpChecks:             map[string]*controlpb.IPCheck{},
+		LastSuccessfulUpdate: timestamp(snap.LastSuccessfulUpdate),
+		Standby:              snap.Standby,
+	}
+	for family, check := range snap.IPChecks {
+		reply.IpChecks[family] = &controlpb.IPCheck{Time: timestamp(check.Time), Ok: check.OK, Ip: check.IP, Error: check.Error}
+	}
+	for _, rec := range snap.Records {
+		reply.Records = append(reply.Records, &controlpb.Record{
+			Name:        rec.Name,
+			Type:        rec.Type,
+			Value:       rec.Value,
+			LastSynced:  timestamp(rec.LastSynced),
+			LastChanged: timestamp(rec.LastChanged),
+			LastError:   rec.LastError,
+		})
+	}
+	for _, cert := range snap.Certificates {
+		reply.Certificates = append(reply.Certificates, &controlpb.Certificate{
+			Domain:      cert.Domain,
+			Arn:         cert.ARN,
+			Status:      cert.Status,
+			Expires:     timestamp(cert.Expires),
+			LastChecked: timestamp(cert.LastChecked),
+			LastError:   cert.LastError,
+		})
+	}
+	return reply, nil
+}
+
+func (s *grpcControl) Check(ctx context.Context, _ *controlpb.CheckRequest) (*controlpb.CheckResponse, error) {
+	if err := requireToken(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.controller.check(); err != nil {
+		return nil, grpcError(err, "")
+	}
+	return &controlpb.CheckResponse{}, nil
+}
+
+func (s *grpcControl) SyncRecord(ctx context.Context, req *controlpb.SyncRecordRequest) (*controlpb.SyncRecordResponse, error) {
+	if err := requireToken(ctx); err != nil {
+		return nil, err
+	}
+	name := canonicalName(req.GetName())
+	if err := s.controller.syncRecord(name); err != nil {
+		return nil, grpcError(err, "no record named "+name+" is configured")
+	}
+	return &controlpb.SyncRecordResponse{}, nil
+}
+
+func (s *grpcControl) ReconcileCertificate(ctx context.Context, req *controlpb.ReconcileCertificateRequest) (*controlpb.ReconcileCertificateResponse, error) {
+	if err := requireToken(ctx); err != nil {
+		return nil, err
+	}
+	domain := canonicalName(req.GetDomain())
+	if err := s.controller.reconcileCertificate(domain); err != nil {
+		return nil, grpcError(err, "no certificate task for "+domain+" is running")
+	}
+	return &controlpb.ReconcileCertificateResponse{}, nil
+}
+
+func (s *grpcControl) WatchEvents(req *controlpb.WatchEventsRequest, stream grpc.ServerStreamingServer[controlpb.Event]) error {
+	for _, event := range req.GetEvents() {
+		if !slices.Contains(notifyEvents, event) {
+			return grpcstatus.Errorf(codes.InvalidArgument, "unknown event %q: must be one of %v", event, notifyEvents)
+		}
+	}
+	events, unsubscribe := subscribeEvents()
+	defer unsubscribe()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event := <-events:
+			if len(req.GetEvents()) > 0 && !slices.Contains(req.GetEvents(), event.Type) {
+				continue
+			}
+			err := stream.Send(&controlpb.Event{
+				Type:    event.Type,
+				Time:    timestamp(event.Time),
+				Record:  event.Record,
+				Message: event.Message,
+				Details: event.Details,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runGRPCServer serves the Control service on addr until ctx is cancelled.
+func runGRPCServer(ctx context.Context, addr string, api *controller) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		slog.Error("gRPC server stopped", "component", "grpc", "error", err)
+		return
+	}
+	server := grpc.NewServer()
+	controlpb.RegisterControlServer(server, &grpcControl{controller: api})
+	go func() {
+		<-ctx.Done()
+		// Event streams only end when their client leaves, so they are cut
+		// off after a grace period.
+		stopped := make(chan struct{})
+		go func() {
+			server.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			server.Stop()
+		}
+	}()
+
+	slog.Info("gRPC server listening", "component", "grpc", "addr", addr)
+	if err := server.Serve(listener); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+		slog.Error("gRPC server stopped", "component", "grpc", "error", err)
+	}
+}
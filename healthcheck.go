@@ -0,0 +1,210 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// --- Health Checks ---
+
+const (
+	healthCheckStateFilePattern    = "data/health_check_%s.txt"
+	defaultHealthCheckFailureCount = 3
+)
+
+// HealthCheckConfig describes the Route53 health check kept pointed at the
+// public address of a routed record, so that failover and weighted routing
+// stop sending traffic to a site that is down.
+type HealthCheckConfig struct {
+	Type             string `json:"type"`
+	Port             int32  `json:"port,omitempty"`
+	Path             string `json:"path,omitempty"`
+	Host             string `json:"host,omitempty"`
+	FailureThreshold int32  `json:"failure_threshold,omitempty"`
+}
+
+var unsafeStateKeyChars = regexp.MustCompile(`[^a-zA-Z0-9._-]`)
+
+// healthCheckStateFile is where the ID of a record's health check is kept.
+// Each address family gets its own check, since each probes its own address.
+func healthCheckStateFile(record RecordConfig, recordType r53types.RRType) string {
+	key := fmt.Sprintf("%s_%s_%s", record.RecordName, recordType, record.SetIdentifier)
+	return fmt.Sprintf(healthCheckStateFilePattern, unsafeStateKeyChars.ReplaceAllString(key, "_"))
+}
+
+// normalizeHealthCheck validates a record's health check and fills in the
+// defaults: port 80 or 443, path /, the record name as the HTTP host and a
+// failure threshold of 3.
+func normalizeHealthCheck(record *RecordConfig) error {
+	check := record.HealthCheck
+	if check == nil {
+		return nil
+	}
+	if record.SetIdentifier == "" {
+		return fmt.Errorf("record %s has a health_check but no routing policy; health checks only apply to weighted, latency and failover records", record.RecordName)
+	}
+	if record.isStatic() {
+		return fmt.Errorf("record %s has a health_check, which is only supported on records that follow the public IP", record.RecordName)
+	}
+
+	check.Type = strings.ToUpper(strings.TrimSpace(check.Type))
+	check.Path = strings.TrimSpace(check.Path)
+	check.Host = canonicalName(check.Host)
+	switch check.Type {
+	case string(r53types.HealthCheckTypeHttp), string(r53types.HealthCheckTypeHttps):
+		if check.Port == 0 {
+			check.Port = 80
+			if check.Type == string(r53types.HealthCheckTypeHttps) {
+				check.Port = 443
+			}
+		}
+		if !strings.HasPrefix(check.Path, "/") {
+			check.Path = "/" + check.Path
+		}
+		if check.Host == "" {
+			check.Host = record.RecordName
+		}
+	case string(r53types.HealthCheckTypeTcp):
+		if check.Port == 0 {
+			return fmt.Errorf("record %s has a TCP health_check without a port", record.RecordName)
+		}
+		if check.Path != "" || check.Host != "" {
+			return fmt.Errorf("record %s has a TCP health_check with a path or host, which only apply to HTTP and HTTPS", record.RecordName)
+		}
+	default:
+		return fmt.Errorf("record %s has an invalid health_check type %q, expected HTTP, HTTPS or TCP", record.RecordName, check.Type)
+	}
+	if check.Port < 1 || check.Port > 65535 {
+		return fmt.Errorf("record %s has an invalid health_check port %d", record.RecordName, check.Port)
+	}
+	if check.FailureThreshold == 0 {
+		check.FailureThreshold = defaultHealthCheckFailureCount
+	}
+	if check.FailureThreshold < 1 || check.FailureThreshold > 10 {
+		return fmt.Errorf("record %s has an invalid health_check failure_threshold %d, expected 1-10", record.RecordName, check.FailureThreshold)
+	}
+	return nil
+}
+
+// route53Config is the Route53 form of the health check, probing ip.
+func (c *HealthCheckConfig) route53Config(ip string) *r53types.HealthCheckConfig {
+	config := &r53types.HealthCheckConfig{
+		Type:             r53types.HealthCheckType(c.Type),
+		IPAddress:        aws.String(ip),
+		Port:             aws.Int32(c.Port),
+		FailureThreshold: aws.Int32(c.FailureThreshold),
+	}
+	if c.Type != string(r53types.HealthCheckTypeTcp) {
+		config.ResourcePath = aws.String(c.Path)
+		config.FullyQualifiedDomainName = aws.String(c.Host)
+	}
+	return config
+}
+
+func sameHealthCheck(current, want *r53types.HealthCheckConfig) bool {
+	return aws.ToString(current.IPAddress) == aws.ToString(want.IPAddress) &&
+		aws.ToInt32(current.Port) == aws.ToInt32(want.Port) &&
+		aws.ToString(current.ResourcePath) == aws.ToString(want.ResourcePath) &&
+		canonicalName(aws.ToString(current.FullyQualifiedDomainName)) == canonicalName(aws.ToString(want.FullyQualifiedDomainName)) &&
+		aws.ToInt32(current.FailureThreshold) == aws.ToInt32(want.FailureThreshold)
+}
+
+// ensureHealthCheck makes sure the record's health check exists and probes
+// ip, creating or updating it as needed, and returns its ID. The check's type
+// cannot be changed in place, so a changed type gets a new check.
+func ensureHealthCheck(ctx context.Context, appConfig *AppConfig, client *route53.Client, record RecordConfig, recordType r53types.RRType, ip string) (string, error) {
+	logger := loggerFrom(ctx).With("record", record.RecordName, "set_identifier", record.SetIdentifier)
+	stateFile := healthCheckStateFile(record, recordType)
+	want := record.HealthCheck.route53Config(ip)
+
+	id, err := getStoredString(stateFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read stored health check ID: %w", err)
+	}
+	if id != "" {
+		output, err := client.GetHealthCheck(ctx, &route53.GetHealthCheckInput{HealthCheckId: aws.String(id)})
+		var notFound *r53types.NoSuchHealthCheck
+		switch {
+		case errors.As(err, &notFound):
+			logger.Warn("Stored health check no longer exists, creating a new one", "health_check_id", id)
+		case err != nil:
+			return "", fmt.Errorf("failed to read health check %s: %w", id, err)
+		case output.HealthCheck.HealthCheckConfig.Type != want.Type:
+			logger.Warn("Health check type changed, creating a new check; the old one is no longer used and can be deleted", "health_check_id", id)
+		case sameHealthCheck(output.HealthCheck.HealthCheckConfig, want):
+			return id, nil
+		case appConfig.DryRun:
+			logger.Info("DRY RUN: Would update health check", "health_check_id", id, "ip", ip)
+			return id, nil
+		default:
+			_, err := client.UpdateHealthCheck(ctx, &route53.UpdateHealthCheckInput{
+				HealthCheckId:            aws.String(id),
+				HealthCheckVersion:       output.HealthCheck.HealthCheckVersion,
+				IPAddress:                want.IPAddress,
+				Port:                     want.Port,
+				ResourcePath:             want.ResourcePath,
+				FullyQualifiedDomainName: want.FullyQualifiedDomainName,
+				FailureThreshold:         want.FailureThreshold,
+			})
+			writeAudit(ctx, appConfig, err, healthCheckAudit(auditHealthCheckUpdate, record, recordType, id, ip))
+			if err != nil {
+				return "", fmt.Errorf("failed to update health check %s: %w", id, err)
+			}
+			logger.Info("Updated health check", "health_check_id", id, "ip", ip)
+			return id, nil
+		}
+	}
+
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would create health check", "type", want.Type, "ip", ip, "port", aws.ToInt32(want.Port))
+		return "", nil
+	}
+	output, err := client.CreateHealthCheck(ctx, &route53.CreateHealthCheckInput{
+		CallerReference:   aws.String(fmt.Sprintf("auto-route53-%d", time.Now().UnixNano())),
+		HealthCheckConfig: want,
+	})
+	if err != nil {
+		writeAudit(ctx, appConfig, err, healthCheckAudit(auditHealthCheckCreate, record, recordType, "", ip))
+		return "", fmt.Errorf("failed to create health check: %w", err)
+	}
+	id = aws.ToString(output.HealthCheck.Id)
+	writeAudit(ctx, appConfig, nil, healthCheckAudit(auditHealthCheckCreate, record, recordType, id, ip))
+	logger.Info("Created health check", "health_check_id", id, "type", want.Type, "ip", ip)
+
+	// The Name tag is what the Route53 console shows for the check.
+	_, err = client.ChangeTagsForResource(ctx, &route53.ChangeTagsForResourceInput{
+		ResourceId:   aws.String(id),
+		ResourceType: r53types.TagResourceTypeHealthcheck,
+		AddTags: []r53types.Tag{
+			{Key: aws.String("Name"), Value: aws.String(fmt.Sprintf("%s %s (%s)", record.RecordName, recordType, record.SetIdentifier))},
+		},
+	})
+	if err != nil {
+		logger.Warn("Failed to tag health check", "health_check_id", id, "error", err)
+	}
+	if err := storeString(stateFile, id); err != nil {
+		logger.Error("Failed to store health check ID, a new check will be created next time", "health_check_id", id, "error", err)
+	}
+	return id, nil
+}
+
+// healthCheckAudit describes the creation or update of the health check of
+// a record set for the audit log.
+func healthCheckAudit(action string, record RecordConfig, recordType r53types.RRType, id, ip string) auditEntry {
+	return auditEntry{
+		Action:        action,
+		Record:        record.RecordName,
+		Type:          string(recordType),
+		SetIdentifier: record.SetIdentifier,
+		HealthCheckID: id,
+		New:           []string{ip},
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+func TestHealthCheckChangesAreAudited(t *testing.T) {
+	useStateDir(t)
+	fake := newFakeAWS(t)
+	var current *r53types.HealthCheckConfig
+	fake.on("CreateHealthCheck", func(input any) (any, error) {
+		current = input.(*route53.CreateHealthCheckInput).HealthCheckConfig
+		return &route53.CreateHealthCheckOutput{HealthCheck: &r53types.HealthCheck{Id: aws.String("HC1"), HealthCheckConfig: current}}, nil
+	})
+	fake.on("ChangeTagsForResource", func(any) (any, error) { return &route53.ChangeTagsForResourceOutput{}, nil })
+	fake.on("GetHealthCheck", func(any) (any, error) {
+		return &route53.GetHealthCheckOutput{HealthCheck: &r53types.HealthCheck{Id: aws.String("HC1"), HealthCheckConfig: current, HealthCheckVersion: aws.Int64(1)}}, nil
+	})
+	fake.on("UpdateHealthCheck", func(any) (any, error) { return &route53.UpdateHealthCheckOutput{}, nil })
+
+	appConfig := &AppConfig{AuditLog: filepath.Join(t.TempDir(), "audit.jsonl")}
+	record := RecordConfig{RecordName: "home.example.com", SetIdentifier: "home", HealthCheck: &HealthCheckConfig{Type: "TCP", Port: 22}}
+	if err := normalizeHealthCheck(&record); err != nil {
+		t.Fatal(err)
+	}
+	for _, ip := range []string{"198.51.100.1", "198.51.100.2"} {
+		if _, err := ensureHealthCheck(context.Background(), appConfig, fake.route53(), record, r53types.RRTypeA, ip); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries := readAuditLog(t, appConfig.AuditLog)
+	if len(entries) != 2 {
+		t.Fatalf("audit log has %d entries, want the check's creation and update", len(entries))
+	}
+	for i, want := range []auditEntry{
+		{Action: auditHealthCheckCreate, New: []string{"198.51.100.1"}},
+		{Action: auditHealthCheckUpdate, New: []string{"198.51.100.2"}},
+	} {
+		got := entries[i]
+		if got.Action != want.Action || got.HealthCheckID != "HC1" || got.Record != "home.example.com" || got.Type != "A" || got.SetIdentifier != "home" || len(got.New) != 1 || got.New[0] != want.New[0] {
+			t.Errorf("audit entry %d = %+v, want %s of HC1 for A home.example.com (home) probing %s", i, got, want.Action, want.New[0])
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// --- Heartbeat ---
+
+// sendHeartbeat pings HEARTBEAT_URL after a DDNS cycle that succeeded, or
+// HEARTBEAT_FAIL_URL after one that failed, so that a dead man's switch such
+// as healthchecks.io alerts when the updater stops running or keeps failing.
+// A ping that fails is logged and otherwise ignored.
+func sendHeartbeat(ctx context.Context, appConfig *AppConfig, cycleErr error) {
+	pingURL := appConfig.HeartbeatURL
+	if cycleErr != nil {
+		pingURL = appConfig.HeartbeatFailURL
+	}
+	if pingURL == "" {
+		return
+	}
+	logger := loggerFrom(ctx)
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would ping heartbeat", "url", redactURL(pingURL), "failed", cycleErr != nil)
+		return
+	}
+	pingCtx, cancel := context.WithTimeout(ctx, notifyTimeout)
+	defer cancel()
+	if err := pingHeartbeat(pingCtx, newNotifyHTTPClient(appConfig.RootCAs), pingURL); err != nil {
+		logger.Warn("Heartbeat ping failed", "url", redactURL(pingURL), "error", err)
+		return
+	}
+	logger.Debug("Pinged heartbeat", "url", redactURL(pingURL), "failed", cycleErr != nil)
+}
+
+func pingHeartbeat(ctx context.Context, client *http.Client, pingURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, pingURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", defaultIPCheckUserAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error includes the URL, which identifies the check.
+		return fmt.Errorf("request to %s failed: %w", redactURL(pingURL), unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// --- Exec Hooks ---
+
+// defaultHookTimeout bounds a hook command, which may restart services.
+const defaultHookTimeout = time.Minute
+
+// hookSettings map the hook settings to the event each one runs on.
+var hookSettings = []struct {
+	setting, event string
+}{
+	{"ON_IP_CHANGE", eventIPChanged},
+	{"ON_UPDATE_FAILURE", eventUpdateFail},
+	{"ON_CERT_ISSUED", eventCertIssued},
+}
+
+// execNotifier runs a command for an event, with the event passed in the
+// environment as AUTO_ROUTE53_EVENT, AUTO_ROUTE53_RECORD,
+// AUTO_ROUTE53_MESSAGE, AUTO_ROUTE53_TIME and one AUTO_ROUTE53_<DETAIL> per
+// detail, e.g. AUTO_ROUTE53_PUBLIC_IP. Like exec: IP sources, the command is
+// run directly, not through a shell.
+type execNotifier struct {
+	setting string
+	command []string
+	timeout time.Duration
+}
+
+func (n *execNotifier) Name() string { return n.setting }
+
+func (n *execNotifier) Timeout() time.Duration { return n.timeout }
+
+func (n *execNotifier) Send(ctx context.Context, event notifyEvent) error {
+	cmd := exec.CommandContext(ctx, n.command[0], n.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		"AUTO_ROUTE53_EVENT="+event.Type,
+		"AUTO_ROUTE53_RECORD="+event.Record,
+		"AUTO_ROUTE53_MESSAGE="+event.Message,
+		"AUTO_ROUTE53_TIME="+event.Time.Format(time.RFC3339),
+	)
+	for key, value := range event.Details {
+		cmd.Env = append(cmd.Env, "AUTO_ROUTE53_"+strings.ToUpper(key)+"="+value)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &limitedWriter{buf: &stderr, remaining: 1024}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command timed out: %w", ctx.Err())
+		}
+		if detail := strings.TrimSpace(stderr.String()); detail != "" {
+			return fmt.Errorf("command failed: %w: %s", err, detail)
+		}
+		return fmt.Errorf("command failed: %w", err)
+	}
+	return nil
+}
+
+// loadHooks builds a target for each hook setting that is set.
+func loadHooks(settings *configSource) ([]notifyTarget, error) {
+	timeout, err := settings.GetDuration("HOOK_TIMEOUT", defaultHookTimeout)
+	if err != nil {
+		return nil, err
+	}
+	var targets []notifyTarget
+	for _, hook := range hookSettings {
+		value := settings.Get(hook.setting)
+		if value == "" {
+			continue
+		}
+		hookNotifier := &execNotifier{setting: hook.setting, command: strings.Fields(value), timeout: timeout}
+		targets = append(targets, notifyTarget{hookNotifier, []string{hook.event}})
+	}
+	return targets, nil
+}
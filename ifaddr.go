@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// interfaceIPSource reads the address assigned to a local network interface,
+// for hosts that hold their public address directly (e.g. on ppp0).
+// Configured as interface:name.
+type interfaceIPSource struct {
+	name string
+}
+
+func (s *interfaceIPSource) Name() string { return "interface:" + s.name }
+
+// GetIP returns the first public address of the family on the interface,
+// or failing that the first private one. Loopback, link-local and other
+// non-global addresses are never used.
+func (s *interfaceIPSource) GetIP(_ context.Context, family addressFamily) (string, error) {
+	iface, err := net.InterfaceByName(s.name)
+	if err != nil {
+		return "", fmt.Errorf("failed to find interface %s: %w", s.name, err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return "", fmt.Errorf("interface %s is down", s.name)
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", fmt.Errorf("failed to list addresses of %s: %w", s.name, err)
+	}
+
+	var private netip.Addr
+	for _, a := range addrs {
+		ipNet, ok := a.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		addr, ok := netip.AddrFromSlice(ipNet.IP)
+		if !ok {
+			continue
+		}
+		addr = addr.Unmap()
+		if addr.Is4() == (family.RecordType == r53types.RRTypeAaaa) || !addr.IsGlobalUnicast() {
+			continue
+		}
+		if !addr.IsPrivate() {
+			return addr.String(), nil
+		}
+		if !private.IsValid() {
+			private = addr
+		}
+	}
+	if private.IsValid() {
+		return private.String(), nil
+	}
+	return "", fmt.Errorf("interface %s has no global %s address", s.name, family.Name)
+}
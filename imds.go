@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/feature/ec2/imds"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const (
+	imdsPublic  = "public"
+	imdsPrivate = "private"
+)
+
+// imdsIPSource reads the instance's address from the EC2 instance metadata
+// service. The SDK client uses IMDSv2 session tokens. Configured as
+// imds:public or imds:private.
+type imdsIPSource struct {
+	kind   string
+	client *imds.Client
+}
+
+func newIMDSIPSource(kind string) (*imdsIPSource, error) {
+	if kind != imdsPublic && kind != imdsPrivate {
+		return nil, fmt.Errorf("unknown IMDS address kind %q: must be %q or %q", kind, imdsPublic, imdsPrivate)
+	}
+	return &imdsIPSource{kind: kind, client: imds.New(imds.Options{})}, nil
+}
+
+func (s *imdsIPSource) Name() string { return "imds:" + s.kind }
+
+// GetIP reads public-ipv4 or local-ipv4 for IPv4. EC2 IPv6 addresses are
+// globally routable, so both kinds read the primary ipv6 address.
+func (s *imdsIPSource) GetIP(ctx context.Context, family addressFamily) (string, error) {
+	path := "public-ipv4"
+	switch {
+	case family.RecordType == r53types.RRTypeAaaa:
+		path = "ipv6"
+	case s.kind == imdsPrivate:
+		path = "local-ipv4"
+	}
+	output, err := s.client.GetMetadata(ctx, &imds.GetMetadataInput{Path: path})
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from instance metadata: %w", path, err)
+	}
+	defer output.Content.Close()
+	value, err := io.ReadAll(io.LimitReader(output.Content, defaultIPResponseMaxBytes))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from instance metadata: %w", path, err)
+	}
+	return strings.TrimSpace(string(value)), nil
+}
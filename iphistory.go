@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"sync"
+	"time"
+)
+
+// --- IP History ---
+
+const (
+	ipHistoryFile = "data/ip_history.json"
+	// defaultIPHistorySize is how many address changes are kept.
+	defaultIPHistorySize = 100
+)
+
+// ipHistoryEntry is a public address and when it was first seen.
+type ipHistoryEntry struct {
+	Time   time.Time `json:"time"`
+	Family string    `json:"family"`
+	IP     string    `json:"ip"`
+}
+
+var (
+	ipHistoryMu sync.Mutex
+	// ipHistory is the stored history, oldest first, once loaded.
+	ipHistory       []ipHistoryEntry
+	ipHistoryLoaded bool
+)
+
+// loadIPHistory reads the history from the state store.
+func loadIPHistory() ([]ipHistoryEntry, error) {
+	data, err := getStoredString(ipHistoryFile)
+	if err != nil || data == "" {
+		return nil, err
+	}
+	var history []ipHistoryEntry
+	if err := json.Unmarshal([]byte(data), &history); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ipHistoryFile, err)
+	}
+	return history, nil
+}
+
+// currentIPHistory returns the history, loading it on first use. Callers
+// must hold ipHistoryMu.
+func currentIPHistory() ([]ipHistoryEntry, error) {
+	if !ipHistoryLoaded {
+		history, err := loadIPHistory()
+		if err != nil {
+			return nil, err
+		}
+		ipHistory, ipHistoryLoaded = history, true
+	}
+	return ipHistory, nil
+}
+
+// recordIPHistory adds ip to the history if it differs from the last address
+// seen for its family, dropping the oldest entries beyond IP_HISTORY_SIZE.
+// The history is kept whether or not the records could be updated, so that
+// it shows how the connection behaved.
+func recordIPHistory(ctx context.Context, appConfig *AppConfig, family addressFamily, ip string) {
+	if appConfig.IPHistorySize == 0 {
+		return
+	}
+	logger := loggerFrom(ctx)
+	ipHistoryMu.Lock()
+	defer ipHistoryMu.Unlock()
+	history, err := currentIPHistory()
+	if err != nil {
+		logger.Warn("Could not read the IP history", "error", err)
+		return
+	}
+	for _, entry := range slices.Backward(history) {
+		if entry.Family == family.Name {
+			if entry.IP == ip {
+				return
+			}
+			break
+		}
+	}
+	history = append(history, ipHistoryEntry{Time: time.Now().UTC(), Family: family.Name, IP: ip})
+	if excess := len(history) - appConfig.IPHistorySize; excess > 0 {
+		history = slices.Delete(history, 0, excess)
+	}
+	ipHistory = history
+	if appConfig.DryRun {
+		return
+	}
+	data, err := json.Marshal(history)
+	if err == nil {
+		err = storeString(ipHistoryFile, string(data))
+	}
+	if err != nil {
+		logger.Warn("Could not store the IP history", "error", err)
+	}
+}
+
+// ipHistorySnapshot returns a copy of the history, newest first.
+func ipHistorySnapshot() ([]ipHistoryEntry, error) {
+	ipHistoryMu.Lock()
+	defer ipHistoryMu.Unlock()
+	history, err := currentIPHistory()
+	if err != nil {
+		return nil, err
+	}
+	snapshot := slices.Clone(history)
+	slices.Reverse(snapshot)
+	return snapshot, nil
+}
+
+// printIPHistory writes the history, newest first, for the history command.
+func printIPHistory(w io.Writer) error {
+	history, err := ipHistorySnapshot()
+	if err != nil {
+		return err
+	}
+	if len(history) == 0 {
+		fmt.Fprintln(w, "No address changes recorded")
+		return nil
+	}
+	for _, entry := range history {
+		fmt.Fprintf(w, "%s  %-4s  %s\n", entry.Time.Format(time.RFC3339), entry.Family, entry.IP)
+	}
+	return nil
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+const (
+	ipCheckFallback  = "fallback"
+	ipCheckConsensus = "consensus"
+)
+
+const (
+	// defaultIPCheckTimeout bounds a single query to an IP source, so that a
+	// provider which hangs only costs one timeout before the next is tried.
+	defaultIPCheckTimeout   = 10 * time.Second
+	defaultIPCheckUserAgent = "auto-route53"
+)
+
+var (
+	defaultIPCheckURLs = []string{
+		"https://checkip.amazonaws.com/",
+		"https://api.ipify.org/",
+		"https://icanhazip.com/",
+		"https://ifconfig.co/ip",
+	}
+	defaultIPv6CheckURLs = []string{
+		"https://api6.ipify.org/",
+		"https://icanhazip.com/",
+		"https://ifconfig.co/ip",
+	}
+)
+
+// ipSource is one way of finding out our public address.
+type ipSource interface {
+	// Name identifies the source in logs.
+	Name() string
+	// GetIP returns the current address of the given family.
+	GetIP(ctx context.Context, family addressFamily) (string, error)
+}
+
+// ipSourceOptions are the settings shared by the HTTP-based sources.
+type ipSourceOptions struct {
+	MaxBytes  int64
+	UserAgent string
+	RootCAs   *x509.CertPool
+}
+
+// newIPSource builds the source described by a check URL.
+func newIPSource(rawURL string, options ipSourceOptions) (ipSource, error) {
+	// Commands are not URLs and may contain characters url.Parse rejects.
+	if command, ok := strings.CutPrefix(rawURL, "exec:"); ok {
+		fields := strings.Fields(command)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("invalid IP check URL %q: expected exec:command [args...]", rawURL)
+		}
+		return &execIPSource{command: fields, maxBytes: options.MaxBytes}, nil
+	}
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IP check URL %q: %w", rawURL, err)
+	}
+	switch parsed.Scheme {
+	case "http", "https":
+		return &httpIPSource{url: rawURL, maxBytes: options.MaxBytes, userAgent: options.UserAgent, rootCAs: options.RootCAs}, nil
+	case "stun":
+		server := parsed.Opaque
+		if server == "" {
+			server = parsed.Host
+		}
+		if server == "" {
+			return nil, fmt.Errorf("invalid IP check URL %q: expected stun:host[:port]", rawURL)
+		}
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			server = net.JoinHostPort(server, defaultSTUNPort)
+		}
+		return &stunIPSource{server: server}, nil
+	case "interface":
+		if parsed.Opaque == "" {
+			return nil, fmt.Errorf("invalid IP check URL %q: expected interface:name", rawURL)
+		}
+		return &interfaceIPSource{name: parsed.Opaque}, nil
+	case "natpmp":
+		return &natpmpIPSource{gateway: parsed.Opaque}, nil
+	case "upnp":
+		return &upnpIPSource{}, nil
+	case "imds":
+		source, err := newIMDSIPSource(parsed.Opaque)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP check URL %q: %w", rawURL, err)
+		}
+		return source, nil
+	default:
+		return nil, fmt.Errorf("invalid IP check URL %q: unsupported scheme %q", rawURL, parsed.Scheme)
+	}
+}
+
+// newIPSources builds the sources for an ordered list of check URLs.
+func newIPSources(urls []string, options ipSourceOptions) ([]ipSource, error) {
+	sources := make([]ipSource, 0, len(urls))
+	for _, rawURL := range urls {
+		source, err := newIPSource(rawURL, options)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	return sources, nil
+}
+
+// httpIPSource asks an HTTP echo service, which answers with the caller's
+// address as plain text.
+type httpIPSource struct {
+	url       string
+	maxBytes  int64
+	userAgent string
+	rootCAs   *x509.CertPool
+}
+
+func (s *httpIPSource) Name() string { return s.url }
+
+// GetIP pins the connection to the family's network ("tcp4" or "tcp6") so
+// that dual-stack services report the address of the family we asked for.
+func (s *httpIPSource) GetIP(ctx context.Context, family addressFamily) (string, error) {
+	dialer := &net.Dialer{}
+	client := &http.Client{
+		Transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: func(ctx context.Context, _, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, family.Network, addr)
+			},
+			TLSClientConfig: clientTLSConfig(s.rootCAs),
+		},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.url, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to build IP check request: %w", err)
+	}
+	req.Header.Set("User-Agent", s.userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get public IP: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("bad status from IP service: %s", resp.Status)
+	}
+	// Read one byte past the limit so an oversized body can be detected
+	// without buffering all of it.
+	ipBytes, err := io.ReadAll(io.LimitReader(resp.Body, s.maxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read response body: %w", err)
+	}
+	if int64(len(ipBytes)) > s.maxBytes {
+		return "", fmt.Errorf("IP service response exceeds %d bytes", s.maxBytes)
+	}
+	return strings.TrimSpace(string(ipBytes)), nil
+}
+
+// parseFamilyAddress checks that a source answered with an address of the
+// requested family, rather than an error page or the other family.
+func parseFamilyAddress(value string, family addressFamily) (string, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return "", fmt.Errorf("response %q is not an IP address", value)
+	}
+	addr = addr.Unmap()
+	if addr.Is4() == (family.RecordType == r53types.RRTypeAaaa) {
+		return "", fmt.Errorf("response %s is not an %s address", addr, family.Name)
+	}
+	return addr.String(), nil
+}
+
+// detectPublicIP tries each source in order, allowing each one timeout, and
+// returns the first valid address along with the name of the source that
+// gave it. Sources that fail, time out or answer with something other than
+// an address of the family are logged and skipped.
+func detectPublicIP(ctx context.Context, sources []ipSource, family addressFamily, timeout time.Duration) (string, string, error) {
+	if len(sources) == 0 {
+		return "", "", fmt.Errorf("no %s sources configured", family.Name)
+	}
+	logger := loggerFrom(ctx)
+	var lastErr error
+	for _, source := range sources {
+		queryCtx, cancel := context.WithTimeout(ctx, timeout)
+		value, err := source.GetIP(queryCtx, family)
+		cancel()
+		if err == nil {
+			value, err = parseFamilyAddress(value, family)
+		}
+		if err != nil {
+			logger.Warn("IP source failed", "family", family.Name, "source", source.Name(), "error", err)
+			lastErr = err
+			continue
+		}
+		return value, source.Name(), nil
+	}
+	return "", "", fmt.Errorf("all %d %s sources failed, last error: %w", len(sources), family.Name, lastErr)
+}
+
+// detectPublicIPConsensus queries every source concurrently and only accepts
+// an address reported by a strict majority of them, so that a single
+// compromised or misbehaving service cannot redirect the records. Sources
+// that fail count as not agreeing.
+func detectPublicIPConsensus(ctx context.Context, sources []ipSource, family addressFamily, timeout time.Duration) (string, string, error) {
+	if len(sources) == 0 {
+		return "", "", fmt.Errorf("no %s sources configured", family.Name)
+	}
+	logger := loggerFrom(ctx)
+	answers := make([]string, len(sources))
+	var wg sync.WaitGroup
+	for i, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			queryCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			value, err := source.GetIP(queryCtx, family)
+			if err == nil {
+				value, err = parseFamilyAddress(value, family)
+			}
+			if err != nil {
+				logger.Warn("IP source failed", "family", family.Name, "source", source.Name(), "error", err)
+				return
+			}
+			answers[i] = value
+		}()
+	}
+	wg.Wait()
+
+	votes := map[string]int{}
+	for i, answer := range answers {
+		if answer == "" {
+			continue
+		}
+		votes[answer]++
+		logger.Debug("IP source answered", "family", family.Name, "source", sources[i].Name(), "ip", answer)
+	}
+	for answer, count := range votes {
+		if count*2 > len(sources) {
+			return answer, fmt.Sprintf("consensus of %d/%d sources", count, len(sources)), nil
+		}
+	}
+	return "", "", fmt.Errorf("no %s address was reported by a majority of %d sources (answers: %v)", family.Name, len(sources), votes)
+}
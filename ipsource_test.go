@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// echoServer answers every request with status and body.
+func echoServer(t *testing.T, status int, body string) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestHTTPIPSourceSizeLimit(t *testing.T) {
+	const maxBytes = 16
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr string
+	}{
+		{name: "address", status: http.StatusOK, body: "198.41.0.4\n", want: "198.41.0.4"},
+		{name: "exactly the limit", status: http.StatusOK, body: "198.41.0.4" + strings.Repeat(" ", maxBytes-len("198.41.0.4")), want: "198.41.0.4"},
+		{name: "one byte over", status: http.StatusOK, body: "198.41.0.4" + strings.Repeat(" ", maxBytes+1-len("198.41.0.4")), wantErr: "exceeds 16 bytes"},
+		{name: "runaway body", status: http.StatusOK, body: strings.Repeat("198.41.0.4\n", 100000), wantErr: "exceeds 16 bytes"},
+		{name: "error status", status: http.StatusBadGateway, body: "198.41.0.4", wantErr: "bad status"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			source, err := newIPSource(echoServer(t, tt.status, tt.body), ipSourceOptions{MaxBytes: maxBytes})
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := source.GetIP(context.Background(), ipv4Family)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("GetIP() = %q, %v, want an error containing %q", got, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("GetIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDetectPublicIPSkipsOversizedResponse(t *testing.T) {
+	sources, err := newIPSources([]string{
+		echoServer(t, http.StatusOK, strings.Repeat("x", defaultIPResponseMaxBytes+1)),
+		echoServer(t, http.StatusOK, "199.9.14.201"),
+	}, ipSourceOptions{MaxBytes: defaultIPResponseMaxBytes})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, source, err := detectPublicIP(context.Background(), sources, ipv4Family, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip != "199.9.14.201" || source != sources[1].Name() {
+		t.Errorf("detectPublicIP() = %s from %s, want 199.9.14.201 from the second source", ip, source)
+	}
+}
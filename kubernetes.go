@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Kubernetes Certificate Sync ---
+
+const (
+	kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+	kubeFieldManager      = "auto-route53"
+	kubeRequestTimeout    = 30 * time.Second
+)
+
+// kubeNamePattern matches the names Kubernetes allows for Secrets and
+// ConfigMaps.
+var kubeNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9.]{0,251}[a-z0-9])?$`)
+
+// kubeClient talks to the API server of the cluster the updater runs in,
+// with the pod's service account. It only needs to apply Secrets and
+// ConfigMaps, which does not justify the weight of client-go.
+type kubeClient struct {
+	baseURL   string
+	namespace string
+	http      *http.Client
+}
+
+var (
+	kubeOnce      sync.Once
+	kubeShared    *kubeClient
+	kubeSharedErr error
+)
+
+// inClusterKubeClient returns the client for the cluster the updater runs in,
+// creating it on first use.
+func inClusterKubeClient() (*kubeClient, error) {
+	kubeOnce.Do(func() {
+		kubeShared, kubeSharedErr = newInClusterKubeClient()
+	})
+	return kubeShared, kubeSharedErr
+}
+
+func newInClusterKubeClient() (*kubeClient, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes cluster: KUBERNETES_SERVICE_HOST is not set")
+	}
+	caData, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("the service account CA contains no certificates")
+	}
+	namespace, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "namespace"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the service account namespace: %w", err)
+	}
+	return &kubeClient{
+		baseURL:   "https://" + net.JoinHostPort(host, port),
+		namespace: strings.TrimSpace(string(namespace)),
+		http: &http.Client{
+			Timeout:   kubeRequestTimeout,
+			Transport: &http.Transport{TLSClientConfig: clientTLSConfig(pool)},
+		},
+	}, nil
+}
+
+// apply creates or updates an object with server-side apply, taking over
+// the fields it sets from any other manager.
+func (c *kubeClient) apply(ctx context.Context, namespace, resource, name string, object map[string]any) error {
+	// Projected service account tokens are rotated, so the token is read
+	// for every request.
+	token, err := os.ReadFile(filepath.Join(kubeServiceAccountDir, "token"))
+	if err != nil {
+		return fmt.Errorf("failed to read the service account token: %w", err)
+	}
+	body, err := json.Marshal(object)
+	if err != nil {
+		return err
+	}
+	endpoint := fmt.Sprintf("%s/api/v1/namespaces/%s/%s/%s?fieldManager=%s&force=true",
+		c.baseURL, url.PathEscape(namespace), resource, url.PathEscape(name), kubeFieldManager)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPatch, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	// JSON is valid YAML, which is what apply patches are.
+	req.Header.Set("Content-Type", "application/apply-patch+yaml")
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to apply %s %s/%s: %w", resource, namespace, name, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("failed to apply %s %s/%s: %s: %s", resource, namespace, name, resp.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// normalizeKubernetesTargets validates the record's kubernetes_secret and
+// kubernetes_configmap.
+func normalizeKubernetesTargets(record *RecordConfig) error {
+	for setting, name := range map[string]*string{"kubernetes_secret": &record.KubernetesSecret, "kubernetes_configmap": &record.KubernetesConfigMap} {
+		*name = strings.TrimSpace(*name)
+		if *name == "" {
+			continue
+		}
+		if !record.TLS {
+			return fmt.Errorf("record %s has a %s but tls is not enabled", record.RecordName, setting)
+		}
+		if !kubeNamePattern.MatchString(*name) {
+			return fmt.Errorf("record %s has an invalid %s %q", record.RecordName, setting, *name)
+		}
+	}
+	return nil
+}
+
+// kubernetesCertificateData returns what the record's certificate puts in a
+// Secret or ConfigMap. With ACM that is the ARN, under certificate-arn, for
+// controllers that take ACM annotations. With ACME it is the certificate
+// chain, with the private key for a Secret only, in the layout of a
+// kubernetes.io/tls Secret. It returns nil if there is no certificate yet.
+func kubernetesCertificateData(appConfig *AppConfig, record RecordConfig, secret bool) (map[string]string, error) {
+	if appConfig.CertBackend != certBackendACME {
+		certArn, _ := getStoredString(certStateFile(certStateKey(record)))
+		if certArn == "" {
+			return nil, nil
+		}
+		return map[string]string{"certificate-arn": certArn}, nil
+	}
+	dir := acmeCertDir(appConfig, record)
+	chain, err := os.ReadFile(filepath.Join(dir, acmeFullChainFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]string{"tls.crt": string(chain)}
+	if secret {
+		key, err := os.ReadFile(filepath.Join(dir, acmePrivateKeyFile))
+		if err != nil {
+			return nil, err
+		}
+		data["tls.key"] = string(key)
+	}
+	return data, nil
+}
+
+// syncCertificateToKubernetes writes the record's certificate to its
+// kubernetes_secret and kubernetes_configmap in the updater's namespace, so
+// that Ingress controllers can pick it up.
+func syncCertificateToKubernetes(ctx context.Context, appConfig *AppConfig, record RecordConfig) error {
+	if record.KubernetesSecret == "" && record.KubernetesConfigMap == "" {
+		return nil
+	}
+	logger := slog.With("component", "kubernetes", "domain", record.RecordName)
+	client, err := inClusterKubeClient()
+	if err != nil {
+		return err
+	}
+	namespace := appConfig.KubernetesNamespace
+	if namespace == "" {
+		namespace = client.namespace
+	}
+
+	targets := []struct {
+		resource, kind, name string
+	}{
+		{"secrets", "Secret", record.KubernetesSecret},
+		{"configmaps", "ConfigMap", record.KubernetesConfigMap},
+	}
+	for _, target := range targets {
+		if target.name == "" {
+			continue
+		}
+		data, err := kubernetesCertificateData(appConfig, record, target.kind == "Secret")
+		if err != nil {
+			return err
+		}
+		if data == nil {
+			continue
+		}
+		if appConfig.DryRun {
+			logger.Info("DRY RUN: Would write certificate to Kubernetes", "kind", target.kind, "namespace", namespace, "name", target.name)
+			continue
+		}
+		object := map[string]any{
+			"apiVersion": "v1",
+			"kind":       target.kind,
+			"metadata": map[string]any{
+				"name":      target.name,
+				"namespace": namespace,
+				"labels":    map[string]string{"app.kubernetes.io/managed-by": kubeFieldManager},
+			},
+		}
+		if target.kind == "Secret" {
+			encoded := map[string]string{}
+			for key, value := range data {
+				encoded[key] = base64.StdEncoding.EncodeToString([]byte(value))
+			}
+			object["data"] = encoded
+			if _, ok := data["tls.key"]; ok {
+				object["type"] = "kubernetes.io/tls"
+			}
+		} else {
+			object["data"] = data
+		}
+		if err := client.apply(ctx, namespace, target.resource, target.name, object); err != nil {
+			return err
+		}
+		logger.Debug("Wrote certificate to Kubernetes", "kind", target.kind, "namespace", namespace, "name", target.name)
+	}
+	return nil
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// --- Leader Election ---
+
+const (
+	leaderElectionOff      = "off"
+	leaderElectionDynamoDB = "dynamodb"
+	leaderElectionS3       = "s3"
+
+	defaultLeaderLeaseDuration = 30 * time.Second
+)
+
+// leaseLock is a lock with an expiry, held by one instance at a time.
+type leaseLock interface {
+	// acquire takes or renews the lease for holder until expires and reports
+	// whether it is now held. It returns false, not an error, when another
+	// holder's lease is still valid.
+	acquire(ctx context.Context, holder string, expires time.Time) (bool, error)
+	// release gives the lease up if holder still has it.
+	release(ctx context.Context, holder string) error
+}
+
+// leaderElector keeps trying to hold a leaseLock and reports changes of
+// leadership. Only the leader performs updates; a standby watches the lock
+// and takes over once the leader's lease runs out.
+type leaderElector struct {
+	lock     leaseLock
+	holder   string
+	lease    time.Duration
+	onChange func(leading bool)
+
+	mu      sync.Mutex
+	leading bool
+}
+
+func newLeaderElector(appConfig *AppConfig, awsCfg aws.Config, onChange func(leading bool)) (*leaderElector, error) {
+	var lock leaseLock
+	switch appConfig.LeaderElection {
+	case leaderElectionDynamoDB:
+		lock = &dynamoDBLease{client: dynamodb.NewFromConfig(awsCfg), table: appConfig.LeaderElectionTable, key: appConfig.LeaderElectionKey}
+	case leaderElectionS3:
+		lock = &s3Lease{client: s3.NewFromConfig(awsCfg), bucket: appConfig.LeaderElectionBucket, key: appConfig.LeaderElectionKey}
+	default:
+		return nil, fmt.Errorf("unknown leader election backend %q", appConfig.LeaderElection)
+	}
+
+	hostname, _ := os.Hostname()
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return &leaderElector{
+		lock:     lock,
+		holder:   hostname + "-" + hex.EncodeToString(suffix),
+		lease:    appConfig.LeaderLeaseDuration,
+		onChange: onChange,
+	}, nil
+}
+
+func (e *leaderElector) isLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+func (e *leaderElector) setLeading(leading bool) {
+	e.mu.Lock()
+	changed := e.leading != leading
+	e.leading = leading
+	e.mu.Unlock()
+	if changed {
+		e.onChange(leading)
+	}
+}
+
+// run renews the lease every third of its duration until ctx is cancelled,
+// then releases it so a standby can take over without waiting for expiry.
+// A leader steps down at the first failed renewal a third of a lease after
+// the last one, which leaves it a third of the lease, or two thirds if the
+// ticker fired early, before a standby may take over.
+func (e *leaderElector) run(ctx context.Context) {
+	logger := slog.With("component", "leader", "holder", e.holder)
+	logger.Info("Starting leader election", "lease", e.lease)
+	var renewedAt time.Time
+	ticker := time.NewTicker(e.lease / 3)
+	defer ticker.Stop()
+	for {
+		attemptAt := time.Now()
+		held, err := e.lock.acquire(ctx, e.holder, attemptAt.Add(e.lease))
+		switch {
+		case err != nil:
+			logger.Warn("Leader lease request failed", "error", err)
+			if e.isLeader() && time.Since(renewedAt) >= e.lease/3 {
+				logger.Error("Stepping down, the leader lease could not be renewed in time")
+				e.setLeading(false)
+			}
+		case held:
+			renewedAt = attemptAt
+			if !e.isLeader() {
+				logger.Info("Became leader")
+			}
+			e.setLeading(true)
+		default:
+			if e.isLeader() {
+				logger.Warn("Lost leadership to another instance")
+			}
+			e.setLeading(false)
+		}
+
+		select {
+		case <-ctx.Done():
+			if e.isLeader() {
+				e.setLeading(false)
+				releaseCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				if err := e.lock.release(releaseCtx, e.holder); err != nil {
+					logger.Warn("Failed to release leader lease", "error", err)
+				}
+				cancel()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dynamoDBLease stores the lease in one item of a table whose partition key
+// is the string attribute "key". Conditional writes make taking it atomic.
+type dynamoDBLease struct {
+	client *dynamodb.Client
+	table  string
+	key    string
+}
+
+func (l *dynamoDBLease) acquire(ctx context.Context, holder string, expires time.Time) (bool, error) {
+	_, err := l.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: &l.table,
+		Item: map[string]ddbtypes.AttributeValue{
+			"key":     &ddbtypes.AttributeValueMemberS{Value: l.key},
+			"holder":  &ddbtypes.AttributeValueMemberS{Value: holder},
+			"expires": &ddbtypes.AttributeValueMemberN{Value: strconv.FormatInt(expires.Unix(), 10)},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(#key) OR holder = :holder OR expires < :now"),
+		ExpressionAttributeNames: map[string]string{
+			"#key": "key",
+		},
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":holder": &ddbtypes.AttributeValueMemberS{Value: holder},
+			":now":    &ddbtypes.AttributeValueMemberN{Value: strconv.FormatInt(time.Now().Unix(), 10)},
+		},
+	})
+	if err != nil {
+		var conditionFailed *ddbtypes.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to write leader lease to DynamoDB table %s: %w", l.table, err)
+	}
+	return true, nil
+}
+
+func (l *dynamoDBLease) release(ctx context.Context, holder string) error {
+	_, err := l.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName:           &l.table,
+		Key:                 map[string]ddbtypes.AttributeValue{"key": &ddbtypes.AttributeValueMemberS{Value: l.key}},
+		ConditionExpression: aws.String("holder = :holder"),
+		ExpressionAttributeValues: map[string]ddbtypes.AttributeValue{
+			":holder": &ddbtypes.AttributeValueMemberS{Value: holder},
+		},
+	})
+	var conditionFailed *ddbtypes.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &conditionFailed) {
+		return err
+	}
+	return nil
+}
+
+// s3Lease stores the lease as a JSON object and uses S3 conditional writes
+// (If-None-Match / If-Match) so that only one instance can replace it.
+type s3Lease struct {
+	client *s3.Client
+	bucket string
+	key    string
+}
+
+type s3LeaseBody struct {
+	Holder  string    `json:"holder"`
+	Expires time.Time `json:"expires"`
+}
+
+// isPreconditionFailed reports whether S3 rejected a conditional write
+// because another writer got there first.
+func isPreconditionFailed(err error) bool {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		code := apiErr.ErrorCode()
+		return code == "PreconditionFailed" || code == "ConditionalRequestConflict"
+	}
+	return false
+}
+
+func (l *s3Lease) read(ctx context.Context) (*s3LeaseBody, string, error) {
+	output, err := l.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &l.bucket, Key: &l.key})
+	if err != nil {
+		var notFound *s3types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return nil, "", nil
+		}
+		return nil, "", fmt.Errorf("failed to read leader lease s3://%s/%s: %w", l.bucket, l.key, err)
+	}
+	defer output.Body.Close()
+	var body s3LeaseBody
+	if err := json.NewDecoder(io.LimitReader(output.Body, 4096)).Decode(&body); err != nil {
+		// A corrupt lease is treated as expired, so it can be replaced.
+		return &s3LeaseBody{}, aws.ToString(output.ETag), nil
+	}
+	return &body, aws.ToString(output.ETag), nil
+}
+
+func (l *s3Lease) write(ctx context.Context, body s3LeaseBody, etag string) (bool, error) {
+	data, _ := json.Marshal(body)
+	input := &s3.PutObjectInput{
+		Bucket:      &l.bucket,
+		Key:         &l.key,
+		Body:        strings.NewReader(string(data)),
+		ContentType: aws.String("application/json"),
+	}
+	if etag == "" {
+		input.IfNoneMatch = aws.String("*")
+	} else {
+		input.IfMatch = aws.String(etag)
+	}
+	if _, err := l.client.PutObject(ctx, input); err != nil {
+		if isPreconditionFailed(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to write leader lease s3://%s/%s: %w", l.bucket, l.key, err)
+	}
+	return true, nil
+}
+
+func (l *s3Lease) acquire(ctx context.Context, holder string, expires time.Time) (bool, error) {
+	current, etag, err := l.read(ctx)
+	if err != nil {
+		return false, err
+	}
+	if current != nil && current.Holder != holder && time.Now().Before(current.Expires) {
+		return false, nil
+	}
+	return l.write(ctx, s3LeaseBody{Holder: holder, Expires: expires}, etag)
+}
+
+func (l *s3Lease) release(ctx context.Context, holder string) error {
+	current, etag, err := l.read(ctx)
+	if err != nil || current == nil || current.Holder != holder {
+		return err
+	}
+	_, err = l.write(ctx, s3LeaseBody{Holder: holder, Expires: time.Now()}, etag)
+	return err
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+// memoryLease is a leaseLock held in memory, which can be made to fail.
+type memoryLease struct {
+	mu       sync.Mutex
+	holder   string
+	expires  time.Time
+	failing  bool
+	released []string
+}
+
+func (l *memoryLease) acquire(_ context.Context, holder string, expires time.Time) (bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failing {
+		return false, errors.New("lease store unreachable")
+	}
+	if l.holder != "" && l.holder != holder && time.Now().Before(l.expires) {
+		return false, nil
+	}
+	l.holder, l.expires = holder, expires
+	return true, nil
+}
+
+func (l *memoryLease) release(_ context.Context, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.released = append(l.released, holder)
+	if l.holder == holder {
+		l.holder = ""
+	}
+	return nil
+}
+
+func (l *memoryLease) setFailing(failing bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.failing = failing
+}
+
+// startElector runs an elector for holder on lock until the test ends, and
+// returns its leadership changes.
+func startElector(t *testing.T, lock leaseLock, holder string, lease time.Duration) (<-chan bool, context.CancelFunc) {
+	t.Helper()
+	changes := make(chan bool, 10)
+	elector := &leaderElector{lock: lock, holder: holder, lease: lease, onChange: func(leading bool) { changes <- leading }}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		elector.run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+	return changes, func() {
+		cancel()
+		<-done
+	}
+}
+
+// nextChange waits up to within for a leadership change.
+func nextChange(t *testing.T, changes <-chan bool, within time.Duration) (bool, bool) {
+	t.Helper()
+	select {
+	case leading := <-changes:
+		return leading, true
+	case <-time.After(within):
+		return false, false
+	}
+}
+
+func TestLeaderTakesOverAfterExpiry(t *testing.T) {
+	const lease = 150 * time.Millisecond
+	expires := time.Now().Add(lease)
+	lock := &memoryLease{holder: "crashed", expires: expires}
+	changes, _ := startElector(t, lock, "standby", lease)
+	leading, ok := nextChange(t, changes, 5*lease)
+	if !ok || !leading {
+		t.Fatal("the standby did not take over once the lease ran out")
+	}
+	if time.Now().Before(expires) {
+		t.Error("the standby took over before the other holder's lease ran out")
+	}
+}
+
+func TestLeaderStepsDownWhenRenewalsFail(t *testing.T) {
+	const lease = 300 * time.Millisecond
+	lock := &memoryLease{}
+	changes, _ := startElector(t, lock, "leader", lease)
+	if leading, ok := nextChange(t, changes, lease); !ok || !leading {
+		t.Fatal("the elector did not become leader of a free lease")
+	}
+	lock.mu.Lock()
+	expires := lock.expires
+	lock.failing = true
+	lock.mu.Unlock()
+
+	leading, ok := nextChange(t, changes, 2*lease)
+	if !ok || leading {
+		t.Fatal("the leader did not step down while its renewals failed")
+	}
+	if margin := time.Until(expires); margin < lease/4 {
+		t.Errorf("the leader stepped down %v before its lease ran out, want at least %v", margin, lease/4)
+	}
+
+	lock.setFailing(false)
+	if leading, ok := nextChange(t, changes, lease); !ok || !leading {
+		t.Error("the elector did not lead again once renewals succeeded")
+	}
+}
+
+func TestLeaderReleasesOnShutdown(t *testing.T) {
+	const lease = 300 * time.Millisecond
+	lock := &memoryLease{}
+	changes, stop := startElector(t, lock, "leader", lease)
+	if leading, ok := nextChange(t, changes, lease); !ok || !leading {
+		t.Fatal("the elector did not become leader of a free lease")
+	}
+	stop()
+	if leading, ok := nextChange(t, changes, lease); !ok || leading {
+		t.Error("the elector did not give up leadership on shutdown")
+	}
+	lock.mu.Lock()
+	defer lock.mu.Unlock()
+	if len(lock.released) != 1 || lock.released[0] != "leader" || lock.holder != "" {
+		t.Errorf("released by %q, holder now %q; want the lease released by leader", lock.released, lock.holder)
+	}
+
+	// A standby never held the lease, so it has nothing to release.
+	standbyLock := &memoryLease{holder: "leader", expires: time.Now().Add(time.Hour)}
+	_, stopStandby := startElector(t, standbyLock, "standby", lease)
+	time.Sleep(lease / 2)
+	stopStandby()
+	if len(standbyLock.released) != 0 {
+		t.Errorf("a standby released the lease: %q", standbyLock.released)
+	}
+}
+
+func TestDynamoDBLease(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantHeld bool
+		wantErr  bool
+	}{
+		{name: "taken", wantHeld: true},
+		{name: "held by another instance", err: &ddbtypes.ConditionalCheckFailedException{Message: aws.String("condition failed")}},
+		{name: "table unreachable", err: errors.New("connection refused"), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			var put *dynamodb.PutItemInput
+			fake.on("PutItem", func(input any) (any, error) {
+				put = input.(*dynamodb.PutItemInput)
+				if tt.err != nil {
+					return nil, tt.err
+				}
+				return &dynamodb.PutItemOutput{}, nil
+			})
+			lock := &dynamoDBLease{client: dynamodb.NewFromConfig(fake.config()), table: "leases", key: "auto-route53"}
+			held, err := lock.acquire(context.Background(), "leader", time.Now().Add(time.Minute))
+			if held != tt.wantHeld || (err != nil) != tt.wantErr {
+				t.Fatalf("acquire() = %t, %v; want %t and an error: %t", held, err, tt.wantHeld, tt.wantErr)
+			}
+			if holder := put.Item["holder"].(*ddbtypes.AttributeValueMemberS).Value; holder != "leader" {
+				t.Errorf("lease written for %q", holder)
+			}
+			if !strings.Contains(aws.ToString(put.ConditionExpression), "expires < :now") {
+				t.Errorf("condition %q does not let an expired lease be taken", aws.ToString(put.ConditionExpression))
+			}
+		})
+	}
+}
+
+func TestS3Lease(t *testing.T) {
+	tests := []struct {
+		name      string
+		current   string
+		putErr    error
+		wantHeld  bool
+		wantWrite bool
+	}{
+		{name: "held by another instance", current: `{"holder":"other","expires":"2999-01-01T00:00:00Z"}`},
+		{name: "expired", current: `{"holder":"other","expires":"2000-01-01T00:00:00Z"}`, wantHeld: true, wantWrite: true},
+		{name: "renewed", current: `{"holder":"leader","expires":"2999-01-01T00:00:00Z"}`, wantHeld: true, wantWrite: true},
+		{name: "lost the race", current: `{"holder":"other","expires":"2000-01-01T00:00:00Z"}`, putErr: &smithy.GenericAPIError{Code: "PreconditionFailed"}, wantWrite: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			fake.on("GetObject", func(any) (any, error) {
+				return &s3.GetObjectOutput{Body: io.NopCloser(strings.NewReader(tt.current)), ETag: aws.String(`"v1"`)}, nil
+			})
+			var put *s3.PutObjectInput
+			fake.on("PutObject", func(input any) (any, error) {
+				put = input.(*s3.PutObjectInput)
+				if tt.putErr != nil {
+					return nil, tt.putErr
+				}
+				return &s3.PutObjectOutput{}, nil
+			})
+			lock := &s3Lease{client: s3.NewFromConfig(fake.config()), bucket: "leases", key: "auto-route53.json"}
+			held, err := lock.acquire(context.Background(), "leader", time.Now().Add(time.Minute))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if held != tt.wantHeld {
+				t.Errorf("acquire() = %t, want %t", held, tt.wantHeld)
+			}
+			if (put != nil) != tt.wantWrite {
+				t.Fatalf("lease written: %t, want %t", put != nil, tt.wantWrite)
+			}
+			if put != nil && aws.ToString(put.IfMatch) != `"v1"` {
+				t.Errorf("lease replaced with If-Match %q, want the ETag read", aws.ToString(put.IfMatch))
+			}
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// --- Logging ---
+
+// setupLogging installs the default slog logger. format is "text" or
+// "json"; level is one of debug, info, warn or error.
+func setupLogging(format, level string) error {
+	var logLevel slog.Level
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q: must be debug, info, warn or error", level)
+	}
+	options := &slog.HandlerOptions{Level: logLevel}
+
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, options)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, options)
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q: must be text or json", format)
+	}
+	slog.SetDefault(slog.New(handler))
+	return nil
+}
+
+// fatal logs msg at error level and exits.
+func fatal(msg string, args ...any) {
+	slog.Error("FATAL: "+msg, args...)
+	os.Exit(1)
+}
+
+type cycleIDKey struct{}
+
+type loggerKey struct{}
+
+// newCycleID returns a short random identifier used to correlate all log
+// lines and Route53 changes belonging to one DDNS cycle.
+func newCycleID() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", time.Now().UnixNano()&0xffffffff)
+	}
+	return hex.EncodeToString(b)
+}
+
+// withCycle returns a context carrying the cycle ID and a logger that adds
+// it as the cycle_id field of every record.
+func withCycle(ctx context.Context, cycleID string) context.Context {
+	logger := slog.Default().With("component", "ddns", "cycle_id", cycleID)
+	ctx = context.WithValue(ctx, cycleIDKey{}, cycleID)
+	return context.WithValue(ctx, loggerKey{}, logger)
+}
+
+func cycleIDFrom(ctx context.Context) string {
+	cycleID, _ := ctx.Value(cycleIDKey{}).(string)
+	return cycleID
+}
+
+// loggerFrom returns the logger stored in ctx, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// captureLogs sends the default logger's records, as JSON, to the returned
+// buffer for the rest of the test.
+func captureLogs(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	old := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	t.Cleanup(func() { slog.SetDefault(old) })
+	return &buf
+}
+
+func TestCycleLogsShareCycleID(t *testing.T) {
+	tests := []struct {
+		name      string
+		stored    string
+		wantWrite bool
+	}{
+		{name: "address changed", stored: "199.9.14.201", wantWrite: true},
+		{name: "address unchanged", stored: "198.41.0.4"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			if err := storeString(ipStateFile, tt.stored); err != nil {
+				t.Fatal(err)
+			}
+			logs := captureLogs(t)
+			fake := newFakeAWS(t)
+			var comment string
+			fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+				comment = aws.ToString(input.(*route53.ChangeResourceRecordSetsInput).ChangeBatch.Comment)
+				return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+			})
+			appConfig := &AppConfig{
+				RecordsToUpdate: []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com", TTL: 300}},
+				IPv4Sources:     []ipSource{staticIPSource{"198.41.0.4"}},
+			}
+
+			const cycleID = "c0ffee00"
+			if err := runDDNSCycle(withCycle(context.Background(), cycleID), appConfig, fake.route53(), ddnsRequest{}); err != nil {
+				t.Fatal(err)
+			}
+
+			lines := strings.Split(strings.TrimSpace(logs.String()), "\n")
+			if len(lines) < 2 {
+				t.Fatalf("the cycle logged %d lines:\n%s", len(lines), logs)
+			}
+			for _, line := range lines {
+				var entry map[string]any
+				if err := json.Unmarshal([]byte(line), &entry); err != nil {
+					t.Fatalf("log line %q is not JSON: %v", line, err)
+				}
+				if entry["cycle_id"] != cycleID {
+					t.Errorf("log line without cycle_id %s: %s", cycleID, line)
+				}
+			}
+			if tt.wantWrite != (fake.count("ChangeResourceRecordSets") == 1) {
+				t.Fatalf("ChangeResourceRecordSets called %d times", fake.count("ChangeResourceRecordSets"))
+			}
+			if tt.wantWrite && !strings.HasSuffix(comment, "(cycle "+cycleID+")") {
+				t.Errorf("change comment %q does not name the cycle", comment)
+			}
+		})
+	}
+}
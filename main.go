@@ -2,40 +2,202 @@ package main
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"io"
-	"log"
-	"net/http"
+	"log/slog"
+	"net/netip"
 	"os"
+	"os/signal"
+	"regexp"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	cwtypes "github.com/aws/aws-sdk-go-v2/service/cloudwatch/types"
 	"github.com/aws/aws-sdk-go-v2/service/route53"
 	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/go-resty/resty/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // --- Struct Definitions ---
 
 type RecordConfig struct {
-	ZoneID          string `json:"zone_id"`
-	RecordName      string `json:"record_name"`
-	TLS             bool   `json:"tls,omitempty"`
-	Port            int    `json:"port,omitempty"`
-	RedirectToHttps bool   `json:"redirect_to_https,omitempty"`
+	ZoneID           string `json:"zone_id"`
+	RecordName       string `json:"record_name"`
+	TLS              bool   `json:"tls,omitempty"`
+	Port             int    `json:"port,omitempty"`
+	RedirectToHttps  bool   `json:"redirect_to_https,omitempty"`
+	ValidationZoneID string `json:"validation_zone_id,omitempty"`
+	IPv6             bool   `json:"ipv6,omitempty"`
+	TTL              int64  `json:"ttl,omitempty"`
+	// Type and Values describe a record with fixed values instead of one that
+	// follows the public IP. Without values, Type may be A (the default) or
+	// AAAA for an IPv6-only record. Value is shorthand for a single value.
+	Type   string   `json:"type,omitempty"`
+	Value  string   `json:"value,omitempty"`
+	Values []string `json:"values,omitempty"`
+	// The routing policy fields make the record one member of a weighted,
+	// latency or failover record set. SetIdentifier tells the members apart,
+	// so each site running the updater keeps its own member up to date.
+	SetIdentifier string `json:"set_identifier,omitempty"`
+	Weight        *int64 `json:"weight,omitempty"`
+	Region        string `json:"region,omitempty"`
+	Failover      string `json:"failover,omitempty"`
+	// HealthCheck creates a Route53 health check against the record's
+	// address and associates it with the record set.
+	HealthCheck *HealthCheckConfig `json:"health_check,omitempty"`
+	// Alias makes the record a Route53 alias to an AWS resource.
+	Alias *AliasConfig `json:"alias,omitempty"`
+	// Mode "append" adds the public IP to the record's values instead of
+	// replacing them, for round-robin across several hosts.
+	Mode string `json:"mode,omitempty"`
+	// ReverseZoneID and IPv6ReverseZoneID are reverse hosted zones in which a
+	// PTR record pointing back at the record follows its address.
+	ReverseZoneID     string `json:"reverse_zone_id,omitempty"`
+	IPv6ReverseZoneID string `json:"ipv6_reverse_zone_id,omitempty"`
+	// RoleARN is an IAM role to assume for this record's Route53 and ACM
+	// calls, for zones that live in another account.
+	RoleARN    string `json:"role_arn,omitempty"`
+	ExternalID string `json:"external_id,omitempty"`
+	// Profile is an AWS shared-config profile to use for this record's
+	// calls instead of the default credentials. A role_arn is assumed from
+	// the profile's credentials.
+	Profile string `json:"profile,omitempty"`
+	// CertRegion is the region to request the record's ACM certificate in,
+	// e.g. us-east-1 for CloudFront, instead of the default region.
+	CertRegion string `json:"cert_region,omitempty"`
+	// SubjectAlternativeNames are further names for the record's
+	// certificate to cover, so that one certificate serves several names.
+	SubjectAlternativeNames []string `json:"subject_alternative_names,omitempty"`
+	// Wildcard adds *.<record_name> to the certificate's names.
+	Wildcard bool `json:"wildcard,omitempty"`
+	// KeyAlgorithm is the certificate's key type, RSA_2048 by default.
+	KeyAlgorithm string `json:"key_algorithm,omitempty"`
+	// CertTags are tags for the record's certificate, on top of CERT_TAGS.
+	CertTags map[string]string `json:"cert_tags,omitempty"`
+	// SSMParameter is an SSM parameter to publish the certificate ARN to,
+	// instead of the one under CERT_SSM_PREFIX.
+	SSMParameter string `json:"ssm_parameter,omitempty"`
+	// KubernetesSecret and KubernetesConfigMap name a Secret and a ConfigMap
+	// to write the certificate to when running in a Kubernetes cluster.
+	KubernetesSecret    string `json:"kubernetes_secret,omitempty"`
+	KubernetesConfigMap string `json:"kubernetes_configmap,omitempty"`
+	// ListenerArns are ALB/NLB listeners to attach the certificate to, as
+	// the default certificate with ListenerDefault.
+	ListenerArns    []string `json:"listener_arns,omitempty"`
+	ListenerDefault bool     `json:"listener_default,omitempty"`
+	// CloudFrontDistID is a CloudFront distribution to use the certificate
+	// as its viewer certificate.
+	CloudFrontDistID string `json:"cloudfront_distribution_id,omitempty"`
+	// APIGatewayDomain is an API Gateway custom domain name to create or
+	// update with the certificate, with an alias record if APIGatewayAlias.
+	APIGatewayDomain string `json:"api_gateway_domain,omitempty"`
+	APIGatewayAlias  bool   `json:"api_gateway_alias,omitempty"`
 }
 
 type AppConfig struct {
-	SleepTime       time.Duration
-	RecordsToUpdate []RecordConfig
-	NPMBaseURL      string
-	NPMIdentity     string
-	NPMSecret       string
-	ForwardHost     string
+	SleepTime            time.Duration
+	RecordsToUpdate      []RecordConfig
+	NPMBaseURL           string
+	NPMIdentity          string
+	NPMSecret            string
+	ForwardHost          string
+	CertMode             string
+	CertTags             map[string]string
+	CertReconcilePeriod  time.Duration
+	CertConcurrency      int
+	CertValidationWait   time.Duration
+	CertPollInterval     time.Duration
+	CertExpiryWarning    time.Duration
+	CertCleanup          string
+	CertDropValidation   bool
+	CertBackend          string
+	ACMEDirectoryURL     string
+	ACMEEmail            string
+	ACMECertDir          string
+	CertSSMPrefix        string
+	KubernetesNamespace  string
+	ACMRegionCheck       string
+	CAACheck             string
+	IPResponseMaxBytes   int64
+	IPCheckMode          string
+	IPCheckTimeout       time.Duration
+	IPv4Sources          []ipSource
+	IPv6Sources          []ipSource
+	Notifiers            []notifyTarget
+	NotifyFailureLimit   int
+	NotifyCoalesceWindow time.Duration
+	NotifyRateLimit      int
+	HeartbeatURL         string
+	HeartbeatFailURL     string
+	CloudWatchNamespace  string
+	CloudWatchRegion     string
+	OTLPTracesEndpoint   string
+	AuditLog             string
+	IPHistorySize        int
+	Dashboard            bool
+	APIToken             string
+	StatusRedactARNs     bool
+	TriggerTrustIP       bool
+	DynDNSUsers          []dyndnsUser
+	RFC2136Addr          string
+	RFC2136Keys          []tsigKey
+	RFC2136Zones         []rfc2136Zone
+	DryRun               bool
+	Stateless            bool
+	StateBackend         string
+	StateS3Bucket        string
+	StateS3Prefix        string
+	StateDynamoDBTable   string
+	StateSSMPrefix       string
+	LeaderElection       string
+	LeaderElectionTable  string
+	LeaderElectionBucket string
+	LeaderElectionKey    string
+	LeaderLeaseDuration  time.Duration
+	OwnerID              string
+	OwnershipTXTPrefix   string
+	OwnershipForce       bool
+	WaitForInsync        bool
+	InsyncTimeout        time.Duration
+	VerifyPropagation    bool
+	PropagationTimeout   time.Duration
+	RetryMaxAttempts     int
+	RetryMaxBackoff      time.Duration
+	RetryMode            string
+	APITimeout           time.Duration
+	APIConnectTimeout    time.Duration
+	AWSEndpointURL       string
+	AWSServiceEndpoints  endpointOverrides
+	UseFIPSEndpoint      bool
+	CABundle             string
+	RootCAs              *x509.CertPool // nil for the system roots
+	RunOnce              bool
+	HTTPAddr             string
+	GRPCAddr             string
+	LogFormat            string
+	LogLevel             string
+	ConfigMerge          string
+	// Origins says which settings and records came from the environment
+	// and which from CONFIG_FILE.
+	Origins configOrigins
+	// AWSAccountID is resolved from STS at startup rather than read from the
+	// environment. It is empty if the caller identity could not be looked up.
+	AWSAccountID string
 }
 
 // Structs for NPM API
@@ -50,67 +212,457 @@ type NpmProxyHost struct {
 }
 
 const (
-	ipStateFile = "data/last_ip.txt"
+	certModeManage = "manage"
+	certModeReport = "report"
 )
 
+const (
+	regionCheckWarn  = "warn"
+	regionCheckAbort = "abort"
+	regionCheckOff   = "off"
+)
+
+const (
+	ipStateFile               = "data/last_ip.txt"
+	ipv6StateFile             = "data/last_ipv6.txt"
+	defaultIPResponseMaxBytes = 4096
+	defaultRecordTTL          = 300
+	defaultInsyncTimeout      = 5 * time.Minute
+	certStateFilePattern      = "data/cert_arn_%s.txt"
+	certPendingFilePattern    = "data/cert_pending_%s.txt"
+	defaultCertValidationWait = 15 * time.Minute
+	defaultCertPollInterval   = 30 * time.Second
+	// defaultCertReconcilePeriod is how often the certificate of every
+	// TLS record is checked again after the first pass.
+	defaultCertReconcilePeriod = time.Hour
+	// defaultCertConcurrency is how many certificate passes run at once.
+	defaultCertConcurrency = 4
+)
+
+var awsRegionPattern = regexp.MustCompile(`^[a-z]{2}(-gov|-iso[a-z]?)?-[a-z]+-\d+$`)
+
 // --- Shared Helper Functions ---
 
-func getStoredString(filename string) (string, error) {
-	data, err := os.ReadFile(filename)
-	if os.IsNotExist(err) {
-		return "", nil
+// canonicalName normalizes a DNS name for comparison. DNS names are
+// case-insensitive and Route53 returns them fully qualified, so
+// "API.example.com." and "api.example.com" refer to the same record.
+func canonicalName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// --- DDNS Functions ---
+
+// addressFamily describes one kind of address the DDNS loop keeps in sync.
+type addressFamily struct {
+	Name          string
+	RecordType    r53types.RRType
+	Network       string
+	PacketNetwork string
+	StateFile     string
+}
+
+var (
+	ipv4Family = addressFamily{Name: "IPv4", RecordType: r53types.RRTypeA, Network: "tcp4", PacketNetwork: "udp4", StateFile: ipStateFile}
+	ipv6Family = addressFamily{Name: "IPv6", RecordType: r53types.RRTypeAaaa, Network: "tcp6", PacketNetwork: "udp6", StateFile: ipv6StateFile}
+)
+
+// reservedIPv4Ranges are special-use ranges that are never a valid public
+// address for a record, even though some captive portals and broken echo
+// services return them.
+var reservedIPv4Ranges = []struct {
+	prefix netip.Prefix
+	name   string
+}{
+	{netip.MustParsePrefix("0.0.0.0/8"), "\"this network\""},
+	{netip.MustParsePrefix("192.0.0.0/24"), "IETF protocol assignments"},
+	{netip.MustParsePrefix("192.0.2.0/24"), "documentation (TEST-NET-1)"},
+	{netip.MustParsePrefix("198.18.0.0/15"), "benchmarking"},
+	{netip.MustParsePrefix("198.51.100.0/24"), "documentation (TEST-NET-2)"},
+	{netip.MustParsePrefix("203.0.113.0/24"), "documentation (TEST-NET-3)"},
+	{netip.MustParsePrefix("240.0.0.0/4"), "reserved"},
+	{netip.MustParsePrefix("255.255.255.255/32"), "limited broadcast"},
+}
+
+// reservedIPv6Ranges are IPv6 ranges that must never be published, on top of
+// anything that is not a global unicast address.
+var reservedIPv6Ranges = []struct {
+	prefix netip.Prefix
+	name   string
+}{
+	{netip.MustParsePrefix("2001:db8::/32"), "documentation"},
+	{netip.MustParsePrefix("fc00::/7"), "unique local"},
+	{netip.MustParsePrefix("64:ff9b::/96"), "NAT64"},
+}
+
+// checkPublishableIP returns an error if ip is not a valid address of the
+// given family or falls in a reserved or special-use range.
+func checkPublishableIP(ip string, family addressFamily) error {
+	addr, err := netip.ParseAddr(ip)
+	if family.RecordType == r53types.RRTypeAaaa {
+		if err != nil || !addr.Is6() || addr.Is4In6() {
+			return fmt.Errorf("%q is not a valid IPv6 address", ip)
+		}
+		if !addr.IsGlobalUnicast() {
+			return fmt.Errorf("%s is not a global unicast address", ip)
+		}
+		for _, reserved := range reservedIPv6Ranges {
+			if reserved.prefix.Contains(addr) {
+				return fmt.Errorf("%s is in the %s range %s", ip, reserved.name, reserved.prefix)
+			}
+		}
+		return nil
 	}
-	return string(data), err
+
+	if err != nil || !addr.Is4() {
+		return fmt.Errorf("%q is not a valid IPv4 address", ip)
+	}
+	for _, reserved := range reservedIPv4Ranges {
+		if reserved.prefix.Contains(addr) {
+			return fmt.Errorf("%s is in the %s range %s", ip, reserved.name, reserved.prefix)
+		}
+	}
+	return nil
 }
 
-func storeString(filename, value string) error {
-	return os.WriteFile(filename, []byte(value), 0644)
+// describeChanges summarizes a change batch for logging, one change per line.
+func describeChanges(zoneID string, batch *r53types.ChangeBatch) string {
+	var lines []string
+	for _, change := range batch.Changes {
+		rrs := change.ResourceRecordSet
+		values := recordSetValues(rrs)
+		details := "zone " + zoneID
+		if rrs.TTL != nil {
+			details += fmt.Sprintf(", TTL %d", aws.ToInt64(rrs.TTL))
+		}
+		if rrs.SetIdentifier != nil {
+			details += ", set " + aws.ToString(rrs.SetIdentifier)
+		}
+		lines = append(lines, fmt.Sprintf("%s %s %s (%s) -> %s",
+			change.Action, rrs.Type, aws.ToString(rrs.Name), details, strings.Join(values, ", ")))
+	}
+	return strings.Join(lines, "; ")
 }
 
-// --- DDNS Functions ---
+// applyChangeBatch sends a change batch to Route53 and records it in the
+// audit log. Every record set the application changes, ACME challenges
+// included, goes through here so that DRY_RUN can intercept it. With
+// WAIT_FOR_INSYNC it only returns once Route53 reports the change as
+// propagated to all of its name servers.
+func applyChangeBatch(ctx context.Context, appConfig *AppConfig, client *route53.Client, input *route53.ChangeResourceRecordSetsInput) (err error) {
+	logger := loggerFrom(ctx)
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would send Route53 change batch", "zone_id", aws.ToString(input.HostedZoneId), "changes", describeChanges(aws.ToString(input.HostedZoneId), input.ChangeBatch))
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "route53.change_batch", trace.WithAttributes(attribute.String("zone_id", aws.ToString(input.HostedZoneId)), attribute.Int("changes", len(input.ChangeBatch.Changes))))
+	defer func() { endSpan(span, err) }()
+	output, err := client.ChangeResourceRecordSets(ctx, input)
+	var changeID string
+	if err == nil {
+		changeID = aws.ToString(output.ChangeInfo.Id)
+	}
+	writeAudit(ctx, appConfig, err, auditChanges(aws.ToString(input.HostedZoneId), changeID, input.ChangeBatch.Changes)...)
+	if err != nil || !appConfig.WaitForInsync {
+		return err
+	}
 
-func getPublicIP() (string, error) {
-	resp, err := http.Get("https://checkip.amazonaws.com/")
+	span.SetAttributes(attribute.String("change_id", changeID))
+	logger.Info("Waiting for Route53 change to reach INSYNC", "zone_id", aws.ToString(input.HostedZoneId), "change_id", changeID, "timeout", appConfig.InsyncTimeout)
+	waitCtx, waitSpan := tracer.Start(ctx, "route53.wait_insync")
+	waiter := route53.NewResourceRecordSetsChangedWaiter(client)
+	err = waiter.Wait(waitCtx, &route53.GetChangeInput{Id: output.ChangeInfo.Id}, appConfig.InsyncTimeout)
+	endSpan(waitSpan, err)
 	if err != nil {
-		return "", fmt.Errorf("failed to get public IP: %w", err)
+		return fmt.Errorf("change %s was accepted but did not reach INSYNC: %w", changeID, err)
+	}
+	logger.Info("Route53 change is INSYNC", "change_id", changeID)
+	return nil
+}
+
+// recordUpdate is one record set to UPSERT.
+type recordUpdate struct {
+	Record        RecordConfig
+	Type          r53types.RRType
+	Values        []string
+	HealthCheckID string
+	// Replaces, when set, is the live record set this update was computed
+	// from. It is deleted and the new set created in the same batch, which
+	// Route53 rejects if the set changed in the meantime.
+	Replaces *r53types.ResourceRecordSet
+	// Delete removes Replaces without creating anything in its place.
+	Delete bool
+}
+
+// maxRecordsPerBatch keeps change batches well inside Route53's limit of 1000
+// changes and values per request, leaving room for ownership records and
+// record sets with several values.
+const maxRecordsPerBatch = 200
+
+// updateRoute53Records UPSERTs the given record sets, sending one change batch
+// per hosted zone so that the records of a zone change together and large
+// configurations need few API calls. It returns one error per update, nil for
+// those that went through. A batch that Route53 rejects fails every record in
+// it.
+func updateRoute53Records(ctx context.Context, appConfig *AppConfig, client *route53.Client, updates []recordUpdate) []error {
+	logger := loggerFrom(ctx)
+	errs := make([]error, len(updates))
+	var zones []string
+	byZone := map[string][]int{}
+	for i, update := range updates {
+		record := update.Record
+		action := r53types.ChangeActionUpsert
+		if update.Delete {
+			action = r53types.ChangeActionDelete
+		}
+		logger.Info("Attempting to "+string(action)+" record", "record", record.RecordName, "type", update.Type, "zone_id", record.ZoneID)
+		recordUpdateAttempts.WithLabelValues(record.RecordName, string(update.Type)).Inc()
+		// Deletions are only made of sets the caller has checked are ours.
+		if appConfig.OwnerID != "" && !update.Delete {
+			if err := checkOwnership(ctx, appConfig, route53For(client, record), record, update.Type); err != nil {
+				recordUpdateFailures.WithLabelValues(record.RecordName, string(update.Type)).Inc()
+				putCloudWatchMetric(appConfig, "RecordUpdateFailures", cwtypes.StandardUnitCount, 1, "Record", record.RecordName, "Type", string(update.Type))
+				errs[i] = err
+				continue
+			}
+		}
+		if _, ok := byZone[record.ZoneID]; !ok {
+			zones = append(zones, record.ZoneID)
+		}
+		byZone[record.ZoneID] = append(byZone[record.ZoneID], i)
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return "", fmt.Errorf("bad status from IP service: %s", resp.Status)
+
+	for _, zoneID := range zones {
+		for _, batch := range splitBatches(updates, byZone[zoneID]) {
+			// A zone belongs to one account, so every record in the batch
+			// uses the same client.
+			start := time.Now()
+			err := sendRecordBatch(ctx, appConfig, route53For(client, updates[batch[0]].Record), zoneID, updates, batch)
+			if err == nil {
+				putCloudWatchMetric(appConfig, "RecordUpdateLatency", cwtypes.StandardUnitMilliseconds, float64(time.Since(start).Milliseconds()), "ZoneId", zoneID)
+			}
+			for _, i := range batch {
+				record, recordType := updates[i].Record, updates[i].Type
+				if err != nil {
+					recordUpdateFailures.WithLabelValues(record.RecordName, string(recordType)).Inc()
+					putCloudWatchMetric(appConfig, "RecordUpdateFailures", cwtypes.StandardUnitCount, 1, "Record", record.RecordName, "Type", string(recordType))
+					errs[i] = fmt.Errorf("failed to update Route53 record %s: %w", record.RecordName, err)
+					continue
+				}
+				logger.Info("Successfully sent update request", "record", record.RecordName, "type", recordType, "zone_id", zoneID)
+			}
+		}
 	}
-	ipBytes, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", fmt.Errorf("failed to read response body: %w", err)
+	return errs
+}
+
+// splitBatches divides the updates of one zone into batches of at most
+// maxRecordsPerBatch. A record set that appears twice goes into a later
+// batch, since Route53 rejects a batch that changes the same set twice.
+func splitBatches(updates []recordUpdate, indices []int) [][]int {
+	var batches [][]int
+	var current []int
+	seen := map[string]bool{}
+	for _, i := range indices {
+		key := updates[i].Record.RecordName + "|" + string(updates[i].Type) + "|" + updates[i].Record.SetIdentifier
+		if len(current) == maxRecordsPerBatch || seen[key] {
+			batches = append(batches, current)
+			current, seen = nil, map[string]bool{}
+		}
+		current = append(current, i)
+		seen[key] = true
+	}
+	if len(current) > 0 {
+		batches = append(batches, current)
 	}
-	return strings.TrimSpace(string(ipBytes)), nil
+	return batches
 }
 
-func updateRoute53Record(ctx context.Context, client *route53.Client, zoneID, recordName, value string) error {
-	log.Printf("Attempting to UPSERT A record for %s in Zone ID %s...", recordName, zoneID)
-	input := &route53.ChangeResourceRecordSetsInput{
+func sendRecordBatch(ctx context.Context, appConfig *AppConfig, client *route53.Client, zoneID string, updates []recordUpdate, batch []int) error {
+	comment := fmt.Sprintf("Automatic DNS update for %s", updates[batch[0]].Record.RecordName)
+	if len(batch) > 1 {
+		comment = fmt.Sprintf("Automatic DNS update for %d records", len(batch))
+	}
+	if cycleID := cycleIDFrom(ctx); cycleID != "" {
+		comment += fmt.Sprintf(" (cycle %s)", cycleID)
+	}
+
+	return applyChangeBatch(ctx, appConfig, client, &route53.ChangeResourceRecordSetsInput{
 		HostedZoneId: aws.String(zoneID),
 		ChangeBatch: &r53types.ChangeBatch{
-			Comment: aws.String(fmt.Sprintf("Automatic DNS update for %s", recordName)),
-			Changes: []r53types.Change{
-				{
-					Action: r53types.ChangeActionUpsert,
-					ResourceRecordSet: &r53types.ResourceRecordSet{
-						Name: aws.String(recordName),
-						Type: r53types.RRTypeA,
-						TTL:  aws.Int64(300),
-						ResourceRecords: []r53types.ResourceRecord{
-							{Value: aws.String(value)},
-						},
-					},
-				},
-			},
+			Comment: aws.String(comment),
+			Changes: batchChanges(appConfig, updates, batch),
 		},
+	})
+}
+
+// batchChanges builds the changes of one batch. Each record set is built from
+// its own update, so records that share a batch keep their own TTL, type and
+// routing settings.
+func batchChanges(appConfig *AppConfig, updates []recordUpdate, batch []int) []r53types.Change {
+	var changes []r53types.Change
+	owned := map[string]bool{}
+	for _, i := range batch {
+		update := updates[i]
+		if update.Delete {
+			changes = append(changes, r53types.Change{Action: r53types.ChangeActionDelete, ResourceRecordSet: update.Replaces})
+			continue
+		}
+		resourceRecords := make([]r53types.ResourceRecord, 0, len(update.Values))
+		for _, value := range update.Values {
+			resourceRecords = append(resourceRecords, r53types.ResourceRecord{Value: aws.String(value)})
+		}
+		recordSet := &r53types.ResourceRecordSet{
+			Name:            aws.String(update.Record.RecordName),
+			Type:            update.Type,
+			TTL:             aws.Int64(update.Record.TTL),
+			ResourceRecords: resourceRecords,
+		}
+		if alias := update.Record.Alias; alias != nil {
+			// Alias records take the TTL of their target.
+			recordSet.TTL, recordSet.ResourceRecords = nil, nil
+			recordSet.AliasTarget = &r53types.AliasTarget{
+				DNSName:              aws.String(alias.DNSName),
+				HostedZoneId:         aws.String(alias.HostedZoneID),
+				EvaluateTargetHealth: alias.EvaluateTargetHealth,
+			}
+		}
+		applyRoutingPolicy(recordSet, update.Record)
+		if update.HealthCheckID != "" {
+			recordSet.HealthCheckId = aws.String(update.HealthCheckID)
+		}
+		if update.Replaces != nil {
+			changes = append(changes,
+				r53types.Change{Action: r53types.ChangeActionDelete, ResourceRecordSet: update.Replaces},
+				r53types.Change{Action: r53types.ChangeActionCreate, ResourceRecordSet: recordSet})
+		} else {
+			changes = append(changes, r53types.Change{Action: r53types.ChangeActionUpsert, ResourceRecordSet: recordSet})
+		}
+		ownerKey := update.Record.RecordName + "|" + update.Record.SetIdentifier
+		if appConfig.OwnerID != "" && !owned[ownerKey] {
+			owned[ownerKey] = true
+			changes = append(changes, ownershipChange(appConfig, update.Record))
+		}
 	}
-	_, err := client.ChangeResourceRecordSets(ctx, input)
+	return changes
+}
+
+// liveRecordSet returns the record set name/recordType with the given set
+// identifier ("" for a simple record) as Route53 currently holds it, or nil
+// if there is none.
+func liveRecordSet(ctx context.Context, client *route53.Client, zoneID, name string, recordType r53types.RRType, setIdentifier string) (*r53types.ResourceRecordSet, error) {
+	input := &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(name),
+		StartRecordType: recordType,
+		MaxItems:        aws.Int32(1),
+	}
+	if setIdentifier != "" {
+		input.StartRecordIdentifier = aws.String(setIdentifier)
+	}
+	output, err := client.ListResourceRecordSets(ctx, input)
 	if err != nil {
-		return fmt.Errorf("failed to update Route53 record %s: %w", recordName, err)
+		return nil, fmt.Errorf("failed to read %s %s: %w", recordType, name, err)
+	}
+	if len(output.ResourceRecordSets) == 0 {
+		return nil, nil
+	}
+	set := output.ResourceRecordSets[0]
+	if canonicalName(aws.ToString(set.Name)) != canonicalName(name) || set.Type != recordType || aws.ToString(set.SetIdentifier) != setIdentifier {
+		return nil, nil
+	}
+	return &set, nil
+}
+
+// liveRecordValues is liveRecordSet for callers that only need the values.
+// Alias targets are returned as described by describeAlias.
+func liveRecordValues(ctx context.Context, client *route53.Client, zoneID, name string, recordType r53types.RRType, setIdentifier string) ([]string, error) {
+	set, err := liveRecordSet(ctx, client, zoneID, name, recordType, setIdentifier)
+	if set == nil {
+		return nil, err
+	}
+	return recordSetValues(set), nil
+}
+
+// recordSetValues returns the values of a record set, or the description of
+// its alias target for an alias record.
+func recordSetValues(set *r53types.ResourceRecordSet) []string {
+	if target := set.AliasTarget; target != nil {
+		return []string{describeAlias(aws.ToString(target.DNSName), aws.ToString(target.HostedZoneId), target.EvaluateTargetHealth)}
+	}
+	values := make([]string, 0, len(set.ResourceRecords))
+	for _, rr := range set.ResourceRecords {
+		values = append(values, aws.ToString(rr.Value))
+	}
+	return values
+}
+
+// hostedZoneCacheTTL is how long a zone lookup is reused. Zones are rarely
+// recreated, but when one is its ID changes, so entries do not live forever.
+const hostedZoneCacheTTL = time.Hour
+
+type hostedZoneCacheEntry struct {
+	zoneID  string
+	expires time.Time
+}
+
+// hostedZoneCache maps canonical names to the hosted zone found for them.
+var hostedZoneCache sync.Map
+
+// findHostedZoneID returns the public hosted zone whose name is the longest
+// suffix of name, or "" if no such zone exists in the account. Zones that
+// were found are cached for hostedZoneCacheTTL.
+func findHostedZoneID(ctx context.Context, client *route53.Client, name string) (string, error) {
+	name = canonicalName(name)
+	if cached, ok := hostedZoneCache.Load(name); ok {
+		entry := cached.(hostedZoneCacheEntry)
+		if time.Now().Before(entry.expires) {
+			return entry.zoneID, nil
+		}
+	}
+
+	labels := strings.Split(name, ".")
+	for i := range labels {
+		candidate := strings.Join(labels[i:], ".") + "."
+		output, err := client.ListHostedZonesByName(ctx, &route53.ListHostedZonesByNameInput{
+			DNSName:  aws.String(candidate),
+			MaxItems: aws.Int32(10),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to list hosted zones for %s: %w", candidate, err)
+		}
+		for _, zone := range output.HostedZones {
+			if aws.ToString(zone.Name) != candidate {
+				break
+			}
+			if zone.Config != nil && zone.Config.PrivateZone {
+				continue
+			}
+			zoneID := strings.TrimPrefix(aws.ToString(zone.Id), "/hostedzone/")
+			hostedZoneCache.Store(name, hostedZoneCacheEntry{zoneID: zoneID, expires: time.Now().Add(hostedZoneCacheTTL)})
+			return zoneID, nil
+		}
+	}
+	return "", nil
+}
+
+// resolveRecordZones fills in the zone_id of records that left it out, using
+// the longest-suffix hosted zone match for the record name.
+func resolveRecordZones(ctx context.Context, client *route53.Client, records []RecordConfig) error {
+	for i := range records {
+		record := &records[i]
+		if record.ZoneID != "" {
+			continue
+		}
+		zoneID, err := findHostedZoneID(ctx, route53For(client, *record), record.RecordName)
+		if err != nil {
+			return fmt.Errorf("could not discover the hosted zone of %s: %w", record.RecordName, err)
+		}
+		if zoneID == "" {
+			return fmt.Errorf("no public hosted zone in this account matches %s; set zone_id explicitly", record.RecordName)
+		}
+		slog.Info("Discovered hosted zone", "component", "ddns", "record", record.RecordName, "zone_id", zoneID)
+		record.ZoneID = zoneID
 	}
-	log.Printf("Successfully sent update request for %s.", recordName)
 	return nil
 }
 
@@ -121,33 +673,38 @@ type NpmClient struct {
 	authToken string
 }
 
-func NewNpmClient(baseURL, identity, secret string) (*NpmClient, error) {
+func NewNpmClient(ctx context.Context, baseURL, identity, secret string, rootCAs *x509.CertPool) (*NpmClient, error) {
 	npm := &NpmClient{
-		client: resty.New().SetBaseURL(baseURL).SetDisableWarn(true),
+		client: resty.New().SetBaseURL(baseURL).SetDisableWarn(true).SetTLSClientConfig(clientTLSConfig(rootCAs)),
 	}
 	authPayload := map[string]string{"identity": identity, "secret": secret}
 	var authResponse NpmAuthResponse
 
 	for i := 0; i < 5; i++ {
 		resp, err := npm.client.R().
+			SetContext(ctx).
 			SetHeader("Content-Type", "application/json").
 			SetBody(authPayload).
 			SetResult(&authResponse).
 			Post("/api/tokens")
 		if err == nil && resp.IsSuccess() {
 			npm.authToken = authResponse.Token
-			log.Println("NPM: Successfully authenticated with Nginx Proxy Manager.")
+			slog.Info("Successfully authenticated with Nginx Proxy Manager", "component", "npm")
 			return npm, nil
 		}
-		log.Printf("NPM: Authentication failed (attempt %d/5), retrying in 15 seconds... Status: %s", i+1, resp.Status())
-		time.Sleep(15 * time.Second)
+		slog.Warn("Authentication failed, retrying in 15 seconds", "component", "npm", "attempt", i+1, "max_attempts", 5, "status", resp.Status())
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(15 * time.Second):
+		}
 	}
 	return nil, fmt.Errorf("could not authenticate with Nginx Proxy Manager after several retries")
 }
 
-func (npm *NpmClient) findExistingProxyHost(domainName string) (*NpmProxyHost, error) {
+func (npm *NpmClient) findExistingProxyHost(ctx context.Context, domainName string) (*NpmProxyHost, error) {
 	var hosts []NpmProxyHost
-	resp, err := npm.client.R().SetAuthToken(npm.authToken).SetResult(&hosts).Get("/api/nginx/proxy-hosts")
+	resp, err := npm.client.R().SetContext(ctx).SetAuthToken(npm.authToken).SetResult(&hosts).Get("/api/nginx/proxy-hosts")
 	if err != nil {
 		return nil, fmt.Errorf("failed to list proxy hosts: %w", err)
 	}
@@ -156,8 +713,8 @@ func (npm *NpmClient) findExistingProxyHost(domainName string) (*NpmProxyHost, e
 	}
 	for i := range hosts {
 		for _, dn := range hosts[i].DomainNames {
-			if dn == domainName {
-				log.Printf("NPM [%s]: Found existing proxy host with ID %d.", domainName, hosts[i].ID)
+			if canonicalName(dn) == canonicalName(domainName) {
+				slog.Info("Found existing proxy host", "component", "npm", "record", domainName, "proxy_host_id", hosts[i].ID)
 				return &hosts[i], nil
 			}
 		}
@@ -165,8 +722,8 @@ func (npm *NpmClient) findExistingProxyHost(domainName string) (*NpmProxyHost, e
 	return nil, nil // Not found
 }
 
-func (npm *NpmClient) createProxyHost(record RecordConfig, forwardHost string) error {
-	log.Printf("NPM [%s]: Creating new proxy host pointing to %s:%d.", record.RecordName, forwardHost, record.Port)
+func (npm *NpmClient) createProxyHost(ctx context.Context, record RecordConfig, forwardHost string) error {
+	slog.Info("Creating new proxy host", "component", "npm", "record", record.RecordName, "forward_host", forwardHost, "forward_port", record.Port)
 
 	payload := map[string]interface{}{
 		"domain_names":            []string{record.RecordName},
@@ -182,7 +739,7 @@ func (npm *NpmClient) createProxyHost(record RecordConfig, forwardHost string) e
 
 	// If TLS is requested, tell NPM to fetch a new Let's Encrypt certificate.
 	if record.TLS {
-		log.Printf("NPM [%s]: Requesting a new Let's Encrypt certificate.", record.RecordName)
+		slog.Info("Requesting a new Let's Encrypt certificate", "component", "npm", "record", record.RecordName)
 		payload["certificate_id"] = "new"
 		payload["hsts_enabled"] = true
 		payload["hsts_subdomains"] = true
@@ -190,6 +747,7 @@ func (npm *NpmClient) createProxyHost(record RecordConfig, forwardHost string) e
 	}
 
 	resp, err := npm.client.R().
+		SetContext(ctx).
 		SetAuthToken(npm.authToken).
 		SetBody(payload).
 		Post("/api/nginx/proxy-hosts")
@@ -201,139 +759,1294 @@ func (npm *NpmClient) createProxyHost(record RecordConfig, forwardHost string) e
 		return fmt.Errorf("failed to create proxy host, status: %s, body: %s", resp.Status(), resp.String())
 	}
 
-	log.Printf("NPM [%s]: Successfully created proxy host.", record.RecordName)
+	slog.Info("Successfully created proxy host", "component", "npm", "record", record.RecordName)
 	return nil
 }
 
-func manageNginxProxy(record RecordConfig, npmClient *NpmClient, forwardHost string) {
-	log.Printf("NPM [%s]: Starting proxy management.", record.RecordName)
-	existingHost, err := npmClient.findExistingProxyHost(record.RecordName)
+func manageNginxProxy(ctx context.Context, appConfig *AppConfig, record RecordConfig, npmClient *NpmClient, forwardHost string) error {
+	slog.Info("Starting proxy management", "component", "npm", "record", record.RecordName)
+	existingHost, err := npmClient.findExistingProxyHost(ctx, record.RecordName)
 	if err != nil {
-		log.Printf("NPM [%s] ERROR: %v", record.RecordName, err)
-		return
+		return err
+	}
+	if existingHost != nil {
+		slog.Info("Proxy host already exists, skipping creation", "component", "npm", "record", record.RecordName)
+		return nil
+	}
+	if appConfig.DryRun {
+		slog.Info("DRY RUN: Would create proxy host", "component", "npm", "record", record.RecordName, "forward_host", forwardHost, "forward_port", record.Port)
+		return nil
+	}
+	return npmClient.createProxyHost(ctx, record, forwardHost)
+}
+
+// --- ACM Certificate Functions ---
+
+func certStateFile(domainName string) string {
+	safeName := strings.ReplaceAll(canonicalName(domainName), "*", "wildcard")
+	return fmt.Sprintf(certStateFilePattern, safeName)
+}
+
+// certPendingFile holds the ARN of a requested certificate that has not
+// finished validation yet.
+func certPendingFile(domainName string) string {
+	safeName := strings.ReplaceAll(canonicalName(domainName), "*", "wildcard")
+	return fmt.Sprintf(certPendingFilePattern, safeName)
+}
+
+// maxCertificateNames is the most names ACM allows on one certificate. The
+// default quota is lower and can be raised on request.
+const maxCertificateNames = 100
+
+// certKeyAlgorithms are the key types ACM can issue public certificates
+// with.
+var certKeyAlgorithms = []acmtypes.KeyAlgorithm{
+	acmtypes.KeyAlgorithmRsa2048,
+	acmtypes.KeyAlgorithmEcPrime256v1,
+	acmtypes.KeyAlgorithmEcSecp384r1,
+}
+
+// normalizeKeyAlgorithm validates the record's key_algorithm, accepting it
+// in any case.
+func normalizeKeyAlgorithm(record *RecordConfig) error {
+	record.KeyAlgorithm = strings.TrimSpace(record.KeyAlgorithm)
+	if record.KeyAlgorithm == "" {
+		return nil
+	}
+	if !record.TLS {
+		return fmt.Errorf("record %s has a key_algorithm but tls is not enabled", record.RecordName)
+	}
+	for _, algorithm := range certKeyAlgorithms {
+		if strings.EqualFold(record.KeyAlgorithm, string(algorithm)) {
+			record.KeyAlgorithm = string(algorithm)
+			return nil
+		}
+	}
+	return fmt.Errorf("record %s has an invalid key_algorithm %q, expected one of %v", record.RecordName, record.KeyAlgorithm, certKeyAlgorithms)
+}
+
+// certKeyAlgorithm returns the key type of the record's certificate.
+func certKeyAlgorithm(record RecordConfig) acmtypes.KeyAlgorithm {
+	if record.KeyAlgorithm == "" {
+		return acmtypes.KeyAlgorithmRsa2048
+	}
+	return acmtypes.KeyAlgorithm(record.KeyAlgorithm)
+}
+
+// normalizeCertificateNames validates the record's subject alternative
+// names, adding the wildcard name if asked for, dropping duplicates and the
+// record name itself and sorting the rest, so that reordering them does not
+// request a new certificate.
+func normalizeCertificateNames(record *RecordConfig) error {
+	if record.Wildcard {
+		if !record.TLS {
+			return fmt.Errorf("record %s has wildcard set but tls is not enabled", record.RecordName)
+		}
+		if strings.HasPrefix(record.RecordName, "*.") {
+			return fmt.Errorf("record %s is already a wildcard name and cannot set wildcard", record.RecordName)
+		}
+		record.SubjectAlternativeNames = append(record.SubjectAlternativeNames, "*."+record.RecordName)
+	}
+	if len(record.SubjectAlternativeNames) == 0 {
+		return nil
+	}
+	if !record.TLS {
+		return fmt.Errorf("record %s has subject_alternative_names but tls is not enabled", record.RecordName)
+	}
+	seen := map[string]bool{record.RecordName: true}
+	var names []string
+	for _, name := range record.SubjectAlternativeNames {
+		name = canonicalName(name)
+		if name == "" || strings.ContainsAny(name, " \t\r\n") || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			return fmt.Errorf("record %s has an invalid subject alternative name %q", record.RecordName, name)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	if len(names)+1 > maxCertificateNames {
+		return fmt.Errorf("record %s has %d certificate names, ACM allows at most %d", record.RecordName, len(names)+1, maxCertificateNames)
+	}
+	record.SubjectAlternativeNames = names
+	return nil
+}
+
+// certificateNames returns the names the record's certificate covers: the
+// record name first, then its subject alternative names.
+func certificateNames(record RecordConfig) []string {
+	return append([]string{record.RecordName}, record.SubjectAlternativeNames...)
+}
+
+// certStateKey names the record's certificate state files. A certificate
+// with subject alternative names or a key algorithm gets files of its own,
+// so that changing them requests a new certificate instead of keeping the
+// stored one.
+func certStateKey(record RecordConfig) string {
+	if len(record.SubjectAlternativeNames) == 0 && record.KeyAlgorithm == "" {
+		return record.RecordName
+	}
+	sum := certRequestHash(record)
+	return record.RecordName + "_" + hex.EncodeToString(sum[:4])
+}
+
+// certRequestHash identifies the certificate a record asks for by its names
+// and key algorithm.
+func certRequestHash(record RecordConfig) [sha256.Size]byte {
+	key := strings.Join(certificateNames(record), ",")
+	if record.KeyAlgorithm != "" {
+		key += "|" + record.KeyAlgorithm
+	}
+	return sha256.Sum256([]byte(key))
+}
+
+// certIdempotencyToken is the RequestCertificate idempotency token for the
+// record. ACM returns the certificate of an earlier request with the same
+// token made within the last hour instead of issuing another, so a crash
+// loop or two instances racing cannot create duplicates. A request that
+// replaces a failed certificate mixes in its ARN, so that it is not handed
+// the failed certificate back.
+func certIdempotencyToken(record RecordConfig, replaces string) string {
+	sum := certRequestHash(record)
+	if replaces != "" {
+		sum = sha256.Sum256(append(sum[:], replaces...))
+	}
+	return hex.EncodeToString(sum[:16])
+}
+
+// certificateFailed reports whether a certificate in status can never become
+// valid, or be valid again, so that a new one has to be requested. ACM
+// does not renew a certificate once it has expired.
+func certificateFailed(status acmtypes.CertificateStatus) bool {
+	switch status {
+	case acmtypes.CertificateStatusValidationTimedOut, acmtypes.CertificateStatusFailed, acmtypes.CertificateStatusRevoked,
+		acmtypes.CertificateStatusExpired, acmtypes.CertificateStatusInactive:
+		return true
+	}
+	return false
+}
+
+// findExistingCertificate returns a certificate in the given status for the
+// record which covers all of its names, directly or with a wildcard, and has
+// its key algorithm, or "" if there is none. A certificate for exactly the
+// record's names is preferred. Certificates that expire within
+// CERT_EXPIRY_WARNING and are not eligible for managed renewal, such as
+// imported ones, are passed over.
+func findExistingCertificate(ctx context.Context, appConfig *AppConfig, client *acm.Client, record RecordConfig, status acmtypes.CertificateStatus) (string, error) {
+	matches, err := listRecordCertificates(ctx, client, record, []acmtypes.CertificateStatus{status})
+	if err != nil {
+		return "", err
+	}
+	names := certificateNames(record)
+	var found string
+	for _, match := range matches {
+		cert := match.Summary
+		if cert.NotAfter != nil && time.Until(*cert.NotAfter) < appConfig.CertExpiryWarning && cert.RenewalEligibility != acmtypes.RenewalEligibilityEligible {
+			slog.Info("Not reusing certificate that expires soon and cannot be renewed", "component", "acm", "domain", record.RecordName, "arn", aws.ToString(cert.CertificateArn), "expires", cert.NotAfter.Format(time.RFC3339), "renewal_eligibility", cert.RenewalEligibility)
+			continue
+		}
+		if sameNames(match.Names, names) {
+			return aws.ToString(cert.CertificateArn), nil
+		}
+		if found == "" {
+			found = aws.ToString(cert.CertificateArn)
+		}
+	}
+	return found, nil
+}
+
+// certificateMatch is a certificate found for a record, along with all of
+// the names it covers.
+type certificateMatch struct {
+	Summary acmtypes.CertificateSummary
+	Names   []string
+}
+
+// listRecordCertificates returns the certificates in one of statuses that
+// findExistingCertificate would accept for the record, in the order ACM lists
+// them.
+func listRecordCertificates(ctx context.Context, client *acm.Client, record RecordConfig, statuses []acmtypes.CertificateStatus) ([]certificateMatch, error) {
+	names := certificateNames(record)
+	// Without a key type filter, ACM only lists RSA_2048 certificates.
+	paginator := acm.NewListCertificatesPaginator(client, &acm.ListCertificatesInput{
+		CertificateStatuses: statuses,
+		Includes:            &acmtypes.Filters{KeyTypes: []acmtypes.KeyAlgorithm{certKeyAlgorithm(record)}},
+	})
+	var matches []certificateMatch
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list ACM certificates: %w", err)
+		}
+		for _, cert := range page.CertificateSummaryList {
+			covered := cert.SubjectAlternativeNameSummaries
+			// The summary lists only the first names of a certificate
+			// with many.
+			if aws.ToBool(cert.HasAdditionalSubjectAlternativeNames) {
+				output, err := client.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: cert.CertificateArn})
+				if err != nil {
+					return nil, fmt.Errorf("failed to describe certificate %s: %w", aws.ToString(cert.CertificateArn), err)
+				}
+				covered = output.Certificate.SubjectAlternativeNames
+			}
+			if coversNames(covered, names) {
+				matches = append(matches, certificateMatch{Summary: cert, Names: covered})
+			}
+		}
+	}
+	return matches, nil
+}
+
+// coversNames reports whether every one of names is in certNames or matched
+// by a wildcard in certNames. A wildcard matches a single label, so
+// *.example.com covers www.example.com but neither example.com nor
+// a.b.example.com.
+func coversNames(certNames, names []string) bool {
+	have := map[string]bool{}
+	for _, name := range certNames {
+		have[canonicalName(name)] = true
+	}
+	for _, name := range names {
+		name = canonicalName(name)
+		if have[name] {
+			continue
+		}
+		_, parent, ok := strings.Cut(name, ".")
+		if !ok || strings.HasPrefix(name, "*.") || !have["*."+parent] {
+			return false
+		}
+	}
+	return true
+}
+
+// sameNames reports whether two lists hold the same names, in any order.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	have := map[string]bool{}
+	for _, name := range a {
+		have[canonicalName(name)] = true
+	}
+	for _, name := range b {
+		if !have[canonicalName(name)] {
+			return false
+		}
+	}
+	return true
+}
+
+// requestCertificate requests a certificate for the record name, with the
+// record's other certificate names as subject alternative names, tagged as
+// certificateTags describes. replaces is the ARN of a failed certificate the
+// new one takes the place of, if any.
+func requestCertificate(ctx context.Context, appConfig *AppConfig, client *acm.Client, record RecordConfig, replaces string) (string, error) {
+	names := certificateNames(record)
+	tags, err := certificateTags(appConfig, record)
+	if err != nil {
+		return "", err
+	}
+	input := &acm.RequestCertificateInput{
+		DomainName:       aws.String(names[0]),
+		ValidationMethod: acmtypes.ValidationMethodDns,
+		KeyAlgorithm:     certKeyAlgorithm(record),
+		Tags:             tags,
+		IdempotencyToken: aws.String(certIdempotencyToken(record, replaces)),
+	}
+	if len(names) > 1 {
+		input.SubjectAlternativeNames = names[1:]
+	}
+	output, err := client.RequestCertificate(ctx, input)
+	entry := auditEntry{Action: auditACMRequest, Record: names[0], New: names}
+	if err != nil {
+		writeAudit(ctx, appConfig, err, entry)
+		return "", fmt.Errorf("failed to request certificate for %s: %w", names[0], err)
+	}
+	entry.ARN = aws.ToString(output.CertificateArn)
+	writeAudit(ctx, appConfig, nil, entry)
+	certRequests.WithLabelValues(names[0]).Inc()
+	return entry.ARN, nil
+}
+
+// getValidationRecords polls ACM until the DNS validation records for every
+// name on the certificate have been generated. ACM populates them
+// asynchronously after the certificate is requested. Names that share a
+// validation record, such as a domain and its wildcard, appear once.
+func getValidationRecords(ctx context.Context, client *acm.Client, certArn string, pollInterval time.Duration) ([]*acmtypes.ResourceRecord, error) {
+	for i := 0; i < 10; i++ {
+		output, err := client.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(certArn)})
+		if err != nil {
+			return nil, fmt.Errorf("failed to describe certificate %s: %w", certArn, err)
+		}
+		options := output.Certificate.DomainValidationOptions
+		var records []*acmtypes.ResourceRecord
+		seen := map[string]bool{}
+		for _, option := range options {
+			if option.ResourceRecord == nil {
+				records = nil
+				break
+			}
+			if name := canonicalName(aws.ToString(option.ResourceRecord.Name)); !seen[name] {
+				seen[name] = true
+				records = append(records, option.ResourceRecord)
+			}
+		}
+		if len(records) > 0 {
+			return records, nil
+		}
+		slog.Info("Validation records not ready yet, retrying", "component", "acm", "arn", certArn, "retry_in", pollInterval)
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+	return nil, fmt.Errorf("validation records for %s were not generated in time", certArn)
+}
+
+func upsertValidationRecord(ctx context.Context, appConfig *AppConfig, client *route53.Client, zoneID string, record *acmtypes.ResourceRecord) error {
+	input := &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &r53types.ChangeBatch{
+			Comment: aws.String("ACM certificate DNS validation"),
+			Changes: []r53types.Change{
+				{
+					Action: r53types.ChangeActionUpsert,
+					ResourceRecordSet: &r53types.ResourceRecordSet{
+						Name: record.Name,
+						Type: r53types.RRType(record.Type),
+						TTL:  aws.Int64(300),
+						ResourceRecords: []r53types.ResourceRecord{
+							{Value: record.Value},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := applyChangeBatch(ctx, appConfig, client, input); err != nil {
+		return fmt.Errorf("failed to create validation record %s: %w", aws.ToString(record.Name), err)
+	}
+	return nil
+}
+
+// resolveValidationZone picks the hosted zone for a validation record: the
+// record's explicit override, else the longest-suffix zone match, else the
+// record's own zone.
+func resolveValidationZone(ctx context.Context, r53Client *route53.Client, record RecordConfig, validationName string) string {
+	if record.ValidationZoneID != "" {
+		return record.ValidationZoneID
+	}
+	zoneID, err := findHostedZoneID(ctx, r53Client, validationName)
+	if err != nil {
+		slog.Warn("Could not look up the hosted zone for the validation record, using the record's zone", "component", "acm", "domain", record.RecordName, "validation_record", validationName, "zone_id", record.ZoneID, "error", err)
+		return record.ZoneID
+	}
+	if zoneID == "" {
+		return record.ZoneID
+	}
+	return zoneID
+}
+
+func getCallerAccountID(ctx context.Context, client *sts.Client) (string, error) {
+	output, err := client.GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return "", fmt.Errorf("failed to get caller identity: %w", err)
+	}
+	return aws.ToString(output.Account), nil
+}
+
+// arnBelongsToAccount reports whether certArn was issued in accountID. It
+// returns true when the account is unknown so that a failed STS lookup does
+// not discard valid state.
+func arnBelongsToAccount(certArn, accountID string) bool {
+	if accountID == "" {
+		return true
+	}
+	parsed, err := arn.Parse(certArn)
+	if err != nil {
+		return false
+	}
+	return parsed.AccountID == accountID
+}
+
+// loadCertArn reads an ARN from a certificate state file, discarding it if it
+// belongs to a different AWS account than the current credentials, as happens
+// after switching accounts.
+func loadCertArn(appConfig *AppConfig, filename, domainName string) string {
+	var certArn string
+	// The ARN is checked and cleared under the key's lock, so that an ARN
+	// stored in the meantime is not cleared with it.
+	err := updateStoredString(filename, func(stored string) (string, error) {
+		if stored == "" || arnBelongsToAccount(stored, appConfig.AWSAccountID) {
+			certArn = stored
+			return stored, nil
+		}
+		slog.Warn("Stored ARN is not in the current account, discarding it", "component", "acm", "domain", domainName, "arn", stored, "account_id", appConfig.AWSAccountID)
+		if appConfig.DryRun {
+			return stored, nil
+		}
+		return "", nil
+	})
+	if err != nil {
+		slog.Error("Failed to check the stored certificate ARN", "component", "acm", "domain", domainName, "error", err)
 	}
-	if existingHost == nil {
-		err := npmClient.createProxyHost(record, forwardHost)
+	return certArn
+}
+
+func manageCertificateLifecycle(ctx context.Context, appConfig *AppConfig, acmClient *acm.Client, r53Client *route53.Client, record RecordConfig) error {
+	domainName := record.RecordName
+	names := certificateNames(record)
+	stateFile := certStateFile(certStateKey(record))
+
+	// failedArn is a certificate that can no longer become valid, which a
+	// new request replaces.
+	var failedArn string
+	storedArn := loadCertArn(appConfig, stateFile, domainName)
+	if storedArn != "" {
+		cert, err := describeStoredCertificate(ctx, appConfig, acmClient, domainName, storedArn)
 		if err != nil {
-			log.Printf("NPM [%s] ERROR: %v", record.RecordName, err)
+			return err
+		}
+		if !certificateFailed(cert.Status) {
+			if cert.Status == acmtypes.CertificateStatusIssued && !appConfig.CertDropValidation {
+				if err := ensureValidationRecords(ctx, appConfig, r53Client, record, cert); err != nil {
+					return err
+				}
+			}
+			slog.Info("Certificate already issued, skipping", "component", "acm", "domain", domainName, "arn", storedArn)
+			return nil
 		}
+		slog.Warn("Stored certificate has failed, requesting a new one", "component", "acm", "domain", domainName, "arn", storedArn, "cert_status", cert.Status)
+		failedArn = storedArn
+		if !appConfig.DryRun {
+			if err := clearStoredString(stateFile); err != nil {
+				return fmt.Errorf("failed to clear failed certificate ARN: %w", err)
+			}
+		}
+	}
+
+	existingArn, err := findExistingCertificate(ctx, appConfig, acmClient, record, acmtypes.CertificateStatusIssued)
+	if err != nil {
+		return err
+	}
+	if existingArn != "" {
+		slog.Info("Found existing issued certificate, storing ARN", "component", "acm", "domain", domainName, "arn", existingArn)
+		if appConfig.DryRun {
+			slog.Info("DRY RUN: Would store certificate ARN", "component", "acm", "domain", domainName, "arn", existingArn)
+			return nil
+		}
+		if err := storeString(stateFile, existingArn); err != nil {
+			return fmt.Errorf("failed to store certificate ARN: %w", err)
+		}
+		return nil
+	}
+
+	// A certificate requested by an earlier run that was interrupted before
+	// validation finished is resumed instead of requesting another one.
+	pendingFile := certPendingFile(certStateKey(record))
+	certArn := loadCertArn(appConfig, pendingFile, domainName)
+	if certArn != "" {
+		output, err := acmClient.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(certArn)})
+		if err != nil {
+			return fmt.Errorf("failed to describe certificate %s: %w", certArn, err)
+		}
+		if status := output.Certificate.Status; certificateFailed(status) {
+			slog.Warn("Pending certificate has failed, requesting a new one", "component", "acm", "domain", domainName, "arn", certArn, "cert_status", status)
+			failedArn = certArn
+			certArn = ""
+			if !appConfig.DryRun {
+				if err := clearStoredString(pendingFile); err != nil {
+					slog.Error("Failed to clear pending certificate ARN", "component", "acm", "domain", domainName, "error", err)
+				}
+			}
+		}
+	}
+	// Without a pending state file, as after losing the data directory, a
+	// certificate still awaiting validation in ACM is resumed as well, rather
+	// than left orphaned.
+	if certArn == "" {
+		certArn, err = findExistingCertificate(ctx, appConfig, acmClient, record, acmtypes.CertificateStatusPendingValidation)
+		if err != nil {
+			return err
+		}
+		if certArn != "" && !appConfig.DryRun {
+			slog.Info("Found certificate pending validation in ACM, storing ARN", "component", "acm", "domain", domainName, "arn", certArn)
+			if err := storeString(pendingFile, certArn); err != nil {
+				slog.Error("Failed to store pending certificate ARN", "component", "acm", "domain", domainName, "error", err)
+			}
+		}
+	}
+	if appConfig.DryRun {
+		if certArn != "" {
+			slog.Info("DRY RUN: Would resume validation of pending certificate", "component", "acm", "domain", domainName, "arn", certArn)
+		} else if preflightCAANames(ctx, appConfig, r53Client, names) {
+			slog.Info("DRY RUN: Would request a certificate with DNS validation and create its validation records", "component", "acm", "domain", domainName, "names", names)
+		}
+		return nil
+	}
+	if certArn != "" {
+		slog.Info("Resuming validation of pending certificate", "component", "acm", "domain", domainName, "arn", certArn)
 	} else {
-		log.Printf("NPM [%s]: Proxy host already exists. Skipping creation.", record.RecordName)
+		if !preflightCAANames(ctx, appConfig, r53Client, names) {
+			return fmt.Errorf("certificate request blocked by CAA records")
+		}
+
+		slog.Info("Requesting a new certificate", "component", "acm", "domain", domainName, "names", names, "key_algorithm", certKeyAlgorithm(record))
+		certArn, err = requestCertificate(ctx, appConfig, acmClient, record, failedArn)
+		if err != nil {
+			return err
+		}
+		if err := storeString(pendingFile, certArn); err != nil {
+			slog.Error("Failed to store pending certificate ARN", "component", "acm", "domain", domainName, "error", err)
+		}
+	}
+
+	validationRecords, err := getValidationRecords(ctx, acmClient, certArn, appConfig.CertPollInterval)
+	if err != nil {
+		return err
+	}
+	for _, validationRecord := range validationRecords {
+		validationZoneID := resolveValidationZone(ctx, r53Client, record, aws.ToString(validationRecord.Name))
+		slog.Info("Creating validation record", "component", "acm", "domain", domainName, "validation_record", aws.ToString(validationRecord.Name), "zone_id", validationZoneID)
+		if err := upsertValidationRecord(ctx, appConfig, r53Client, validationZoneID, validationRecord); err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Waiting for certificate validation", "component", "acm", "domain", domainName, "timeout", appConfig.CertValidationWait)
+	waiter := acm.NewCertificateValidatedWaiter(acmClient, func(o *acm.CertificateValidatedWaiterOptions) {
+		o.MinDelay, o.MaxDelay = appConfig.CertPollInterval, appConfig.CertPollInterval
+	})
+	waitStart := time.Now()
+	if err := waiter.Wait(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(certArn)}, appConfig.CertValidationWait); err != nil {
+		if ctx.Err() != nil {
+			slog.Info("Shutdown requested, validation will resume on the next start", "component", "acm", "domain", domainName, "arn", certArn)
+			return ctx.Err()
+		}
+		if err := clearStoredString(pendingFile); err != nil {
+			slog.Error("Failed to clear pending certificate ARN", "component", "acm", "domain", domainName, "error", err)
+		}
+		if time.Since(waitStart) >= appConfig.CertValidationWait {
+			notify(ctx, appConfig, notifyEvent{
+				Type:    eventCertTimeout,
+				Record:  domainName,
+				Message: fmt.Sprintf("Certificate for %s was not validated within %s", domainName, appConfig.CertValidationWait),
+				Details: map[string]string{"arn": certArn},
+			})
+		}
+		return fmt.Errorf("certificate validation did not complete: %w", err)
+	}
+
+	slog.Info("Certificate issued successfully, storing ARN", "component", "acm", "domain", domainName, "arn", certArn)
+	if err := storeString(stateFile, certArn); err != nil {
+		return fmt.Errorf("failed to store certificate ARN: %w", err)
+	}
+	if err := clearStoredString(pendingFile); err != nil {
+		slog.Error("Failed to clear pending certificate ARN", "component", "acm", "domain", domainName, "error", err)
 	}
+	notify(ctx, appConfig, notifyEvent{
+		Type:    eventCertIssued,
+		Record:  domainName,
+		Message: "Certificate issued for " + domainName,
+		Details: map[string]string{"arn": certArn},
+	})
+	if appConfig.CertDropValidation {
+		slog.Info("Removing validation records of the issued certificate", "component", "acm", "domain", domainName)
+		if err := removeValidationRecords(ctx, appConfig, r53Client, record, validationRecords); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkACMRegion reports whether public ACM certificates can be issued in the
+// given region. ACM is regional, so a missing or mistyped region would only
+// surface once every certificate workflow has failed.
+func checkACMRegion(region string) error {
+	if region == "" {
+		return fmt.Errorf("no AWS region is configured; set AWS_REGION")
+	}
+	if !awsRegionPattern.MatchString(region) {
+		return fmt.Errorf("%q does not look like a valid AWS region", region)
+	}
+	if strings.HasPrefix(region, "cn-") || strings.HasPrefix(region, "us-iso") {
+		return fmt.Errorf("public ACM certificates are not available in region %s", region)
+	}
+	return nil
+}
+
+// checkCertRegions runs checkACMRegion on region, the default region of
+// certificates, and checks that the records whose certificates go to
+// CloudFront have them issued in us-east-1.
+func checkCertRegions(region string, records []RecordConfig) error {
+	if err := checkACMRegion(region); err != nil {
+		return err
+	}
+	for _, record := range records {
+		if record.TLS && record.CloudFrontDistID != "" && record.CertRegion == "" && region != cloudfrontCertRegion {
+			return fmt.Errorf("record %s has a cloudfront_distribution_id, so its certificate must be issued in %s rather than %s; set cert_region", record.RecordName, cloudfrontCertRegion, region)
+		}
+	}
+	return nil
+}
+
+// reportCertificateStatus logs whether the domain has a valid issued
+// certificate and when it expires. It only reads from ACM and the state file
+// and never requests certificates or changes DNS records.
+func reportCertificateStatus(ctx context.Context, appConfig *AppConfig, acmClient *acm.Client, record RecordConfig) error {
+	domainName := record.RecordName
+	certArn, _ := getStoredString(certStateFile(certStateKey(record)))
+	if !arnBelongsToAccount(certArn, appConfig.AWSAccountID) {
+		slog.Warn("REPORT: Stored ARN is not in the current account, ignoring it", "component", "acm", "domain", domainName, "arn", certArn, "account_id", appConfig.AWSAccountID)
+		certArn = ""
+	}
+	if certArn == "" {
+		existingArn, err := findExistingCertificate(ctx, appConfig, acmClient, record, acmtypes.CertificateStatusIssued)
+		if err != nil {
+			return err
+		}
+		certArn = existingArn
+	}
+	if certArn == "" {
+		slog.Info("REPORT: No issued certificate found", "component", "acm", "domain", domainName)
+		return nil
+	}
+
+	output, err := acmClient.DescribeCertificate(ctx, &acm.DescribeCertificateInput{CertificateArn: aws.String(certArn)})
+	if err != nil {
+		return fmt.Errorf("failed to describe certificate %s: %w", certArn, err)
+	}
+	cert := output.Certificate
+	checkCertificateHealth(ctx, appConfig, domainName, cert)
+	if cert.Status != acmtypes.CertificateStatusIssued {
+		slog.Info("REPORT: Certificate is not valid", "component", "acm", "domain", domainName, "arn", certArn, "cert_status", cert.Status)
+		return nil
+	}
+	expiry := "unknown"
+	if cert.NotAfter != nil {
+		expiry = cert.NotAfter.Format(time.RFC3339)
+	}
+	slog.Info("REPORT: Valid certificate", "component", "acm", "domain", domainName, "arn", certArn, "expires", expiry)
+	return nil
 }
 
 // --- Main Application Logic ---
 
-func loadConfig() (*AppConfig, error) {
-	sleepTimeStr := os.Getenv("SLEEP_TIME")
-	if sleepTimeStr == "" {
-		sleepTimeStr = "300"
+// certWorkSummary describes the certificate work planned at startup.
+type certWorkSummary struct {
+	TLSDomains int
+	StoredArns int
+	ToRequest  int
+}
+
+// summarizeCertWork counts the TLS domains and how many of them already have
+// a stored certificate ARN, using the same state files as
+// manageCertificateLifecycle.
+func summarizeCertWork(records []RecordConfig) certWorkSummary {
+	var summary certWorkSummary
+	for _, record := range records {
+		if !record.TLS {
+			continue
+		}
+		summary.TLSDomains++
+		if storedArn, _ := getStoredString(certStateFile(certStateKey(record))); storedArn != "" {
+			summary.StoredArns++
+		} else {
+			summary.ToRequest++
+		}
+	}
+	return summary
+}
+
+// recordsForFamily returns the records that should carry an address of the
+// given family. Records get an A record unless their type is AAAA; AAAA is
+// opt-in through ipv6 or the type. Static records never follow the address.
+func recordsForFamily(records []RecordConfig, family addressFamily) []RecordConfig {
+	var selected []RecordConfig
+	for _, record := range records {
+		if record.isStatic() {
+			continue
+		}
+		isAAAA := record.Type == string(r53types.RRTypeAaaa)
+		if family.RecordType == r53types.RRTypeAaaa && (record.IPv6 || isAAAA) ||
+			family.RecordType != r53types.RRTypeAaaa && !isAAAA {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// syncAddress detects the current public address of one family and updates
+// the records that use it if it differs from the stored state (or, in
+// stateless mode, from the live record), or always when force is set.
+func syncAddress(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, family addressFamily, sources []ipSource, force bool, trustedIP string) (err error) {
+	logger := loggerFrom(ctx)
+	records := recordsForFamily(appConfig.RecordsToUpdate, family)
+	if len(records) == 0 {
+		return nil
+	}
+	ctx, span := tracer.Start(ctx, "ddns.sync_address", trace.WithAttributes(attribute.String("family", family.Name), attribute.Int("records", len(records))))
+	defer func() { endSpan(span, err) }()
+
+	// An address supplied to /trigger is published as is.
+	publicIP, source := trustedIP, "trigger"
+	if trustedIP == "" {
+		detect := detectPublicIP
+		if appConfig.IPCheckMode == ipCheckConsensus {
+			detect = detectPublicIPConsensus
+		}
+		detectCtx, detectSpan := tracer.Start(ctx, "ip.detect", trace.WithAttributes(attribute.String("mode", appConfig.IPCheckMode)))
+		publicIP, source, err = detect(detectCtx, sources, family, appConfig.IPCheckTimeout)
+		detectSpan.SetAttributes(attribute.String("source", source), attribute.String("public_ip", publicIP))
+		endSpan(detectSpan, err)
+	}
+	if err == nil {
+		err = checkPublishableIP(publicIP, family)
+		if err != nil {
+			logger.Warn("Refusing to publish detected address", "family", family.Name, "error", err)
+		}
+	} else {
+		logger.Error("Public IP check failed", "family", family.Name, "error", err)
 	}
-	sleepTime, err := time.ParseDuration(sleepTimeStr + "s")
+	status.recordIPCheck(family.Name, publicIP, err)
 	if err != nil {
-		return nil, fmt.Errorf("invalid SLEEP_TIME format: %w", err)
+		ipCheckFailures.WithLabelValues(family.Name).Inc()
+		return err
+	}
+	recordIPHistory(ctx, appConfig, family, publicIP)
+
+	toUpdate := records
+	// storedIP is the address published last time, which append mode
+	// removes from the record. It is unknown in stateless mode.
+	var storedIP string
+	// changed is whether the address differs from the published one, and
+	// previousIP the published address, if known.
+	var changed bool
+	var previousIP string
+	if appConfig.Stateless {
+		logger.Info("Checked public address", "family", family.Name, "source", source, "public_ip", publicIP)
+		toUpdate = nil
+		read := 0
+		for _, record := range records {
+			live, err := liveRecordValues(ctx, route53For(r53Client, record), record.ZoneID, record.RecordName, family.RecordType, record.SetIdentifier)
+			if err != nil {
+				// Writing the record anyway is safe, since updates are UPSERTs.
+				logger.Warn("Could not read the live record, updating it anyway", "record", record.RecordName, "zone_id", record.ZoneID, "error", err)
+				toUpdate = append(toUpdate, record)
+				continue
+			}
+			read++
+			if !slices.Contains(live, publicIP) {
+				changed = true
+				if previousIP == "" && len(live) == 1 {
+					previousIP = live[0]
+				}
+			} else if (len(live) == 1 || record.Mode == recordModeAppend) && !force {
+				status.recordSynced(record.RecordName, string(family.RecordType), publicIP, false)
+				continue
+			}
+			toUpdate = append(toUpdate, record)
+		}
+		// Without any live record to compare with, the address is taken
+		// to have changed.
+		changed = changed || read == 0
+		if len(toUpdate) == 0 {
+			logger.Info("All records already hold the current address", "family", family.Name)
+			return nil
+		}
+	} else {
+		var err error
+		storedIP, err = getStoredString(family.StateFile)
+		if err != nil {
+			logger.Warn("Could not read the stored address, treating it as changed", "family", family.Name, "error", err)
+		}
+		logger.Info("Checked public address", "family", family.Name, "source", source, "public_ip", publicIP, "stored_ip", storedIP)
+		changed, previousIP = publicIP != storedIP, storedIP
+		if !changed && !force {
+			logger.Info("Address has not changed", "family", family.Name)
+			for _, record := range records {
+				status.recordSynced(record.RecordName, string(family.RecordType), publicIP, false)
+			}
+			return nil
+		}
 	}
 
-	recordsJSON := os.Getenv("RECORDS_TO_UPDATE")
-	if recordsJSON == "" {
-		return nil, fmt.Errorf("RECORDS_TO_UPDATE environment variable not set or empty")
+	if changed {
+		logger.Info("Address has changed, updating records", "family", family.Name, "public_ip", publicIP, "type", family.RecordType, "records", len(toUpdate))
+		ipChanges.WithLabelValues(family.Name).Inc()
+		putCloudWatchMetric(appConfig, "IPChanges", cwtypes.StandardUnitCount, 1, "Family", family.Name)
+		notify(ctx, appConfig, notifyEvent{
+			Type:    eventIPChanged,
+			Message: fmt.Sprintf("Public %s address changed to %s", family.Name, publicIP),
+			Details: map[string]string{"family": family.Name, "public_ip": publicIP, "previous_ip": previousIP},
+		})
+	} else {
+		logger.Info("Address has not changed, updating records anyway", "family", family.Name, "public_ip", publicIP, "type", family.RecordType, "records", len(toUpdate))
+	}
+	failed := 0
+	var failedRecords []string
+	var updates []recordUpdate
+	for _, record := range toUpdate {
+		update := recordUpdate{Record: record, Type: family.RecordType, Values: []string{publicIP}}
+		if record.Mode == recordModeAppend {
+			if err := appendAddress(ctx, route53For(r53Client, record), &update, storedIP, publicIP); err != nil {
+				logger.Error("Record update failed", "record", record.RecordName, "zone_id", record.ZoneID, "type", family.RecordType, "error", err)
+				status.recordFailed(record.RecordName, string(family.RecordType), err)
+				failed++
+				failedRecords = append(failedRecords, record.RecordName)
+				continue
+			}
+		}
+		if record.HealthCheck != nil {
+			// Without its health check the record set would lose the
+			// association, so a record whose check fails is not written.
+			id, err := ensureHealthCheck(ctx, appConfig, route53For(r53Client, record), record, family.RecordType, publicIP)
+			if err != nil {
+				logger.Error("Health check update failed", "record", record.RecordName, "zone_id", record.ZoneID, "type", family.RecordType, "error", err)
+				status.recordFailed(record.RecordName, string(family.RecordType), err)
+				failed++
+				failedRecords = append(failedRecords, record.RecordName)
+				continue
+			}
+			update.HealthCheckID = id
+		}
+		updates = append(updates, update)
+	}
+	var updated []RecordConfig
+	for i, err := range updateRoute53Records(ctx, appConfig, r53Client, updates) {
+		record := updates[i].Record
+		if err != nil {
+			logger.Error("Record update failed", "record", record.RecordName, "zone_id", record.ZoneID, "type", family.RecordType, "error", err)
+			status.recordFailed(record.RecordName, string(family.RecordType), err)
+			failed++
+			failedRecords = append(failedRecords, record.RecordName)
+			continue
+		}
+		updated = append(updated, record)
+		status.recordSynced(record.RecordName, string(family.RecordType), publicIP, true)
+		// Name servers answer a routed record with any member of its set,
+		// so only simple records can be checked for the new address.
+		if appConfig.VerifyPropagation && !appConfig.DryRun && record.SetIdentifier == "" {
+			if err := verifyPropagation(ctx, appConfig, route53For(r53Client, record), record, family.RecordType, publicIP); err != nil {
+				logger.Error("Record update has not propagated", "record", record.RecordName, "zone_id", record.ZoneID, "error", err)
+			}
+		}
 	}
-	// Replace single quotes with double quotes if needed
-    recordsJSON = strings.ReplaceAll(recordsJSON, "'", "\"")
-	var records []RecordConfig
-	if err := json.Unmarshal([]byte(recordsJSON), &records); err != nil {
-		return nil, fmt.Errorf("failed to parse RECORDS_TO_UPDATE JSON: %w", err)
+	if len(updated) > 0 {
+		names := make([]string, 0, len(updated))
+		for _, record := range updated {
+			names = append(names, record.RecordName)
+		}
+		notify(ctx, appConfig, notifyEvent{
+			Type:    eventUpdated,
+			Message: fmt.Sprintf("Updated %d '%s' record(s) to %s", len(updated), family.RecordType, publicIP),
+			Details: map[string]string{"family": family.Name, "public_ip": publicIP, "records": strings.Join(names, ",")},
+		})
 	}
+	if failed > 0 {
+		err := fmt.Errorf("%d of %d '%s' record(s) failed to update", failed, len(toUpdate), family.RecordType)
+		notify(ctx, appConfig, notifyEvent{
+			Type:    eventUpdateFail,
+			Message: err.Error(),
+			Details: map[string]string{"family": family.Name, "public_ip": publicIP, "records": strings.Join(failedRecords, ",")},
+		})
+		recordUpdateOutcome(ctx, appConfig, family, err)
+		return err
+	}
+	recordUpdateOutcome(ctx, appConfig, family, nil)
+	// A failed PTR update keeps the new address from being stored, so the
+	// whole update is retried on the next cycle.
+	if err := syncReverseRecords(ctx, appConfig, r53Client, updated, family, storedIP, publicIP); err != nil {
+		return err
+	}
+	if appConfig.Stateless {
+		return nil
+	}
+	if appConfig.DryRun {
+		logger.Info("DRY RUN: Would store new address", "family", family.Name, "public_ip", publicIP)
+		return nil
+	}
+	logger.Info("All records updated successfully, storing new address", "family", family.Name, "type", family.RecordType)
+	if err := storeString(family.StateFile, publicIP); err != nil {
+		logger.Error("Failed to store new address", "family", family.Name, "error", err)
+		return err
+	}
+	return nil
+}
+
+// runDDNSCycle syncs every address family and the static records once and
+// returns the combined errors.
+func runDDNSCycle(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, req ddnsRequest) error {
+	ctx, span := tracer.Start(ctx, "ddns.cycle", trace.WithAttributes(attribute.String("cycle_id", cycleIDFrom(ctx)), attribute.Bool("force", req.force)))
+	err := syncAllRecords(ctx, appConfig, r53Client, req)
+	sendHeartbeat(ctx, appConfig, err)
+	flushCloudWatchMetrics(ctx, appConfig)
+	endSpan(span, err)
+	return err
+}
+
+// syncAllRecords updates the records of both address families and the
+// static records, the part of a DDNS cycle that a manual sync also runs.
+func syncAllRecords(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, req ddnsRequest) error {
+	return errors.Join(
+		syncAddress(ctx, appConfig, r53Client, ipv4Family, appConfig.IPv4Sources, req.force, req.trusted[ipv4Family.Name]),
+		syncAddress(ctx, appConfig, r53Client, ipv6Family, appConfig.IPv6Sources, req.force, req.trusted[ipv6Family.Name]),
+		syncStaticRecords(ctx, appConfig, r53Client, req.force),
+	)
+}
+
+// ddnsRequest asks for a DDNS cycle. force writes every record, and trusted
+// holds, by address family name, addresses supplied to /trigger to publish
+// instead of detecting them.
+type ddnsRequest struct {
+	force   bool
+	trusted map[string]string
+}
+
+// ddnsWaker holds the requests for an early DDNS cycle until the loop takes
+// them, merging those that arrive before it does.
+type ddnsWaker struct {
+	wake    chan struct{}
+	mu      sync.Mutex
+	pending ddnsRequest
+}
 
-	return &AppConfig{
-		SleepTime:       sleepTime,
-		RecordsToUpdate: records,
-		NPMBaseURL:      os.Getenv("NPM_URL"),
-		NPMIdentity:     os.Getenv("NPM_IDENTITY"),
-		NPMSecret:       os.Getenv("NPM_SECRET"),
-		ForwardHost:     os.Getenv("FORWARD_HOST_IP"),
-	}, nil
+func newDDNSWaker() *ddnsWaker {
+	return &ddnsWaker{wake: make(chan struct{}, 1)}
 }
 
-func runDDNSLoop(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client) {
+// request adds req to the pending request and wakes the loop. A later
+// address for a family replaces an earlier one.
+func (w *ddnsWaker) request(req ddnsRequest) {
+	w.mu.Lock()
+	w.pending.force = w.pending.force || req.force
+	for family, ip := range req.trusted {
+		if w.pending.trusted == nil {
+			w.pending.trusted = map[string]string{}
+		}
+		w.pending.trusted[family] = ip
+	}
+	w.mu.Unlock()
+	select {
+	case w.wake <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the pending request and clears it.
+func (w *ddnsWaker) take() ddnsRequest {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	req := w.pending
+	w.pending = ddnsRequest{}
+	return req
+}
+
+// runDDNSLoop runs a DDNS cycle every SleepTime until ctx is cancelled,
+// using the configuration in effect at the start of each cycle. Cycles are
+// skipped while leading reports false. A request to waker starts the next
+// cycle early, and each cycle carries out the requests made before it. A
+// cycle that is already running is allowed to finish, so that a record
+// update which went through is always followed by storing the new state.
+func runDDNSLoop(ctx context.Context, r53Client *route53.Client, waker *ddnsWaker, leading func() bool) {
+	var req ddnsRequest
 	for {
-		publicIP, err := getPublicIP()
-		if err != nil {
-			log.Printf("DDNS ERROR: %v", err)
+		appConfig := currentConfig.Load()
+		cycleCtx := withCycle(context.WithoutCancel(ctx), newCycleID())
+		if leading() {
+			runDDNSCycle(cycleCtx, appConfig, r53Client, req)
+			loggerFrom(cycleCtx).Info("Sleeping until the next check", "sleep_time", appConfig.SleepTime)
 		} else {
-			storedIP, _ := getStoredString(ipStateFile)
-			log.Printf("DDNS Check - Public IP: %s, Stored IP: %s", publicIP, storedIP)
-			if publicIP != storedIP {
-				log.Printf("DDNS: IP address has changed to %s. Updating all 'A' records...", publicIP)
-				allUpdated := true
-				for _, record := range appConfig.RecordsToUpdate {
-					if err := updateRoute53Record(ctx, r53Client, record.ZoneID, record.RecordName, publicIP); err != nil {
-						log.Printf("DDNS ERROR for %s: %v", record.RecordName, err)
-						allUpdated = false
-					}
-				}
-				if allUpdated {
-					log.Println("DDNS: All records updated successfully. Storing new IP.")
-					if err := storeString(ipStateFile, publicIP); err != nil {
-						log.Printf("DDNS ERROR: Failed to store new IP: %v", err)
-					}
-				}
-			} else {
-				log.Println("DDNS: IP has not changed.")
+			loggerFrom(cycleCtx).Debug("Standing by, another instance is the leader")
+		}
+
+		select {
+		case <-ctx.Done():
+			slog.Info("Shutdown requested, stopping DDNS loop", "component", "ddns")
+			return
+		case <-waker.wake:
+		case <-time.After(appConfig.SleepTime):
+		}
+		req = waker.take()
+	}
+}
+
+// watchCheckSignals calls wake whenever the process receives one of
+// checkSignals, e.g. after a known reconnect, rather than waiting out
+// SLEEP_TIME.
+func watchCheckSignals(ctx context.Context, wake func(req ddnsRequest)) {
+	if len(checkSignals) == 0 {
+		return
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, checkSignals...)
+	defer signal.Stop(signals)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-signals:
+			slog.Info("Check requested by signal", "component", "ddns", "signal", sig)
+			wake(ddnsRequest{})
+		}
+	}
+}
+
+// prepareCertWork logs the planned certificate work and, the first time
+// there is any, checks the ACM region and looks up the caller's account.
+// previous is the configuration being replaced, or nil at startup.
+func prepareCertWork(ctx context.Context, appConfig, previous *AppConfig, awsCfg aws.Config) {
+	summary := summarizeCertWork(appConfig.RecordsToUpdate)
+	slog.Info("Planned certificate work", "component", "acm",
+		"tls_domains", summary.TLSDomains, "stored_arns", summary.StoredArns, "to_request", summary.ToRequest)
+	if summary.TLSDomains == 0 || previous != nil && summarizeCertWork(previous.RecordsToUpdate).TLSDomains > 0 {
+		return
+	}
+	// The region and account checks are about ACM.
+	if appConfig.CertBackend == certBackendACME {
+		return
+	}
+
+	if appConfig.ACMRegionCheck != regionCheckOff {
+		if err := checkCertRegions(awsCfg.Region, appConfig.RecordsToUpdate); err != nil {
+			// Only refuse to start; a reload cannot change the region.
+			if appConfig.ACMRegionCheck == regionCheckAbort && previous == nil {
+				fatal("ACM region check failed", "region", awsCfg.Region, "error", err)
 			}
+			slog.Warn("ACM region check failed, certificate workflows are likely to fail", "component", "acm", "region", awsCfg.Region, "error", err)
+		}
+	}
+
+	if appConfig.AWSAccountID == "" {
+		var err error
+		appConfig.AWSAccountID, err = getCallerAccountID(ctx, sts.NewFromConfig(awsCfg))
+		if err != nil {
+			slog.Warn("Stored certificate ARNs will not be checked against the current account", "component", "acm", "error", err)
 		}
-		log.Printf("DDNS: Sleeping for %s...", appConfig.SleepTime)
-		time.Sleep(appConfig.SleepTime)
+	}
+}
+
+// runHistoryCommand prints the IP history for the history argument. Only
+// the state backend is set up, so it needs no records and touches no AWS
+// service other than the one holding the state.
+func runHistoryCommand(ctx context.Context) {
+	appConfig, err := readConfig(false)
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	if err := setupLogging(appConfig.LogFormat, appConfig.LogLevel); err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	var awsCfg aws.Config
+	if appConfig.StateBackend != stateBackendFile {
+		if awsCfg, err = loadAWSConfig(ctx, appConfig, ""); err != nil {
+			fatal("Failed to load AWS config", "error", err)
+		}
+	}
+	if stateStore, err = newStateStore(appConfig, awsCfg); err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	if err := printIPHistory(os.Stdout); err != nil {
+		fatal("Failed to read the IP history", "error", err)
 	}
 }
 
 func main() {
-	log.Println("Starting Go Dynamic DNS, TLS, and Proxy automation script...")
 	var wg sync.WaitGroup
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if len(os.Args) > 1 && os.Args[1] == "history" {
+		runHistoryCommand(ctx)
+		return
+	}
+
 	appConfig, err := loadConfig()
 	if err != nil {
-		log.Fatalf("FATAL: Configuration error: %v", err)
+		fatal("Configuration error", "error", err)
+	}
+	if err := setupLogging(appConfig.LogFormat, appConfig.LogLevel); err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	slog.Info("Starting Go Dynamic DNS, TLS, and Proxy automation script")
+	logConfigOrigins(appConfig)
+	shutdownTracing, err := setupTracing(ctx, appConfig)
+	if err != nil {
+		fatal("Configuration error", "error", err)
 	}
+	defer shutdownTracing()
 
-	awsCfg, err := config.LoadDefaultConfig(context.TODO())
+	if appConfig.DryRun {
+		slog.Info("DRY RUN: No Route53 records, ACM certificates, proxy hosts, or state files will be changed")
+	}
+
+	awsCfg, err := loadAWSConfig(ctx, appConfig, "")
 	if err != nil {
-		log.Fatalf("FATAL: Failed to load AWS config: %v", err)
+		fatal("Failed to load AWS config", "error", err)
+	}
+	baseAWSConfig = awsCfg
+	if err := loadAccountProfiles(ctx, appConfig, appConfig.RecordsToUpdate); err != nil {
+		fatal("Configuration error", "error", err)
 	}
 	r53Client := route53.NewFromConfig(awsCfg)
+	acmClient := acm.NewFromConfig(awsCfg)
 
 	var npmClient *NpmClient
 	if appConfig.NPMBaseURL != "" && appConfig.NPMIdentity != "" {
-		npmClient, err = NewNpmClient(appConfig.NPMBaseURL, appConfig.NPMIdentity, appConfig.NPMSecret)
+		npmClient, err = NewNpmClient(ctx, appConfig.NPMBaseURL, appConfig.NPMIdentity, appConfig.NPMSecret, appConfig.RootCAs)
 		if err != nil {
-			log.Printf("FATAL: Could not connect to Nginx Proxy Manager: %v. Proxy features will be disabled.", err)
+			slog.Error("Could not connect to Nginx Proxy Manager, proxy features will be disabled", "component", "npm", "error", err)
 		}
 	}
 
-	// Goroutine for the continuous DDNS loop
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		runDDNSLoop(context.Background(), appConfig, r53Client)
-	}()
+	stateStore, err = newStateStore(appConfig, awsCfg)
+	if err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	if appConfig.StateBackend == stateBackendFile {
+		if err := lockStateDir(); err != nil {
+			fatal("Cannot use the state directory", "error", err)
+		}
+	}
+	if err := resolveRecordZones(ctx, r53Client, appConfig.RecordsToUpdate); err != nil {
+		fatal("Configuration error", "error", err)
+	}
+	prepareCertWork(ctx, appConfig, nil, awsCfg)
+	currentConfig.Store(appConfig)
 
-	// Launch one-time proxy setup tasks for each record
-	for _, record := range appConfig.RecordsToUpdate {
-		// Manage Nginx Proxy if port is specified and NPM is configured
-		if record.Port > 0 && npmClient != nil {
-			rec := record // Create a new variable for the goroutine to avoid closure issues
-			if appConfig.ForwardHost == "" {
-				log.Printf("NPM [%s]: Skipping proxy setup because FORWARD_HOST_IP is not set.", rec.RecordName)
-				continue
+	// failures counts the tasks that did not complete successfully; in run-once
+	// mode it decides the exit status.
+	var failures atomic.Int32
+
+	ddnsWaker := newDDNSWaker()
+	wakeDDNS := ddnsWaker.request
+	tasks := newRecordTasks(ctx, &wg, &failures, acmClient, r53Client, ssm.NewFromConfig(awsCfg), npmClient, appConfig.CertConcurrency)
+
+	api := &controller{ctx: ctx, r53Client: r53Client, tasks: tasks, wakeDDNS: wakeDDNS}
+	if appConfig.HTTPAddr != "" && !appConfig.RunOnce {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runHTTPServer(ctx, appConfig.HTTPAddr, api)
+		}()
+	}
+	if appConfig.GRPCAddr != "" && !appConfig.RunOnce {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runGRPCServer(ctx, appConfig.GRPCAddr, api)
+		}()
+	}
+	if appConfig.RFC2136Addr != "" && !appConfig.RunOnce {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runRFC2136Server(ctx, appConfig.RFC2136Addr, r53Client)
+		}()
+	}
+
+	// With leader election, only the leader runs DDNS cycles and record
+	// tasks. A new leader writes every record straight away, since the old
+	// one may have been mid-update.
+	leading := func() bool { return true }
+	if appConfig.LeaderElection != leaderElectionOff && !appConfig.RunOnce {
+		elector, err := newLeaderElector(appConfig, awsCfg, func(isLeader bool) {
+			status.setStandby(!isLeader)
+			if isLeader {
+				tasks.resume(currentConfig.Load())
+				wakeDDNS(ddnsRequest{force: true})
+			} else {
+				tasks.pause()
 			}
-			wg.Add(1)
-			go func() {
-				defer wg.Done()
-				manageNginxProxy(rec, npmClient, appConfig.ForwardHost)
-			}()
+		})
+		if err != nil {
+			fatal("Configuration error", "error", err)
+		}
+		leading = elector.isLeader
+		status.setStandby(true)
+		tasks.pause()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			elector.run(ctx)
+		}()
+	}
+
+	if appConfig.RunOnce {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := runDDNSCycle(withCycle(ctx, newCycleID()), appConfig, r53Client, ddnsRequest{}); err != nil {
+				failures.Add(1)
+			}
+		}()
+	} else {
+		// Goroutine for the continuous DDNS loop
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			runDDNSLoop(ctx, r53Client, ddnsWaker, leading)
+		}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchCheckSignals(ctx, wakeDDNS)
+		}()
+	}
+
+	// Launch the certificate and proxy setup tasks for each record
+	tasks.sync(appConfig)
+
+	if appConfig.RunOnce {
+		wg.Wait()
+		if n := failures.Load(); n > 0 {
+			slog.Error("Run complete with failed tasks", "failed_tasks", n)
+			shutdownTracing()
+			os.Exit(1)
 		}
+		slog.Info("Run complete, all tasks succeeded")
+		return
 	}
 
-	log.Println("Application running. All startup tasks launched.")
+	reload := func(reason string) {
+		slog.Info("Reloading configuration", "reason", reason)
+		next, err := loadConfig()
+		if err != nil {
+			slog.Error("Reload failed, keeping the current configuration", "error", err)
+			return
+		}
+		if err := loadAccountProfiles(ctx, appConfig, next.RecordsToUpdate); err != nil {
+			slog.Error("Reload failed, keeping the current configuration", "error", err)
+			return
+		}
+		if err := resolveRecordZones(ctx, r53Client, next.RecordsToUpdate); err != nil {
+			slog.Error("Reload failed, keeping the current configuration", "error", err)
+			return
+		}
+		old := currentConfig.Load()
+		keepRestartOnlySettings(old, next)
+		prepareCertWork(ctx, next, old, awsCfg)
+		currentConfig.Store(next)
+		status.retainRecords(next.RecordsToUpdate)
+		tasks.sync(next)
+		if ddnsRecordsChanged(old, next) {
+			wakeDDNS(ddnsRequest{force: true})
+		}
+		logConfigOrigins(next)
+		slog.Info("Configuration reloaded", "records", len(next.RecordsToUpdate))
+	}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		watchConfig(ctx, os.Getenv("CONFIG_FILE"), reload)
+	}()
+
+	slog.Info("Application running, all startup tasks launched")
+	<-ctx.Done()
+	slog.Info("Shutdown signal received, waiting for running tasks to finish")
 	wg.Wait()
+	slog.Info("Shutdown complete")
 }
@@ -0,0 +1,698 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	acmtypes "github.com/aws/aws-sdk-go-v2/service/acm/types"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/aws/smithy-go/middleware"
+)
+
+// useStateDir runs the test in an empty working directory, so that the state
+// files under data/ start out empty.
+func useStateDir(t *testing.T) {
+	t.Helper()
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir("data", 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readAuditLog returns the entries written to the audit log at path.
+func readAuditLog(t *testing.T, path string) []auditEntry {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []auditEntry
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var entry auditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("audit line %q: %v", line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// resetHostedZoneCache forgets the zones found by earlier tests.
+func resetHostedZoneCache(t *testing.T) {
+	hostedZoneCache.Clear()
+	t.Cleanup(hostedZoneCache.Clear)
+}
+
+// fakeAWS answers AWS API calls in process instead of sending them, so that
+// tests run the real SDK clients, paginators and waiters without credentials
+// or network access. Handlers are keyed by operation name and get the typed
+// input of the call; a call without a handler fails the test.
+type fakeAWS struct {
+	t        *testing.T
+	mu       sync.Mutex
+	handlers map[string]func(input any) (any, error)
+	calls    []string
+}
+
+func newFakeAWS(t *testing.T) *fakeAWS {
+	return &fakeAWS{t: t, handlers: map[string]func(input any) (any, error){}}
+}
+
+// on sets the handler of operation, e.g. "ListResourceRecordSets".
+func (f *fakeAWS) on(operation string, handler func(input any) (any, error)) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.handlers[operation] = handler
+}
+
+// count returns how many times operation was called.
+func (f *fakeAWS) count(operation string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	for _, call := range f.calls {
+		if call == operation {
+			n++
+		}
+	}
+	return n
+}
+
+// config returns an AWS configuration whose clients are answered by f.
+func (f *fakeAWS) config() aws.Config {
+	return aws.Config{
+		Region:      "us-east-1",
+		Credentials: aws.AnonymousCredentials{},
+		Retryer:     func() aws.Retryer { return aws.NopRetryer{} },
+		APIOptions:  []func(*middleware.Stack) error{f.addToStack},
+	}
+}
+
+func (f *fakeAWS) route53() *route53.Client { return route53.NewFromConfig(f.config()) }
+
+func (f *fakeAWS) acm() *acm.Client { return acm.NewFromConfig(f.config()) }
+
+// addToStack answers the call once its input has been validated, before
+// anything is serialized or signed.
+func (f *fakeAWS) addToStack(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("fakeAWS",
+		func(_ context.Context, in middleware.InitializeInput, _ middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			operation := strings.TrimSuffix(reflect.TypeOf(in.Parameters).Elem().Name(), "Input")
+			f.mu.Lock()
+			f.calls = append(f.calls, operation)
+			handler := f.handlers[operation]
+			f.mu.Unlock()
+			if handler == nil {
+				f.t.Errorf("unexpected AWS call %s", operation)
+				return middleware.InitializeOutput{}, middleware.Metadata{}, fmt.Errorf("fake AWS has no handler for %s", operation)
+			}
+			result, err := handler(in.Parameters)
+			return middleware.InitializeOutput{Result: result}, middleware.Metadata{}, err
+		}), middleware.After)
+}
+
+func TestSummarizeCertWork(t *testing.T) {
+	issued := RecordConfig{RecordName: "issued.example.com", TLS: true}
+	withSANs := RecordConfig{RecordName: "san.example.com", TLS: true, SubjectAlternativeNames: []string{"www.san.example.com"}}
+	tests := []struct {
+		name    string
+		records []RecordConfig
+		stored  []RecordConfig
+		want    certWorkSummary
+	}{
+		{
+			name:    "no tls records",
+			records: []RecordConfig{{RecordName: "plain.example.com"}},
+			want:    certWorkSummary{},
+		},
+		{
+			name:    "all new",
+			records: []RecordConfig{issued, {RecordName: "new.example.com", TLS: true}},
+			want:    certWorkSummary{TLSDomains: 2, ToRequest: 2},
+		},
+		{
+			name:    "issued and new",
+			records: []RecordConfig{issued, {RecordName: "new.example.com", TLS: true}, {RecordName: "plain.example.com"}},
+			stored:  []RecordConfig{issued},
+			want:    certWorkSummary{TLSDomains: 2, StoredArns: 1, ToRequest: 1},
+		},
+		{
+			// The ARN stored for the bare name is not the certificate the
+			// record with subject alternative names asks for.
+			name:    "names changed since the certificate was stored",
+			records: []RecordConfig{withSANs},
+			stored:  []RecordConfig{{RecordName: withSANs.RecordName, TLS: true}},
+			want:    certWorkSummary{TLSDomains: 1, ToRequest: 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			for _, record := range tt.stored {
+				if err := storeString(certStateFile(certStateKey(record)), "arn:aws:acm:us-east-1:111111111111:certificate/stored"); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if got := summarizeCertWork(tt.records); got != tt.want {
+				t.Errorf("summarizeCertWork() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMixedCaseNames(t *testing.T) {
+	tests := []struct {
+		name       string
+		configured string
+		live       string
+	}{
+		{"upper case config", "API.Example.COM", "api.example.com."},
+		{"upper case live record", "api.example.com", "API.EXAMPLE.COM."},
+		{"trailing dot in config", "Api.Example.com.", "api.example.com."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := []RecordConfig{{ZoneID: "Z1", RecordName: tt.configured, TLS: true}}
+			if err := normalizeRecords(records); err != nil {
+				t.Fatal(err)
+			}
+			record := records[0]
+			if record.RecordName != "api.example.com" {
+				t.Errorf("record name = %q, want api.example.com", record.RecordName)
+			}
+			if got, want := certStateFile(tt.configured), certStateFile("api.example.com"); got != want {
+				t.Errorf("certStateFile(%q) = %q, want %q", tt.configured, got, want)
+			}
+
+			fake := newFakeAWS(t)
+			const certArn = "arn:aws:acm:us-east-1:111111111111:certificate/mixed"
+			fake.on("ListCertificates", func(any) (any, error) {
+				return &acm.ListCertificatesOutput{CertificateSummaryList: []acmtypes.CertificateSummary{{
+					CertificateArn:                       aws.String(certArn),
+					DomainName:                           aws.String(strings.TrimSuffix(tt.live, ".")),
+					SubjectAlternativeNameSummaries:      []string{strings.TrimSuffix(tt.live, ".")},
+					RenewalEligibility:                   acmtypes.RenewalEligibilityEligible,
+					HasAdditionalSubjectAlternativeNames: aws.Bool(false),
+				}}}, nil
+			})
+			fake.on("ListResourceRecordSets", func(any) (any, error) {
+				return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []r53types.ResourceRecordSet{{
+					Name:            aws.String(tt.live),
+					Type:            r53types.RRTypeA,
+					ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("198.41.0.4")}},
+				}}}, nil
+			})
+
+			found, err := findExistingCertificate(context.Background(), &AppConfig{}, fake.acm(), record, acmtypes.CertificateStatusIssued)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found != certArn {
+				t.Errorf("findExistingCertificate() = %q, want %q", found, certArn)
+			}
+			live, err := liveRecordValues(context.Background(), fake.route53(), record.ZoneID, tt.configured, r53types.RRTypeA, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(live) != 1 || live[0] != "198.41.0.4" {
+				t.Errorf("liveRecordValues() = %v, want the live record", live)
+			}
+		})
+	}
+}
+
+// acmWriteOperations are the calls that change ACM or Route53.
+var acmWriteOperations = []string{"RequestCertificate", "DeleteCertificate", "AddTagsToCertificate", "ChangeResourceRecordSets"}
+
+func TestCertificatePassMakesNoWrites(t *testing.T) {
+	const storedArn = "arn:aws:acm:us-east-1:111111111111:certificate/stored"
+	issued := &acmtypes.CertificateDetail{
+		CertificateArn: aws.String(storedArn),
+		Status:         acmtypes.CertificateStatusIssued,
+		NotAfter:       aws.Time(time.Now().Add(90 * 24 * time.Hour)),
+	}
+	tests := []struct {
+		name      string
+		appConfig AppConfig
+		stored    string
+		listed    []acmtypes.CertificateSummary
+	}{
+		{
+			name:      "report with a stored certificate",
+			appConfig: AppConfig{CertMode: certModeReport},
+			stored:    storedArn,
+		},
+		{
+			name:      "report with a certificate found in ACM",
+			appConfig: AppConfig{CertMode: certModeReport},
+			listed:    []acmtypes.CertificateSummary{{CertificateArn: aws.String(storedArn), SubjectAlternativeNameSummaries: []string{"www.example.com"}}},
+		},
+		{
+			name:      "report without a certificate",
+			appConfig: AppConfig{CertMode: certModeReport},
+		},
+		{
+			name:      "dry run without a certificate",
+			appConfig: AppConfig{CertMode: certModeManage, DryRun: true, CAACheck: caaCheckOff, CertCleanup: certCleanupOff},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			stateFile := certStateFile("www.example.com")
+			if tt.stored != "" {
+				if err := storeString(stateFile, tt.stored); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fake := newFakeAWS(t)
+			fake.on("ListCertificates", func(any) (any, error) {
+				return &acm.ListCertificatesOutput{CertificateSummaryList: tt.listed}, nil
+			})
+			fake.on("DescribeCertificate", func(any) (any, error) {
+				return &acm.DescribeCertificateOutput{Certificate: issued}, nil
+			})
+			for _, operation := range acmWriteOperations {
+				fake.on(operation, func(any) (any, error) { return nil, fmt.Errorf("%s is not allowed", operation) })
+			}
+
+			tasks := &recordTasks{acmClient: fake.acm(), r53Client: fake.route53()}
+			record := RecordConfig{ZoneID: "Z1", RecordName: "www.example.com", TLS: true}
+			if err := tasks.runCertificatePass(context.Background(), &tt.appConfig, record); err != nil {
+				t.Fatal(err)
+			}
+			for _, operation := range acmWriteOperations {
+				if n := fake.count(operation); n > 0 {
+					t.Errorf("%s was called %d times", operation, n)
+				}
+			}
+			if stored, _ := getStoredString(stateFile); stored != tt.stored {
+				t.Errorf("state file holds %q, want %q", stored, tt.stored)
+			}
+		})
+	}
+}
+
+func TestCertificateFailed(t *testing.T) {
+	failed := []acmtypes.CertificateStatus{
+		acmtypes.CertificateStatusValidationTimedOut,
+		acmtypes.CertificateStatusFailed,
+		acmtypes.CertificateStatusRevoked,
+		acmtypes.CertificateStatusExpired,
+		acmtypes.CertificateStatusInactive,
+	}
+	for _, status := range acmtypes.CertificateStatus("").Values() {
+		if got, want := certificateFailed(status), slices.Contains(failed, status); got != want {
+			t.Errorf("certificateFailed(%s) = %t, want %t", status, got, want)
+		}
+	}
+}
+
+func TestBatchKeepsPerRecordSettings(t *testing.T) {
+	weight := aws.Int64(20)
+	tests := []struct {
+		name    string
+		updates []recordUpdate
+		want    []r53types.ResourceRecordSet
+	}{
+		{
+			name: "different ttls and types",
+			updates: []recordUpdate{
+				{Record: RecordConfig{ZoneID: "Z1", RecordName: "home.example.com", TTL: 60}, Type: r53types.RRTypeA, Values: []string{"198.41.0.4"}},
+				{Record: RecordConfig{ZoneID: "Z1", RecordName: "txt.example.com", TTL: 3600}, Type: r53types.RRTypeTxt, Values: []string{`"v=spf1 -all"`}},
+				{Record: RecordConfig{ZoneID: "Z1", RecordName: "home.example.com", TTL: 300}, Type: r53types.RRTypeAaaa, Values: []string{"2001:db8::1"}},
+			},
+			want: []r53types.ResourceRecordSet{
+				{Name: aws.String("home.example.com"), Type: r53types.RRTypeA, TTL: aws.Int64(60), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("198.41.0.4")}}},
+				{Name: aws.String("txt.example.com"), Type: r53types.RRTypeTxt, TTL: aws.Int64(3600), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String(`"v=spf1 -all"`)}}},
+				{Name: aws.String("home.example.com"), Type: r53types.RRTypeAaaa, TTL: aws.Int64(300), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("2001:db8::1")}}},
+			},
+		},
+		{
+			name: "routing policies and aliases",
+			updates: []recordUpdate{
+				{Record: RecordConfig{ZoneID: "Z1", RecordName: "api.example.com", TTL: 60, SetIdentifier: "home", Weight: weight}, Type: r53types.RRTypeA, Values: []string{"198.41.0.4"}, HealthCheckID: "hc-1"},
+				{Record: RecordConfig{ZoneID: "Z1", RecordName: "api.example.com", TTL: 120, SetIdentifier: "eu", Region: "eu-west-1"}, Type: r53types.RRTypeA, Values: []string{"199.9.14.201"}},
+				{Record: RecordConfig{ZoneID: "Z1", RecordName: "www.example.com", TTL: 300, Alias: &AliasConfig{DNSName: "lb.example.net", HostedZoneID: "Z2"}}, Type: r53types.RRTypeA},
+			},
+			want: []r53types.ResourceRecordSet{
+				{Name: aws.String("api.example.com"), Type: r53types.RRTypeA, TTL: aws.Int64(60), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("198.41.0.4")}}, SetIdentifier: aws.String("home"), Weight: weight, HealthCheckId: aws.String("hc-1")},
+				{Name: aws.String("api.example.com"), Type: r53types.RRTypeA, TTL: aws.Int64(120), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("199.9.14.201")}}, SetIdentifier: aws.String("eu"), Region: r53types.ResourceRecordSetRegionEuWest1},
+				{Name: aws.String("www.example.com"), Type: r53types.RRTypeA, AliasTarget: &r53types.AliasTarget{DNSName: aws.String("lb.example.net"), HostedZoneId: aws.String("Z2")}},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			var sent []*route53.ChangeResourceRecordSetsInput
+			fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+				sent = append(sent, input.(*route53.ChangeResourceRecordSetsInput))
+				return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+			})
+			for _, err := range updateRoute53Records(context.Background(), &AppConfig{}, fake.route53(), tt.updates) {
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(sent) != 1 {
+				t.Fatalf("sent %d change batches, want the zone's records in one", len(sent))
+			}
+			var got []r53types.ResourceRecordSet
+			for _, change := range sent[0].ChangeBatch.Changes {
+				if change.Action != r53types.ChangeActionUpsert {
+					t.Errorf("change action = %s, want UPSERT", change.Action)
+				}
+				got = append(got, *change.ResourceRecordSet)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batch record sets:\n got %+v\nwant %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCertRegions(t *testing.T) {
+	cloudfront := RecordConfig{RecordName: "cdn.example.com", TLS: true, CloudFrontDistID: "E2QWRUHAPOMQZL"}
+	pinned := cloudfront
+	pinned.CertRegion = "us-east-1"
+	tests := []struct {
+		name    string
+		region  string
+		records []RecordConfig
+		wantErr bool
+	}{
+		{name: "supported region", region: "eu-west-1", records: []RecordConfig{{RecordName: "www.example.com", TLS: true}}},
+		{name: "cloudfront in us-east-1", region: "us-east-1", records: []RecordConfig{cloudfront}},
+		{name: "cloudfront with cert_region", region: "eu-west-1", records: []RecordConfig{pinned}},
+		{name: "no region", region: "", wantErr: true},
+		{name: "malformed region", region: "europe", wantErr: true},
+		{name: "china", region: "cn-north-1", wantErr: true},
+		{name: "isolated region", region: "us-iso-east-1", wantErr: true},
+		{name: "cloudfront outside us-east-1", region: "eu-west-1", records: []RecordConfig{cloudfront}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkCertRegions(tt.region, tt.records)
+			if tt.wantErr && err == nil {
+				t.Errorf("checkCertRegions(%q) accepted the region", tt.region)
+			}
+			if !tt.wantErr && err != nil {
+				t.Errorf("checkCertRegions(%q) failed: %v", tt.region, err)
+			}
+		})
+	}
+}
+
+func TestCertRegionSetting(t *testing.T) {
+	tests := []struct {
+		name    string
+		record  RecordConfig
+		wantErr bool
+	}{
+		{name: "supported", record: RecordConfig{ZoneID: "Z1", RecordName: "www.example.com", TLS: true, CertRegion: " US-East-1 "}},
+		{name: "unsupported", record: RecordConfig{ZoneID: "Z1", RecordName: "www.example.com", TLS: true, CertRegion: "cn-northwest-1"}, wantErr: true},
+		{name: "malformed", record: RecordConfig{ZoneID: "Z1", RecordName: "www.example.com", TLS: true, CertRegion: "east"}, wantErr: true},
+		{name: "cloudfront elsewhere", record: RecordConfig{ZoneID: "Z1", RecordName: "www.example.com", TLS: true, CertRegion: "eu-west-1", CloudFrontDistID: "E2QWRUHAPOMQZL"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records := []RecordConfig{tt.record}
+			err := normalizeRecords(records)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("normalizeRecords() accepted cert_region %q", tt.record.CertRegion)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if records[0].CertRegion != "us-east-1" {
+				t.Errorf("cert_region = %q, want us-east-1", records[0].CertRegion)
+			}
+		})
+	}
+}
+
+// hostedZones answers ListHostedZonesByName with the public zones by name,
+// e.g. "sub.example.com." for zone Z_SUB, like Route53 does.
+func hostedZones(zones map[string]string) func(input any) (any, error) {
+	return func(input any) (any, error) {
+		name := aws.ToString(input.(*route53.ListHostedZonesByNameInput).DNSName)
+		output := &route53.ListHostedZonesByNameOutput{}
+		if id, ok := zones[name]; ok {
+			output.HostedZones = []r53types.HostedZone{{Id: aws.String("/hostedzone/" + id), Name: aws.String(name)}}
+		}
+		return output, nil
+	}
+}
+
+// onCertificateRequest answers the ACM calls of a new certificate request
+// for www.sub.example.com, with status giving the certificate's status on
+// each describe.
+func onCertificateRequest(fake *fakeAWS, certArn string, status func() acmtypes.CertificateStatus) {
+	fake.on("ListCertificates", func(any) (any, error) { return &acm.ListCertificatesOutput{}, nil })
+	fake.on("RequestCertificate", func(any) (any, error) {
+		return &acm.RequestCertificateOutput{CertificateArn: aws.String(certArn)}, nil
+	})
+	fake.on("DescribeCertificate", func(any) (any, error) {
+		certStatus, validationStatus := status(), acmtypes.DomainStatusPendingValidation
+		if certStatus == acmtypes.CertificateStatusIssued {
+			validationStatus = acmtypes.DomainStatusSuccess
+		}
+		return &acm.DescribeCertificateOutput{Certificate: &acmtypes.CertificateDetail{
+			CertificateArn: aws.String(certArn),
+			DomainName:     aws.String("www.sub.example.com"),
+			Status:         certStatus,
+			DomainValidationOptions: []acmtypes.DomainValidation{{
+				DomainName:       aws.String("www.sub.example.com"),
+				ValidationStatus: validationStatus,
+				ResourceRecord: &acmtypes.ResourceRecord{
+					Name:  aws.String("_x1.www.sub.example.com."),
+					Type:  acmtypes.RecordTypeCname,
+					Value: aws.String("_x2.acm-validations.aws."),
+				},
+			}},
+		}}, nil
+	})
+}
+
+func TestResolveValidationZone(t *testing.T) {
+	zones := map[string]string{"example.com.": "Z_PARENT", "sub.example.com.": "Z_SUB"}
+	tests := []struct {
+		name     string
+		record   RecordConfig
+		search   func(input any) (any, error)
+		validate string
+		want     string
+	}{
+		{
+			name:     "explicit override",
+			record:   RecordConfig{ZoneID: "Z_PARENT", ValidationZoneID: "Z_OTHER"},
+			validate: "_x1.www.sub.example.com.",
+			want:     "Z_OTHER",
+		},
+		{
+			name:     "delegated subdomain",
+			record:   RecordConfig{ZoneID: "Z_PARENT"},
+			search:   hostedZones(zones),
+			validate: "_x1.www.sub.example.com.",
+			want:     "Z_SUB",
+		},
+		{
+			name:     "same zone as the record",
+			record:   RecordConfig{ZoneID: "Z_PARENT"},
+			search:   hostedZones(zones),
+			validate: "_x1.www.example.com.",
+			want:     "Z_PARENT",
+		},
+		{
+			name:     "no hosted zone found",
+			record:   RecordConfig{ZoneID: "Z_PARENT"},
+			search:   hostedZones(nil),
+			validate: "_x1.www.example.org.",
+			want:     "Z_PARENT",
+		},
+		{
+			name:     "lookup fails",
+			record:   RecordConfig{ZoneID: "Z_PARENT"},
+			search:   func(any) (any, error) { return nil, fmt.Errorf("access denied") },
+			validate: "_x1.www.sub.example.com.",
+			want:     "Z_PARENT",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetHostedZoneCache(t)
+			fake := newFakeAWS(t)
+			if tt.search != nil {
+				fake.on("ListHostedZonesByName", tt.search)
+			}
+			if got := resolveValidationZone(context.Background(), fake.route53(), tt.record, tt.validate); got != tt.want {
+				t.Errorf("resolveValidationZone(%q) = %q, want %q", tt.validate, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidationRecordInDelegatedZone(t *testing.T) {
+	useStateDir(t)
+	resetHostedZoneCache(t)
+	const certArn = "arn:aws:acm:us-east-1:111111111111:certificate/new"
+	fake := newFakeAWS(t)
+	fake.on("ListHostedZonesByName", hostedZones(map[string]string{"example.com.": "Z_PARENT", "sub.example.com.": "Z_SUB"}))
+	var validationZone string
+	fake.on("ChangeResourceRecordSets", func(input any) (any, error) {
+		validationZone = aws.ToString(input.(*route53.ChangeResourceRecordSetsInput).HostedZoneId)
+		return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+	})
+	onCertificateRequest(fake, certArn, func() acmtypes.CertificateStatus {
+		if fake.count("ChangeResourceRecordSets") > 0 {
+			return acmtypes.CertificateStatusIssued
+		}
+		return acmtypes.CertificateStatusPendingValidation
+	})
+
+	appConfig := &AppConfig{CAACheck: caaCheckOff, CertPollInterval: time.Millisecond, CertValidationWait: 5 * time.Second}
+	record := RecordConfig{ZoneID: "Z_PARENT", RecordName: "www.sub.example.com", TLS: true}
+	if err := manageCertificateLifecycle(context.Background(), appConfig, fake.acm(), fake.route53(), record); err != nil {
+		t.Fatal(err)
+	}
+	if validationZone != "Z_SUB" {
+		t.Errorf("validation record written to %q, want the delegated zone Z_SUB", validationZone)
+	}
+	if stored, _ := getStoredString(certStateFile(certStateKey(record))); stored != certArn {
+		t.Errorf("stored ARN = %q, want %q", stored, certArn)
+	}
+}
+
+func TestValidationWaitStopsOnShutdown(t *testing.T) {
+	useStateDir(t)
+	const certArn = "arn:aws:acm:us-east-1:111111111111:certificate/pending"
+	fake := newFakeAWS(t)
+	fake.on("ChangeResourceRecordSets", func(any) (any, error) {
+		return &route53.ChangeResourceRecordSetsOutput{ChangeInfo: &r53types.ChangeInfo{Id: aws.String("C1")}}, nil
+	})
+	// waiting is closed by the first describe after the validation record
+	// was written, which is the waiter's.
+	waiting := make(chan struct{})
+	var once sync.Once
+	onCertificateRequest(fake, certArn, func() acmtypes.CertificateStatus {
+		if fake.count("ChangeResourceRecordSets") > 0 {
+			once.Do(func() { close(waiting) })
+		}
+		return acmtypes.CertificateStatusPendingValidation
+	})
+
+	// With an hour between polls, only the cancellation can end the wait.
+	appConfig := &AppConfig{CAACheck: caaCheckOff, CertPollInterval: time.Hour, CertValidationWait: 2 * time.Hour}
+	record := RecordConfig{ZoneID: "Z1", ValidationZoneID: "Z1", RecordName: "www.sub.example.com", TLS: true}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- manageCertificateLifecycle(ctx, appConfig, fake.acm(), fake.route53(), record) }()
+	select {
+	case <-waiting:
+	case err := <-done:
+		t.Fatalf("manageCertificateLifecycle() returned %v before waiting for validation", err)
+	}
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("manageCertificateLifecycle() = %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("manageCertificateLifecycle() did not return after the context was cancelled")
+	}
+
+	pendingFile := certPendingFile(certStateKey(record))
+	if pending, _ := getStoredString(pendingFile); pending != certArn {
+		t.Errorf("pending ARN = %q, want %q kept for the next start", pending, certArn)
+	}
+	if stored, _ := getStoredString(certStateFile(certStateKey(record))); stored != "" {
+		t.Errorf("certificate ARN = %q, want none until validation completes", stored)
+	}
+
+	// The next start resumes the pending certificate instead of requesting
+	// another one.
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := manageCertificateLifecycle(ctx, appConfig, fake.acm(), fake.route53(), record); !errors.Is(err, context.Canceled) {
+		t.Errorf("resumed manageCertificateLifecycle() = %v, want context.Canceled", err)
+	}
+	if n := fake.count("RequestCertificate"); n != 1 {
+		t.Errorf("RequestCertificate was called %d times, want the pending certificate resumed", n)
+	}
+}
+
+// staticIPSource answers every IP check with ip.
+type staticIPSource struct{ ip string }
+
+func (s staticIPSource) Name() string { return "static:" + s.ip }
+
+func (s staticIPSource) GetIP(context.Context, addressFamily) (string, error) { return s.ip, nil }
+
+func TestReservedAddressesAreNotPublished(t *testing.T) {
+	tests := []struct {
+		ip      string
+		family  addressFamily
+		wantErr bool
+	}{
+		{"198.41.0.4", ipv4Family, false},
+		{"0.0.0.0", ipv4Family, true},
+		{"0.1.2.3", ipv4Family, true},
+		{"192.0.0.8", ipv4Family, true},
+		{"192.0.2.1", ipv4Family, true},
+		{"198.18.0.1", ipv4Family, true},
+		{"198.19.255.254", ipv4Family, true},
+		{"198.51.100.7", ipv4Family, true},
+		{"203.0.113.99", ipv4Family, true},
+		{"240.0.0.1", ipv4Family, true},
+		{"255.255.255.255", ipv4Family, true},
+		{"2606:4700:4700::1111", ipv6Family, false},
+		{"2001:db8::1", ipv6Family, true},
+		{"fd00::1", ipv6Family, true},
+		{"64:ff9b::c629:4", ipv6Family, true},
+		{"fe80::1", ipv6Family, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ip, func(t *testing.T) {
+			err := checkPublishableIP(tt.ip, tt.family)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkPublishableIP(%s) = %v, want an error: %t", tt.ip, err, tt.wantErr)
+			}
+			if !tt.wantErr {
+				return
+			}
+
+			useStateDir(t)
+			fake := newFakeAWS(t)
+			fake.on("ChangeResourceRecordSets", func(any) (any, error) { return nil, fmt.Errorf("records must not be written") })
+			appConfig := &AppConfig{RecordsToUpdate: []RecordConfig{{ZoneID: "Z1", RecordName: "home.example.com", IPv6: tt.family == ipv6Family, TTL: 300}}}
+			err = syncAddress(context.Background(), appConfig, fake.route53(), tt.family, []ipSource{staticIPSource{tt.ip}}, true, "")
+			if err == nil {
+				t.Errorf("syncAddress() published %s", tt.ip)
+			}
+			if n := fake.count("ChangeResourceRecordSets"); n > 0 {
+				t.Errorf("ChangeResourceRecordSets was called %d times", n)
+			}
+			if stored, _ := getStoredString(tt.family.StateFile); stored != "" {
+				t.Errorf("stored address = %q, want none", stored)
+			}
+		})
+	}
+}
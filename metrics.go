@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// --- Prometheus Metrics ---
+
+var (
+	recordUpdateAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_record_update_attempts_total",
+		Help: "Route53 record updates attempted, by record and type.",
+	}, []string{"record", "type"})
+
+	recordUpdateFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_record_update_failures_total",
+		Help: "Route53 record updates that failed, by record and type.",
+	}, []string{"record", "type"})
+
+	ipCheckFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_ip_check_failures_total",
+		Help: "Public IP detections that failed or returned an unusable address, by family.",
+	}, []string{"family"})
+
+	ipChanges = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_ip_changes_total",
+		Help: "Detected changes of the public IP address, by family.",
+	}, []string{"family"})
+
+	propagationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_propagation_failures_total",
+		Help: "Record updates not seen on every authoritative name server within the verification window, by record and type.",
+	}, []string{"record", "type"})
+
+	certRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_certificate_requests_total",
+		Help: "ACM certificates requested, by domain.",
+	}, []string{"domain"})
+
+	notificationFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_notification_failures_total",
+		Help: "Notifications that could not be delivered, by notifier.",
+	}, []string{"notifier"})
+
+	notificationsDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_notifications_dropped_total",
+		Help: "Notifications not sent because NOTIFY_RATE_LIMIT was reached, by notifier.",
+	}, []string{"notifier"})
+
+	certExpiry = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "auto_route53_certificate_expiry_timestamp_seconds",
+		Help: "Expiry of the issued ACM certificate, as a Unix timestamp, by domain.",
+	}, []string{"domain"})
+
+	awsAPIErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "auto_route53_aws_api_errors_total",
+		Help: "AWS API calls that failed after retries, by service and operation.",
+	}, []string{"service", "operation"})
+)
+
+// countAPIErrors is an AWS SDK middleware that records every failed API call
+// in awsAPIErrors. It sits in the initialize step, so an operation that
+// succeeds after retries is not counted.
+func countAPIErrors(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("CountAPIErrors",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if err != nil {
+				awsAPIErrors.WithLabelValues(awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)).Inc()
+			}
+			return out, metadata, err
+		}), middleware.After)
+}
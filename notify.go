@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// --- Notifications ---
+
+// The events notifications are sent for.
+const (
+	eventIPChanged    = "ip_changed"
+	eventUpdated      = "records_updated"
+	eventUpdateFail   = "update_failed"
+	eventUpdatesStuck = "updates_failing"
+	eventCertIssued   = "certificate_issued"
+	eventCertFailure  = "certificate_failed"
+	eventCertTimeout  = "certificate_validation_timeout"
+	eventCertExpiring = "certificate_expiring"
+)
+
+var notifyEvents = []string{eventIPChanged, eventUpdated, eventUpdateFail, eventUpdatesStuck, eventCertIssued, eventCertFailure, eventCertTimeout, eventCertExpiring}
+
+// criticalNotifyEvents are the events that need someone to act, which is
+// all that email is sent for by default.
+var criticalNotifyEvents = []string{eventUpdatesStuck, eventCertTimeout, eventCertExpiring}
+
+const (
+	// defaultNotifyFailureThreshold is how many cycles in a row an address
+	// family must fail to update before updates_failing is sent.
+	defaultNotifyFailureThreshold = 3
+	// certExpiringRepeat is how often certificate_expiring is repeated for
+	// a certificate that is still about to expire.
+	certExpiringRepeat = 24 * time.Hour
+)
+
+var (
+	failureStreaksMu sync.Mutex
+	// failureStreaks counts the cycles in a row that failed to update the
+	// records of each address family.
+	failureStreaks = map[string]int{}
+	// expiryNotified holds when certificate_expiring was last sent for
+	// each domain.
+	expiryNotified sync.Map
+)
+
+// notifyTimeout bounds a single notification, so that a slow endpoint only
+// delays the cycle that sent it by this much.
+const notifyTimeout = 10 * time.Second
+
+// notifyEvent is what a notification is sent about. Webhooks receive it as
+// JSON.
+type notifyEvent struct {
+	Type    string            `json:"event"`
+	Time    time.Time         `json:"time"`
+	Record  string            `json:"record,omitempty"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// notifier delivers events to one destination.
+type notifier interface {
+	// Name identifies the destination in logs and metrics.
+	Name() string
+	Send(ctx context.Context, event notifyEvent) error
+}
+
+// notifyTarget is a notifier and the events it is sent.
+type notifyTarget struct {
+	notifier
+	events []string
+}
+
+// slowNotifier is implemented by notifiers that need longer than
+// notifyTimeout.
+type slowNotifier interface {
+	Timeout() time.Duration
+}
+
+// parseNotifyEvents reads a list of event names, defaulting to every event.
+func parseNotifyEvents(settings *configSource, key string) ([]string, error) {
+	return parseNotifyEventsDefault(settings, key, notifyEvents)
+}
+
+// parseNotifyEventsDefault is parseNotifyEvents with other default events.
+func parseNotifyEventsDefault(settings *configSource, key string, defaults []string) ([]string, error) {
+	events := settings.GetList(key, defaults)
+	for _, event := range events {
+		if !slices.Contains(notifyEvents, event) {
+			return nil, fmt.Errorf("invalid %s entry %q: must be one of %v", key, event, notifyEvents)
+		}
+	}
+	return events, nil
+}
+
+// notify sends event to every target that wants it, concurrently, and waits
+// for them. It is also streamed to gRPC subscribers, even in dry run.
+// Failures are logged and counted but not returned, since a notification
+// must not fail the work it reports on. With a coalescing window, an event
+// of a type that was sent within the window is held and sent, together with
+// the others held, when the window closes.
+func notify(ctx context.Context, appConfig *AppConfig, event notifyEvent) {
+	event.Time = time.Now().UTC()
+	publishEvent(event)
+	logger := loggerFrom(ctx)
+	var wg sync.WaitGroup
+	for _, target := range appConfig.Notifiers {
+		if !slices.Contains(target.events, event.Type) {
+			continue
+		}
+		if appConfig.DryRun {
+			logger.Info("DRY RUN: Would send notification", "notifier", target.Name(), "event", event.Type, "record", event.Record)
+			continue
+		}
+		gate := notifyGateFor(target.Name())
+		if appConfig.NotifyCoalesceWindow > 0 && gate.hold(event, appConfig.NotifyCoalesceWindow, func(held []notifyEvent) {
+			// The window outlives the cycle that opened it.
+			deliverNotification(context.Background(), appConfig, target, gate, coalesceEvents(held))
+		}) {
+			logger.Debug("Holding notification until the coalescing window closes", "notifier", target.Name(), "event", event.Type)
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			deliverNotification(ctx, appConfig, target, gate, event)
+		}()
+	}
+	wg.Wait()
+}
+
+// deliverNotification sends event to target, unless that would exceed
+// NOTIFY_RATE_LIMIT.
+func deliverNotification(ctx context.Context, appConfig *AppConfig, target notifyTarget, gate *notifyGate, event notifyEvent) {
+	logger := loggerFrom(ctx)
+	if appConfig.NotifyRateLimit > 0 && !gate.allow(appConfig.NotifyRateLimit, time.Now()) {
+		notificationsDropped.WithLabelValues(target.Name()).Inc()
+		logger.Warn("Notification dropped, the rate limit was reached", "notifier", target.Name(), "event", event.Type, "limit_per_hour", appConfig.NotifyRateLimit)
+		return
+	}
+	timeout := notifyTimeout
+	if slow, ok := target.notifier.(slowNotifier); ok {
+		timeout = slow.Timeout()
+	}
+	sendCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	defer cancel()
+	if err := target.Send(sendCtx, event); err != nil {
+		notificationFailures.WithLabelValues(target.Name()).Inc()
+		logger.Warn("Notification failed", "notifier", target.Name(), "event", event.Type, "error", err)
+		return
+	}
+	logger.Debug("Sent notification", "notifier", target.Name(), "event", event.Type)
+}
+
+// notifyGates holds the coalescing and rate limit state of each notifier, by
+// name, so that it is kept across reloads.
+var notifyGates sync.Map
+
+// notifyGate coalesces and rate limits the notifications of one notifier.
+type notifyGate struct {
+	mu sync.Mutex
+	// held has an entry for each event type whose coalescing window is
+	// open, with the events that arrived since it opened.
+	held map[string][]notifyEvent
+	// sent holds when each notification of the last hour was sent.
+	sent []time.Time
+}
+
+func notifyGateFor(name string) *notifyGate {
+	gate, _ := notifyGates.LoadOrStore(name, &notifyGate{held: map[string][]notifyEvent{}})
+	return gate.(*notifyGate)
+}
+
+// hold reports whether event was held because the coalescing window of its
+// type is open. If not, it opens the window, which calls flush with the
+// events held in it, if any, once window has passed.
+func (g *notifyGate) hold(event notifyEvent, window time.Duration, flush func(held []notifyEvent)) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if held, open := g.held[event.Type]; open {
+		g.held[event.Type] = append(held, event)
+		return true
+	}
+	g.held[event.Type] = nil
+	time.AfterFunc(window, func() {
+		g.mu.Lock()
+		held := g.held[event.Type]
+		delete(g.held, event.Type)
+		g.mu.Unlock()
+		if len(held) > 0 {
+			flush(held)
+		}
+	})
+	return false
+}
+
+// allow reports whether fewer than limit notifications were sent in the hour
+// before now, and counts one more if so.
+func (g *notifyGate) allow(limit int, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	cutoff := now.Add(-time.Hour)
+	g.sent = slices.DeleteFunc(g.sent, func(sent time.Time) bool { return !sent.After(cutoff) })
+	if len(g.sent) >= limit {
+		return false
+	}
+	g.sent = append(g.sent, now)
+	return true
+}
+
+// coalesceEvents combines events of one type into a single notification: the
+// latest event, with every event's message and the number of events in the
+// coalesced_events detail.
+func coalesceEvents(events []notifyEvent) notifyEvent {
+	if len(events) == 1 {
+		return events[0]
+	}
+	combined := events[len(events)-1]
+	messages := make([]string, 0, len(events))
+	for _, event := range events {
+		messages = append(messages, event.Message)
+		if event.Record != combined.Record {
+			combined.Record = ""
+		}
+	}
+	combined.Message = strings.Join(messages, "\n")
+	combined.Details = maps.Clone(combined.Details)
+	if combined.Details == nil {
+		combined.Details = map[string]string{}
+	}
+	combined.Details["coalesced_events"] = strconv.Itoa(len(events))
+	return combined
+}
+
+// newNotifyHTTPClient returns the client notifiers use for HTTP endpoints.
+func newNotifyHTTPClient(rootCAs *x509.CertPool) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: clientTLSConfig(rootCAs),
+		},
+	}
+}
+
+// redactURL shortens an endpoint to its scheme and host for logs, since
+// webhook URLs often carry a token in the path or query.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	return parsed.Scheme + "://" + parsed.Host
+}
+
+// unwrapURLError strips the URL from an error returned by http.Client.Do.
+func unwrapURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return urlErr.Err
+	}
+	return err
+}
+
+// postJSON posts body to endpoint as JSON with the given extra headers.
+func postJSON(ctx context.Context, client *http.Client, endpoint string, body []byte, headers map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", defaultIPCheckUserAgent)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error includes the URL, which may hold a token.
+		return fmt.Errorf("request to %s failed: %w", redactURL(endpoint), unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("bad status: %s", resp.Status)
+	}
+	return nil
+}
+
+// webhookNotifier posts the event as JSON. With a secret, the body is signed
+// with HMAC-SHA256 and the signature sent, hex encoded, as
+// "X-Auto-Route53-Signature: sha256=<signature>", so that receivers can check
+// the request came from us.
+type webhookNotifier struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func (n *webhookNotifier) Name() string { return "webhook " + redactURL(n.url) }
+
+func (n *webhookNotifier) Send(ctx context.Context, event notifyEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{"X-Auto-Route53-Event": event.Type}
+	if n.secret != "" {
+		mac := hmac.New(sha256.New, []byte(n.secret))
+		mac.Write(body)
+		headers["X-Auto-Route53-Signature"] = "sha256=" + hex.EncodeToString(mac.Sum(nil))
+	}
+	return postJSON(ctx, n.client, n.url, body, headers)
+}
+
+// loadNotifiers builds the notification targets from the configuration.
+func loadNotifiers(settings *configSource, rootCAs *x509.CertPool) ([]notifyTarget, error) {
+	client := newNotifyHTTPClient(rootCAs)
+	var targets []notifyTarget
+
+	webhookURLs := settings.GetList("WEBHOOK_URLS", nil)
+	if len(webhookURLs) > 0 {
+		events, err := parseNotifyEvents(settings, "WEBHOOK_EVENTS")
+		if err != nil {
+			return nil, err
+		}
+		secret := settings.Get("WEBHOOK_SECRET")
+		for _, webhookURL := range webhookURLs {
+			if err := validateEndpointURL("WEBHOOK_URLS entry", webhookURL); err != nil {
+				return nil, err
+			}
+			targets = append(targets, notifyTarget{&webhookNotifier{url: webhookURL, secret: secret, client: client}, events})
+		}
+	}
+	chatTargets, err := loadChatNotifiers(settings, client)
+	if err != nil {
+		return nil, err
+	}
+	awsTargets, err := loadAWSNotifiers(settings)
+	if err != nil {
+		return nil, err
+	}
+	hookTargets, err := loadHooks(settings)
+	if err != nil {
+		return nil, err
+	}
+	return slices.Concat(targets, chatTargets, awsTargets, hookTargets), nil
+}
+
+// recordUpdateOutcome tracks the failure streak of an address family and
+// sends updates_failing when it reaches NOTIFY_FAILURE_THRESHOLD. A nil err
+// ends the streak.
+func recordUpdateOutcome(ctx context.Context, appConfig *AppConfig, family addressFamily, err error) {
+	failureStreaksMu.Lock()
+	if err == nil {
+		delete(failureStreaks, family.Name)
+		failureStreaksMu.Unlock()
+		return
+	}
+	failureStreaks[family.Name]++
+	streak := failureStreaks[family.Name]
+	failureStreaksMu.Unlock()
+	if streak != appConfig.NotifyFailureLimit {
+		return
+	}
+	notify(ctx, appConfig, notifyEvent{
+		Type:    eventUpdatesStuck,
+		Message: fmt.Sprintf("%s records have failed to update %d times in a row", family.Name, streak),
+		Details: map[string]string{"family": family.Name, "error": err.Error()},
+	})
+}
+
+// notifyCertificateExpiring sends certificate_expiring for a domain, at most
+// once every certExpiringRepeat.
+func notifyCertificateExpiring(ctx context.Context, appConfig *AppConfig, domainName string, notAfter time.Time, details map[string]string) {
+	if last, ok := expiryNotified.Load(domainName); ok && time.Since(last.(time.Time)) < certExpiringRepeat {
+		return
+	}
+	expiryNotified.Store(domainName, time.Now())
+	details["expires"] = notAfter.Format(time.RFC3339)
+	notify(ctx, appConfig, notifyEvent{
+		Type:    eventCertExpiring,
+		Record:  domainName,
+		Message: fmt.Sprintf("Certificate for %s expires in %s", domainName, time.Until(notAfter).Round(time.Hour)),
+		Details: details,
+	})
+}
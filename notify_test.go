@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingNotifier is a notifier that keeps what it is sent.
+type recordingNotifier struct {
+	name   string
+	mu     sync.Mutex
+	events []notifyEvent
+}
+
+func (n *recordingNotifier) Name() string { return n.name }
+
+func (n *recordingNotifier) Send(_ context.Context, event notifyEvent) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, event)
+	return nil
+}
+
+func (n *recordingNotifier) sent() []notifyEvent {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return append([]notifyEvent(nil), n.events...)
+}
+
+// useNotifier returns a config that sends every event to a new
+// recordingNotifier, with fresh coalescing and rate limit state.
+func useNotifier(t *testing.T) (*AppConfig, *recordingNotifier) {
+	t.Helper()
+	notifyGates.Clear()
+	t.Cleanup(notifyGates.Clear)
+	target := &recordingNotifier{name: "test"}
+	return &AppConfig{Notifiers: []notifyTarget{{notifier: target, events: notifyEvents}}}, target
+}
+
+func TestNotifyCoalescesFlappingEvents(t *testing.T) {
+	appConfig, target := useNotifier(t)
+	appConfig.NotifyCoalesceWindow = 100 * time.Millisecond
+	for range 50 {
+		notify(context.Background(), appConfig, notifyEvent{Type: eventIPChanged, Record: "home.example.com", Message: "changed", Details: map[string]string{"public_ip": "198.41.0.4"}})
+	}
+	notify(context.Background(), appConfig, notifyEvent{Type: eventUpdated, Message: "updated"})
+	if sent := target.sent(); len(sent) != 2 {
+		t.Fatalf("sent %d notifications within the window, want the first of each type", len(sent))
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for len(target.sent()) < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	sent := target.sent()
+	if len(sent) != 3 {
+		t.Fatalf("sent %d notifications, want 3 once the window closed", len(sent))
+	}
+	combined := sent[2]
+	if combined.Type != eventIPChanged || combined.Record != "home.example.com" || combined.Details["coalesced_events"] != "49" {
+		t.Errorf("coalesced notification = %+v, want the 49 held ip_changed events", combined)
+	}
+	if combined.Details["public_ip"] != "198.41.0.4" {
+		t.Errorf("coalesced notification lost the details of the latest event: %v", combined.Details)
+	}
+
+	// The window is closed, so the next event is sent at once.
+	notify(context.Background(), appConfig, notifyEvent{Type: eventIPChanged})
+	if sent := target.sent(); len(sent) != 4 {
+		t.Errorf("sent %d notifications, want the event after the window sent at once", len(sent))
+	}
+}
+
+func TestNotifyRateLimit(t *testing.T) {
+	appConfig, target := useNotifier(t)
+	appConfig.NotifyRateLimit = 3
+	for range 10 {
+		notify(context.Background(), appConfig, notifyEvent{Type: eventUpdateFail})
+	}
+	if sent := target.sent(); len(sent) != 3 {
+		t.Errorf("sent %d notifications, want the limit of 3", len(sent))
+	}
+
+	gate := notifyGateFor(target.Name())
+	if !gate.allow(3, time.Now().Add(time.Hour+time.Second)) {
+		t.Error("the rate limit should allow notifications again an hour later")
+	}
+}
+
+func TestCoalesceEventsMixedRecords(t *testing.T) {
+	combined := coalesceEvents([]notifyEvent{
+		{Type: eventUpdateFail, Record: "a.example.com", Message: "a failed"},
+		{Type: eventUpdateFail, Record: "b.example.com", Message: "b failed"},
+	})
+	if combined.Record != "" || combined.Message != "a failed\nb failed" || combined.Details["coalesced_events"] != "2" {
+		t.Errorf("coalesceEvents() = %+v, want no record, both messages and a count of 2", combined)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// --- Record Ownership ---
+
+// ownershipHeritage marks the ownership TXT records written by this tool, in
+// the same key=value format external-dns uses.
+const (
+	ownershipHeritage         = "heritage=auto-route53"
+	defaultOwnershipTXTPrefix = "_auto-route53."
+)
+
+// ownershipRecordName is the companion TXT record for a managed record. It
+// lives under a prefix so that it never collides with the user's own TXT
+// records (SPF, verification tokens) at the record name itself.
+func ownershipRecordName(appConfig *AppConfig, recordName string) string {
+	return appConfig.OwnershipTXTPrefix + recordName
+}
+
+func ownershipValue(ownerID string) string {
+	return fmt.Sprintf("%q", ownershipHeritage+",owner="+ownerID)
+}
+
+// parseOwner returns the owner named in an ownership TXT value, or "" if the
+// value was not written by this tool.
+func parseOwner(value string) string {
+	value = strings.Trim(value, `"`)
+	if !strings.HasPrefix(value, ownershipHeritage+",") {
+		return ""
+	}
+	for _, field := range strings.Split(value, ",") {
+		if owner, ok := strings.CutPrefix(field, "owner="); ok {
+			return owner
+		}
+	}
+	return ""
+}
+
+// checkOwnership decides whether this instance may write the record. A
+// record is ours if its ownership TXT names our OWNER_ID, or if neither the
+// record nor a TXT exists yet. Records owned by someone else, or existing
+// without any owner, are only taken over with OWNERSHIP_FORCE.
+func checkOwnership(ctx context.Context, appConfig *AppConfig, client *route53.Client, record RecordConfig, recordType r53types.RRType) error {
+	txt, err := liveRecordValues(ctx, client, record.ZoneID, ownershipRecordName(appConfig, record.RecordName), r53types.RRTypeTxt, record.SetIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to check ownership: %w", err)
+	}
+	var owners []string
+	for _, value := range txt {
+		if owner := parseOwner(value); owner != "" {
+			if owner == appConfig.OwnerID {
+				return nil
+			}
+			owners = append(owners, owner)
+		}
+	}
+
+	if len(owners) > 0 {
+		if appConfig.OwnershipForce {
+			loggerFrom(ctx).Warn("Taking over record owned by another deployment", "record", record.RecordName, "owner", strings.Join(owners, ","))
+			return nil
+		}
+		return fmt.Errorf("%s is owned by %q, not %q; set OWNERSHIP_FORCE=true to take it over", record.RecordName, strings.Join(owners, ","), appConfig.OwnerID)
+	}
+
+	existing, err := liveRecordValues(ctx, client, record.ZoneID, record.RecordName, recordType, record.SetIdentifier)
+	if err != nil {
+		return fmt.Errorf("failed to check ownership: %w", err)
+	}
+	if existing != nil && !appConfig.OwnershipForce {
+		return fmt.Errorf("%s %s already exists and has no ownership record; set OWNERSHIP_FORCE=true to adopt it", recordType, record.RecordName)
+	}
+	return nil
+}
+
+// ownershipChange upserts the ownership TXT record alongside an update, so
+// that the claim and the record change land in the same atomic batch. A
+// routed record's TXT joins a record set with the same routing policy, so
+// every member of the set carries its own claim.
+func ownershipChange(appConfig *AppConfig, record RecordConfig) r53types.Change {
+	recordSet := &r53types.ResourceRecordSet{
+		Name: aws.String(ownershipRecordName(appConfig, record.RecordName)),
+		Type: r53types.RRTypeTxt,
+		TTL:  aws.Int64(record.TTL),
+		ResourceRecords: []r53types.ResourceRecord{
+			{Value: aws.String(ownershipValue(appConfig.OwnerID))},
+		},
+	}
+	applyRoutingPolicy(recordSet, record)
+	return r53types.Change{Action: r53types.ChangeActionUpsert, ResourceRecordSet: recordSet}
+}
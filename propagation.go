@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+)
+
+// --- Propagation Verification ---
+
+const (
+	defaultPropagationTimeout = 2 * time.Minute
+	propagationPollInterval   = 10 * time.Second
+)
+
+// zoneNameServers caches the delegation set of each hosted zone by ID.
+var zoneNameServers sync.Map
+
+func authoritativeNameServers(ctx context.Context, client *route53.Client, zoneID string) ([]string, error) {
+	if cached, ok := zoneNameServers.Load(zoneID); ok {
+		return cached.([]string), nil
+	}
+	output, err := client.GetHostedZone(ctx, &route53.GetHostedZoneInput{Id: &zoneID})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get name servers of zone %s: %w", zoneID, err)
+	}
+	if output.DelegationSet == nil || len(output.DelegationSet.NameServers) == 0 {
+		return nil, fmt.Errorf("zone %s has no delegation set", zoneID)
+	}
+	servers := output.DelegationSet.NameServers
+	zoneNameServers.Store(zoneID, servers)
+	return servers, nil
+}
+
+// queryAuthoritative asks one name server for the record and reports
+// whether value is among the answers.
+func queryAuthoritative(ctx context.Context, server, name string, recordType r53types.RRType, value string) (bool, error) {
+	qtype, ok := dns.StringToType[string(recordType)]
+	if !ok {
+		return false, fmt.Errorf("cannot verify records of type %s", recordType)
+	}
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(name), qtype)
+	msg.RecursionDesired = false
+	client := new(dns.Client)
+	resp, _, err := client.ExchangeContext(ctx, msg, net.JoinHostPort(strings.TrimSuffix(server, "."), "53"))
+	if err != nil {
+		return false, err
+	}
+	for _, rr := range resp.Answer {
+		var got string
+		switch answer := rr.(type) {
+		case *dns.A:
+			got = answer.A.String()
+		case *dns.AAAA:
+			got = answer.AAAA.String()
+		default:
+			continue
+		}
+		if got == value {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// verifyPropagation polls every authoritative name server of the record's
+// zone until they all answer with value, or until the timeout. It only
+// reports what it found; the update itself already succeeded.
+func verifyPropagation(ctx context.Context, appConfig *AppConfig, client *route53.Client, record RecordConfig, recordType r53types.RRType, value string) error {
+	logger := loggerFrom(ctx)
+	servers, err := authoritativeNameServers(ctx, client, record.ZoneID)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, appConfig.PropagationTimeout)
+	defer cancel()
+	pending := servers
+	for {
+		var stillPending []string
+		for _, server := range pending {
+			ok, err := queryAuthoritative(ctx, server, record.RecordName, recordType, value)
+			if err != nil {
+				logger.Debug("Authoritative query failed", "record", record.RecordName, "server", server, "error", err)
+			}
+			if !ok {
+				stillPending = append(stillPending, server)
+			}
+		}
+		if len(stillPending) == 0 {
+			logger.Info("Record verified on all authoritative name servers", "record", record.RecordName, "type", recordType, "servers", len(servers))
+			return nil
+		}
+		pending = stillPending
+
+		select {
+		case <-ctx.Done():
+			propagationFailures.WithLabelValues(record.RecordName, string(recordType)).Inc()
+			return fmt.Errorf("%s %s did not return %s on %s within %s", recordType, record.RecordName, value, strings.Join(pending, ", "), appConfig.PropagationTimeout)
+		case <-time.After(propagationPollInterval):
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+)
+
+// --- Reverse (PTR) Records ---
+
+// normalizeReverseZones validates the reverse zone settings of a record.
+func normalizeReverseZones(record *RecordConfig) error {
+	record.ReverseZoneID = strings.TrimSpace(record.ReverseZoneID)
+	record.IPv6ReverseZoneID = strings.TrimSpace(record.IPv6ReverseZoneID)
+	if record.ReverseZoneID == "" && record.IPv6ReverseZoneID == "" {
+		return nil
+	}
+	if record.isStatic() {
+		return fmt.Errorf("record %s has a reverse zone, which only applies to records that follow the public IP", record.RecordName)
+	}
+	if record.Mode == recordModeAppend || record.SetIdentifier != "" {
+		return fmt.Errorf("record %s has a reverse zone, which needs a record holding only this host's address", record.RecordName)
+	}
+	return nil
+}
+
+// reverseZoneFor returns the reverse zone of the record for the family.
+func reverseZoneFor(record RecordConfig, family addressFamily) string {
+	if family.RecordType == r53types.RRTypeAaaa {
+		return record.IPv6ReverseZoneID
+	}
+	return record.ReverseZoneID
+}
+
+// syncReverseRecords points the PTR record of newIP at each record that has
+// a reverse zone, and deletes the PTR record of oldIP if it still points at
+// the record. A PTR record that names another host is left alone.
+func syncReverseRecords(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, records []RecordConfig, family addressFamily, oldIP, newIP string) error {
+	logger := loggerFrom(ctx)
+	var updates []recordUpdate
+	for _, record := range records {
+		zoneID := reverseZoneFor(record, family)
+		if zoneID == "" {
+			continue
+		}
+		target := record.RecordName + "."
+		ptrName, err := dns.ReverseAddr(newIP)
+		if err != nil {
+			return fmt.Errorf("failed to build PTR name for %s: %w", newIP, err)
+		}
+		ptr := RecordConfig{ZoneID: zoneID, RecordName: canonicalName(ptrName), TTL: record.TTL, RoleARN: record.RoleARN, ExternalID: record.ExternalID, Profile: record.Profile}
+		updates = append(updates, recordUpdate{Record: ptr, Type: r53types.RRTypePtr, Values: []string{target}})
+
+		if oldIP == "" || oldIP == newIP {
+			continue
+		}
+		oldName, err := dns.ReverseAddr(oldIP)
+		if err != nil {
+			continue
+		}
+		old := RecordConfig{ZoneID: zoneID, RecordName: canonicalName(oldName), TTL: record.TTL, RoleARN: record.RoleARN, ExternalID: record.ExternalID, Profile: record.Profile}
+		live, err := liveRecordSet(ctx, route53For(r53Client, record), zoneID, old.RecordName, r53types.RRTypePtr, "")
+		if err != nil {
+			logger.Warn("Could not read the previous PTR record, leaving it in place", "record", old.RecordName, "error", err)
+			continue
+		}
+		if live != nil && sameValues(recordSetValues(live), []string{target}) {
+			updates = append(updates, recordUpdate{Record: old, Type: r53types.RRTypePtr, Replaces: live, Delete: true})
+		}
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for i, err := range updateRoute53Records(ctx, appConfig, r53Client, updates) {
+		if err != nil {
+			logger.Error("PTR record update failed", "record", updates[i].Record.RecordName, "zone_id", updates[i].Record.ZoneID, "error", err)
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
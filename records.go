@@ -0,0 +1,337 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// --- Static Records ---
+
+// staticRecordTypes are the record types a record may list fixed values for.
+var staticRecordTypes = map[string]bool{
+	"A": true, "AAAA": true, "CAA": true, "CNAME": true, "DS": true, "MX": true,
+	"NAPTR": true, "NS": true, "PTR": true, "SPF": true, "SRV": true, "TXT": true,
+}
+
+// isStatic reports whether the record has fixed values or an alias target
+// rather than following the public address.
+func (r RecordConfig) isStatic() bool {
+	return len(r.Values) > 0 || r.Alias != nil
+}
+
+// staticValues is what the record should hold, in the form liveRecordValues
+// reports it.
+func (r RecordConfig) staticValues() []string {
+	if r.Alias != nil {
+		return []string{describeAlias(r.Alias.DNSName, r.Alias.HostedZoneID, r.Alias.EvaluateTargetHealth)}
+	}
+	return r.Values
+}
+
+// quoteTXT turns a plain string into the quoted form Route53 expects for TXT
+// and SPF values, splitting it into 255 byte character-strings. Values that
+// are already quoted are passed through.
+func quoteTXT(value string) string {
+	if strings.HasPrefix(value, `"`) {
+		return value
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+	var parts []string
+	for len(escaped) > 255 {
+		cut := 255
+		// Never split an escape sequence.
+		if escaped[cut-1] == '\\' {
+			cut--
+		}
+		parts = append(parts, `"`+escaped[:cut]+`"`)
+		escaped = escaped[cut:]
+	}
+	parts = append(parts, `"`+escaped+`"`)
+	return strings.Join(parts, " ")
+}
+
+// normalizeRecordType validates the record's type and values, and folds the
+// single value shorthand into Values.
+func normalizeRecordType(record *RecordConfig) error {
+	record.Type = strings.ToUpper(strings.TrimSpace(record.Type))
+	if record.Value != "" {
+		if len(record.Values) > 0 {
+			return fmt.Errorf("record %s sets both value and values", record.RecordName)
+		}
+		record.Values = []string{record.Value}
+		record.Value = ""
+	}
+	for i, value := range record.Values {
+		record.Values[i] = strings.TrimSpace(value)
+		if record.Values[i] == "" {
+			return fmt.Errorf("record %s has an empty value", record.RecordName)
+		}
+	}
+
+	if record.Alias != nil {
+		return normalizeAlias(record)
+	}
+	if !record.isStatic() {
+		if record.Type != "" && record.Type != "A" && record.Type != "AAAA" {
+			return fmt.Errorf("record %s of type %s needs values; only A and AAAA records can follow the public IP", record.RecordName, record.Type)
+		}
+		return nil
+	}
+
+	if record.Type == "" {
+		record.Type = string(r53types.RRTypeA)
+	}
+	if !staticRecordTypes[record.Type] {
+		return fmt.Errorf("record %s has an unsupported type %q", record.RecordName, record.Type)
+	}
+	if record.Type == "CNAME" && len(record.Values) != 1 {
+		return fmt.Errorf("CNAME record %s must have exactly one value", record.RecordName)
+	}
+	if record.IPv6 {
+		return fmt.Errorf("record %s has values, so ipv6 does not apply", record.RecordName)
+	}
+	if record.Type == "A" || record.Type == "AAAA" {
+		for _, value := range record.Values {
+			addr, err := netip.ParseAddr(value)
+			if err != nil || addr.Is4() != (record.Type == "A") {
+				return fmt.Errorf("record %s has an invalid %s value %q", record.RecordName, record.Type, value)
+			}
+		}
+	}
+	if record.Type == "TXT" || record.Type == "SPF" {
+		for i, value := range record.Values {
+			record.Values[i] = quoteTXT(value)
+		}
+	}
+	return nil
+}
+
+// staticRecords returns the records with fixed values.
+func staticRecords(records []RecordConfig) []RecordConfig {
+	var selected []RecordConfig
+	for _, record := range records {
+		if record.isStatic() {
+			selected = append(selected, record)
+		}
+	}
+	return selected
+}
+
+// sameValues reports whether two record value lists hold the same values,
+// in any order.
+func sameValues(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.Sort(a)
+	slices.Sort(b)
+	return slices.Equal(a, b)
+}
+
+// syncStaticRecords compares each static record with its live values and
+// writes the ones that are missing or have drifted, so that changes made
+// outside the tool are undone on the next cycle. With force set every static
+// record is written. A record whose live values cannot be read is written
+// anyway.
+func syncStaticRecords(ctx context.Context, appConfig *AppConfig, r53Client *route53.Client, force bool) error {
+	logger := loggerFrom(ctx)
+	var updates []recordUpdate
+	for _, record := range staticRecords(appConfig.RecordsToUpdate) {
+		recordType := r53types.RRType(record.Type)
+		if !force {
+			live, err := liveRecordValues(ctx, route53For(r53Client, record), record.ZoneID, record.RecordName, recordType, record.SetIdentifier)
+			switch {
+			case err != nil:
+				logger.Warn("Could not read live record, writing it anyway", "record", record.RecordName, "type", recordType, "error", err)
+			case sameValues(live, record.staticValues()):
+				status.recordSynced(record.RecordName, record.Type, strings.Join(record.staticValues(), ", "), false)
+				continue
+			case live != nil:
+				logger.Warn("Static record has drifted, restoring it", "record", record.RecordName, "type", recordType, "live", live, "want", record.staticValues())
+			}
+		}
+		updates = append(updates, recordUpdate{Record: record, Type: recordType, Values: record.Values})
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for i, err := range updateRoute53Records(ctx, appConfig, r53Client, updates) {
+		record := updates[i].Record
+		if err != nil {
+			logger.Error("Static record update failed", "record", record.RecordName, "zone_id", record.ZoneID, "type", record.Type, "error", err)
+			status.recordFailed(record.RecordName, record.Type, err)
+			errs = append(errs, err)
+			continue
+		}
+		status.recordSynced(record.RecordName, record.Type, strings.Join(record.staticValues(), ", "), true)
+	}
+	return errors.Join(errs...)
+}
+
+// --- Alias Records ---
+
+// cloudFrontHostedZoneID is the fixed hosted zone of every CloudFront
+// distribution. Load balancers and S3 websites have one per region.
+const cloudFrontHostedZoneID = "Z2FDTNDATAQYW2"
+
+// AliasConfig points a record at an AWS resource, such as a load balancer,
+// CloudFront distribution or S3 website, instead of listing values.
+type AliasConfig struct {
+	DNSName              string `json:"dns_name"`
+	HostedZoneID         string `json:"hosted_zone_id,omitempty"`
+	EvaluateTargetHealth bool   `json:"evaluate_target_health,omitempty"`
+}
+
+func normalizeAlias(record *RecordConfig) error {
+	alias := record.Alias
+	alias.DNSName = canonicalName(alias.DNSName)
+	alias.HostedZoneID = strings.TrimSpace(alias.HostedZoneID)
+	if len(record.Values) > 0 {
+		return fmt.Errorf("record %s sets both alias and values", record.RecordName)
+	}
+	if record.IPv6 {
+		return fmt.Errorf("record %s is an alias, so ipv6 does not apply; add a second record with type AAAA instead", record.RecordName)
+	}
+	if record.Type == "" {
+		record.Type = string(r53types.RRTypeA)
+	}
+	if record.Type != "A" && record.Type != "AAAA" {
+		return fmt.Errorf("alias record %s must be of type A or AAAA", record.RecordName)
+	}
+	if alias.DNSName == "" {
+		return fmt.Errorf("alias record %s has no dns_name", record.RecordName)
+	}
+	if alias.HostedZoneID == "" {
+		if !strings.HasSuffix(alias.DNSName, ".cloudfront.net") {
+			return fmt.Errorf("alias record %s needs the hosted_zone_id of its target", record.RecordName)
+		}
+		alias.HostedZoneID = cloudFrontHostedZoneID
+	}
+	return nil
+}
+
+// describeAlias renders an alias target as a single value, so that alias
+// records can be compared and logged like records with values.
+func describeAlias(dnsName, hostedZoneID string, evaluateTargetHealth bool) string {
+	description := fmt.Sprintf("ALIAS %s (zone %s)", canonicalName(dnsName), hostedZoneID)
+	if evaluateTargetHealth {
+		description += " evaluating target health"
+	}
+	return description
+}
+
+// --- Multi-Value Records ---
+
+const (
+	recordModeReplace = "replace"
+	recordModeAppend  = "append"
+)
+
+func normalizeRecordMode(record *RecordConfig) error {
+	record.Mode = strings.ToLower(strings.TrimSpace(record.Mode))
+	switch record.Mode {
+	case "":
+		record.Mode = recordModeReplace
+	case recordModeReplace:
+	case recordModeAppend:
+		if record.isStatic() {
+			return fmt.Errorf("record %s uses mode append, which only applies to records that follow the public IP", record.RecordName)
+		}
+	default:
+		return fmt.Errorf("record %s has an invalid mode %q, expected replace or append", record.RecordName, record.Mode)
+	}
+	return nil
+}
+
+// appendAddress turns update into an append of newIP to the live record set,
+// dropping oldIP (this host's previous address) and keeping the addresses
+// other hosts added. The live set is replaced rather than upserted, so that
+// two hosts changing it at once cannot silently drop each other's address:
+// the second batch fails and is retried on the next cycle.
+func appendAddress(ctx context.Context, client *route53.Client, update *recordUpdate, oldIP, newIP string) error {
+	record := update.Record
+	live, err := liveRecordSet(ctx, client, record.ZoneID, record.RecordName, update.Type, record.SetIdentifier)
+	if err != nil {
+		return err
+	}
+	if live == nil {
+		return nil
+	}
+	if live.AliasTarget != nil {
+		return fmt.Errorf("%s %s is an alias record, so addresses cannot be appended to it", update.Type, record.RecordName)
+	}
+	values := []string{}
+	for _, value := range recordSetValues(live) {
+		if value != oldIP && value != newIP {
+			values = append(values, value)
+		}
+	}
+	update.Values = append(values, newIP)
+	update.Replaces = live
+	return nil
+}
+
+// --- Routing Policies ---
+
+// normalizeRoutingPolicy validates the routing policy fields. A record may
+// use at most one policy, and needs a set_identifier exactly when it does.
+func normalizeRoutingPolicy(record *RecordConfig) error {
+	record.SetIdentifier = strings.TrimSpace(record.SetIdentifier)
+	record.Region = strings.ToLower(strings.TrimSpace(record.Region))
+	record.Failover = strings.ToUpper(strings.TrimSpace(record.Failover))
+
+	policies := 0
+	if record.Weight != nil {
+		policies++
+		if *record.Weight < 0 || *record.Weight > 255 {
+			return fmt.Errorf("record %s has an invalid weight %d, expected 0-255", record.RecordName, *record.Weight)
+		}
+	}
+	if record.Region != "" {
+		policies++
+	}
+	if record.Failover != "" {
+		policies++
+		if record.Failover != string(r53types.ResourceRecordSetFailoverPrimary) && record.Failover != string(r53types.ResourceRecordSetFailoverSecondary) {
+			return fmt.Errorf("record %s has an invalid failover %q, expected PRIMARY or SECONDARY", record.RecordName, record.Failover)
+		}
+	}
+
+	switch {
+	case policies > 1:
+		return fmt.Errorf("record %s sets more than one of weight, region and failover", record.RecordName)
+	case policies == 1 && record.SetIdentifier == "":
+		return fmt.Errorf("record %s has a routing policy but no set_identifier", record.RecordName)
+	case policies == 0 && record.SetIdentifier != "":
+		return fmt.Errorf("record %s has a set_identifier but no weight, region or failover", record.RecordName)
+	case len(record.SetIdentifier) > 128:
+		return fmt.Errorf("record %s has a set_identifier longer than 128 characters", record.RecordName)
+	}
+	return nil
+}
+
+// applyRoutingPolicy copies the record's routing policy onto a record set.
+func applyRoutingPolicy(recordSet *r53types.ResourceRecordSet, record RecordConfig) {
+	if record.SetIdentifier == "" {
+		return
+	}
+	recordSet.SetIdentifier = aws.String(record.SetIdentifier)
+	recordSet.Weight = record.Weight
+	if record.Region != "" {
+		recordSet.Region = r53types.ResourceRecordSetRegion(record.Region)
+	}
+	if record.Failover != "" {
+		recordSet.Failover = r53types.ResourceRecordSetFailover(record.Failover)
+	}
+}
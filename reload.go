@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// --- Configuration Reload ---
+
+// configPollInterval is how often CONFIG_FILE is checked for changes.
+const configPollInterval = 10 * time.Second
+
+// currentConfig is the configuration in effect. A reload swaps it; the DDNS
+// loop and the HTTP server load it afresh for every cycle or request.
+var currentConfig atomic.Pointer[AppConfig]
+
+// watchConfig calls reload on SIGHUP and whenever the modification time or
+// size of path changes. Polling, rather than watching the file, also picks up
+// editors and Kubernetes ConfigMaps that replace the file instead of writing
+// to it.
+func watchConfig(ctx context.Context, path string, reload func(reason string)) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var lastMod time.Time
+	var lastSize int64
+	if path != "" {
+		if info, err := os.Stat(path); err == nil {
+			lastMod, lastSize = info.ModTime(), info.Size()
+		}
+	}
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			reload("SIGHUP")
+		case <-ticker.C:
+			if path == "" {
+				continue
+			}
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			if !info.ModTime().Equal(lastMod) || info.Size() != lastSize {
+				lastMod, lastSize = info.ModTime(), info.Size()
+				reload("config file changed")
+			}
+		}
+	}
+}
+
+// keepRestartOnlySettings copies the settings that only take effect at
+// startup from old into next, warning about any that were changed.
+func keepRestartOnlySettings(old, next *AppConfig) {
+	restartOnly := []struct {
+		name      string
+		old, next *string
+	}{
+		{"HTTP_ADDR", &old.HTTPAddr, &next.HTTPAddr},
+		{"GRPC_ADDR", &old.GRPCAddr, &next.GRPCAddr},
+		{"RFC2136_ADDR", &old.RFC2136Addr, &next.RFC2136Addr},
+		{"LOG_FORMAT", &old.LogFormat, &next.LogFormat},
+		{"LOG_LEVEL", &old.LogLevel, &next.LogLevel},
+		{"NPM_URL", &old.NPMBaseURL, &next.NPMBaseURL},
+		{"NPM_IDENTITY", &old.NPMIdentity, &next.NPMIdentity},
+		{"NPM_SECRET", &old.NPMSecret, &next.NPMSecret},
+		{"STATE_BACKEND", &old.StateBackend, &next.StateBackend},
+		{"STATE_S3_BUCKET", &old.StateS3Bucket, &next.StateS3Bucket},
+		{"STATE_S3_PREFIX", &old.StateS3Prefix, &next.StateS3Prefix},
+		{"STATE_DYNAMODB_TABLE", &old.StateDynamoDBTable, &next.StateDynamoDBTable},
+		{"STATE_SSM_PREFIX", &old.StateSSMPrefix, &next.StateSSMPrefix},
+		{"LEADER_ELECTION", &old.LeaderElection, &next.LeaderElection},
+		{"LEADER_ELECTION_TABLE", &old.LeaderElectionTable, &next.LeaderElectionTable},
+		{"LEADER_ELECTION_BUCKET", &old.LeaderElectionBucket, &next.LeaderElectionBucket},
+		{"LEADER_ELECTION_KEY", &old.LeaderElectionKey, &next.LeaderElectionKey},
+		{"RETRY_MODE", &old.RetryMode, &next.RetryMode},
+		{"AWS_ENDPOINT_URL", &old.AWSEndpointURL, &next.AWSEndpointURL},
+		{"CA_BUNDLE", &old.CABundle, &next.CABundle},
+		{"OTEL_EXPORTER_OTLP_ENDPOINT", &old.OTLPTracesEndpoint, &next.OTLPTracesEndpoint},
+	}
+	for _, setting := range restartOnly {
+		if *setting.old != *setting.next {
+			slog.Warn("Setting changed but only takes effect after a restart", "setting", setting.name)
+			*setting.next = *setting.old
+		}
+	}
+	next.RunOnce = old.RunOnce
+	next.CertConcurrency = old.CertConcurrency
+	next.LeaderLeaseDuration = old.LeaderLeaseDuration
+	next.RetryMaxAttempts = old.RetryMaxAttempts
+	next.RetryMaxBackoff = old.RetryMaxBackoff
+	next.APITimeout = old.APITimeout
+	next.APIConnectTimeout = old.APIConnectTimeout
+	next.AWSServiceEndpoints = old.AWSServiceEndpoints
+	next.UseFIPSEndpoint = old.UseFIPSEndpoint
+	next.RootCAs = old.RootCAs
+	next.AWSAccountID = old.AWSAccountID
+}
+
+// ddnsRecordsChanged reports whether a reload changed the records the DDNS
+// loop keeps up to date, in which case they must be written even though the
+// public address has not changed.
+func ddnsRecordsChanged(old, next *AppConfig) bool {
+	for _, family := range []addressFamily{ipv4Family, ipv6Family} {
+		if !reflect.DeepEqual(recordsForFamily(old.RecordsToUpdate, family), recordsForFamily(next.RecordsToUpdate, family)) {
+			return true
+		}
+	}
+	return !reflect.DeepEqual(staticRecords(old.RecordsToUpdate), staticRecords(next.RecordsToUpdate))
+}
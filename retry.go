@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/ratelimit"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+)
+
+// --- AWS API Retries and Timeouts ---
+
+const (
+	retryModeStandard = "standard"
+	retryModeAdaptive = "adaptive"
+
+	// Route53 allows five requests per second per account, and returns
+	// Throttling or PriorRequestNotComplete well before most clients notice
+	// load. The SDK default of three attempts gives up within a second.
+	defaultRetryMaxAttempts = 8
+	defaultRetryMaxBackoff  = 20 * time.Second
+
+	// defaultAPITimeout bounds each attempt of an AWS API call, so that a
+	// connection that stalls on a flaky link is retried instead of hanging.
+	defaultAPITimeout        = 30 * time.Second
+	defaultAPIConnectTimeout = 10 * time.Second
+)
+
+// newRetryer returns the retryer used for every AWS client: the SDK's
+// standard retryer, which already treats throttling and
+// PriorRequestNotComplete as retryable, with exponential backoff and full
+// jitter capped at RETRY_MAX_BACKOFF. The client-side retry quota is turned
+// off, since its token bucket would stop retrying exactly when Route53 is
+// throttling a burst of updates. In adaptive mode, requests are additionally
+// rate limited on the client once throttling is seen.
+func newRetryer(appConfig *AppConfig) func() aws.Retryer {
+	standard := func(o *retry.StandardOptions) {
+		o.MaxAttempts = appConfig.RetryMaxAttempts
+		o.MaxBackoff = appConfig.RetryMaxBackoff
+		o.Backoff = retry.NewExponentialJitterBackoff(appConfig.RetryMaxBackoff)
+		o.RateLimiter = ratelimit.None
+	}
+	return func() aws.Retryer {
+		if appConfig.RetryMode == retryModeAdaptive {
+			return retry.NewAdaptiveMode(func(o *retry.AdaptiveModeOptions) {
+				o.StandardOptions = append(o.StandardOptions, standard)
+			})
+		}
+		return retry.NewStandard(standard)
+	}
+}
+
+// newAPIHTTPClient returns the HTTP client used for every AWS client, with
+// the API_TIMEOUT, API_CONNECT_TIMEOUT and CA_BUNDLE settings applied.
+func newAPIHTTPClient(appConfig *AppConfig) *awshttp.BuildableClient {
+	return awshttp.NewBuildableClient().
+		WithTimeout(appConfig.APITimeout).
+		WithDialerOptions(func(d *net.Dialer) {
+			d.Timeout = appConfig.APIConnectTimeout
+		}).
+		WithTransportOptions(func(t *http.Transport) {
+			if appConfig.RootCAs != nil {
+				t.TLSClientConfig.RootCAs = appConfig.RootCAs
+			}
+		})
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+	"github.com/miekg/dns"
+)
+
+// --- RFC 2136 Gateway ---
+
+// tsigAlgorithms are the TSIG algorithms keys may use, by the name given in
+// RFC2136_TSIG_KEYS.
+var tsigAlgorithms = map[string]string{
+	"hmac-sha1":   dns.HmacSHA1,
+	"hmac-sha224": dns.HmacSHA224,
+	"hmac-sha256": dns.HmacSHA256,
+	"hmac-sha384": dns.HmacSHA384,
+	"hmac-sha512": dns.HmacSHA512,
+}
+
+// tsigKey is a key that may sign DNS UPDATE messages.
+type tsigKey struct {
+	Name      string // fully qualified and lower case, as on the wire
+	Algorithm string // one of the dns.Hmac* names
+	Secret    []byte
+}
+
+// rfc2136Zone is a zone that DNS UPDATE messages may change. Without a
+// ZoneID, the public hosted zone is looked up by name.
+type rfc2136Zone struct {
+	Name   string // fully qualified and lower case
+	ZoneID string
+}
+
+// parseTSIGKeys reads RFC2136_TSIG_KEYS, a list of keys in the
+// [algorithm:]name:secret form that nsupdate -y takes.
+func parseTSIGKeys(settings *configSource) ([]tsigKey, error) {
+	var keys []tsigKey
+	for _, entry := range settings.GetList("RFC2136_TSIG_KEYS", nil) {
+		parts := strings.Split(entry, ":")
+		algorithm := dns.HmacSHA256
+		if len(parts) == 3 {
+			var ok bool
+			if algorithm, ok = tsigAlgorithms[strings.ToLower(parts[0])]; !ok {
+				return nil, fmt.Errorf("invalid RFC2136_TSIG_KEYS algorithm %q: must be one of hmac-sha1, hmac-sha224, hmac-sha256, hmac-sha384 or hmac-sha512", parts[0])
+			}
+			parts = parts[1:]
+		}
+		// The entry holds the secret, so it is left out of the errors.
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid RFC2136_TSIG_KEYS entry: expected [algorithm:]name:secret")
+		}
+		secret, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid RFC2136_TSIG_KEYS entry for key %s: the secret must be base64", parts[0])
+		}
+		keys = append(keys, tsigKey{Name: dns.CanonicalName(parts[0]), Algorithm: algorithm, Secret: secret})
+	}
+	return keys, nil
+}
+
+// parseRFC2136Zones reads RFC2136_ZONES, a list of zone names, each
+// optionally followed by =<zone ID>.
+func parseRFC2136Zones(settings *configSource) ([]rfc2136Zone, error) {
+	var zones []rfc2136Zone
+	for _, entry := range settings.GetList("RFC2136_ZONES", nil) {
+		name, zoneID, _ := strings.Cut(entry, "=")
+		name, zoneID = strings.TrimSpace(name), strings.TrimSpace(zoneID)
+		if name == "" || strings.ContainsAny(name, " \t\r\n") {
+			return nil, fmt.Errorf("invalid RFC2136_ZONES entry %q: expected zone name[=zone ID]", entry)
+		}
+		zones = append(zones, rfc2136Zone{Name: dns.CanonicalName(name), ZoneID: zoneID})
+	}
+	return zones, nil
+}
+
+// tsigKeyring signs and verifies messages with the keys of the configuration
+// in effect, so that a reload can add or revoke keys.
+type tsigKeyring struct{}
+
+func (tsigKeyring) mac(msg []byte, t *dns.TSIG) ([]byte, error) {
+	var key *tsigKey
+	for _, candidate := range currentConfig.Load().RFC2136Keys {
+		if candidate.Name == dns.CanonicalName(t.Hdr.Name) {
+			key = &candidate
+			break
+		}
+	}
+	if key == nil {
+		return nil, dns.ErrSecret
+	}
+	// A key is only good for the algorithm it was configured with.
+	if dns.CanonicalName(t.Algorithm) != key.Algorithm {
+		return nil, dns.ErrKeyAlg
+	}
+	var h hash.Hash
+	switch key.Algorithm {
+	case dns.HmacSHA1:
+		h = hmac.New(sha1.New, key.Secret)
+	case dns.HmacSHA224:
+		h = hmac.New(sha256.New224, key.Secret)
+	case dns.HmacSHA256:
+		h = hmac.New(sha256.New, key.Secret)
+	case dns.HmacSHA384:
+		h = hmac.New(sha512.New384, key.Secret)
+	case dns.HmacSHA512:
+		h = hmac.New(sha512.New, key.Secret)
+	default:
+		return nil, dns.ErrKeyAlg
+	}
+	h.Write(msg)
+	return h.Sum(nil), nil
+}
+
+func (k tsigKeyring) Generate(msg []byte, t *dns.TSIG) ([]byte, error) {
+	return k.mac(msg, t)
+}
+
+func (k tsigKeyring) Verify(msg []byte, t *dns.TSIG) error {
+	expected, err := k.mac(msg, t)
+	if err != nil {
+		return err
+	}
+	mac, err := hex.DecodeString(t.MAC)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(expected, mac) {
+		return dns.ErrSig
+	}
+	return nil
+}
+
+// acceptUpdates lets DNS UPDATE messages through, whose sections can hold
+// any number of records, and answers anything else with NOTIMP.
+func acceptUpdates(dh dns.Header) dns.MsgAcceptAction {
+	const qrBit = 1 << 15
+	if dh.Bits&qrBit != 0 {
+		return dns.MsgIgnore
+	}
+	if int(dh.Bits>>11)&0xF != dns.OpcodeUpdate {
+		return dns.MsgRejectNotImplemented
+	}
+	if dh.Qdcount != 1 {
+		return dns.MsgReject
+	}
+	return dns.MsgAccept
+}
+
+// errUpdateRefused marks an update that the gateway will not make, such as
+// one changing the zone's SOA or an alias record.
+var errUpdateRefused = errors.New("update refused")
+
+// rrsetKey identifies a record set of the zone being updated.
+type rrsetKey struct {
+	name   string
+	rrtype uint16
+}
+
+// pendingSet is a record set as the update leaves it.
+type pendingSet struct {
+	live   *r53types.ResourceRecordSet // nil if the set does not exist yet
+	ttl    int64
+	values []string
+}
+
+// rfc2136Gateway turns DNS UPDATE messages into Route53 change batches.
+type rfc2136Gateway struct {
+	r53Client *route53.Client
+}
+
+func (g *rfc2136Gateway) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	ctx := withCycle(context.Background(), newCycleID())
+	reply := new(dns.Msg)
+	reply.SetRcode(r, g.update(ctx, w, r))
+	// Replies to a signed request are signed too, unless the signature
+	// was bad, which the client could not verify a signed reply with.
+	if t := r.IsTsig(); t != nil && w.TsigStatus() == nil {
+		reply.SetTsig(t.Hdr.Name, t.Algorithm, 300, time.Now().Unix())
+	}
+	if err := w.WriteMsg(reply); err != nil {
+		loggerFrom(ctx).Warn("Failed to answer DNS UPDATE", "remote_addr", w.RemoteAddr().String(), "error", err)
+	}
+}
+
+// update carries out a DNS UPDATE and returns its RCODE.
+func (g *rfc2136Gateway) update(ctx context.Context, w dns.ResponseWriter, r *dns.Msg) int {
+	logger := loggerFrom(ctx).With("remote_addr", w.RemoteAddr().String())
+	t := r.IsTsig()
+	if t == nil {
+		logger.Warn("Refusing unsigned DNS UPDATE")
+		return dns.RcodeRefused
+	}
+	logger = logger.With("key", strings.TrimSuffix(t.Hdr.Name, "."))
+	if err := w.TsigStatus(); err != nil {
+		logger.Warn("Refusing DNS UPDATE with a bad signature", "error", err)
+		return dns.RcodeNotAuth
+	}
+	question := r.Question[0]
+	if question.Qtype != dns.TypeSOA || question.Qclass != dns.ClassINET {
+		return dns.RcodeFormatError
+	}
+	appConfig := currentConfig.Load()
+	zoneName := dns.CanonicalName(question.Name)
+	i := slices.IndexFunc(appConfig.RFC2136Zones, func(zone rfc2136Zone) bool { return zone.Name == zoneName })
+	if i < 0 {
+		logger.Warn("Refusing DNS UPDATE for a zone not in RFC2136_ZONES", "zone", zoneName)
+		return dns.RcodeRefused
+	}
+	if status.snapshot().Standby {
+		logger.Warn("Refusing DNS UPDATE, this instance is a standby")
+		return dns.RcodeServerFailure
+	}
+	zoneID := appConfig.RFC2136Zones[i].ZoneID
+	if zoneID == "" {
+		var err error
+		zoneID, err = findHostedZoneID(ctx, g.r53Client, zoneName)
+		if err != nil {
+			logger.Error("DNS UPDATE failed", "zone", zoneName, "error", err)
+			return dns.RcodeServerFailure
+		}
+		if zoneID == "" {
+			logger.Warn("No public hosted zone matches the DNS UPDATE zone", "zone", zoneName)
+			return dns.RcodeRefused
+		}
+	}
+	logger = logger.With("zone", canonicalName(zoneName))
+	ctx = context.WithValue(ctx, loggerKey{}, logger)
+
+	if rcode, err := g.checkPrerequisites(ctx, zoneID, zoneName, r.Answer); rcode != dns.RcodeSuccess {
+		logger.Info("DNS UPDATE prerequisites not met", "rcode", dns.RcodeToString[rcode], "error", err)
+		return rcode
+	}
+	changes, rcode, err := g.changes(ctx, zoneID, zoneName, r.Ns)
+	if rcode != dns.RcodeSuccess {
+		logger.Warn("Refusing DNS UPDATE", "rcode", dns.RcodeToString[rcode], "error", err)
+		return rcode
+	}
+	if len(changes) == 0 {
+		logger.Info("DNS UPDATE changes nothing")
+		return dns.RcodeSuccess
+	}
+
+	var names []string
+	for _, change := range changes {
+		if name := canonicalName(aws.ToString(change.ResourceRecordSet.Name)); !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	batch := changes
+	if appConfig.OwnerID != "" {
+		batch, err = g.ownedChanges(ctx, appConfig, zoneID, changes)
+		if err != nil {
+			logger.Warn("Refusing DNS UPDATE", "rcode", dns.RcodeToString[dns.RcodeRefused], "error", err)
+			return dns.RcodeRefused
+		}
+	}
+	err = applyChangeBatch(ctx, appConfig, g.r53Client, &route53.ChangeResourceRecordSetsInput{
+		HostedZoneId: aws.String(zoneID),
+		ChangeBatch: &r53types.ChangeBatch{
+			Comment: aws.String("DNS UPDATE signed by " + strings.TrimSuffix(t.Hdr.Name, ".")),
+			Changes: batch,
+		},
+	})
+	details := map[string]string{"source": "rfc2136", "key": strings.TrimSuffix(t.Hdr.Name, "."), "zone": canonicalName(zoneName), "records": strings.Join(names, ",")}
+	if err != nil {
+		logger.Error("DNS UPDATE failed", "error", err)
+		details["error"] = err.Error()
+		notify(ctx, appConfig, notifyEvent{
+			Type:    eventUpdateFail,
+			Message: fmt.Sprintf("DNS UPDATE of %s failed", strings.Join(names, ", ")),
+			Details: details,
+		})
+		return dns.RcodeServerFailure
+	}
+	logger.Info("DNS UPDATE applied", "zone_id", zoneID, "changes", len(changes), "records", strings.Join(names, ","))
+	notify(ctx, appConfig, notifyEvent{
+		Type:    eventUpdated,
+		Message: fmt.Sprintf("DNS UPDATE changed %d record set(s) of %s", len(changes), strings.Join(names, ", ")),
+		Details: details,
+	})
+	return dns.RcodeSuccess
+}
+
+// ownedChanges checks that this instance owns every record set the changes
+// touch, as updateRoute53Records does for the configured records, and adds
+// the ownership TXT records of the names they write. The ownership records
+// themselves cannot be updated, or any key could take over a record.
+func (g *rfc2136Gateway) ownedChanges(ctx context.Context, appConfig *AppConfig, zoneID string, changes []r53types.Change) ([]r53types.Change, error) {
+	owned := slices.Clone(changes)
+	var claimed []string
+	for _, change := range changes {
+		set := change.ResourceRecordSet
+		name := canonicalName(aws.ToString(set.Name))
+		if appConfig.OwnershipTXTPrefix != "" && strings.HasPrefix(name, appConfig.OwnershipTXTPrefix) {
+			return nil, fmt.Errorf("%w: %s is an ownership record", errUpdateRefused, name)
+		}
+		record := RecordConfig{ZoneID: zoneID, RecordName: name, TTL: aws.ToInt64(set.TTL)}
+		if err := checkOwnership(ctx, appConfig, g.r53Client, record, set.Type); err != nil {
+			return nil, err
+		}
+		if change.Action != r53types.ChangeActionDelete && !slices.Contains(claimed, name) {
+			claimed = append(claimed, name)
+			owned = append(owned, ownershipChange(appConfig, record))
+		}
+	}
+	return owned, nil
+}
+
+// checkPrerequisites evaluates the prerequisite section of an update
+// (RFC 2136 section 3.2) against the live records. Route53 cannot make the
+// check and the change atomic, so a concurrent change may slip in between.
+func (g *rfc2136Gateway) checkPrerequisites(ctx context.Context, zoneID, zoneName string, prereqs []dns.RR) (int, error) {
+	expected := map[rrsetKey][]string{}
+	var order []rrsetKey
+	for _, rr := range prereqs {
+		header := rr.Header()
+		name := dns.CanonicalName(header.Name)
+		if !dns.IsSubDomain(zoneName, name) {
+			return dns.RcodeNotZone, fmt.Errorf("%s is outside the zone", name)
+		}
+		if header.Ttl != 0 {
+			return dns.RcodeFormatError, fmt.Errorf("prerequisite for %s has a TTL", name)
+		}
+		switch header.Class {
+		case dns.ClassANY, dns.ClassNONE:
+			if header.Rdlength != 0 {
+				return dns.RcodeFormatError, fmt.Errorf("prerequisite for %s has data", name)
+			}
+			var exists bool
+			if header.Rrtype == dns.TypeANY {
+				sets, err := liveNameSets(ctx, g.r53Client, zoneID, name)
+				if err != nil {
+					return dns.RcodeServerFailure, err
+				}
+				exists = len(sets) > 0
+			} else {
+				set, err := liveRecordSet(ctx, g.r53Client, zoneID, name, r53types.RRType(dns.TypeToString[header.Rrtype]), "")
+				if err != nil {
+					return dns.RcodeServerFailure, err
+				}
+				exists = set != nil
+			}
+			switch {
+			case header.Class == dns.ClassANY && header.Rrtype == dns.TypeANY && !exists:
+				return dns.RcodeNameError, fmt.Errorf("%s does not exist", name)
+			case header.Class == dns.ClassANY && !exists:
+				return dns.RcodeNXRrset, fmt.Errorf("no %s %s record set", dns.TypeToString[header.Rrtype], name)
+			case header.Class == dns.ClassNONE && header.Rrtype == dns.TypeANY && exists:
+				return dns.RcodeYXDomain, fmt.Errorf("%s exists", name)
+			case header.Class == dns.ClassNONE && exists:
+				return dns.RcodeYXRrset, fmt.Errorf("a %s %s record set exists", dns.TypeToString[header.Rrtype], name)
+			}
+		case dns.ClassINET:
+			key := rrsetKey{name, header.Rrtype}
+			if _, ok := expected[key]; !ok {
+				order = append(order, key)
+			}
+			expected[key] = append(expected[key], rdata(rr))
+		default:
+			return dns.RcodeFormatError, fmt.Errorf("prerequisite for %s has class %s", name, dns.ClassToString[header.Class])
+		}
+	}
+	// A value-dependent prerequisite holds if the set has exactly the
+	// given values.
+	for _, key := range order {
+		set, err := liveRecordSet(ctx, g.r53Client, zoneID, key.name, r53types.RRType(dns.TypeToString[key.rrtype]), "")
+		if err != nil {
+			return dns.RcodeServerFailure, err
+		}
+		var live []string
+		if set != nil && set.AliasTarget == nil {
+			live = liveRdata(set)
+		}
+		want := expected[key]
+		slices.Sort(want)
+		if !sameValues(slices.Compact(want), live) {
+			return dns.RcodeNXRrset, fmt.Errorf("the %s %s record set does not hold the expected values", dns.TypeToString[key.rrtype], key.name)
+		}
+	}
+	return dns.RcodeSuccess, nil
+}
+
+// changes works out the Route53 changes that carry out the update section
+// (RFC 2136 section 3.4) and returns them as one batch, so that the update
+// is applied all or nothing.
+func (g *rfc2136Gateway) changes(ctx context.Context, zoneID, zoneName string, updates []dns.RR) ([]r53types.Change, int, error) {
+	// The whole section is checked before anything is read from Route53.
+	for _, rr := range updates {
+		header := rr.Header()
+		name := dns.CanonicalName(header.Name)
+		if !dns.IsSubDomain(zoneName, name) {
+			return nil, dns.RcodeNotZone, fmt.Errorf("%s is outside the zone", name)
+		}
+		switch header.Class {
+		case dns.ClassINET:
+			if header.Rrtype == dns.TypeANY || header.Rrtype == dns.TypeAXFR || header.Rrtype == dns.TypeIXFR {
+				return nil, dns.RcodeFormatError, fmt.Errorf("cannot add a %s record", dns.TypeToString[header.Rrtype])
+			}
+		case dns.ClassANY:
+			if header.Ttl != 0 || header.Rdlength != 0 {
+				return nil, dns.RcodeFormatError, fmt.Errorf("deletion of %s has a TTL or data", name)
+			}
+		case dns.ClassNONE:
+			if header.Ttl != 0 || header.Rrtype == dns.TypeANY {
+				return nil, dns.RcodeFormatError, fmt.Errorf("deletion of a %s record has a TTL or type ANY", name)
+			}
+		default:
+			return nil, dns.RcodeFormatError, fmt.Errorf("update of %s has class %s", name, dns.ClassToString[header.Class])
+		}
+		if header.Rrtype == dns.TypeANY {
+			continue
+		}
+		if header.Rrtype == dns.TypeSOA || header.Rrtype == dns.TypeNS && name == zoneName {
+			return nil, dns.RcodeRefused, fmt.Errorf("%w: Route53 manages the %s records of the zone apex", errUpdateRefused, dns.TypeToString[header.Rrtype])
+		}
+		if !slices.Contains(r53types.RRType("").Values(), r53types.RRType(dns.TypeToString[header.Rrtype])) {
+			return nil, dns.RcodeRefused, fmt.Errorf("%w: Route53 does not support %s records", errUpdateRefused, dns.TypeToString[header.Rrtype])
+		}
+	}
+
+	sets := map[rrsetKey]*pendingSet{}
+	var order []rrsetKey
+	load := func(key rrsetKey, live *r53types.ResourceRecordSet) (*pendingSet, error) {
+		if set, ok := sets[key]; ok {
+			return set, nil
+		}
+		if live == nil {
+			var err error
+			live, err = liveRecordSet(ctx, g.r53Client, zoneID, key.name, r53types.RRType(dns.TypeToString[key.rrtype]), "")
+			if err != nil {
+				return nil, err
+			}
+		}
+		set := &pendingSet{live: live}
+		if live != nil {
+			if live.AliasTarget != nil {
+				return nil, fmt.Errorf("%w: %s %s is an alias record", errUpdateRefused, live.Type, key.name)
+			}
+			set.ttl = aws.ToInt64(live.TTL)
+			set.values = liveRdata(live)
+		}
+		sets[key] = set
+		order = append(order, key)
+		return set, nil
+	}
+	for _, rr := range updates {
+		header := rr.Header()
+		name := dns.CanonicalName(header.Name)
+		if header.Class == dns.ClassANY && header.Rrtype == dns.TypeANY {
+			// Deleting every set at a name leaves the apex SOA and NS,
+			// aliases and routed sets alone, which the protocol has no
+			// notion of.
+			live, err := liveNameSets(ctx, g.r53Client, zoneID, name)
+			if err != nil {
+				return nil, dns.RcodeServerFailure, err
+			}
+			for i := range live {
+				rrtype := dns.StringToType[string(live[i].Type)]
+				if live[i].AliasTarget != nil || live[i].SetIdentifier != nil || rrtype == dns.TypeSOA || rrtype == dns.TypeNS && name == zoneName {
+					continue
+				}
+				if _, err := load(rrsetKey{name, rrtype}, &live[i]); err != nil {
+					return nil, dns.RcodeRefused, err
+				}
+			}
+			for key, set := range sets {
+				if key.name == name {
+					set.values = nil
+				}
+			}
+			continue
+		}
+		set, err := load(rrsetKey{name, header.Rrtype}, nil)
+		if errors.Is(err, errUpdateRefused) {
+			return nil, dns.RcodeRefused, err
+		} else if err != nil {
+			return nil, dns.RcodeServerFailure, err
+		}
+		switch header.Class {
+		case dns.ClassINET:
+			if value := rdata(rr); !slices.Contains(set.values, value) {
+				set.values = append(set.values, value)
+			}
+			set.ttl = int64(header.Ttl)
+		case dns.ClassANY:
+			set.values = nil
+		case dns.ClassNONE:
+			set.values = slices.DeleteFunc(set.values, func(value string) bool { return value == rdata(rr) })
+		}
+	}
+
+	var changes []r53types.Change
+	for _, key := range order {
+		set := sets[key]
+		switch {
+		case len(set.values) == 0 && set.live == nil:
+		case len(set.values) == 0:
+			changes = append(changes, r53types.Change{Action: r53types.ChangeActionDelete, ResourceRecordSet: set.live})
+		case set.live != nil && set.ttl == aws.ToInt64(set.live.TTL) && sameValues(set.values, liveRdata(set.live)):
+		default:
+			records := make([]r53types.ResourceRecord, 0, len(set.values))
+			for _, value := range set.values {
+				records = append(records, r53types.ResourceRecord{Value: aws.String(value)})
+			}
+			changes = append(changes, r53types.Change{
+				Action: r53types.ChangeActionUpsert,
+				ResourceRecordSet: &r53types.ResourceRecordSet{
+					Name:            aws.String(key.name),
+					Type:            r53types.RRType(dns.TypeToString[key.rrtype]),
+					TTL:             aws.Int64(set.ttl),
+					ResourceRecords: records,
+				},
+			})
+		}
+	}
+	return changes, dns.RcodeSuccess, nil
+}
+
+// rdata returns the data of rr in presentation format, which is also the
+// form Route53 takes record values in.
+func rdata(rr dns.RR) string {
+	return strings.TrimPrefix(rr.String(), rr.Header().String())
+}
+
+// liveRdata returns the values of a Route53 record set in the form rdata
+// produces, so that they compare equal to the records of an update.
+func liveRdata(set *r53types.ResourceRecordSet) []string {
+	values := make([]string, 0, len(set.ResourceRecords))
+	for _, record := range set.ResourceRecords {
+		value := aws.ToString(record.Value)
+		if rr, err := dns.NewRR(fmt.Sprintf(". 0 IN %s %s", set.Type, value)); err == nil && rr != nil {
+			value = rdata(rr)
+		}
+		values = append(values, value)
+	}
+	return values
+}
+
+// liveNameSets returns the record sets at name. Names with more than a
+// hundred sets are not expected and only their first hundred are returned.
+func liveNameSets(ctx context.Context, client *route53.Client, zoneID, name string) ([]r53types.ResourceRecordSet, error) {
+	output, err := client.ListResourceRecordSets(ctx, &route53.ListResourceRecordSetsInput{
+		HostedZoneId:    aws.String(zoneID),
+		StartRecordName: aws.String(name),
+		MaxItems:        aws.Int32(100),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the records of %s: %w", name, err)
+	}
+	var sets []r53types.ResourceRecordSet
+	for _, set := range output.ResourceRecordSets {
+		if canonicalName(aws.ToString(set.Name)) != canonicalName(name) {
+			break
+		}
+		sets = append(sets, set)
+	}
+	return sets, nil
+}
+
+// runRFC2136Server accepts DNS UPDATE messages on addr, over UDP and TCP,
+// until ctx is cancelled.
+func runRFC2136Server(ctx context.Context, addr string, r53Client *route53.Client) {
+	gateway := &rfc2136Gateway{r53Client: r53Client}
+	var wg sync.WaitGroup
+	for _, network := range []string{"udp", "tcp"} {
+		started := make(chan struct{})
+		server := &dns.Server{
+			Addr:              addr,
+			Net:               network,
+			Handler:           gateway,
+			TsigProvider:      tsigKeyring{},
+			MsgAcceptFunc:     acceptUpdates,
+			NotifyStartedFunc: func() { close(started) },
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := server.ListenAndServe(); err != nil {
+				slog.Error("RFC 2136 server stopped", "component", "rfc2136", "network", network, "error", err)
+			}
+		}()
+		go func() {
+			// A server can only be shut down once it has started.
+			<-started
+			<-ctx.Done()
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			server.ShutdownContext(shutdownCtx)
+		}()
+	}
+	slog.Info("RFC 2136 server listening", "component", "rfc2136", "addr", addr)
+	wg.Wait()
+}
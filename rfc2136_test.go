@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// liveSets answers ListResourceRecordSets with the set of the requested name
+// and type in sets, keyed by "name type", if there is one.
+func liveSets(sets map[string]r53types.ResourceRecordSet) func(input any) (any, error) {
+	return func(input any) (any, error) {
+		list := input.(*route53.ListResourceRecordSetsInput)
+		set, ok := sets[aws.ToString(list.StartRecordName)+" "+string(list.StartRecordType)]
+		if !ok {
+			return &route53.ListResourceRecordSetsOutput{}, nil
+		}
+		return &route53.ListResourceRecordSetsOutput{ResourceRecordSets: []r53types.ResourceRecordSet{set}}, nil
+	}
+}
+
+func txtSet(name, value string) r53types.ResourceRecordSet {
+	return r53types.ResourceRecordSet{Name: aws.String(name + "."), Type: r53types.RRTypeTxt, TTL: aws.Int64(300), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String(value)}}}
+}
+
+func TestRFC2136UpdatesCheckOwnership(t *testing.T) {
+	upsert := func(name string, recordType r53types.RRType) r53types.Change {
+		return r53types.Change{Action: r53types.ChangeActionUpsert, ResourceRecordSet: &r53types.ResourceRecordSet{
+			Name: aws.String(name), Type: recordType, TTL: aws.Int64(60), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("10.0.0.5")}},
+		}}
+	}
+	live := map[string]r53types.ResourceRecordSet{
+		"_auto-route53.ours.lan.example.com TXT":   txtSet("_auto-route53.ours.lan.example.com", ownershipValue("home")),
+		"_auto-route53.theirs.lan.example.com TXT": txtSet("_auto-route53.theirs.lan.example.com", ownershipValue("office")),
+		"manual.lan.example.com A":                 {Name: aws.String("manual.lan.example.com."), Type: r53types.RRTypeA, TTL: aws.Int64(300), ResourceRecords: []r53types.ResourceRecord{{Value: aws.String("10.0.0.9")}}},
+	}
+	tests := []struct {
+		name        string
+		changes     []r53types.Change
+		force       bool
+		wantErr     bool
+		wantRefused bool
+		wantClaimed []string
+	}{
+		{
+			name:        "new and owned records",
+			changes:     []r53types.Change{upsert("new.lan.example.com", r53types.RRTypeA), upsert("new.lan.example.com", r53types.RRTypeAaaa), upsert("ours.lan.example.com", r53types.RRTypeA)},
+			wantClaimed: []string{"_auto-route53.new.lan.example.com", "_auto-route53.ours.lan.example.com"},
+		},
+		{
+			name:    "record owned by another deployment",
+			changes: []r53types.Change{upsert("new.lan.example.com", r53types.RRTypeA), upsert("theirs.lan.example.com", r53types.RRTypeA)},
+			wantErr: true,
+		},
+		{
+			name:    "existing record without an owner",
+			changes: []r53types.Change{upsert("manual.lan.example.com", r53types.RRTypeA)},
+			wantErr: true,
+		},
+		{
+			name:        "existing record adopted with OWNERSHIP_FORCE",
+			changes:     []r53types.Change{upsert("manual.lan.example.com", r53types.RRTypeA)},
+			force:       true,
+			wantClaimed: []string{"_auto-route53.manual.lan.example.com"},
+		},
+		{
+			name:        "ownership record",
+			changes:     []r53types.Change{upsert("_auto-route53.theirs.lan.example.com", r53types.RRTypeTxt)},
+			force:       true,
+			wantErr:     true,
+			wantRefused: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := newFakeAWS(t)
+			fake.on("ListResourceRecordSets", liveSets(live))
+			appConfig := &AppConfig{OwnerID: "home", OwnershipTXTPrefix: defaultOwnershipTXTPrefix, OwnershipForce: tt.force}
+			g := &rfc2136Gateway{r53Client: fake.route53()}
+			batch, err := g.ownedChanges(context.Background(), appConfig, "Z1", tt.changes)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ownedChanges() = %d changes, want the update refused", len(batch))
+				}
+				if tt.wantRefused && !errors.Is(err, errUpdateRefused) {
+					t.Errorf("ownedChanges() = %v, want errUpdateRefused", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(batch) != len(tt.changes)+len(tt.wantClaimed) {
+				t.Fatalf("batch has %d changes, want the %d of the update and %d ownership records", len(batch), len(tt.changes), len(tt.wantClaimed))
+			}
+			for i, name := range tt.wantClaimed {
+				change := batch[len(tt.changes)+i]
+				if got := aws.ToString(change.ResourceRecordSet.Name); got != name || change.ResourceRecordSet.Type != r53types.RRTypeTxt {
+					t.Errorf("ownership change %d is for %s %s, want TXT %s", i, change.ResourceRecordSet.Type, got, name)
+				}
+				if got := aws.ToString(change.ResourceRecordSet.ResourceRecords[0].Value); got != ownershipValue("home") {
+					t.Errorf("ownership record %s holds %s, want %s", name, got, ownershipValue("home"))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	r53types "github.com/aws/aws-sdk-go-v2/service/route53/types"
+)
+
+// Router IP sources ask the local gateway for its WAN address. Both protocols
+// only know about IPv4.
+
+const (
+	natpmpPort          = "5351"
+	natpmpInitialWait   = 250 * time.Millisecond
+	ssdpAddress         = "239.255.255.250:1900"
+	upnpSearchTarget    = "urn:schemas-upnp-org:device:InternetGatewayDevice:1"
+	upnpDiscoveryWindow = 3 * time.Second
+)
+
+// defaultGateway reads the IPv4 default route from /proc/net/route.
+func defaultGateway() (string, error) {
+	data, err := os.ReadFile("/proc/net/route")
+	if err != nil {
+		return "", fmt.Errorf("failed to read routing table: %w", err)
+	}
+	for _, line := range strings.Split(string(data), "\n")[1:] {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || fields[1] != "00000000" {
+			continue
+		}
+		raw, err := hex.DecodeString(fields[2])
+		if err != nil || len(raw) != 4 {
+			continue
+		}
+		// The kernel prints the address in host (little-endian) order.
+		return netip.AddrFrom4([4]byte{raw[3], raw[2], raw[1], raw[0]}).String(), nil
+	}
+	return "", errors.New("no IPv4 default route found")
+}
+
+func requireIPv4(family addressFamily, source string) error {
+	if family.RecordType == r53types.RRTypeAaaa {
+		return fmt.Errorf("%s only reports an IPv4 address", source)
+	}
+	return nil
+}
+
+// natpmpIPSource asks a NAT-PMP (RFC 6886) gateway for its external address.
+// Configured as natpmp: for the default gateway or natpmp:address.
+type natpmpIPSource struct {
+	gateway string
+}
+
+func (s *natpmpIPSource) Name() string { return "natpmp:" + s.gateway }
+
+func (s *natpmpIPSource) GetIP(ctx context.Context, family addressFamily) (string, error) {
+	if err := requireIPv4(family, "NAT-PMP"); err != nil {
+		return "", err
+	}
+	gateway := s.gateway
+	if gateway == "" {
+		var err error
+		if gateway, err = defaultGateway(); err != nil {
+			return "", err
+		}
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp4", net.JoinHostPort(gateway, natpmpPort))
+	if err != nil {
+		return "", fmt.Errorf("failed to reach NAT-PMP gateway: %w", err)
+	}
+	defer conn.Close()
+
+	// Version 0, opcode 0: external address request. Retransmit with the
+	// doubling interval the RFC asks for until the context runs out.
+	request := []byte{0, 0}
+	response := make([]byte, 16)
+	for wait := natpmpInitialWait; ; wait *= 2 {
+		if _, err := conn.Write(request); err != nil {
+			return "", fmt.Errorf("failed to send NAT-PMP request: %w", err)
+		}
+		deadline := time.Now().Add(wait)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(response)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("no response from NAT-PMP gateway %s: %w", gateway, ctx.Err())
+			}
+			return "", fmt.Errorf("failed to read NAT-PMP response: %w", err)
+		}
+		if n < 12 || response[0] != 0 || response[1] != 128 {
+			return "", fmt.Errorf("malformed NAT-PMP response from %s", gateway)
+		}
+		if code := binary.BigEndian.Uint16(response[2:4]); code != 0 {
+			return "", fmt.Errorf("NAT-PMP gateway %s returned result code %d", gateway, code)
+		}
+		return netip.AddrFrom4([4]byte(response[8:12])).String(), nil
+	}
+}
+
+// upnpIPSource asks a UPnP Internet Gateway Device for its external address.
+// The device's control URL is found through SSDP once and reused until a
+// request against it fails. Configured as upnp:.
+type upnpIPSource struct {
+	mu          sync.Mutex
+	controlURL  string
+	serviceType string
+}
+
+func (s *upnpIPSource) Name() string { return "upnp:" }
+
+func (s *upnpIPSource) GetIP(ctx context.Context, family addressFamily) (string, error) {
+	if err := requireIPv4(family, "UPnP"); err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.controlURL == "" {
+		controlURL, serviceType, err := discoverUPnPService(ctx)
+		if err != nil {
+			return "", err
+		}
+		s.controlURL, s.serviceType = controlURL, serviceType
+	}
+	ip, err := upnpExternalIP(ctx, s.controlURL, s.serviceType)
+	if err != nil {
+		// The router may have rebooted onto a different port.
+		s.controlURL = ""
+		return "", err
+	}
+	return ip, nil
+}
+
+// upnpDevice is the part of a UPnP device description we need.
+type upnpDevice struct {
+	Services []struct {
+		ServiceType string `xml:"serviceType"`
+		ControlURL  string `xml:"controlURL"`
+	} `xml:"serviceList>service"`
+	Devices []upnpDevice `xml:"deviceList>device"`
+}
+
+func (d *upnpDevice) findWANService() (string, string) {
+	for _, service := range d.Services {
+		if strings.Contains(service.ServiceType, ":WANIPConnection:") || strings.Contains(service.ServiceType, ":WANPPPConnection:") {
+			return service.ControlURL, service.ServiceType
+		}
+	}
+	for i := range d.Devices {
+		if controlURL, serviceType := d.Devices[i].findWANService(); controlURL != "" {
+			return controlURL, serviceType
+		}
+	}
+	return "", ""
+}
+
+// discoverUPnPService finds a gateway with SSDP and returns the absolute
+// control URL and type of its WAN connection service.
+func discoverUPnPService(ctx context.Context) (string, string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to open SSDP socket: %w", err)
+	}
+	defer conn.Close()
+	target, err := net.ResolveUDPAddr("udp4", ssdpAddress)
+	if err != nil {
+		return "", "", err
+	}
+	search := "M-SEARCH * HTTP/1.1\r\n" +
+		"HOST: " + ssdpAddress + "\r\n" +
+		"MAN: \"ssdp:discover\"\r\n" +
+		"MX: 2\r\n" +
+		"ST: " + upnpSearchTarget + "\r\n\r\n"
+	if _, err := conn.WriteTo([]byte(search), target); err != nil {
+		return "", "", fmt.Errorf("failed to send SSDP search: %w", err)
+	}
+
+	deadline := time.Now().Add(upnpDiscoveryWindow)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	conn.SetReadDeadline(deadline)
+	buf := make([]byte, 2048)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return "", "", errors.New("no UPnP Internet Gateway Device answered the SSDP search")
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(buf[:n])), nil)
+		if err != nil {
+			continue
+		}
+		location := resp.Header.Get("Location")
+		if location == "" {
+			continue
+		}
+		controlURL, serviceType, err := upnpServiceFromDescription(ctx, location)
+		if err != nil {
+			loggerFrom(ctx).Debug("Skipping UPnP device", "location", location, "error", err)
+			continue
+		}
+		return controlURL, serviceType, nil
+	}
+}
+
+func upnpServiceFromDescription(ctx context.Context, location string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, location, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to fetch device description: %w", err)
+	}
+	defer resp.Body.Close()
+	var description struct {
+		URLBase string     `xml:"URLBase"`
+		Device  upnpDevice `xml:"device"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&description); err != nil {
+		return "", "", fmt.Errorf("failed to parse device description: %w", err)
+	}
+	controlURL, serviceType := description.Device.findWANService()
+	if controlURL == "" {
+		return "", "", errors.New("device has no WAN connection service")
+	}
+	base := description.URLBase
+	if base == "" {
+		base = location
+	}
+	baseURL, err := url.Parse(base)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid device URL %q: %w", base, err)
+	}
+	resolved, err := baseURL.Parse(controlURL)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid control URL %q: %w", controlURL, err)
+	}
+	return resolved.String(), serviceType, nil
+}
+
+// upnpExternalIP calls GetExternalIPAddress on the WAN connection service.
+func upnpExternalIP(ctx context.Context, controlURL, serviceType string) (string, error) {
+	body := `<?xml version="1.0"?>` +
+		`<s:Envelope xmlns:s="http://schemas.xmlsoap.org/soap/envelope/" s:encodingStyle="http://schemas.xmlsoap.org/soap/encoding/">` +
+		`<s:Body><u:GetExternalIPAddress xmlns:u="` + serviceType + `"/></s:Body></s:Envelope>`
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, controlURL, strings.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", `text/xml; charset="utf-8"`)
+	req.Header.Set("SOAPAction", `"`+serviceType+`#GetExternalIPAddress"`)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("UPnP GetExternalIPAddress failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("UPnP GetExternalIPAddress failed: %s", resp.Status)
+	}
+	var envelope struct {
+		IP string `xml:"Body>GetExternalIPAddressResponse>NewExternalIPAddress"`
+	}
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&envelope); err != nil {
+		return "", fmt.Errorf("failed to parse UPnP response: %w", err)
+	}
+	return strings.TrimSpace(envelope.IP), nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// --- HTTP Server ---
+
+// healthReport is the JSON body returned by /healthz and /readyz.
+type healthReport struct {
+	Status                 string                   `json:"status"`
+	Reason                 string                   `json:"reason,omitempty"`
+	IPChecks               map[string]ipCheckStatus `json:"ip_checks"`
+	LastSuccessfulUpdate   *time.Time               `json:"last_successful_update,omitempty"`
+	SecondsSinceLastUpdate *float64                 `json:"seconds_since_last_update,omitempty"`
+	Records                []recordHealth           `json:"records"`
+	Standby                bool                     `json:"standby,omitempty"`
+}
+
+type recordHealth struct {
+	recordSyncStatus
+	StalenessSeconds *float64 `json:"staleness_seconds,omitempty"`
+}
+
+func newHealthReport(snap statusSnapshot, now time.Time) healthReport {
+	report := healthReport{Status: "ok", IPChecks: snap.IPChecks, Records: []recordHealth{}, Standby: snap.Standby}
+	if !snap.LastSuccessfulUpdate.IsZero() {
+		since := now.Sub(snap.LastSuccessfulUpdate).Seconds()
+		report.LastSuccessfulUpdate = &snap.LastSuccessfulUpdate
+		report.SecondsSinceLastUpdate = &since
+	}
+	for _, rec := range snap.Records {
+		health := recordHealth{recordSyncStatus: rec}
+		if !rec.LastSynced.IsZero() {
+			staleness := now.Sub(rec.LastSynced).Seconds()
+			health.StalenessSeconds = &staleness
+		}
+		report.Records = append(report.Records, health)
+	}
+	return report
+}
+
+func writeHealth(w http.ResponseWriter, report healthReport, reason string) {
+	code := http.StatusOK
+	if reason != "" {
+		report.Status = "unavailable"
+		report.Reason = reason
+		code = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(report)
+}
+
+// livenessProblem reports why the DDNS loop looks wedged, or "" if it is
+// running. The loop checks the IP every SleepTime, so no check within two
+// intervals (plus slack for a slow cycle) means it is stuck. A standby does
+// not check at all and is always live.
+func livenessProblem(snap statusSnapshot, startedAt, now time.Time, sleepTime time.Duration) string {
+	if snap.Standby {
+		return ""
+	}
+	staleAfter := 2*sleepTime + time.Minute
+	last := startedAt
+	for _, check := range snap.IPChecks {
+		if check.Time.After(last) {
+			last = check.Time
+		}
+	}
+	if now.Sub(last) > staleAfter {
+		return fmt.Sprintf("no IP check completed in the last %s", staleAfter)
+	}
+	return ""
+}
+
+// readinessProblem reports why the records are not known to be in sync, or
+// "" if they are. A standby is ready, so that it does not hold up rollouts.
+func readinessProblem(snap statusSnapshot) string {
+	if snap.Standby {
+		return ""
+	}
+	if len(snap.IPChecks) == 0 {
+		return "no IP check has completed yet"
+	}
+	for family, check := range snap.IPChecks {
+		if !check.OK {
+			return fmt.Sprintf("last %s check failed: %s", family, check.Error)
+		}
+	}
+	for _, rec := range snap.Records {
+		if rec.LastError != "" {
+			return fmt.Sprintf("last update of %s %s failed: %s", rec.Type, rec.Name, rec.LastError)
+		}
+	}
+	return ""
+}
+
+// runHTTPServer serves the application's HTTP endpoints on addr until ctx
+// is cancelled.
+func runHTTPServer(ctx context.Context, addr string, api *controller) {
+	startedAt := time.Now()
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		snap, now := status.snapshot(), time.Now()
+		writeHealth(w, newHealthReport(snap, now), livenessProblem(snap, startedAt, now, currentConfig.Load().SleepTime))
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		snap, now := status.snapshot(), time.Now()
+		writeHealth(w, newHealthReport(snap, now), readinessProblem(snap))
+	})
+	mux.HandleFunc("/", serveDashboard)
+	api.registerAPI(mux)
+	mux.HandleFunc("/ip-history", func(w http.ResponseWriter, r *http.Request) {
+		history, err := ipHistorySnapshot()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"ip_history": append([]ipHistoryEntry{}, history...)})
+	})
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			slog.Error("Failed to shut down HTTP server", "component", "http", "error", err)
+		}
+	}()
+
+	slog.Info("HTTP server listening", "component", "http", "addr", addr)
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Error("HTTP server stopped", "component", "http", "error", err)
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// checkSignals is empty on platforms without SIGUSR1.
+var checkSignals []os.Signal
@@ -0,0 +1,11 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// checkSignals make the DDNS loop check the public IP right away.
+var checkSignals = []os.Signal{syscall.SIGUSR1}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	ddbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+// --- State Storage ---
+
+const (
+	stateBackendFile     = "file"
+	stateBackendS3       = "s3"
+	stateBackendDynamoDB = "dynamodb"
+	stateBackendSSM      = "ssm"
+
+	// stateTimeout bounds a single read or write of a remote state backend.
+	stateTimeout = 30 * time.Second
+)
+
+// StateStore persists the small pieces of state the application keeps: the
+// last published addresses and the certificate ARNs. Keys are the state
+// file paths (e.g. data/last_ip.txt); remote backends drop the data/ prefix.
+// Get returns "" for a key that was never stored.
+type StateStore interface {
+	Get(ctx context.Context, key string) (string, error)
+	Put(ctx context.Context, key, value string) error
+	Delete(ctx context.Context, key string) error
+}
+
+// stateStore is the backend in use, chosen by STATE_BACKEND at startup.
+var stateStore StateStore = fileStateStore{}
+
+// newStateStore builds the backend selected in appConfig.
+func newStateStore(appConfig *AppConfig, awsCfg aws.Config) (StateStore, error) {
+	switch appConfig.StateBackend {
+	case stateBackendFile:
+		return fileStateStore{}, nil
+	case stateBackendS3:
+		return &s3StateStore{client: s3.NewFromConfig(awsCfg), bucket: appConfig.StateS3Bucket, prefix: appConfig.StateS3Prefix}, nil
+	case stateBackendDynamoDB:
+		return &dynamoDBStateStore{client: dynamodb.NewFromConfig(awsCfg), table: appConfig.StateDynamoDBTable}, nil
+	case stateBackendSSM:
+		return &ssmStateStore{client: ssm.NewFromConfig(awsCfg), prefix: appConfig.StateSSMPrefix}, nil
+	default:
+		return nil, fmt.Errorf("unknown state backend %q", appConfig.StateBackend)
+	}
+}
+
+func stateKeyName(key string) string {
+	return strings.TrimPrefix(key, "data/")
+}
+
+// stateLocks holds one mutex per state key so that the DDNS loop and the
+// certificate goroutines never interleave reads and writes of the same key.
+var stateLocks sync.Map
+
+func stateLock(key string) *sync.Mutex {
+	lock, _ := stateLocks.LoadOrStore(key, &sync.Mutex{})
+	return lock.(*sync.Mutex)
+}
+
+func getStoredString(key string) (string, error) {
+	lock := stateLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	return stateStore.Get(ctx, key)
+}
+
+func storeString(key, value string) error {
+	lock := stateLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	return stateStore.Put(ctx, key, value)
+}
+
+func clearStoredString(key string) error {
+	lock := stateLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	return stateStore.Delete(ctx, key)
+}
+
+// updateStoredString replaces the value of key with update(old) while holding
+// the key's lock, so that no other read or write of the key comes in between.
+// An empty result deletes the key; returning old leaves it alone.
+func updateStoredString(key string, update func(old string) (string, error)) error {
+	lock := stateLock(key)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), stateTimeout)
+	defer cancel()
+	old, err := stateStore.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	value, err := update(old)
+	if err != nil || value == old {
+		return err
+	}
+	if value == "" {
+		return stateStore.Delete(ctx, key)
+	}
+	return stateStore.Put(ctx, key, value)
+}
+
+// stateDirLockFile guards the data directory against a second instance
+// sharing it, which would make the two overwrite each other's state.
+const stateDirLockFile = "data/.lock"
+
+// stateDirLock holds the lock file open; the file's finalizer would
+// otherwise release the lock once the file is garbage collected.
+var stateDirLock *os.File
+
+// lockStateDir takes the advisory lock on the data directory for the life of
+// the process.
+func lockStateDir() error {
+	if err := os.MkdirAll(filepath.Dir(stateDirLockFile), 0755); err != nil {
+		return fmt.Errorf("failed to create the state directory: %w", err)
+	}
+	lock, err := lockFile(stateDirLockFile)
+	if err != nil {
+		return fmt.Errorf("another instance appears to be using the state directory: %w", err)
+	}
+	stateDirLock = lock
+	return nil
+}
+
+// fileStateStore keeps each key in a local file, normally under data/.
+// Writes go to a temporary file that is synced and then renamed over the
+// key, so a crash never leaves a truncated state file behind.
+type fileStateStore struct{}
+
+func (fileStateStore) Get(_ context.Context, key string) (string, error) {
+	data, err := os.ReadFile(key)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	return string(data), err
+}
+
+func (fileStateStore) Put(_ context.Context, key, value string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(key), "."+filepath.Base(key)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), key)
+}
+
+func (fileStateStore) Delete(_ context.Context, key string) error {
+	if err := os.Remove(key); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// s3StateStore keeps each key in an object under prefix.
+type s3StateStore struct {
+	client *s3.Client
+	bucket string
+	prefix string
+}
+
+func (s *s3StateStore) objectKey(key string) *string {
+	return aws.String(s.prefix + stateKeyName(key))
+}
+
+func (s *s3StateStore) Get(ctx context.Context, key string) (string, error) {
+	output, err := s.client.GetObject(ctx, &s3.GetObjectInput{Bucket: &s.bucket, Key: s.objectKey(key)})
+	if err != nil {
+		var notFound *s3types.NoSuchKey
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read s3://%s/%s: %w", s.bucket, *s.objectKey(key), err)
+	}
+	defer output.Body.Close()
+	data, err := io.ReadAll(io.LimitReader(output.Body, 1<<20))
+	return string(data), err
+}
+
+func (s *s3StateStore) Put(ctx context.Context, key, value string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      &s.bucket,
+		Key:         s.objectKey(key),
+		Body:        strings.NewReader(value),
+		ContentType: aws.String("text/plain"),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write s3://%s/%s: %w", s.bucket, *s.objectKey(key), err)
+	}
+	return nil
+}
+
+func (s *s3StateStore) Delete(ctx context.Context, key string) error {
+	// DeleteObject succeeds for keys that do not exist.
+	if _, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{Bucket: &s.bucket, Key: s.objectKey(key)}); err != nil {
+		return fmt.Errorf("failed to delete s3://%s/%s: %w", s.bucket, *s.objectKey(key), err)
+	}
+	return nil
+}
+
+// dynamoDBStateStore keeps each key in an item of a table whose partition
+// key is the string attribute "key"; the state is held in "value".
+type dynamoDBStateStore struct {
+	client *dynamodb.Client
+	table  string
+}
+
+func (s *dynamoDBStateStore) itemKey(key string) map[string]ddbtypes.AttributeValue {
+	return map[string]ddbtypes.AttributeValue{"key": &ddbtypes.AttributeValueMemberS{Value: stateKeyName(key)}}
+}
+
+func (s *dynamoDBStateStore) Get(ctx context.Context, key string) (string, error) {
+	output, err := s.client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      &s.table,
+		Key:            s.itemKey(key),
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s from DynamoDB table %s: %w", stateKeyName(key), s.table, err)
+	}
+	value, ok := output.Item["value"].(*ddbtypes.AttributeValueMemberS)
+	if !ok {
+		return "", nil
+	}
+	return value.Value, nil
+}
+
+func (s *dynamoDBStateStore) Put(ctx context.Context, key, value string) error {
+	item := s.itemKey(key)
+	item["value"] = &ddbtypes.AttributeValueMemberS{Value: value}
+	if _, err := s.client.PutItem(ctx, &dynamodb.PutItemInput{TableName: &s.table, Item: item}); err != nil {
+		return fmt.Errorf("failed to write %s to DynamoDB table %s: %w", stateKeyName(key), s.table, err)
+	}
+	return nil
+}
+
+func (s *dynamoDBStateStore) Delete(ctx context.Context, key string) error {
+	if _, err := s.client.DeleteItem(ctx, &dynamodb.DeleteItemInput{TableName: &s.table, Key: s.itemKey(key)}); err != nil {
+		return fmt.Errorf("failed to delete %s from DynamoDB table %s: %w", stateKeyName(key), s.table, err)
+	}
+	return nil
+}
+
+// ssmParameterUnsafe matches characters that SSM parameter names may not
+// contain, such as the * of wildcard domains.
+var ssmParameterUnsafe = regexp.MustCompile(`[^a-zA-Z0-9_.\-/]`)
+
+// ssmStateStore keeps each key in a String parameter under prefix.
+type ssmStateStore struct {
+	client *ssm.Client
+	prefix string
+}
+
+func (s *ssmStateStore) parameterName(key string) *string {
+	return aws.String(s.prefix + ssmParameterUnsafe.ReplaceAllString(stateKeyName(key), "_"))
+}
+
+func (s *ssmStateStore) Get(ctx context.Context, key string) (string, error) {
+	output, err := s.client.GetParameter(ctx, &ssm.GetParameterInput{Name: s.parameterName(key)})
+	if err != nil {
+		var notFound *ssmtypes.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read SSM parameter %s: %w", *s.parameterName(key), err)
+	}
+	return aws.ToString(output.Parameter.Value), nil
+}
+
+func (s *ssmStateStore) Put(ctx context.Context, key, value string) error {
+	_, err := s.client.PutParameter(ctx, &ssm.PutParameterInput{
+		Name:      s.parameterName(key),
+		Value:     aws.String(value),
+		Type:      ssmtypes.ParameterTypeString,
+		Overwrite: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write SSM parameter %s: %w", *s.parameterName(key), err)
+	}
+	return nil
+}
+
+func (s *ssmStateStore) Delete(ctx context.Context, key string) error {
+	_, err := s.client.DeleteParameter(ctx, &ssm.DeleteParameterInput{Name: s.parameterName(key)})
+	if err != nil {
+		var notFound *ssmtypes.ParameterNotFound
+		if errors.As(err, &notFound) {
+			return nil
+		}
+		return fmt.Errorf("failed to delete SSM parameter %s: %w", *s.parameterName(key), err)
+	}
+	return nil
+}
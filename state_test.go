@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// increment adds one to the counter stored under key.
+func increment(key string) error {
+	return updateStoredString(key, func(old string) (string, error) {
+		n := 0
+		if old != "" {
+			var err error
+			if n, err = strconv.Atoi(old); err != nil {
+				return "", fmt.Errorf("%s holds %q: %w", key, old, err)
+			}
+		}
+		return strconv.Itoa(n + 1), nil
+	})
+}
+
+func TestConcurrentStateUpdates(t *testing.T) {
+	const goroutines, updates = 16, 25
+	tests := []struct {
+		name string
+		key  func(goroutine int) string
+		want map[string]int
+	}{
+		{
+			name: "same key",
+			key:  func(int) string { return "data/counter.txt" },
+			want: map[string]int{"data/counter.txt": goroutines * updates},
+		},
+		{
+			name: "different keys",
+			key:  func(g int) string { return fmt.Sprintf("data/counter_%d.txt", g%4) },
+			want: map[string]int{
+				"data/counter_0.txt": goroutines / 4 * updates,
+				"data/counter_1.txt": goroutines / 4 * updates,
+				"data/counter_2.txt": goroutines / 4 * updates,
+				"data/counter_3.txt": goroutines / 4 * updates,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useStateDir(t)
+			var wg sync.WaitGroup
+			for g := range goroutines {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for range updates {
+						if err := increment(tt.key(g)); err != nil {
+							t.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			for key, want := range tt.want {
+				got, err := getStoredString(key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got != strconv.Itoa(want) {
+					t.Errorf("%s = %q, want %d", key, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestConcurrentStateWrites(t *testing.T) {
+	useStateDir(t)
+	const key = "data/last_ip.txt"
+	values := map[string]bool{"198.41.0.4": true, "199.9.14.201": true, "192.33.4.12": true}
+	var wg sync.WaitGroup
+	for value := range values {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				if err := storeString(key, value); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for range 50 {
+				// A reader sees one whole value, never a mix or a partial write.
+				got, err := getStoredString(key)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if got != "" && !values[got] {
+					t.Errorf("read %q from %s", got, key)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestUpdateStoredStringDeletes(t *testing.T) {
+	useStateDir(t)
+	const key = "data/cert_arn.txt"
+	if err := storeString(key, "arn:aws:acm:us-east-1:111111111111:certificate/old"); err != nil {
+		t.Fatal(err)
+	}
+	if err := updateStoredString(key, func(string) (string, error) { return "", nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := getStoredString(key); got != "" {
+		t.Errorf("%s = %q after an empty update, want it deleted", key, got)
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// --- Runtime Status ---
+
+// ipCheckStatus is the outcome of the most recent public IP check of one
+// address family.
+type ipCheckStatus struct {
+	Time  time.Time `json:"time"`
+	OK    bool      `json:"ok"`
+	IP    string    `json:"ip,omitempty"`
+	Error string    `json:"error,omitempty"`
+}
+
+// recordSyncStatus tracks when a record was last known to hold the current
+// public address, and when an update of it was last sent.
+type recordSyncStatus struct {
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	Value       string    `json:"value,omitempty"`
+	LastSynced  time.Time `json:"last_synced,omitempty"`
+	LastChanged time.Time `json:"last_changed,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// certificateStatus is what the last certificate pass of a record found.
+type certificateStatus struct {
+	Domain      string    `json:"domain"`
+	ARN         string    `json:"arn,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Expires     time.Time `json:"expires,omitempty"`
+	LastChecked time.Time `json:"last_checked,omitempty"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// appStatus is the in-memory view of the DDNS loop's progress that the HTTP
+// endpoints report on.
+type appStatus struct {
+	mu                   sync.RWMutex
+	ipChecks             map[string]ipCheckStatus
+	lastSuccessfulUpdate time.Time
+	records              map[string]*recordSyncStatus
+	certificates         map[string]*certificateStatus
+	standby              bool
+}
+
+var status = &appStatus{
+	ipChecks:     map[string]ipCheckStatus{},
+	records:      map[string]*recordSyncStatus{},
+	certificates: map[string]*certificateStatus{},
+}
+
+func (s *appStatus) recordIPCheck(family, ip string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	check := ipCheckStatus{Time: time.Now(), OK: err == nil, IP: ip}
+	if err != nil {
+		check.Error = err.Error()
+	}
+	s.ipChecks[family] = check
+}
+
+func (s *appStatus) record(name, recordType string) *recordSyncStatus {
+	key := name + "|" + recordType
+	rec, ok := s.records[key]
+	if !ok {
+		rec = &recordSyncStatus{Name: name, Type: recordType}
+		s.records[key] = rec
+	}
+	return rec
+}
+
+// recordSynced marks the record as holding value. updated is true when an
+// update was sent rather than the value being unchanged.
+func (s *appStatus) recordSynced(name, recordType, value string, updated bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	rec := s.record(name, recordType)
+	rec.Value = value
+	rec.LastSynced = now
+	rec.LastError = ""
+	if updated {
+		rec.LastChanged = now
+		s.lastSuccessfulUpdate = now
+	}
+}
+
+func (s *appStatus) recordFailed(name, recordType string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.record(name, recordType).LastError = err.Error()
+}
+
+func (s *appStatus) certificate(domain string) *certificateStatus {
+	cert, ok := s.certificates[domain]
+	if !ok {
+		cert = &certificateStatus{Domain: domain}
+		s.certificates[domain] = cert
+	}
+	return cert
+}
+
+// recordCertificate stores the state of the record's certificate. ACME
+// certificates have no ARN.
+func (s *appStatus) recordCertificate(domain, arn, certStatus string, expires time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cert := s.certificate(domain)
+	cert.ARN, cert.Status, cert.Expires = arn, certStatus, expires
+}
+
+// recordCertificatePass stores the outcome of a certificate pass.
+func (s *appStatus) recordCertificatePass(domain string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cert := s.certificate(domain)
+	cert.LastChecked = time.Now()
+	cert.LastError = ""
+	if err != nil {
+		cert.LastError = err.Error()
+	}
+}
+
+// setStandby records whether this instance is a leader-election standby,
+// which does not run DDNS cycles.
+func (s *appStatus) setStandby(standby bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.standby = standby
+}
+
+// retainRecords forgets the records that are no longer configured, so that a
+// removed record does not keep the service unready.
+func (s *appStatus) retainRecords(records []RecordConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keep := map[string]bool{}
+	for _, record := range records {
+		keep[record.RecordName] = true
+	}
+	for key, rec := range s.records {
+		if !keep[rec.Name] {
+			delete(s.records, key)
+		}
+	}
+	for domain := range s.certificates {
+		if !keep[domain] {
+			delete(s.certificates, domain)
+		}
+	}
+}
+
+// statusSnapshot is a consistent copy of appStatus for reporting.
+type statusSnapshot struct {
+	IPChecks             map[string]ipCheckStatus `json:"ip_checks"`
+	LastSuccessfulUpdate time.Time                `json:"last_successful_update,omitempty"`
+	Records              []recordSyncStatus       `json:"records"`
+	Certificates         []certificateStatus      `json:"certificates,omitempty"`
+	Standby              bool                     `json:"standby,omitempty"`
+	// Config is the effective configuration, with its secrets redacted. It
+	// is only filled in for GET /status.
+	Config map[string]any `json:"config,omitempty"`
+}
+
+func (s *appStatus) snapshot() statusSnapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap := statusSnapshot{
+		IPChecks:             make(map[string]ipCheckStatus, len(s.ipChecks)),
+		LastSuccessfulUpdate: s.lastSuccessfulUpdate,
+		Standby:              s.standby,
+		Records:              make([]recordSyncStatus, 0, len(s.records)),
+	}
+	for family, check := range s.ipChecks {
+		snap.IPChecks[family] = check
+	}
+	for _, rec := range s.records {
+		snap.Records = append(snap.Records, *rec)
+	}
+	sort.Slice(snap.Records, func(i, j int) bool {
+		if snap.Records[i].Name != snap.Records[j].Name {
+			return snap.Records[i].Name < snap.Records[j].Name
+		}
+		return snap.Records[i].Type < snap.Records[j].Type
+	})
+	for _, cert := range s.certificates {
+		snap.Certificates = append(snap.Certificates, *cert)
+	}
+	sort.Slice(snap.Certificates, func(i, j int) bool {
+		return snap.Certificates[i].Domain < snap.Certificates[j].Domain
+	})
+	return snap
+}
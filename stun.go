@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"time"
+)
+
+// STUN (RFC 5389) constants for a Binding request.
+const (
+	stunBindingRequest  = 0x0001
+	stunBindingResponse = 0x0101
+	stunMagicCookie     = 0x2112A442
+	stunHeaderSize      = 20
+
+	stunAttrMappedAddress    = 0x0001
+	stunAttrXorMappedAddress = 0x0020
+
+	defaultSTUNPort = "3478"
+	// stunRetransmitInterval is how long to wait for a response before
+	// resending the request, since UDP gives no delivery guarantee.
+	stunRetransmitInterval = 2 * time.Second
+)
+
+// stunIPSource asks a STUN server for the address our Binding request was
+// seen from. Configured as stun:host[:port], following RFC 7064.
+type stunIPSource struct {
+	server string
+}
+
+func (s *stunIPSource) Name() string { return "stun:" + s.server }
+
+func (s *stunIPSource) GetIP(ctx context.Context, family addressFamily) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, family.PacketNetwork, s.server)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach STUN server: %w", err)
+	}
+	defer conn.Close()
+
+	request := make([]byte, stunHeaderSize)
+	binary.BigEndian.PutUint16(request[0:2], stunBindingRequest)
+	binary.BigEndian.PutUint32(request[4:8], stunMagicCookie)
+	if _, err := rand.Read(request[8:20]); err != nil {
+		return "", fmt.Errorf("failed to generate STUN transaction ID: %w", err)
+	}
+	transactionID := request[8:20]
+
+	buf := make([]byte, 1500)
+	for {
+		if _, err := conn.Write(request); err != nil {
+			return "", fmt.Errorf("failed to send STUN request: %w", err)
+		}
+		deadline := time.Now().Add(stunRetransmitInterval)
+		if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+			deadline = ctxDeadline
+		}
+		conn.SetReadDeadline(deadline)
+		n, err := conn.Read(buf)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && ctx.Err() == nil {
+				continue
+			}
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("no response from STUN server: %w", ctx.Err())
+			}
+			return "", fmt.Errorf("failed to read STUN response: %w", err)
+		}
+		addr, err := parseSTUNResponse(buf[:n], transactionID)
+		if err != nil {
+			return "", err
+		}
+		return addr.String(), nil
+	}
+}
+
+// parseSTUNResponse extracts the mapped address from a Binding success
+// response, preferring XOR-MAPPED-ADDRESS over the legacy MAPPED-ADDRESS.
+func parseSTUNResponse(msg, transactionID []byte) (netip.Addr, error) {
+	if len(msg) < stunHeaderSize {
+		return netip.Addr{}, errors.New("STUN response too short")
+	}
+	if binary.BigEndian.Uint16(msg[0:2]) != stunBindingResponse {
+		return netip.Addr{}, fmt.Errorf("unexpected STUN message type 0x%04x", binary.BigEndian.Uint16(msg[0:2]))
+	}
+	if binary.BigEndian.Uint32(msg[4:8]) != stunMagicCookie || !bytes.Equal(msg[8:20], transactionID) {
+		return netip.Addr{}, errors.New("STUN response does not match our request")
+	}
+	length := int(binary.BigEndian.Uint16(msg[2:4]))
+	if stunHeaderSize+length > len(msg) {
+		return netip.Addr{}, errors.New("STUN response truncated")
+	}
+
+	var mapped netip.Addr
+	attrs := msg[stunHeaderSize : stunHeaderSize+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLen := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if 4+attrLen > len(attrs) {
+			break
+		}
+		value := attrs[4 : 4+attrLen]
+		switch attrType {
+		case stunAttrXorMappedAddress:
+			if addr, ok := stunAddress(value, msg[4:20]); ok {
+				return addr, nil
+			}
+		case stunAttrMappedAddress:
+			if addr, ok := stunAddress(value, nil); ok {
+				mapped = addr
+			}
+		}
+		// Attributes are padded to a multiple of four bytes.
+		next := 4 + (attrLen+3)&^3
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	if !mapped.IsValid() {
+		return netip.Addr{}, errors.New("STUN response has no mapped address")
+	}
+	return mapped, nil
+}
+
+// stunAddress decodes a (XOR-)MAPPED-ADDRESS value. For the XOR form, key is
+// the magic cookie followed by the transaction ID.
+func stunAddress(value, key []byte) (netip.Addr, bool) {
+	if len(value) < 4 {
+		return netip.Addr{}, false
+	}
+	var size int
+	switch value[1] {
+	case 0x01:
+		size = 4
+	case 0x02:
+		size = 16
+	default:
+		return netip.Addr{}, false
+	}
+	if len(value) < 4+size {
+		return netip.Addr{}, false
+	}
+	ip := make([]byte, size)
+	copy(ip, value[4:4+size])
+	for i := range key {
+		if i < size {
+			ip[i] ^= key[i]
+		}
+	}
+	addr, ok := netip.AddrFromSlice(ip)
+	return addr, ok
+}
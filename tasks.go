@@ -0,0 +1,325 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/acm"
+	"github.com/aws/aws-sdk-go-v2/service/route53"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// --- Per-Record Tasks ---
+
+// recordTasks runs the certificate reconciliation and one-time proxy setup
+// for each record and keeps the running set in line with the configuration.
+// Each task is keyed by the record settings it depends on, so a reload
+// leaves unchanged tasks alone, starts tasks for new or modified records,
+// and cancels the tasks of records that were removed or modified.
+type recordTasks struct {
+	ctx       context.Context
+	wg        *sync.WaitGroup
+	failures  *atomic.Int32
+	acmClient *acm.Client
+	r53Client *route53.Client
+	ssmClient *ssm.Client
+	npmClient *NpmClient
+	// certSlots limits how many certificate passes run at the same time.
+	certSlots chan struct{}
+
+	mu      sync.Mutex
+	running map[string]context.CancelFunc
+	// certWake starts the next pass of a certificate task now, by task key.
+	certWake map[string]certWake
+	paused   bool
+}
+
+// certWake is the channel that wakes the certificate task of a domain.
+type certWake struct {
+	domain string
+	wake   chan struct{}
+}
+
+func newRecordTasks(ctx context.Context, wg *sync.WaitGroup, failures *atomic.Int32, acmClient *acm.Client, r53Client *route53.Client, ssmClient *ssm.Client, npmClient *NpmClient, certConcurrency int) *recordTasks {
+	return &recordTasks{
+		ctx:       ctx,
+		wg:        wg,
+		failures:  failures,
+		acmClient: acmClient,
+		r53Client: r53Client,
+		ssmClient: ssmClient,
+		npmClient: npmClient,
+		certSlots: make(chan struct{}, certConcurrency),
+		running:   map[string]context.CancelFunc{},
+		certWake:  map[string]certWake{},
+	}
+}
+
+// certTaskSettings are the settings a certificate task works from, so that
+// changing any of them restarts the task on reload. Fields are named after
+// the RecordConfig field they come from or, prefixed with App, the AppConfig
+// one.
+type certTaskSettings struct {
+	StateKey            string
+	ZoneID              string
+	ValidationZoneID    string
+	Profile             string
+	RoleARN             string
+	ExternalID          string
+	CertRegion          string
+	KubernetesSecret    string
+	KubernetesConfigMap string
+	CertTags            map[string]string
+	SSMParameter        string
+	ListenerArns        []string
+	ListenerDefault     bool
+	CloudFrontDistID    string
+	APIGatewayDomain    string
+	APIGatewayAlias     bool
+	AppCertMode         string
+	AppCertBackend      string
+	AppCertTags         map[string]string
+}
+
+func certTaskSettingsFor(appConfig *AppConfig, record RecordConfig) certTaskSettings {
+	return certTaskSettings{
+		StateKey:            certStateKey(record),
+		ZoneID:              record.ZoneID,
+		ValidationZoneID:    record.ValidationZoneID,
+		Profile:             record.Profile,
+		RoleARN:             record.RoleARN,
+		ExternalID:          record.ExternalID,
+		CertRegion:          record.CertRegion,
+		KubernetesSecret:    record.KubernetesSecret,
+		KubernetesConfigMap: record.KubernetesConfigMap,
+		CertTags:            record.CertTags,
+		SSMParameter:        record.SSMParameter,
+		ListenerArns:        record.ListenerArns,
+		ListenerDefault:     record.ListenerDefault,
+		CloudFrontDistID:    record.CloudFrontDistID,
+		APIGatewayDomain:    record.APIGatewayDomain,
+		APIGatewayAlias:     record.APIGatewayAlias,
+		AppCertMode:         appConfig.CertMode,
+		AppCertBackend:      appConfig.CertBackend,
+		AppCertTags:         appConfig.CertTags,
+	}
+}
+
+func (s certTaskSettings) key() string {
+	data, _ := json.Marshal(s)
+	return "cert|" + string(data)
+}
+
+func certTaskKey(appConfig *AppConfig, record RecordConfig) string {
+	return certTaskSettingsFor(appConfig, record).key()
+}
+
+func proxyTaskKey(appConfig *AppConfig, record RecordConfig) string {
+	return fmt.Sprintf("proxy|%s|%d|%t|%t|%s", record.RecordName, record.Port, record.TLS, record.RedirectToHttps, appConfig.ForwardHost)
+}
+
+// sync starts the tasks appConfig calls for that are not running yet and
+// cancels the ones it no longer calls for. Tasks that have finished stay in
+// the set, so they are not repeated on every reload.
+func (t *recordTasks) sync(appConfig *AppConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.paused {
+		return
+	}
+
+	wanted := map[string]bool{}
+	for _, record := range appConfig.RecordsToUpdate {
+		if record.TLS {
+			key := certTaskKey(appConfig, record)
+			wanted[key] = true
+			if _, ok := t.running[key]; !ok {
+				wake := make(chan struct{}, 1)
+				t.certWake[key] = certWake{domain: canonicalName(record.RecordName), wake: wake}
+				t.start(key, func(ctx context.Context) {
+					t.runCertificateTask(ctx, appConfig, record, wake)
+				})
+			}
+		}
+
+		// Manage Nginx Proxy if port is specified and NPM is configured
+		if record.Port > 0 && t.npmClient != nil {
+			if appConfig.ForwardHost == "" {
+				slog.Warn("Skipping proxy setup because FORWARD_HOST_IP is not set", "component", "npm", "record", record.RecordName)
+				continue
+			}
+			key := proxyTaskKey(appConfig, record)
+			wanted[key] = true
+			if _, ok := t.running[key]; !ok {
+				t.start(key, func(ctx context.Context) {
+					t.runProxyTask(ctx, appConfig, record)
+				})
+			}
+		}
+	}
+
+	for key, cancel := range t.running {
+		if !wanted[key] {
+			slog.Info("Dropping task for removed or changed record", "task", key)
+			cancel()
+			delete(t.running, key)
+			delete(t.certWake, key)
+		}
+	}
+}
+
+// pause cancels every task and stops sync from starting new ones, for an
+// instance that is not the leader.
+func (t *recordTasks) pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = true
+	for key, cancel := range t.running {
+		cancel()
+		delete(t.running, key)
+		delete(t.certWake, key)
+	}
+}
+
+// reconcile starts the next pass of the domain's certificate task now, or
+// right after the pass that is running. It reports whether the domain has a
+// certificate task.
+func (t *recordTasks) reconcile(domain string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, task := range t.certWake {
+		if task.domain == domain {
+			select {
+			case task.wake <- struct{}{}:
+			default:
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// resume undoes pause and starts the tasks appConfig calls for.
+func (t *recordTasks) resume(appConfig *AppConfig) {
+	t.mu.Lock()
+	t.paused = false
+	t.mu.Unlock()
+	t.sync(appConfig)
+}
+
+func (t *recordTasks) start(key string, run func(ctx context.Context)) {
+	ctx, cancel := context.WithCancel(t.ctx)
+	t.running[key] = cancel
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		run(ctx)
+	}()
+}
+
+// runCertificateTask runs the certificate workflow for the record, then,
+// unless in run-once mode, again every CERT_RECONCILE_INTERVAL, so that a
+// failed request or validation is retried without a restart. Later passes
+// use the configuration in effect at the time. Each pass waits for one of
+// the CERT_CONCURRENCY slots, so that many records do not all call ACM and
+// Route53 at once. A signal on wake starts the next pass early.
+func (t *recordTasks) runCertificateTask(ctx context.Context, appConfig *AppConfig, record RecordConfig, wake <-chan struct{}) {
+	for {
+		select {
+		case t.certSlots <- struct{}{}:
+		default:
+			slog.Debug("Waiting for a free certificate slot", "component", "acm", "domain", record.RecordName, "limit", cap(t.certSlots))
+			select {
+			case t.certSlots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+		}
+		err := t.runCertificatePass(ctx, appConfig, record)
+		<-t.certSlots
+		if err != nil {
+			t.failures.Add(1)
+			if !errors.Is(err, context.Canceled) {
+				slog.Error("Certificate workflow failed", "component", "acm", "domain", record.RecordName, "error", err)
+				notify(ctx, appConfig, notifyEvent{
+					Type:    eventCertFailure,
+					Record:  record.RecordName,
+					Message: "Certificate workflow failed for " + record.RecordName + ": " + err.Error(),
+				})
+			}
+		}
+		if appConfig.RunOnce {
+			return
+		}
+		if next := currentConfig.Load(); next != nil {
+			appConfig = next
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+			slog.Info("Certificate check requested", "component", "acm", "domain", record.RecordName)
+		case <-time.After(appConfig.CertReconcilePeriod):
+		}
+	}
+}
+
+// runCertificatePass runs the certificate workflow for the record once.
+func (t *recordTasks) runCertificatePass(ctx context.Context, appConfig *AppConfig, record RecordConfig) (err error) {
+	ctx, span := tracer.Start(ctx, "certificate.pass", trace.WithAttributes(
+		attribute.String("domain", record.RecordName),
+		attribute.String("backend", appConfig.CertBackend),
+		attribute.String("mode", appConfig.CertMode),
+	))
+	defer func() {
+		status.recordCertificatePass(record.RecordName, err)
+		endSpan(span, err)
+	}()
+	if appConfig.CertBackend == certBackendACME {
+		if appConfig.CertMode == certModeReport {
+			err = reportACMECertificate(appConfig, record)
+		} else {
+			err = manageACMECertificate(ctx, appConfig, route53For(t.r53Client, record), record)
+		}
+	} else if appConfig.CertMode == certModeReport {
+		err = reportCertificateStatus(ctx, appConfig, acmFor(t.acmClient, record), record)
+	} else {
+		err = manageCertificateLifecycle(ctx, appConfig, acmFor(t.acmClient, record), route53For(t.r53Client, record), record)
+		if err == nil && appConfig.CertCleanup != certCleanupOff {
+			err = cleanupDuplicateCertificates(ctx, appConfig, acmFor(t.acmClient, record), record)
+		}
+		if err == nil {
+			err = publishCertificateArn(ctx, appConfig, t.ssmClient, record)
+		}
+		if err == nil {
+			err = attachCertificateToListeners(ctx, appConfig, record)
+		}
+		if err == nil {
+			err = attachCertificateToDistribution(ctx, appConfig, record)
+		}
+		if err == nil {
+			err = attachCertificateToAPIGateway(ctx, appConfig, route53For(t.r53Client, record), record)
+		}
+	}
+	if err == nil && appConfig.CertMode != certModeReport {
+		err = syncCertificateToKubernetes(ctx, appConfig, record)
+	}
+	return err
+}
+
+func (t *recordTasks) runProxyTask(ctx context.Context, appConfig *AppConfig, record RecordConfig) {
+	if err := manageNginxProxy(ctx, appConfig, record, t.npmClient, appConfig.ForwardHost); err != nil {
+		t.failures.Add(1)
+		if !errors.Is(err, context.Canceled) {
+			slog.Error("Proxy setup failed", "component", "npm", "record", record.RecordName, "error", err)
+		}
+	}
+}
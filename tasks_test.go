@@ -0,0 +1,75 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// setNonZero sets v, a field of one of the kinds certTaskSettings uses, to
+// a value other than its zero value.
+func setNonZero(t *testing.T, v reflect.Value) {
+	t.Helper()
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString("changed")
+	case reflect.Bool:
+		v.SetBool(!v.Bool())
+	case reflect.Slice:
+		v.Set(reflect.Append(reflect.MakeSlice(v.Type(), 0, 1), reflect.ValueOf("changed")))
+	case reflect.Map:
+		v.Set(reflect.MakeMap(v.Type()))
+		v.SetMapIndex(reflect.ValueOf("changed"), reflect.ValueOf("changed"))
+	default:
+		t.Fatalf("no test value for a field of kind %s", v.Kind())
+	}
+}
+
+func TestCertTaskKeyChangesWithSettings(t *testing.T) {
+	appConfig := &AppConfig{CertMode: certModeManage}
+	record := RecordConfig{RecordName: "home.example.com", ZoneID: "Z1", TLS: true}
+	base := certTaskSettingsFor(appConfig, record)
+	if certTaskKey(appConfig, record) != base.key() {
+		t.Fatal("the certificate task key is not that of the record's settings")
+	}
+
+	fields := reflect.TypeOf(base)
+	for i := range fields.NumField() {
+		field := fields.Field(i)
+		t.Run(field.Name, func(t *testing.T) {
+			changed := base
+			setNonZero(t, reflect.ValueOf(&changed).Elem().Field(i))
+			if changed.key() == base.key() {
+				t.Errorf("changing %s keeps the certificate task key, so a reload would not restart the task", field.Name)
+			}
+
+			// The field is taken from the setting it is named after.
+			var source reflect.Value
+			if name, ok := strings.CutPrefix(field.Name, "App"); ok {
+				changedConfig := *appConfig
+				source = reflect.ValueOf(&changedConfig).Elem().FieldByName(name)
+				if source.IsValid() {
+					setNonZero(t, source)
+					if reflect.DeepEqual(certTaskSettingsFor(&changedConfig, record), base) {
+						t.Errorf("AppConfig.%s is not copied into the certificate task settings", name)
+					}
+				}
+			} else {
+				changedRecord := record
+				source = reflect.ValueOf(&changedRecord).Elem().FieldByName(field.Name)
+				if source.IsValid() {
+					setNonZero(t, source)
+					if reflect.DeepEqual(certTaskSettingsFor(appConfig, changedRecord), base) {
+						t.Errorf("RecordConfig.%s is not copied into the certificate task settings", field.Name)
+					}
+				}
+			}
+			if !source.IsValid() && field.Name != "StateKey" {
+				t.Errorf("%s is not named after a RecordConfig or AppConfig field", field.Name)
+			}
+		})
+	}
+	if certTaskKey(appConfig, record) != base.key() {
+		t.Error("the certificate task key is not stable")
+	}
+}
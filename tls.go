@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// --- TLS Trust ---
+
+// loadCABundle returns the system certificate pool with the PEM certificates
+// in path added, for networks behind a TLS-intercepting proxy or with a
+// private CA.
+func loadCABundle(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA_BUNDLE: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("CA_BUNDLE %s contains no PEM certificates", path)
+	}
+	return pool, nil
+}
+
+// clientTLSConfig is the TLS configuration for outbound HTTPS connections.
+// A nil pool means the system roots.
+func clientTLSConfig(rootCAs *x509.CertPool) *tls.Config {
+	return &tls.Config{RootCAs: rootCAs, MinVersion: tls.VersionTLS12}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	awsmiddleware "github.com/aws/aws-sdk-go-v2/aws/middleware"
+	"github.com/aws/smithy-go/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// --- OpenTelemetry Tracing ---
+
+// tracingShutdownTimeout bounds the export of the last spans at exit.
+const tracingShutdownTimeout = 5 * time.Second
+
+// tracer creates the application's spans. It does nothing until
+// setupTracing installs an exporter.
+var tracer = otel.Tracer("github.com/moootid/awsroute53updater-go")
+
+// setupTracing exports spans over OTLP/HTTP to the configured endpoint. The
+// returned function flushes the spans that are still buffered, and must be
+// called before exiting. The other OTEL_* variables, e.g.
+// OTEL_EXPORTER_OTLP_HEADERS, OTEL_SERVICE_NAME and OTEL_TRACES_SAMPLER, are
+// read by the OpenTelemetry SDK itself.
+func setupTracing(ctx context.Context, appConfig *AppConfig) (func(), error) {
+	if appConfig.OTLPTracesEndpoint == "" {
+		return func() {}, nil
+	}
+	exporter, err := otlptracehttp.New(ctx,
+		otlptracehttp.WithEndpointURL(appConfig.OTLPTracesEndpoint),
+		otlptracehttp.WithTLSClientConfig(clientTLSConfig(appConfig.RootCAs)),
+	)
+	if err != nil {
+		return nil, err
+	}
+	// Attributes from the environment take precedence over the default
+	// service name.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "auto-route53")),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		slog.Warn("Failed to export traces", "error", err)
+	}))
+	slog.Info("Exporting traces", "endpoint", redactURL(appConfig.OTLPTracesEndpoint))
+	return func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+		defer cancel()
+		if err := provider.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Failed to export the remaining traces", "error", err)
+		}
+	}, nil
+}
+
+// endSpan ends span, marking it as failed if err is not nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// traceAPICalls is an AWS SDK middleware that wraps every API call, retries
+// included, in a client span, so that slow ACM or Route53 calls show up
+// under the cycle or certificate pass that made them.
+func traceAPICalls(stack *middleware.Stack) error {
+	return stack.Initialize.Add(middleware.InitializeMiddlewareFunc("TraceAPICalls",
+		func(ctx context.Context, in middleware.InitializeInput, next middleware.InitializeHandler) (middleware.InitializeOutput, middleware.Metadata, error) {
+			service, operation := awsmiddleware.GetServiceID(ctx), awsmiddleware.GetOperationName(ctx)
+			ctx, span := tracer.Start(ctx, service+"."+operation,
+				trace.WithSpanKind(trace.SpanKindClient),
+				trace.WithAttributes(
+					attribute.String("rpc.system", "aws-api"),
+					attribute.String("rpc.service", service),
+					attribute.String("rpc.method", operation),
+					attribute.String("cloud.region", awsmiddleware.GetRegion(ctx)),
+				))
+			out, metadata, err := next.HandleInitialize(ctx, in)
+			if requestID, ok := awsmiddleware.GetRequestIDMetadata(metadata); ok {
+				span.SetAttributes(attribute.String("aws.request_id", requestID))
+			}
+			endSpan(span, err)
+			return out, metadata, err
+		}), middleware.After)
+}
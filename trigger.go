@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// --- Update Trigger ---
+
+// triggerAuthorized checks the API token of a /trigger request. Routers can
+// rarely set headers, so besides a bearer token it is accepted as the
+// password of basic auth or as the token query parameter. Like the other
+// actions, /trigger is refused while API_TOKEN is unset.
+func triggerAuthorized(w http.ResponseWriter, r *http.Request) bool {
+	token := currentConfig.Load().APIToken
+	if token == "" {
+		writeAPIError(w, http.StatusForbidden, "set API_TOKEN to enable /trigger")
+		return false
+	}
+	given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		if _, password, basic := r.BasicAuth(); basic {
+			given = password
+		} else {
+			given = r.URL.Query().Get("token")
+		}
+	}
+	if subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+		w.Header().Set("WWW-Authenticate", `Basic realm="auto-route53"`)
+		writeAPIError(w, http.StatusUnauthorized, "missing or invalid API token")
+		return false
+	}
+	return true
+}
+
+// serveTrigger handles /trigger, for routers that call a URL when their WAN
+// address changes.
+func (c *controller) serveTrigger(w http.ResponseWriter, r *http.Request) {
+	if !triggerAuthorized(w, r) {
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if !c.respond(w, c.trigger(ip), "") {
+		return
+	}
+	slog.Info("Update triggered", "component", "api", "remote_addr", r.RemoteAddr, "ip", ip)
+	writeJSON(w, http.StatusAccepted, map[string]string{"status": "update started"})
+}
+
+// trigger starts a DDNS cycle now that writes every record. With ip set and
+// TRIGGER_TRUST_IP on, the cycle publishes ip instead of detecting the
+// address of its family; otherwise ip is ignored.
+func (c *controller) trigger(ip string) error {
+	if status.snapshot().Standby {
+		return errStandby
+	}
+	appConfig := currentConfig.Load()
+	req := ddnsRequest{force: true}
+	if ip != "" && appConfig.TriggerTrustIP {
+		addr, err := netip.ParseAddr(ip)
+		if err != nil {
+			return fmt.Errorf("%w: %q is not an IP address", errInvalidRequest, ip)
+		}
+		family := ipv4Family
+		if addr.Is6() && !addr.Is4In6() {
+			family = ipv6Family
+		}
+		ip = addr.Unmap().String()
+		if err := checkPublishableIP(ip, family); err != nil {
+			return fmt.Errorf("%w: %w", errInvalidRequest, err)
+		}
+		if len(recordsForFamily(appConfig.RecordsToUpdate, family)) == 0 {
+			return fmt.Errorf("%w: no records are configured for %s addresses", errInvalidRequest, family.Name)
+		}
+		req.trusted = map[string]string{family.Name: ip}
+	}
+	c.wakeDDNS(req)
+	return nil
+}